@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+	"strings"
+	"sync"
+)
+
+// Hasher verifies passwords stored in one hash format. New passwords are always
+// hashed with Argon2id; other hashers exist so imported users can still log in
+// and be upgraded on their next successful login.
+type Hasher interface {
+	// Name identifies the algorithm, e.g. "argon2id" or "bcrypt"
+	Name() string
+	// Identify reports whether the encoded hash is in this hasher's format
+	Identify(encodedHash string) bool
+	// Verify checks a password against an encoded hash
+	Verify(password, encodedHash string) (bool, error)
+	// NeedsRehash reports whether the hash should be replaced with a current Argon2id hash
+	NeedsRehash(encodedHash string) bool
+}
+
+var (
+	hashersMu sync.RWMutex
+	hashers   = []Hasher{argon2Hasher{}, bcryptHasher{}, scryptHasher{}}
+)
+
+// RegisterHasher adds a hasher for verifying an additional hash format. Hashers
+// registered later take precedence over earlier ones for the formats they identify.
+func RegisterHasher(h Hasher) {
+	hashersMu.Lock()
+	defer hashersMu.Unlock()
+	hashers = append([]Hasher{h}, hashers...)
+}
+
+// HasherFor returns the registered hasher that recognizes the encoded hash, or nil
+func HasherFor(encodedHash string) Hasher {
+	hashersMu.RLock()
+	defer hashersMu.RUnlock()
+	for _, h := range hashers {
+		if h.Identify(encodedHash) {
+			return h
+		}
+	}
+	return nil
+}
+
+// VerifyPassword checks a password against a hash in any registered format. When the
+// password matches, needsRehash reports whether the caller should store a fresh hash
+// because the stored one uses outdated parameters or a legacy algorithm.
+func VerifyPassword(password, encodedHash string) (match bool, needsRehash bool, err error) {
+	h := HasherFor(encodedHash)
+	if h == nil {
+		return false, false, fmt.Errorf("unrecognized hash format")
+	}
+	match, err = h.Verify(password, encodedHash)
+	if err != nil || !match {
+		return false, false, err
+	}
+	return true, h.NeedsRehash(encodedHash), nil
+}
+
+// NeedsRehash reports whether a stored hash should be upgraded to the current defaults
+func NeedsRehash(encodedHash string) bool {
+	h := HasherFor(encodedHash)
+	return h == nil || h.NeedsRehash(encodedHash)
+}
+
+// argon2Hasher verifies the native Argon2id format and flags hashes made with weaker
+// parameters than DefaultArgon2Params
+type argon2Hasher struct{}
+
+func (argon2Hasher) Name() string { return "argon2id" }
+
+func (argon2Hasher) Identify(encodedHash string) bool {
+	return strings.HasPrefix(encodedHash, "$argon2id$")
+}
+
+func (argon2Hasher) Verify(password, encodedHash string) (bool, error) {
+	return CheckPassword(password, encodedHash)
+}
+
+func (argon2Hasher) NeedsRehash(encodedHash string) bool {
+	params, _, _, err := parseEncodedHash(encodedHash)
+	if err != nil {
+		return true
+	}
+	current := DefaultArgon2Params()
+	return params.Memory != current.Memory ||
+		params.Iterations != current.Iterations ||
+		params.Parallelism != current.Parallelism ||
+		params.SaltLength < current.SaltLength ||
+		params.KeyLength != current.KeyLength
+}
+
+// bcryptHasher verifies bcrypt hashes ($2a$, $2b$ and $2y$) from imported user databases
+type bcryptHasher struct{}
+
+func (bcryptHasher) Name() string { return "bcrypt" }
+
+func (bcryptHasher) Identify(encodedHash string) bool {
+	return strings.HasPrefix(encodedHash, "$2a$") ||
+		strings.HasPrefix(encodedHash, "$2b$") ||
+		strings.HasPrefix(encodedHash, "$2y$")
+}
+
+func (bcryptHasher) Verify(password, encodedHash string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to verify bcrypt hash: %w", err)
+	}
+	return true, nil
+}
+
+func (bcryptHasher) NeedsRehash(encodedHash string) bool { return true }
+
+// scryptHasher verifies scrypt hashes in the format
+// $scrypt$ln=<log2 N>,r=<r>,p=<p>$<base64 salt>$<base64 hash>
+type scryptHasher struct{}
+
+// minScryptKeyLength is the shortest derived key accepted from a stored scrypt hash
+const minScryptKeyLength = 16
+
+func (scryptHasher) Name() string { return "scrypt" }
+
+func (scryptHasher) Identify(encodedHash string) bool {
+	return strings.HasPrefix(encodedHash, "$scrypt$")
+}
+
+func (scryptHasher) Verify(password, encodedHash string) (bool, error) {
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 5 {
+		return false, fmt.Errorf("invalid scrypt hash format")
+	}
+
+	var logN, r, p int
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &logN, &r, &p); err != nil {
+		return false, fmt.Errorf("invalid scrypt parameters: %w", err)
+	}
+	if logN <= 0 || logN > 30 {
+		return false, fmt.Errorf("invalid scrypt cost: %d", logN)
+	}
+	if r < 1 || p < 1 {
+		return false, fmt.Errorf("invalid scrypt parameters: r=%d, p=%d", r, p)
+	}
+
+	salt, err := decodeBase64(parts[3])
+	if err != nil {
+		return false, fmt.Errorf("failed to decode salt: %w", err)
+	}
+	hash, err := decodeBase64(parts[4])
+	if err != nil {
+		return false, fmt.Errorf("failed to decode hash: %w", err)
+	}
+	// A short key would let any password match it
+	if len(hash) < minScryptKeyLength {
+		return false, fmt.Errorf("scrypt hash is too short: %d bytes", len(hash))
+	}
+
+	otherHash, err := scrypt.Key([]byte(password), salt, 1<<logN, r, p, len(hash))
+	if err != nil {
+		return false, fmt.Errorf("failed to compute scrypt hash: %w", err)
+	}
+	return subtle.ConstantTimeCompare(hash, otherHash) == 1, nil
+}
+
+func (scryptHasher) NeedsRehash(encodedHash string) bool { return true }
+
+// decodeBase64 accepts both padded and unpadded standard base64
+func decodeBase64(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -153,9 +153,9 @@ func parseEncodedHash(encodedHash string) (*Argon2Params, []byte, []byte, error)
 	return params, salt, hash, nil
 }
 
-// IsHashFormat checks if a string is in the expected Argon2 hash format
+// IsHashFormat checks if a string is in a hash format recognized by a registered hasher
 func IsHashFormat(hash string) bool {
-	return strings.HasPrefix(hash, "$argon2id$")
+	return HasherFor(hash) != nil
 }
 
 // MigratePlainTextPassword migrates a plain text password to hashed format
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// PasswordPolicy defines the rules a new password must satisfy
+type PasswordPolicy struct {
+	MinLength        int
+	RequireUpper     bool
+	RequireLower     bool
+	RequireDigit     bool
+	RequireSymbol    bool
+	DisallowUserInfo bool // Reject passwords containing the username or email name
+	HistorySize      int  // Number of previous passwords that may not be reused
+	breached         map[string]struct{}
+}
+
+// PolicyError lists every rule a password failed
+type PolicyError struct {
+	Violations []string
+}
+
+func (e *PolicyError) Error() string {
+	return strings.Join(e.Violations, " ")
+}
+
+// IsPolicyError reports whether err is a password policy violation and returns it
+func IsPolicyError(err error) (*PolicyError, bool) {
+	var policyErr *PolicyError
+	if errors.As(err, &policyErr) {
+		return policyErr, true
+	}
+	return nil, false
+}
+
+// Validate checks a password against the policy for the given account. It returns a
+// *PolicyError describing every violation, or nil if the password is acceptable.
+// Password history is checked separately because it needs stored hashes.
+func (p *PasswordPolicy) Validate(password, username, email string) error {
+	if p == nil {
+		return nil
+	}
+
+	var violations []string
+	if len([]rune(password)) < p.MinLength {
+		violations = append(violations, fmt.Sprintf("Password must be at least %d characters long.", p.MinLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, "Password must contain an uppercase letter.")
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, "Password must contain a lowercase letter.")
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, "Password must contain a number.")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, "Password must contain a symbol.")
+	}
+
+	if p.DisallowUserInfo {
+		lowered := strings.ToLower(password)
+		emailName := strings.ToLower(strings.SplitN(email, "@", 2)[0])
+		if (username != "" && strings.Contains(lowered, strings.ToLower(username))) ||
+			(len(emailName) >= 3 && strings.Contains(lowered, emailName)) {
+			violations = append(violations, "Password must not contain your username or email address.")
+		}
+	}
+
+	if p.IsBreached(password) {
+		violations = append(violations, "This password has appeared in a known data breach. Please choose a different one.")
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// Requirements describes the policy's rules for display next to password forms
+func (p *PasswordPolicy) Requirements() []string {
+	if p == nil {
+		return nil
+	}
+
+	var requirements []string
+	if p.MinLength > 0 {
+		requirements = append(requirements, fmt.Sprintf("At least %d characters", p.MinLength))
+	}
+	var classes []string
+	if p.RequireUpper {
+		classes = append(classes, "an uppercase letter")
+	}
+	if p.RequireLower {
+		classes = append(classes, "a lowercase letter")
+	}
+	if p.RequireDigit {
+		classes = append(classes, "a number")
+	}
+	if p.RequireSymbol {
+		classes = append(classes, "a symbol")
+	}
+	if len(classes) > 0 {
+		requirements = append(requirements, "Contains "+strings.Join(classes, ", "))
+	}
+	if p.DisallowUserInfo {
+		requirements = append(requirements, "Does not contain your username or email address")
+	}
+	if p.HistorySize > 0 {
+		requirements = append(requirements, fmt.Sprintf("Differs from your last %d passwords", p.HistorySize))
+	}
+	if len(p.breached) > 0 {
+		requirements = append(requirements, "Has not appeared in a known data breach")
+	}
+	return requirements
+}
+
+// IsBreached reports whether the password appears in the loaded breached password list
+func (p *PasswordPolicy) IsBreached(password string) bool {
+	if p == nil || p.breached == nil {
+		return false
+	}
+	_, found := p.breached[strings.ToLower(password)]
+	return found
+}
+
+// SetBreachedPasswords replaces the breached password list
+func (p *PasswordPolicy) SetBreachedPasswords(passwords []string) {
+	p.breached = make(map[string]struct{}, len(passwords))
+	for _, password := range passwords {
+		if password = strings.TrimSpace(password); password != "" {
+			p.breached[strings.ToLower(password)] = struct{}{}
+		}
+	}
+}
+
+// LoadBreachedPasswords reads a breached password list with one password per line.
+// Blank lines and lines starting with # are ignored.
+func (p *PasswordPolicy) LoadBreachedPasswords(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var passwords []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords = append(passwords, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	p.SetBreachedPasswords(passwords)
+	return nil
+}
+
+// BreachedPasswordCount returns the number of passwords in the breached list
+func (p *PasswordPolicy) BreachedPasswordCount() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.breached)
+}
+
+// ReusedPassword returns a policy error if the password matches any of the given hashes
+func (p *PasswordPolicy) ReusedPassword(password string, previousHashes []string) error {
+	if p == nil || p.HistorySize <= 0 {
+		return nil
+	}
+	for _, hash := range previousHashes {
+		if match, _, err := VerifyPassword(password, hash); err == nil && match {
+			return &PolicyError{Violations: []string{
+				fmt.Sprintf("Password must not match any of your last %d passwords.", p.HistorySize),
+			}}
+		}
+	}
+	return nil
+}
@@ -7,8 +7,13 @@ import (
 	"strings"
 	"strconv"
 	"log"
+	"time"
 )
 
+// DefaultContentSecurityPolicy allows scripts only from the site itself or carrying the
+// request's nonce. Styles stay inline-friendly because pages use style attributes.
+const DefaultContentSecurityPolicy = "default-src 'self'; script-src 'self' 'nonce-{nonce}'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; object-src 'none'; base-uri 'self'; form-action 'self'"
+
 type Config struct {
 	MySQLHost     string
 	MySQLPort     string
@@ -35,7 +40,59 @@ type Config struct {
 	DKIMSelector       string
 	DKIMDomain         string
 	// Server configuration
-	ServerPort string
+	ServerPort    string
+	PublicBaseURL string
+	// TrustedProxies lists the CIDRs of reverse proxies whose X-Forwarded-For and Forwarded
+	// headers are believed when working out the client IP address
+	TrustedProxies string
+	// TLS configuration; TLS is served when both files are set
+	TLSCertFile           string
+	TLSKeyFile            string
+	HTTPRedirectPort      string
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	// Security headers; ContentSecurityPolicy may contain {nonce}, replaced per request
+	ContentSecurityPolicy string
+	CSPReportOnly         bool
+	CSPReportURI          string
+	FrameAncestors        string
+	ReferrerPolicy        string
+	PermissionsPolicy     string
+	// DevShowTokenLinks shows password reset, verification and invitation links on the page
+	// when email is not configured. Only for local development.
+	DevShowTokenLinks bool
+	// Session configuration
+	SessionIdleTimeout        time.Duration
+	SessionAbsoluteLifetime   time.Duration
+	SessionRememberMeLifetime time.Duration
+	SessionRenewInterval      time.Duration
+	SessionStore              string
+	SessionSecret             string
+	SessionCacheSize          int
+	// Registration configuration
+	RegistrationEnabled         bool
+	RegistrationDefaultGroup    string
+	RegistrationVerifyEmail     bool
+	RegistrationRequireApproval bool
+	// Password policy configuration
+	PasswordMinLength        int
+	PasswordRequireUpper     bool
+	PasswordRequireLower     bool
+	PasswordRequireDigit     bool
+	PasswordRequireSymbol    bool
+	PasswordDisallowUserInfo bool
+	PasswordHistorySize      int
+	BreachedPasswordsFile    string
+	// Password reset configuration
+	PasswordResetMaxRequests int
+	PasswordResetWindow      time.Duration
+	// Magic link login configuration
+	MagicLinkDuration time.Duration
+	// Audit log configuration
+	AuditLogRetention time.Duration
+	// PermissionCacheTTL keeps users' group sets and permissions across requests; zero
+	// loads them once per request
+	PermissionCacheTTL time.Duration
 }
 
 func LoadConfig() *Config {
@@ -44,7 +101,7 @@ func LoadConfig() *Config {
 
 	log.Printf("[DEBUG] LoadConfig: os.Getenv(LOGGING_LEVEL) = %s", os.Getenv("LOGGING_LEVEL"))
 
-	return &Config{
+	cfg := &Config{
 		MySQLHost:     os.Getenv("MYSQL_HOST"),
 		MySQLPort:     os.Getenv("MYSQL_PORT"),
 		MySQLUser:     os.Getenv("MYSQL_USER"),
@@ -70,8 +127,63 @@ func LoadConfig() *Config {
 		DKIMSelector:       getEnv("DKIM_SELECTOR", "default"),
 		DKIMDomain:         getEnv("DKIM_DOMAIN", "yourdomain.com"),
 		// Server configuration
-		ServerPort: getEnv("SERVER_PORT", "8080"),
+		ServerPort:        getEnv("SERVER_PORT", "8080"),
+		PublicBaseURL:     getEnv("PUBLIC_BASE_URL", ""),
+		TrustedProxies:    getEnv("TRUSTED_PROXIES", ""),
+		// TLS configuration
+		TLSCertFile:           getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:            getEnv("TLS_KEY_FILE", ""),
+		HTTPRedirectPort:      getEnv("HTTP_REDIRECT_PORT", ""),
+		HSTSMaxAge:            getEnvDuration("HSTS_MAX_AGE", 365*24*time.Hour),
+		HSTSIncludeSubdomains: getEnvBool("HSTS_INCLUDE_SUBDOMAINS", false),
+		// Security headers
+		ContentSecurityPolicy: getEnv("CSP_POLICY", DefaultContentSecurityPolicy),
+		CSPReportOnly:         getEnvBool("CSP_REPORT_ONLY", false),
+		CSPReportURI:          getEnv("CSP_REPORT_URI", "/csp-report"),
+		FrameAncestors:        getEnv("FRAME_ANCESTORS", "'none'"),
+		ReferrerPolicy:        getEnv("REFERRER_POLICY", "strict-origin-when-cross-origin"),
+		PermissionsPolicy:     getEnv("PERMISSIONS_POLICY", "camera=(), microphone=(), geolocation=(), payment=()"),
+		DevShowTokenLinks: getEnvBool("DEV_SHOW_TOKEN_LINKS", false),
+		// Session configuration
+		SessionIdleTimeout:        getEnvDuration("SESSION_IDLE_TIMEOUT", 2*time.Hour),
+		SessionAbsoluteLifetime:   getEnvDuration("SESSION_ABSOLUTE_LIFETIME", 24*time.Hour),
+		SessionRememberMeLifetime: getEnvDuration("SESSION_REMEMBER_ME_LIFETIME", 30*24*time.Hour),
+		SessionRenewInterval:      getEnvDuration("SESSION_RENEW_INTERVAL", 1*time.Minute),
+		SessionStore:              getEnv("SESSION_STORE", "mysql"),
+		SessionSecret:             getEnv("SESSION_SECRET", ""),
+		SessionCacheSize:          getEnvInt("SESSION_CACHE_SIZE", 10000),
+		// Registration configuration
+		RegistrationEnabled:         getEnvBool("REGISTRATION_ENABLED", false),
+		RegistrationDefaultGroup:    getEnv("REGISTRATION_DEFAULT_GROUP", "customers"),
+		RegistrationVerifyEmail:     getEnvBool("REGISTRATION_VERIFY_EMAIL", true),
+		RegistrationRequireApproval: getEnvBool("REGISTRATION_REQUIRE_APPROVAL", false),
+		// Password policy configuration
+		PasswordMinLength:        getEnvInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordRequireUpper:     getEnvBool("PASSWORD_REQUIRE_UPPER", false),
+		PasswordRequireLower:     getEnvBool("PASSWORD_REQUIRE_LOWER", false),
+		PasswordRequireDigit:     getEnvBool("PASSWORD_REQUIRE_DIGIT", false),
+		PasswordRequireSymbol:    getEnvBool("PASSWORD_REQUIRE_SYMBOL", false),
+		PasswordDisallowUserInfo: getEnvBool("PASSWORD_DISALLOW_USER_INFO", true),
+		PasswordHistorySize:      getEnvInt("PASSWORD_HISTORY_SIZE", 5),
+		BreachedPasswordsFile:    getEnv("BREACHED_PASSWORDS_FILE", ""),
+		// Password reset configuration
+		PasswordResetMaxRequests: getEnvInt("PASSWORD_RESET_MAX_REQUESTS", 3),
+		PasswordResetWindow:      getEnvDuration("PASSWORD_RESET_WINDOW", 1*time.Hour),
+		// Magic link login configuration
+		MagicLinkDuration: getEnvDuration("MAGIC_LINK_DURATION", 15*time.Minute),
+		// Audit log configuration
+		AuditLogRetention: getEnvDuration("AUDIT_LOG_RETENTION", 90*24*time.Hour),
+		// Permission cache configuration
+		PermissionCacheTTL: getEnvDuration("PERMISSION_CACHE_TTL", 0),
+	}
+
+	// Links in emails must not depend on the Host header of the request that triggered them
+	if cfg.PublicBaseURL == "" {
+		cfg.PublicBaseURL = "http://localhost:" + cfg.ServerPort
 	}
+	cfg.PublicBaseURL = strings.TrimRight(cfg.PublicBaseURL, "/")
+
+	return cfg
 }
 
 func loadEnvFile(filename string) {
@@ -154,6 +266,32 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvDuration parses Go duration strings such as "30m" or "720h"
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if duration, err := time.ParseDuration(value); err == nil && duration > 0 {
+			return duration
+		}
+	}
+	return defaultValue
+}
+
+// TLSEnabled reports whether the server listens with TLS itself
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// SecureCookies reports whether cookies must carry the Secure flag: when the server
+// terminates TLS itself, or when it sits behind a proxy serving the public URL over HTTPS
+func (c *Config) SecureCookies() bool {
+	return c.TLSEnabled() || strings.HasPrefix(strings.ToLower(c.PublicBaseURL), "https://")
+}
+
+// PublicURL returns an absolute link to path on the site, for use in emails
+func (c *Config) PublicURL(path string) string {
+	return strings.TrimRight(c.PublicBaseURL, "/") + path
+}
+
 func (c *Config) GetDSN() string {
 	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
 		c.MySQLUser, c.MySQLPassword, c.MySQLHost, c.MySQLPort, c.MySQLDatabase)
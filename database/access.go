@@ -0,0 +1,281 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"stingray/models"
+	"sync"
+	"time"
+)
+
+// Access is a user's full group set, including nested groups and everyone, and the
+// permissions granted to it. Loading it costs a fixed number of queries; every check
+// afterwards is a set lookup. An Access may be shared and must not be modified.
+type Access struct {
+	UserID      int
+	Groups      map[string]bool
+	Permissions []string
+}
+
+// NewAccess builds an Access from a user's group names and granted permissions
+func NewAccess(userID int, groups []string, permissions []string) *Access {
+	set := make(map[string]bool, len(groups)+1)
+	for _, group := range groups {
+		set[group] = true
+	}
+	set["everyone"] = true
+	return &Access{UserID: userID, Groups: set, Permissions: permissions}
+}
+
+// InGroup reports whether the user belongs to the group, directly or through nesting
+func (a *Access) InGroup(name string) bool {
+	return a.Groups[name]
+}
+
+// InAnyGroup reports whether the user belongs to at least one of the groups
+func (a *Access) InAnyGroup(names []string) bool {
+	for _, name := range names {
+		if a.Groups[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether one of the user's groups was granted the permission.
+// Table permissions granted through table metadata are checked by CanAccessTable.
+func (a *Access) HasPermission(permission string) bool {
+	for _, granted := range a.Permissions {
+		if models.PermissionMatches(granted, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessTable reports whether the user may read or write ("read" or "write") a table,
+// through a granted permission or the table's read_groups or write_groups. An empty group
+// list leaves the table open.
+func (a *Access) CanAccessTable(metadata *models.TableMetadata, action string) (bool, error) {
+	if a.HasPermission(models.TablePermission(metadata.TableName, action)) {
+		return true, nil
+	}
+
+	groupsJSON := metadata.ReadGroups
+	if action == "write" {
+		groupsJSON = metadata.WriteGroups
+	}
+	if groupsJSON == "" {
+		return true, nil // No restrictions
+	}
+
+	var allowed []string
+	if err := json.Unmarshal([]byte(groupsJSON), &allowed); err != nil {
+		return false, fmt.Errorf("failed to parse %s groups: %w", action, err)
+	}
+	return len(allowed) == 0 || a.InAnyGroup(allowed), nil
+}
+
+// CanReadRow reports whether the user is in one of a row's read groups
+func (a *Access) CanReadRow(readGroups sql.NullString) (bool, error) {
+	return a.rowGroupsAllow(readGroups, "read")
+}
+
+// CanWriteRow reports whether the user is in one of a row's write groups
+func (a *Access) CanWriteRow(writeGroups sql.NullString) (bool, error) {
+	return a.rowGroupsAllow(writeGroups, "write")
+}
+
+// rowGroupsAllow checks a row's JSON group list. A missing list leaves the row open; an
+// empty list admits nobody.
+func (a *Access) rowGroupsAllow(groups sql.NullString, kind string) (bool, error) {
+	if !groups.Valid || groups.String == "" {
+		return true, nil // No restrictions
+	}
+
+	var names []string
+	if err := json.Unmarshal([]byte(groups.String), &names); err != nil {
+		return false, fmt.Errorf("failed to parse %s groups: %w", kind, err)
+	}
+	return a.InAnyGroup(names), nil
+}
+
+// LoadAccess loads the user's group set and permissions. Pass userID 0 for anonymous
+// users, who only belong to everyone. Results come from the access cache when enabled.
+func (d *Database) LoadAccess(userID int) (*Access, error) {
+	if access := d.accessCache.get(userID); access != nil {
+		return access, nil
+	}
+	generation := d.accessCache.currentGeneration()
+
+	var groups []string
+	if userID > 0 {
+		var err error
+		groups, err = d.GetUserEffectiveGroups(userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	access, err := d.accessForGroups(userID, groups)
+	if err != nil {
+		return nil, err
+	}
+
+	d.accessCache.put(access, generation)
+	return access, nil
+}
+
+// AccessWithoutGroup loads the Access the user would have after leaving a group they
+// belong to directly, so a removal can be checked before it is made. The result is not
+// cached.
+func (d *Database) AccessWithoutGroup(userID int, groupName string) (*Access, error) {
+	direct, err := d.getUserDirectGroupNames(userID)
+	if err != nil {
+		return nil, err
+	}
+	var remaining []string
+	for _, name := range direct {
+		if name != groupName {
+			remaining = append(remaining, name)
+		}
+	}
+	groups, err := d.expandGroupNames(remaining)
+	if err != nil {
+		return nil, err
+	}
+	return d.accessForGroups(userID, groups)
+}
+
+// accessForGroups builds the Access for a user's full group set and loads its permissions
+func (d *Database) accessForGroups(userID int, groups []string) (*Access, error) {
+	access := NewAccess(userID, groups, nil)
+
+	names := make([]string, 0, len(access.Groups))
+	for name := range access.Groups {
+		names = append(names, name)
+	}
+	permissions, err := d.getPermissionsForGroups(names)
+	if err != nil {
+		return nil, err
+	}
+	access.Permissions = permissions
+	return access, nil
+}
+
+// Can reports whether the user behind access holds the permission through any group they
+// belong to, including nested groups and everyone. Load access with LoadAccess, passing
+// userID 0 for anonymous users. This is the one permission check; handlers reach it through
+// SessionMiddleware.Can.
+//
+// Table permissions (table.<name>.read and table.<name>.write) are also granted by the
+// table's read_groups and write_groups, and an empty group list leaves the table open. Only
+// table permissions not granted outright need a query, to read the table's groups.
+func (d *Database) Can(access *Access, permission string) (bool, error) {
+	if access.HasPermission(permission) {
+		return true, nil
+	}
+	tableName, action, ok := models.ParseTablePermission(permission)
+	if !ok {
+		return false, nil
+	}
+	metadata, err := d.GetTableMetadata(tableName)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return access.CanAccessTable(metadata, action)
+}
+
+// invalidateUserAccess drops a user's cached group set after their memberships change
+func (d *Database) invalidateUserAccess(userID int) {
+	d.accessCache.remove(userID)
+}
+
+// invalidateAllAccess drops every cached group set after a change to a group, its nesting
+// or its permissions, which can affect any number of users
+func (d *Database) invalidateAllAccess() {
+	d.accessCache.clear()
+}
+
+type accessCacheEntry struct {
+	access  *Access
+	expires time.Time
+}
+
+// accessCache holds loaded Access values by user ID until they expire or are invalidated.
+// A nil cache is disabled and never stores anything.
+type accessCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[int]accessCacheEntry
+	// generation changes on every invalidation, so a value loaded before an invalidation
+	// is not stored after it
+	generation uint64
+}
+
+func newAccessCache(ttl time.Duration) *accessCache {
+	return &accessCache{ttl: ttl, entries: make(map[int]accessCacheEntry)}
+}
+
+func (c *accessCache) get(userID int) *Access {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[userID]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, userID)
+		return nil
+	}
+	return entry.access
+}
+
+func (c *accessCache) currentGeneration() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// put stores access unless the cache was invalidated since generation was read
+func (c *accessCache) put(access *Access, generation uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	c.entries[access.UserID] = accessCacheEntry{access: access, expires: time.Now().Add(c.ttl)}
+}
+
+func (c *accessCache) remove(userID int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	delete(c.entries, userID)
+}
+
+func (c *accessCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.entries = make(map[int]accessCacheEntry)
+}
@@ -2,12 +2,48 @@ package database
 
 import (
 	"database/sql"
+	"stingray/auth"
+	"time"
 	_ "github.com/go-sql-driver/mysql"
 )
 
 type Database struct {
 	db       *sql.DB
 	debugDB  *DebugDB
+	// passwordPolicy is enforced whenever a password is set; nil accepts any password
+	passwordPolicy *auth.PasswordPolicy
+	// accessCache keeps users' group sets across requests when enabled
+	accessCache *accessCache
+}
+
+// SetPasswordPolicy sets the policy enforced by every operation that sets a password
+func (d *Database) SetPasswordPolicy(policy *auth.PasswordPolicy) {
+	d.passwordPolicy = policy
+}
+
+// SetAccessCacheTTL keeps loaded group sets and permissions for ttl so permission checks
+// on later requests need no queries. Membership and permission changes made through this
+// Database clear it at once; ttl bounds how long changes made elsewhere go unnoticed.
+// Zero disables the cache.
+func (d *Database) SetAccessCacheTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		d.accessCache = nil
+		return
+	}
+	d.accessCache = newAccessCache(ttl)
+}
+
+// QueryCount returns the number of statements sent to the database so far
+func (d *Database) QueryCount() int64 {
+	if d.debugDB == nil {
+		return 0
+	}
+	return d.debugDB.QueryCount()
+}
+
+// PasswordPolicy returns the policy enforced when passwords are set
+func (d *Database) PasswordPolicy() *auth.PasswordPolicy {
+	return d.passwordPolicy
 }
 
 func NewDatabase(dsn string, debuggingMode bool) (*Database, error) {
@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,6 +15,8 @@ type DebugDB struct {
 	debuggingMode bool
 	logger        *log.Logger
 	logFile       *os.File
+	// queries counts every statement sent, for benchmarks and diagnostics
+	queries atomic.Int64
 }
 
 // NewDebugDB creates a new debug database wrapper
@@ -51,8 +54,14 @@ func (d *DebugDB) setupQueryLogger() {
 	d.logger = log.New(file, "", log.LstdFlags)
 }
 
-// logQuery logs a database query with timestamp
+// QueryCount returns the number of statements sent since the wrapper was created
+func (d *DebugDB) QueryCount() int64 {
+	return d.queries.Load()
+}
+
+// logQuery counts and logs a database query with timestamp
 func (d *DebugDB) logQuery(query string, args ...interface{}) {
+	d.queries.Add(1)
 	if !d.debuggingMode || d.logger == nil {
 		return
 	}
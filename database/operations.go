@@ -2,8 +2,11 @@ package database
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"log"
@@ -39,8 +42,13 @@ func (d *Database) initDatabase() error {
 		css_class VARCHAR(255),
 		scripts TEXT,
 		template VARCHAR(100) DEFAULT 'default',
+		content_format VARCHAR(16) NOT NULL DEFAULT 'html',
 		read_groups TEXT,
 		write_groups TEXT,
+		status VARCHAR(32) NOT NULL DEFAULT 'published',
+		published_revision INT NULL,
+		publish_at TIMESTAMP NULL,
+		unpublish_at TIMESTAMP NULL,
 		created TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		modified TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
 	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`
@@ -79,6 +87,7 @@ func (d *Database) initDatabase() error {
 		password VARCHAR(255) NOT NULL,
 		read_groups TEXT,
 		write_groups TEXT,
+		status VARCHAR(32) NOT NULL DEFAULT 'active',
 		created TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		modified TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 		INDEX idx_username (username),
@@ -114,6 +123,26 @@ func (d *Database) initDatabase() error {
 		return err
 	}
 
+	// Create group_and_group table for nested groups: members of member_group_id
+	// are also members of group_id
+	createGroupGroupsTableQuery := `
+	CREATE TABLE IF NOT EXISTS _group_and_group (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		group_id INT NOT NULL,
+		member_group_id INT NOT NULL,
+		created TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE KEY unique_group_member (group_id, member_group_id),
+		FOREIGN KEY (group_id) REFERENCES _group(id) ON DELETE CASCADE,
+		FOREIGN KEY (member_group_id) REFERENCES _group(id) ON DELETE CASCADE,
+		INDEX idx_member_group_id (member_group_id)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`
+
+	_, err = d.Exec(createGroupGroupsTableQuery)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+
 	// Create sessions table (depends on users)
 	createSessionsTableQuery := `
 	CREATE TABLE IF NOT EXISTS _session (
@@ -127,6 +156,13 @@ func (d *Database) initDatabase() error {
 		modified TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 		expires_at TIMESTAMP NOT NULL,
 		is_active BOOLEAN DEFAULT TRUE,
+		last_seen TIMESTAMP NULL,
+		ip_address VARCHAR(64),
+		user_agent TEXT,
+		absolute_expires_at TIMESTAMP NULL,
+		remember_me BOOLEAN DEFAULT FALSE,
+		rotate_pending BOOLEAN DEFAULT FALSE,
+		impersonator_id INT NULL,
 		INDEX idx_session_id (session_id),
 		INDEX idx_expires_at (expires_at),
 		INDEX idx_is_active (is_active),
@@ -212,6 +248,169 @@ func (d *Database) initDatabase() error {
 		return err
 	}
 
+	// Create email verification tokens table
+	createEmailVerificationTokensQuery := `
+	CREATE TABLE IF NOT EXISTS _email_verification_token (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		user_id INT NOT NULL,
+		token VARCHAR(255) UNIQUE NOT NULL,
+		email VARCHAR(255) NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		used BOOLEAN DEFAULT FALSE,
+		created TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		modified TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		INDEX idx_token (token),
+		INDEX idx_user_id (user_id),
+		INDEX idx_expires_at (expires_at),
+		FOREIGN KEY (user_id) REFERENCES _user(id) ON DELETE CASCADE
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`
+
+	_, err = d.Exec(createEmailVerificationTokensQuery)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Create magic link login tokens table; token and browser_key hold SHA-256 hashes
+	createMagicLinkTokensQuery := `
+	CREATE TABLE IF NOT EXISTS _magic_link_token (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		user_id INT NOT NULL,
+		token VARCHAR(64) UNIQUE NOT NULL,
+		browser_key VARCHAR(64) NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		used BOOLEAN DEFAULT FALSE,
+		created TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_user_id (user_id),
+		INDEX idx_expires_at (expires_at),
+		FOREIGN KEY (user_id) REFERENCES _user(id) ON DELETE CASCADE
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`
+
+	_, err = d.Exec(createMagicLinkTokensQuery)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Create password history table
+	createPasswordHistoryQuery := `
+	CREATE TABLE IF NOT EXISTS _password_history (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		user_id INT NOT NULL,
+		password VARCHAR(255) NOT NULL,
+		created TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_user_id (user_id),
+		FOREIGN KEY (user_id) REFERENCES _user(id) ON DELETE CASCADE
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`
+
+	_, err = d.Exec(createPasswordHistoryQuery)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Create invitations table
+	createInvitationsQuery := `
+	CREATE TABLE IF NOT EXISTS _invitation (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		email VARCHAR(255) NOT NULL,
+		token VARCHAR(255) UNIQUE NOT NULL,
+		group_names JSON,
+		invited_by INT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		accepted BOOLEAN DEFAULT FALSE,
+		accepted_user_id INT NULL,
+		revoked BOOLEAN DEFAULT FALSE,
+		created TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		modified TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		INDEX idx_token (token),
+		INDEX idx_email (email),
+		FOREIGN KEY (invited_by) REFERENCES _user(id) ON DELETE SET NULL,
+		FOREIGN KEY (accepted_user_id) REFERENCES _user(id) ON DELETE SET NULL
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`
+
+	_, err = d.Exec(createInvitationsQuery)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Create group permissions table mapping groups to named permissions
+	createGroupPermissionsQuery := `
+	CREATE TABLE IF NOT EXISTS _group_permission (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		group_id INT NOT NULL,
+		permission VARCHAR(128) NOT NULL,
+		created TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE KEY unique_group_permission (group_id, permission),
+		FOREIGN KEY (group_id) REFERENCES _group(id) ON DELETE CASCADE,
+		INDEX idx_permission (permission)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`
+
+	_, err = d.Exec(createGroupPermissionsQuery)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Create audit log table; user IDs are kept without foreign keys so events outlive users
+	createAuditLogQuery := `
+	CREATE TABLE IF NOT EXISTS _audit_log (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		event_type VARCHAR(64) NOT NULL,
+		actor_id INT NULL,
+		actor_name VARCHAR(255),
+		target_user_id INT NULL,
+		target_name VARCHAR(255),
+		details TEXT,
+		ip_address VARCHAR(64),
+		user_agent TEXT,
+		created TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_event_type (event_type),
+		INDEX idx_created (created)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`
+
+	_, err = d.Exec(createAuditLogQuery)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Create page revisions table holding a full snapshot of every page save. Authors are
+	// kept without foreign keys so history outlives users; revisions go with their page.
+	createPageRevisionQuery := `
+	CREATE TABLE IF NOT EXISTS _page_revision (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		page_id INT NOT NULL,
+		revision INT NOT NULL,
+		slug VARCHAR(255) NOT NULL,
+		title VARCHAR(255) NOT NULL,
+		meta_description TEXT,
+		header TEXT,
+		navigation TEXT,
+		main_content TEXT,
+		sidebar TEXT,
+		footer TEXT,
+		css_class VARCHAR(255),
+		scripts TEXT,
+		template VARCHAR(100),
+		content_format VARCHAR(16) NOT NULL DEFAULT 'html',
+		read_groups TEXT,
+		write_groups TEXT,
+		author_id INT NULL,
+		author_name VARCHAR(255),
+		note VARCHAR(255),
+		created TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE KEY unique_page_revision (page_id, revision),
+		FOREIGN KEY (page_id) REFERENCES _page(id) ON DELETE CASCADE
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`
+
+	_, err = d.Exec(createPageRevisionQuery)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+
 	// Initialize with default pages and users
 	if err := d.initializePages(); err != nil {
 		LogSQLError(err)
@@ -223,6 +422,11 @@ func (d *Database) initDatabase() error {
 		return err
 	}
 
+	if err := d.initializePermissions(); err != nil {
+		LogSQLError(err)
+		return err
+	}
+
 	if err := d.initializeMetadata(); err != nil {
 		LogSQLError(err)
 		return err
@@ -252,11 +456,46 @@ func (d *Database) initDatabase() error {
 		return err
 	}
 
+	// Add client tracking columns to existing session tables
+	if err := d.migrateAddSessionClientFields(); err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Add account status to existing user tables
+	if err := d.addColumnsIfMissing("_user", []columnDefinition{
+		{"status", "VARCHAR(32) NOT NULL DEFAULT 'active'"},
+	}); err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Add the publishing workflow to existing page tables; existing pages stay published
+	if err := d.addColumnsIfMissing("_page", []columnDefinition{
+		{"status", "VARCHAR(32) NOT NULL DEFAULT 'published'"},
+		{"published_revision", "INT NULL"},
+		{"publish_at", "TIMESTAMP NULL"},
+		{"unpublish_at", "TIMESTAMP NULL"},
+		{"content_format", "VARCHAR(16) NOT NULL DEFAULT 'html'"},
+	}); err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	if err := d.addColumnsIfMissing("_page_revision", []columnDefinition{
+		{"content_format", "VARCHAR(16) NOT NULL DEFAULT 'html'"},
+	}); err != nil {
+		LogSQLError(err)
+		return err
+	}
+
 	return nil
 }
 
-func (d *Database) initializePages() error {
-	pages := []models.Page{
+// builtInPages are created at startup when missing. Routes such as /user/login render
+// them, so they cannot be deleted or renamed in the page editor.
+func builtInPages() []models.Page {
+	return []models.Page{
 		{
 			Slug:           "home",
 			Title:          "Welcome to Sting Ray",
@@ -308,11 +547,11 @@ func (d *Database) initializePages() error {
 			MetaDescription: "Server shutdown confirmation",
 			Header:         "Server Shutdown",
 			Navigation:     `<a href="/">Home</a> | <a href="/page/about">About</a> | <a href="/user/login">Login</a>`,
-			MainContent:    `<h2>Server Shutdown</h2><p>The server is shutting down gracefully. Please wait...</p><div id="countdown">30</div><script>let count = 30; const timer = setInterval(() => { count--; document.getElementById('countdown').textContent = count; if (count <= 0) { clearInterval(timer); window.location.href = '/'; } }, 1000);</script>`,
+			MainContent:    `<h2>Server Shutdown</h2><p>The server is shutting down gracefully. Please wait...</p><div id="countdown">30</div>`,
 			Sidebar:        `<h3>Shutdown Progress</h3><p>Server will be unavailable during shutdown.</p>`,
 			Footer:         "© 2025 StingRay",
 			CSSClass:       "modern",
-			Scripts:        "",
+			Scripts:        `<script>let count = 30; const timer = setInterval(() => { count--; document.getElementById('countdown').textContent = count; if (count <= 0) { clearInterval(timer); window.location.href = '/'; } }, 1000);</script>`,
 			Template:       "modern",
 			ReadGroups:     sql.NullString{String: "[\"everyone\"]", Valid: true},
 			WriteGroups:    sql.NullString{String: "[\"admin\", \"engineer\"]", Valid: true},
@@ -408,8 +647,20 @@ func (d *Database) initializePages() error {
 			WriteGroups:    sql.NullString{String: "[\"admin\", \"engineer\"]", Valid: true},
 		},
 	}
+}
+
+// IsBuiltInPage reports whether slug names one of the pages created at startup
+func IsBuiltInPage(slug string) bool {
+	for _, page := range builtInPages() {
+		if page.Slug == slug {
+			return true
+		}
+	}
+	return false
+}
 
-	for _, page := range pages {
+func (d *Database) initializePages() error {
+	for _, page := range builtInPages() {
 		if err := d.createPageIfNotExists(page); err != nil {
 			LogSQLError(err)
 			return err
@@ -444,29 +695,35 @@ func (d *Database) createPageIfNotExists(page models.Page) error {
 	return nil
 }
 
-func (d *Database) GetPage(slug string) (*models.Page, error) {
+// pageColumns lists the _page columns read by scanPage, in order
+const pageColumns = `id, slug, title, meta_description, header, navigation, main_content, sidebar, footer, css_class, scripts, template, content_format, read_groups, write_groups, status, published_revision, publish_at, unpublish_at, created, modified`
+
+// scanPage reads a page selected with pageColumns
+func scanPage(row rowScanner) (*models.Page, error) {
 	var page models.Page
-	var readGroups, writeGroups sql.NullString
-	err := d.QueryRow(`
-		SELECT id, slug, title, meta_description, header, navigation, main_content, sidebar, footer, css_class, scripts, template, read_groups, write_groups, created, modified
-		FROM _page WHERE slug = ?`, slug).Scan(
+	err := row.Scan(
 		&page.ID, &page.Slug, &page.Title, &page.MetaDescription, &page.Header,
 		&page.Navigation, &page.MainContent, &page.Sidebar, &page.Footer,
-		&page.CSSClass, &page.Scripts, &page.Template, &readGroups, &writeGroups,
+		&page.CSSClass, &page.Scripts, &page.Template, &page.ContentFormat, &page.ReadGroups, &page.WriteGroups,
+		&page.Status, &page.PublishedRevision, &page.PublishAt, &page.UnpublishAt,
 		&page.Created, &page.Modified)
 	if err != nil {
-		LogSQLError(err)
 		return nil, err
 	}
-	page.ReadGroups = readGroups
-	page.WriteGroups = writeGroups
 	return &page, nil
 }
 
+func (d *Database) GetPage(slug string) (*models.Page, error) {
+	page, err := scanPage(d.QueryRow(`SELECT `+pageColumns+` FROM _page WHERE slug = ?`, slug))
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
+	}
+	return page, nil
+}
+
 func (d *Database) GetAllPages() ([]models.Page, error) {
-	rows, err := d.Query(`
-		SELECT id, slug, title, meta_description, header, navigation, main_content, sidebar, footer, css_class, scripts, template, read_groups, write_groups, created, modified
-		FROM _page ORDER BY slug`)
+	rows, err := d.Query(`SELECT ` + pageColumns + ` FROM _page ORDER BY slug`)
 	if err != nil {
 		LogSQLError(err)
 		return nil, err
@@ -475,684 +732,970 @@ func (d *Database) GetAllPages() ([]models.Page, error) {
 
 	var pages []models.Page
 	for rows.Next() {
-		var page models.Page
-		var readGroups, writeGroups sql.NullString
-		err := rows.Scan(
-			&page.ID, &page.Slug, &page.Title, &page.MetaDescription, &page.Header,
-			&page.Navigation, &page.MainContent, &page.Sidebar, &page.Footer,
-			&page.CSSClass, &page.Scripts, &page.Template, &readGroups, &writeGroups,
-			&page.Created, &page.Modified)
+		page, err := scanPage(rows)
 		if err != nil {
 			LogSQLError(err)
 			return nil, err
 		}
-		page.ReadGroups = readGroups
-		page.WriteGroups = writeGroups
-		pages = append(pages, page)
+		pages = append(pages, *page)
 	}
 	return pages, nil
 }
 
-// Session operations
-func (d *Database) CreateSession(userID int, username string, duration time.Duration) (*models.Session, error) {
-	sessionID, err := generateSessionID()
+// ErrPageSlugTaken is returned when a page is created or renamed to a slug another page uses
+var ErrPageSlugTaken = errors.New("a page with that slug already exists")
+
+// pageSlugTaken reports whether a page other than excludeID uses the slug
+func (d *Database) pageSlugTaken(slug string, excludeID int) (bool, error) {
+	var count int
+	err := d.QueryRow("SELECT COUNT(*) FROM _page WHERE slug = ? AND id != ?", slug, excludeID).Scan(&count)
 	if err != nil {
-		return nil, err
+		LogSQLError(err)
+		return false, err
 	}
+	return count > 0, nil
+}
 
-	expiresAt := time.Now().Add(duration)
+// CreatePage inserts a new page as a draft and records it as the page's first revision by
+// author. The new page's ID is returned.
+func (d *Database) CreatePage(page *models.Page, author models.PageAuthor) (int, error) {
+	taken, err := d.pageSlugTaken(page.Slug, 0)
+	if err != nil {
+		return 0, err
+	}
+	if taken {
+		return 0, ErrPageSlugTaken
+	}
 
-	_, err = d.Exec(`
-		INSERT INTO _session (session_id, user_id, username, expires_at, is_active)
-		VALUES (?, ?, ?, ?, TRUE)`,
-		sessionID, userID, username, expiresAt)
+	tx, err := d.Begin()
 	if err != nil {
 		LogSQLError(err)
-		return nil, err
+		return 0, err
 	}
+	defer tx.Rollback()
 
-	session := &models.Session{
-		SessionID: sessionID,
-		UserID:    userID,
-		Username:  username,
-		CreatedAt: time.Now(),
-		ExpiresAt: expiresAt,
-		IsActive:  true,
+	result, err := tx.Exec(`
+		INSERT INTO _page (slug, title, meta_description, header, navigation, main_content, sidebar, footer, css_class, scripts, template, content_format, read_groups, write_groups, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		page.Slug, page.Title, page.MetaDescription, page.Header, page.Navigation,
+		page.MainContent, page.Sidebar, page.Footer, page.CSSClass, page.Scripts, page.Template, contentFormat(page),
+		page.ReadGroups, page.WriteGroups, models.PageStatusDraft)
+	if err != nil {
+		LogSQLError(err)
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		LogSQLError(err)
+		return 0, err
 	}
 
-	return session, nil
+	saved := *page
+	saved.ID = int(id)
+	if _, err := insertPageRevision(tx, &saved, author, ""); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		LogSQLError(err)
+		return 0, err
+	}
+	return int(id), nil
 }
 
-func (d *Database) GetSession(sessionID string) (*models.Session, error) {
-	var session models.Session
-	var readGroups, writeGroups sql.NullString
-	err := d.QueryRow(`
-		SELECT id, session_id, user_id, username, read_groups, write_groups, created, expires_at, is_active
-		FROM _session WHERE session_id = ? AND is_active = TRUE AND expires_at > NOW()`,
-		sessionID).Scan(
-		&session.ID, &session.SessionID, &session.UserID, &session.Username,
-		&readGroups, &writeGroups, &session.CreatedAt, &session.ExpiresAt, &session.IsActive)
+// UpdatePage saves every field of an existing page's working draft and records the result
+// as a new revision by author. A published page becomes a draft with unpublished changes;
+// readers keep seeing the published revision. The page is found by ID, so its slug can
+// change; sql.ErrNoRows is returned when the page does not exist.
+func (d *Database) UpdatePage(page *models.Page, author models.PageAuthor) error {
+	_, err := d.updatePage(page, author, "")
+	return err
+}
+
+// updatePage saves a page and its new revision in one transaction, returning the revision
+// number
+func (d *Database) updatePage(page *models.Page, author models.PageAuthor, note string) (int, error) {
+	taken, err := d.pageSlugTaken(page.Slug, page.ID)
+	if err != nil {
+		return 0, err
+	}
+	if taken {
+		return 0, ErrPageSlugTaken
+	}
+
+	tx, err := d.Begin()
+	if err != nil {
+		LogSQLError(err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	current, err := lockPage(tx, page.ID)
+	if err != nil {
+		return 0, err
+	}
+	status := current.Status
+	if status == models.PageStatusPublished {
+		status = models.PageStatusDraft
+	}
+
+	_, err = tx.Exec(`
+		UPDATE _page SET slug = ?, title = ?, meta_description = ?, header = ?, navigation = ?, main_content = ?,
+			sidebar = ?, footer = ?, css_class = ?, scripts = ?, template = ?, content_format = ?, read_groups = ?, write_groups = ?, status = ?
+		WHERE id = ?`,
+		page.Slug, page.Title, page.MetaDescription, page.Header, page.Navigation, page.MainContent,
+		page.Sidebar, page.Footer, page.CSSClass, page.Scripts, page.Template, contentFormat(page), page.ReadGroups, page.WriteGroups, status,
+		page.ID)
 	if err != nil {
 		LogSQLError(err)
+		return 0, err
+	}
+	revision, err := insertPageRevision(tx, page, author, note)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		LogSQLError(err)
+		return 0, err
+	}
+	return revision, nil
+}
+
+// lockPage reads a page for update within tx, returning sql.ErrNoRows when it does not
+// exist. Pages saved before revision history existed first get their current content
+// recorded, so the first change can still be undone, and pages published before drafts
+// existed get that revision pinned as their published version, so the change stays a draft.
+func lockPage(tx *sql.Tx, id int) (*models.Page, error) {
+	page, err := scanPage(tx.QueryRow(`SELECT `+pageColumns+` FROM _page WHERE id = ? FOR UPDATE`, id))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			LogSQLError(err)
+		}
 		return nil, err
 	}
-	
-	// Handle NULL values
-	if readGroups.Valid {
-		session.ReadGroups = readGroups.String
-	} else {
-		session.ReadGroups = ""
+	latest, err := latestPageRevision(tx, id)
+	if err != nil {
+		return nil, err
 	}
-	if writeGroups.Valid {
-		session.WriteGroups = writeGroups.String
-	} else {
-		session.WriteGroups = ""
+	if latest == 0 {
+		if latest, err = insertPageRevision(tx, page, models.PageAuthor{}, "Content before revision history"); err != nil {
+			return nil, err
+		}
 	}
-	
-	return &session, nil
+	if page.Status == models.PageStatusPublished && !page.PublishedRevision.Valid {
+		if _, err := tx.Exec("UPDATE _page SET published_revision = ? WHERE id = ?", latest, id); err != nil {
+			LogSQLError(err)
+			return nil, err
+		}
+		page.PublishedRevision = sql.NullInt64{Int64: int64(latest), Valid: true}
+	}
+	return page, nil
 }
 
-func (d *Database) InvalidateSession(sessionID string) error {
-	_, err := d.Exec(`
-		UPDATE _session SET is_active = FALSE WHERE session_id = ?`,
-		sessionID)
+// latestPageRevision returns the number of a page's newest revision, or zero when it has none
+func latestPageRevision(tx *sql.Tx, pageID int) (int, error) {
+	var revision int
+	err := tx.QueryRow("SELECT COALESCE(MAX(revision), 0) FROM _page_revision WHERE page_id = ?", pageID).Scan(&revision)
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return 0, err
 	}
-	return nil
+	return revision, nil
 }
 
-func (d *Database) CleanupExpiredSessions() error {
-	_, err := d.Exec(`
-		UPDATE _session SET is_active = FALSE WHERE expires_at <= NOW()`)
+// DeletePage removes a page and its revisions, returning sql.ErrNoRows when it does not
+// exist. Built-in pages are recreated on the next start.
+func (d *Database) DeletePage(id int) error {
+	result, err := d.Exec("DELETE FROM _page WHERE id = ?", id)
 	if err != nil {
 		LogSQLError(err)
 		return err
 	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return sql.ErrNoRows
+	}
 	return nil
 }
 
-func generateSessionID() (string, error) {
-	bytes := make([]byte, 32)
-	if _, err := rand.Read(bytes); err != nil {
-		return "", err
+// Page revision operations
+
+// contentFormat returns the format page content is stored in, HTML unless set
+func contentFormat(page *models.Page) string {
+	if page.ContentFormat == "" {
+		return models.PageFormatHTML
 	}
-	return hex.EncodeToString(bytes), nil
+	return page.ContentFormat
 }
 
-// Exported for testing
-func GenerateSessionIDForTest() (string, error) {
-	return generateSessionID()
+// pageRevisionColumns lists the _page_revision columns read by scanPageRevision, in order
+const pageRevisionColumns = `id, page_id, revision, slug, title, meta_description, header, navigation, main_content, sidebar, footer, css_class, scripts, template, content_format, read_groups, write_groups, author_id, author_name, note, created`
+
+// scanPageRevision reads a revision selected with pageRevisionColumns
+func scanPageRevision(row rowScanner) (*models.PageRevision, error) {
+	var revision models.PageRevision
+	var authorID sql.NullInt64
+	var authorName, note sql.NullString
+	snapshot := &revision.Snapshot
+	err := row.Scan(
+		&revision.ID, &revision.PageID, &revision.Revision, &snapshot.Slug, &snapshot.Title, &snapshot.MetaDescription,
+		&snapshot.Header, &snapshot.Navigation, &snapshot.MainContent, &snapshot.Sidebar, &snapshot.Footer,
+		&snapshot.CSSClass, &snapshot.Scripts, &snapshot.Template, &snapshot.ContentFormat, &snapshot.ReadGroups, &snapshot.WriteGroups,
+		&authorID, &authorName, &note, &revision.Created)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.ID = revision.PageID
+	revision.Author = models.PageAuthor{ID: int(authorID.Int64), Name: authorName.String}
+	revision.Note = note.String
+	return &revision, nil
 }
 
-// User and Group Management Functions
+// insertPageRevision records a snapshot of page as its next revision and returns the
+// revision number
+func insertPageRevision(tx *sql.Tx, page *models.Page, author models.PageAuthor, note string) (int, error) {
+	var revision int
+	err := tx.QueryRow("SELECT COALESCE(MAX(revision), 0) + 1 FROM _page_revision WHERE page_id = ? FOR UPDATE", page.ID).Scan(&revision)
+	if err != nil {
+		LogSQLError(err)
+		return 0, err
+	}
 
-func (d *Database) initializeUsers() error {
-	// Create default groups
-	groups := []models.Group{
-		{Name: "admin", Description: "Administrator group with full access"},
-		{Name: "customers", Description: "Customer group with limited access"},
-		{Name: "engineer", Description: "Engineer group with technical access"},
-		{Name: "everyone", Description: "Special group that includes all users including unauthenticated users"},
-	}
-
-	for _, group := range groups {
-		if err := d.createGroupIfNotExists(group); err != nil {
-			LogSQLError(err)
-			return err
-		}
+	authorID := sql.NullInt64{Int64: int64(author.ID), Valid: author.ID > 0}
+	_, err = tx.Exec(`
+		INSERT INTO _page_revision (page_id, revision, slug, title, meta_description, header, navigation, main_content, sidebar, footer, css_class, scripts, template, content_format, read_groups, write_groups, author_id, author_name, note)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		page.ID, revision, page.Slug, page.Title, page.MetaDescription, page.Header, page.Navigation,
+		page.MainContent, page.Sidebar, page.Footer, page.CSSClass, page.Scripts, page.Template, contentFormat(page),
+		page.ReadGroups, page.WriteGroups, authorID, author.Name, note)
+	if err != nil {
+		LogSQLError(err)
+		return 0, err
 	}
+	return revision, nil
+}
 
-	// Create default users with hashed passwords
-	users := []struct {
-		user   models.User
-		groups []string
-	}{
-		{
-			user: models.User{
-				Username: "admin",
-				Email:    "adminuser@servicecompany.net",
-				Password: "", // Will be set below
-			},
-			groups: []string{"admin"},
-		},
-		{
-			user: models.User{
-				Username: "customer",
-				Email:    "customeruser@company.com",
-				Password: "", // Will be set below
-			},
-			groups: []string{"customers"},
-		},
-		{
-			user: models.User{
-				Username: "engineer",
-				Email:    "engineeruser@servicecompany.net",
-				Password: "", // Will be set below
-			},
-			groups: []string{"engineer"},
-		},
+// GetPageRevisions returns a page's revisions, newest first
+func (d *Database) GetPageRevisions(pageID int) ([]models.PageRevision, error) {
+	rows, err := d.Query(`SELECT `+pageRevisionColumns+` FROM _page_revision WHERE page_id = ? ORDER BY revision DESC`, pageID)
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
 	}
+	defer rows.Close()
 
-	for _, userData := range users {
-		// Hash the password from environment
-		var plainPassword string
-		if userData.user.Username == "admin" {
-			plainPassword = os.Getenv("TEST_ADMIN_PASSWORD")
-		} else if userData.user.Username == "customer" {
-			plainPassword = os.Getenv("TEST_CUSTOMER_PASSWORD")
-		} else if userData.user.Username == "engineer" {
-			plainPassword = os.Getenv("TEST_ENGINEER_PASSWORD")
-			if plainPassword == "" {
-				plainPassword = "engineer" // Default password if not set in environment
-			}
-		}
-		
-		// Hash the password before storing
-		hashedPassword, err := auth.HashPassword(plainPassword)
+	var revisions []models.PageRevision
+	for rows.Next() {
+		revision, err := scanPageRevision(rows)
 		if err != nil {
-			return fmt.Errorf("failed to hash password for user %s: %w", userData.user.Username, err)
+			LogSQLError(err)
+			return nil, err
 		}
-		userData.user.Password = hashedPassword
-		
-		if err := d.createUserIfNotExists(userData.user, userData.groups); err != nil {
+		revisions = append(revisions, *revision)
+	}
+	return revisions, nil
+}
+
+// GetPageRevision returns one revision of a page, or sql.ErrNoRows
+func (d *Database) GetPageRevision(pageID, revision int) (*models.PageRevision, error) {
+	result, err := scanPageRevision(d.QueryRow(`SELECT `+pageRevisionColumns+` FROM _page_revision WHERE page_id = ? AND revision = ?`, pageID, revision))
+	if err != nil {
+		if err != sql.ErrNoRows {
 			LogSQLError(err)
-			return err
 		}
+		return nil, err
 	}
+	return result, nil
+}
 
-	return nil
+// RestorePageRevision makes an earlier revision the page's content again. The restore is
+// recorded as a new revision, so it can itself be undone; the new revision number is
+// returned.
+func (d *Database) RestorePageRevision(pageID, revision int, author models.PageAuthor) (int, error) {
+	restored, err := d.GetPageRevision(pageID, revision)
+	if err != nil {
+		return 0, err
+	}
+	return d.updatePage(&restored.Snapshot, author, fmt.Sprintf("Restored revision %d", revision))
 }
 
-func (d *Database) createGroupIfNotExists(group models.Group) error {
-	// Check if group exists
-	var count int
-	err := d.QueryRow("SELECT COUNT(*) FROM _group WHERE name = ?", group.Name).Scan(&count)
+// Page publishing operations
+
+// SetPageStatus moves a page to another state, returning sql.ErrNoRows when it does not
+// exist. Publishing makes the newest revision the one readers see and cancels a scheduled
+// publish; archiving takes the page down and cancels a scheduled unpublish, so it stays
+// down until published again.
+func (d *Database) SetPageStatus(pageID int, status string) error {
+	if !models.ValidPageStatus(status) {
+		return fmt.Errorf("invalid page status %q", status)
+	}
+
+	tx, err := d.Begin()
 	if err != nil {
 		LogSQLError(err)
 		return err
 	}
+	defer tx.Rollback()
 
-	if count == 0 {
-		_, err = d.Exec(`
-			INSERT INTO _group (name, description)
-			VALUES (?, ?)`,
-			group.Name, group.Description)
+	if _, err := lockPage(tx, pageID); err != nil {
+		return err
+	}
+	switch status {
+	case models.PageStatusPublished:
+		latest, err := latestPageRevision(tx, pageID)
 		if err != nil {
-			LogSQLError(err)
 			return err
 		}
+		_, err = tx.Exec("UPDATE _page SET status = ?, published_revision = ?, publish_at = NULL WHERE id = ?", status, latest, pageID)
+	case models.PageStatusArchived:
+		_, err = tx.Exec("UPDATE _page SET status = ?, published_revision = NULL, unpublish_at = NULL WHERE id = ?", status, pageID)
+	default:
+		_, err = tx.Exec("UPDATE _page SET status = ? WHERE id = ?", status, pageID)
+	}
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		LogSQLError(err)
+		return err
 	}
-
 	return nil
 }
 
-func (d *Database) createUserIfNotExists(user models.User, groupNames []string) error {
-	// Check if user exists
-	var count int
-	err := d.QueryRow("SELECT COUNT(*) FROM _user WHERE username = ?", user.Username).Scan(&count)
+// SchedulePage sets when a page is next published and archived automatically. A null time
+// cancels that part of the schedule.
+func (d *Database) SchedulePage(pageID int, publishAt, unpublishAt sql.NullTime) error {
+	result, err := d.Exec("UPDATE _page SET publish_at = ?, unpublish_at = ? WHERE id = ?", publishAt, unpublishAt, pageID)
 	if err != nil {
 		LogSQLError(err)
 		return err
 	}
-
-	if count == 0 {
-		// Create user
-		result, err := d.Exec(`
-			INSERT INTO _user (username, email, password)
-			VALUES (?, ?, ?)`,
-			user.Username, user.Email, user.Password)
-		if err != nil {
-			LogSQLError(err)
-			return err
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		var count int
+		if err := d.QueryRow("SELECT COUNT(*) FROM _page WHERE id = ?", pageID).Scan(&count); err == nil && count == 0 {
+			return sql.ErrNoRows
 		}
+	}
+	return nil
+}
 
-		userID, err := result.LastInsertId()
+// ProcessPageSchedules publishes the working draft of pages whose publish time has passed,
+// then archives pages whose unpublish time has passed. The slugs of the pages changed are
+// returned.
+func (d *Database) ProcessPageSchedules() (published, archived []string, err error) {
+	for _, step := range []struct {
+		column string
+		status string
+		slugs  *[]string
+	}{
+		{"publish_at", models.PageStatusPublished, &published},
+		{"unpublish_at", models.PageStatusArchived, &archived},
+	} {
+		due, err := d.duePages(step.column)
 		if err != nil {
-			LogSQLError(err)
-			return err
+			return published, archived, err
 		}
-
-		// Add user to groups
-		for _, groupName := range groupNames {
-			if err := d.addUserToGroup(int(userID), groupName); err != nil {
-				LogSQLError(err)
-				return err
+		for _, page := range due {
+			if err := d.SetPageStatus(page.ID, step.status); err != nil && err != sql.ErrNoRows {
+				return published, archived, err
 			}
+			*step.slugs = append(*step.slugs, page.Slug)
 		}
 	}
-
-	return nil
+	return published, archived, nil
 }
 
-func (d *Database) addUserToGroup(userID int, groupName string) error {
-	// Get group ID
-	var groupID int
-	err := d.QueryRow("SELECT id FROM _group WHERE name = ?", groupName).Scan(&groupID)
+// duePages returns the ID and slug of each page whose time in column has passed, earliest first
+func (d *Database) duePages(column string) ([]models.Page, error) {
+	rows, err := d.Query("SELECT id, slug FROM _page WHERE " + column + " <= NOW() ORDER BY " + column)
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	// Check if user is already in group
-	var count int
-	err = d.QueryRow("SELECT COUNT(*) FROM _user_and_group WHERE user_id = ? AND group_id = ?", userID, groupID).Scan(&count)
+	var due []models.Page
+	for rows.Next() {
+		var page models.Page
+		if err := rows.Scan(&page.ID, &page.Slug); err != nil {
+			LogSQLError(err)
+			return nil, err
+		}
+		due = append(due, page)
+	}
+	return due, rows.Err()
+}
+
+// GetPublishedPage returns the version of a page readers see, or sql.ErrNoRows when the page
+// has never been published or is archived
+func (d *Database) GetPublishedPage(slug string) (*models.Page, error) {
+	page, err := d.GetPage(slug)
 	if err != nil {
-		LogSQLError(err)
-		return err
+		return nil, err
 	}
+	return d.publishedVersion(page)
+}
 
-	if count == 0 {
-		_, err = d.Exec(`
-			INSERT INTO _user_and_group (user_id, group_id)
-			VALUES (?, ?)`,
-			userID, groupID)
+// GetPublishedPages returns the version readers see of every live page, ordered by slug
+func (d *Database) GetPublishedPages() ([]models.Page, error) {
+	pages, err := d.GetAllPages()
+	if err != nil {
+		return nil, err
+	}
+	var published []models.Page
+	for i := range pages {
+		page, err := d.publishedVersion(&pages[i])
+		if err == sql.ErrNoRows {
+			continue
+		}
 		if err != nil {
-			LogSQLError(err)
-			return err
+			return nil, err
 		}
+		published = append(published, *page)
 	}
+	return published, nil
+}
 
-	return nil
+// publishedVersion replaces a page's working draft with its published revision. The slug
+// addresses the page rather than a version, so it is always the current one. Read and
+// write groups control access rather than content, so they are always the live ones too.
+func (d *Database) publishedVersion(page *models.Page) (*models.Page, error) {
+	if !page.IsLive() {
+		return nil, sql.ErrNoRows
+	}
+	if !page.PublishedRevision.Valid {
+		return page, nil
+	}
+	revision, err := d.GetPageRevision(page.ID, int(page.PublishedRevision.Int64))
+	if err != nil {
+		return nil, err
+	}
+	published := revision.Snapshot
+	published.Slug = page.Slug
+	published.ReadGroups = page.ReadGroups
+	published.WriteGroups = page.WriteGroups
+	published.Status = page.Status
+	published.PublishedRevision = page.PublishedRevision
+	published.PublishAt = page.PublishAt
+	published.UnpublishAt = page.UnpublishAt
+	published.Created = page.Created
+	published.Modified = revision.Created
+	return &published, nil
 }
 
-// CreateUser creates a new user with a hashed password
-func (d *Database) CreateUser(username, email, password string) error {
-	// Hash the password before storing
-	hashedPassword, err := auth.HashPassword(password)
+// Session operations
+func (d *Database) CreateSession(userID int, username string, duration time.Duration) (*models.Session, error) {
+	return d.CreateSessionWithClient(userID, username, duration, duration, false, "", "")
+}
+
+// CreateSessionWithClient creates a session and records the client's IP address and user agent.
+// The session expires after idleTimeout without activity and never outlives absoluteLifetime.
+func (d *Database) CreateSessionWithClient(userID int, username string, idleTimeout, absoluteLifetime time.Duration, rememberMe bool, ipAddress, userAgent string) (*models.Session, error) {
+	return d.createSession(userID, username, idleTimeout, absoluteLifetime, rememberMe, ipAddress, userAgent, 0)
+}
+
+// CreateImpersonationSession creates a session for userID on behalf of the administrator
+// impersonatorID. The session is marked so it can be recognised and ended later.
+func (d *Database) CreateImpersonationSession(userID int, username string, impersonatorID int, idleTimeout, absoluteLifetime time.Duration, ipAddress, userAgent string) (*models.Session, error) {
+	return d.createSession(userID, username, idleTimeout, absoluteLifetime, false, ipAddress, userAgent, impersonatorID)
+}
+
+func (d *Database) createSession(userID int, username string, idleTimeout, absoluteLifetime time.Duration, rememberMe bool, ipAddress, userAgent string, impersonatorID int) (*models.Session, error) {
+	sessionID, err := generateSessionID()
 	if err != nil {
-		return fmt.Errorf("failed to hash password: %w", err)
+		return nil, err
 	}
 
-	// Create user
-	result, err := d.Exec(`
-		INSERT INTO _user (username, email, password)
-		VALUES (?, ?, ?)`,
-		username, email, hashedPassword)
+	now := time.Now()
+	absoluteExpiresAt := now.Add(absoluteLifetime)
+	expiresAt := now.Add(idleTimeout)
+	if expiresAt.After(absoluteExpiresAt) {
+		expiresAt = absoluteExpiresAt
+	}
+
+	_, err = d.Exec(`
+		INSERT INTO _session (session_id, user_id, username, expires_at, is_active, last_seen, ip_address, user_agent,
+		                      absolute_expires_at, remember_me, impersonator_id)
+		VALUES (?, ?, ?, ?, TRUE, ?, ?, ?, ?, ?, NULLIF(?, 0))`,
+		sessionID, userID, username, expiresAt, now, ipAddress, userAgent, absoluteExpiresAt, rememberMe, impersonatorID)
 	if err != nil {
 		LogSQLError(err)
-		return fmt.Errorf("failed to create user: %w", err)
+		return nil, err
 	}
 
-	userID, err := result.LastInsertId()
+	session := &models.Session{
+		SessionID:         sessionID,
+		UserID:            userID,
+		Username:          username,
+		CreatedAt:         now,
+		ExpiresAt:         expiresAt,
+		IsActive:          true,
+		LastSeen:          now,
+		IPAddress:         ipAddress,
+		UserAgent:         userAgent,
+		AbsoluteExpiresAt: absoluteExpiresAt,
+		RememberMe:        rememberMe,
+		ImpersonatorID:    impersonatorID,
+	}
+
+	return session, nil
+}
+
+func (d *Database) GetSession(sessionID string) (*models.Session, error) {
+	row := d.QueryRow(`
+		SELECT id, session_id, user_id, username, read_groups, write_groups, created, expires_at, is_active,
+		       last_seen, ip_address, user_agent, absolute_expires_at, remember_me, rotate_pending, impersonator_id
+		FROM _session WHERE session_id = ? AND is_active = TRUE AND expires_at > NOW()`,
+		sessionID)
+	return scanSession(row)
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanSession scans a session row selected with the standard session column list
+func scanSession(row rowScanner) (*models.Session, error) {
+	var session models.Session
+	var readGroups, writeGroups, ipAddress, userAgent sql.NullString
+	var lastSeen, absoluteExpiresAt sql.NullTime
+	var rememberMe, rotatePending sql.NullBool
+	var impersonatorID sql.NullInt64
+	err := row.Scan(
+		&session.ID, &session.SessionID, &session.UserID, &session.Username,
+		&readGroups, &writeGroups, &session.CreatedAt, &session.ExpiresAt, &session.IsActive,
+		&lastSeen, &ipAddress, &userAgent, &absoluteExpiresAt, &rememberMe, &rotatePending, &impersonatorID)
 	if err != nil {
 		LogSQLError(err)
-		return fmt.Errorf("failed to get user ID: %w", err)
+		return nil, err
 	}
 
-	// Add user to default group (customers)
-	if err := d.addUserToGroup(int(userID), "customers"); err != nil {
-		LogSQLError(err)
-		return fmt.Errorf("failed to add user to default group: %w", err)
+	// Handle NULL values
+	session.ReadGroups = readGroups.String
+	session.WriteGroups = writeGroups.String
+	session.IPAddress = ipAddress.String
+	session.UserAgent = userAgent.String
+	if lastSeen.Valid {
+		session.LastSeen = lastSeen.Time
+	} else {
+		session.LastSeen = session.CreatedAt
+	}
+	if absoluteExpiresAt.Valid {
+		session.AbsoluteExpiresAt = absoluteExpiresAt.Time
+	} else {
+		session.AbsoluteExpiresAt = session.ExpiresAt
 	}
+	session.RememberMe = rememberMe.Bool
+	session.RotatePending = rotatePending.Bool
+	session.ImpersonatorID = int(impersonatorID.Int64)
 
-	return nil
+	return &session, nil
 }
 
-// UpdateUserPassword updates a user's password with a new hash
-func (d *Database) UpdateUserPassword(userID int, newPassword string) error {
-	// Hash the new password
-	hashedPassword, err := auth.HashPassword(newPassword)
+// RenewSession records activity and slides the idle expiry forward, capped at the absolute expiry
+func (d *Database) RenewSession(sessionID string, idleTimeout time.Duration) (time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(idleTimeout)
+	_, err := d.Exec(`
+		UPDATE _session SET last_seen = ?, expires_at = LEAST(?, COALESCE(absolute_expires_at, expires_at))
+		WHERE session_id = ? AND is_active = TRUE`,
+		now, expiresAt, sessionID)
 	if err != nil {
-		return fmt.Errorf("failed to hash password: %w", err)
+		LogSQLError(err)
+		return time.Time{}, err
 	}
+	return expiresAt, nil
+}
 
-	// Update the password in database
-	_, err = d.Exec("UPDATE _user SET password = ? WHERE id = ?", hashedPassword, userID)
+// RotateSessionID replaces a session's identifier, keeping the session itself, and clears any pending rotation
+func (d *Database) RotateSessionID(sessionID string) (string, error) {
+	newSessionID, err := generateSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	result, err := d.Exec(`
+		UPDATE _session SET session_id = ?, rotate_pending = FALSE
+		WHERE session_id = ? AND is_active = TRUE`,
+		newSessionID, sessionID)
 	if err != nil {
 		LogSQLError(err)
-		return fmt.Errorf("failed to update password: %w", err)
+		return "", err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if rowsAffected == 0 {
+		return "", fmt.Errorf("session not found")
 	}
+	return newSessionID, nil
+}
 
+// MarkUserSessionsForRotation flags a user's sessions so their IDs are rotated on next use,
+// called whenever the user's privileges change
+func (d *Database) MarkUserSessionsForRotation(userID int) error {
+	d.invalidateUserAccess(userID)
+	_, err := d.Exec(`
+		UPDATE _session SET rotate_pending = TRUE WHERE user_id = ? AND is_active = TRUE`,
+		userID)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
 	return nil
 }
 
-func (d *Database) AuthenticateUser(username, password string) (*models.User, error) {
-	var user models.User
-	err := d.QueryRow(`
-		SELECT id, username, email, password, read_groups, write_groups, created, modified
-		FROM _user WHERE username = ?`,
-		username).Scan(
-		&user.ID, &user.Username, &user.Email, &user.Password,
-		&user.ReadGroups, &user.WriteGroups, &user.CreatedAt, &user.UpdatedAt)
+// GetActiveUserSessions returns all active sessions for a user, most recently used first
+func (d *Database) GetActiveUserSessions(userID int) ([]models.Session, error) {
+	return d.queryActiveSessions(`
+		SELECT id, session_id, user_id, username, read_groups, write_groups, created, expires_at, is_active,
+		       last_seen, ip_address, user_agent, absolute_expires_at, remember_me, rotate_pending, impersonator_id
+		FROM _session WHERE user_id = ? AND is_active = TRUE AND expires_at > NOW()
+		ORDER BY COALESCE(last_seen, created) DESC`, userID)
+}
+
+// GetAllActiveSessions returns every active session, grouped by user
+func (d *Database) GetAllActiveSessions() ([]models.Session, error) {
+	return d.queryActiveSessions(`
+		SELECT id, session_id, user_id, username, read_groups, write_groups, created, expires_at, is_active,
+		       last_seen, ip_address, user_agent, absolute_expires_at, remember_me, rotate_pending, impersonator_id
+		FROM _session WHERE is_active = TRUE AND expires_at > NOW()
+		ORDER BY username, COALESCE(last_seen, created) DESC`)
+}
+
+func (d *Database) queryActiveSessions(query string, args ...interface{}) ([]models.Session, error) {
+	rows, err := d.Query(query, args...)
 	if err != nil {
 		LogSQLError(err)
 		return nil, err
 	}
+	defer rows.Close()
 
-	// Check if password is in plain text (for migration)
-	if !auth.IsHashFormat(user.Password) {
-		// Migrate plain text password to hash
-		if user.Password == password {
-			// Password matches plain text, migrate to hash
-			hashedPassword, err := auth.HashPassword(password)
-			if err != nil {
-				return nil, fmt.Errorf("failed to hash password: %w", err)
-			}
-			
-			// Update the password in database
-			_, err = d.Exec("UPDATE _user SET password = ? WHERE id = ?", hashedPassword, user.ID)
-			if err != nil {
-				LogSQLError(err)
-				return nil, fmt.Errorf("failed to update password hash: %w", err)
-			}
-			
-			user.Password = hashedPassword
-			return &user, nil
+	var sessions []models.Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
 		}
-		return nil, fmt.Errorf("invalid password")
+		sessions = append(sessions, *session)
 	}
+	return sessions, nil
+}
 
-	// Verify password against hash
-	valid, err := auth.CheckPassword(password, user.Password)
+func (d *Database) InvalidateSession(sessionID string) error {
+	_, err := d.Exec(`
+		UPDATE _session SET is_active = FALSE WHERE session_id = ?`,
+		sessionID)
 	if err != nil {
 		LogSQLError(err)
-		return nil, fmt.Errorf("failed to verify password: %w", err)
-	}
-	
-	if !valid {
-		return nil, fmt.Errorf("invalid password")
+		return err
 	}
-
-	return &user, nil
+	return nil
 }
 
-func (d *Database) GetUserByID(userID int) (*models.User, error) {
-	var user models.User
-	err := d.QueryRow(`
-		SELECT id, username, email, password, read_groups, write_groups, created, modified
-		FROM _user WHERE id = ?`,
-		userID).Scan(
-		&user.ID, &user.Username, &user.Email, &user.Password,
-		&user.ReadGroups, &user.WriteGroups, &user.CreatedAt, &user.UpdatedAt)
+// InvalidateUserSession revokes a single session by its row ID, scoped to the owning user
+func (d *Database) InvalidateUserSession(userID, id int) error {
+	result, err := d.Exec(`
+		UPDATE _session SET is_active = FALSE WHERE id = ? AND user_id = ?`,
+		id, userID)
 	if err != nil {
 		LogSQLError(err)
-		return nil, err
+		return err
 	}
-	return &user, nil
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("session not found")
+	}
+	return nil
 }
 
-func (d *Database) GetUserGroups(userID int) ([]models.Group, error) {
-	rows, err := d.Query(`
-		SELECT g.id, g.name, g.description, g.read_groups, g.write_groups, g.created
-		FROM _group g
-		JOIN _user_and_group ug ON g.id = ug.group_id
-		WHERE ug.user_id = ?
-		ORDER BY g.name`,
+// InvalidateAllUserSessions revokes every session belonging to a user
+func (d *Database) InvalidateAllUserSessions(userID int) error {
+	_, err := d.Exec(`
+		UPDATE _session SET is_active = FALSE WHERE user_id = ? AND is_active = TRUE`,
 		userID)
 	if err != nil {
 		LogSQLError(err)
-		return nil, err
-	}
-	defer rows.Close()
-
-	var groups []models.Group
-	for rows.Next() {
-		var group models.Group
-		err := rows.Scan(
-			&group.ID, &group.Name, &group.Description, &group.ReadGroups, &group.WriteGroups, &group.CreatedAt)
-		if err != nil {
-			LogSQLError(err)
-			return nil, err
-		}
-		groups = append(groups, group)
+		return err
 	}
-	return groups, nil
+	return nil
 }
 
-func (d *Database) IsUserInGroup(userID int, groupName string) (bool, error) {
-	var count int
-	err := d.QueryRow(`
-		SELECT COUNT(*)
-		FROM _user_and_group ug
-		JOIN _group g ON ug.group_id = g.id
-		WHERE ug.user_id = ? AND g.name = ?`,
-		userID, groupName).Scan(&count)
+func (d *Database) CleanupExpiredSessions() error {
+	_, err := d.Exec(`
+		UPDATE _session SET is_active = FALSE WHERE expires_at <= NOW()`)
 	if err != nil {
 		LogSQLError(err)
-		return false, err
+		return err
 	}
-	return count > 0, nil
+	return nil
 }
 
-func (d *Database) GetAllGroups() ([]models.Group, error) {
-	rows, err := d.Query(`
-		SELECT id, name, description, read_groups, write_groups, created
-		FROM _group
-		ORDER BY name`)
-	if err != nil {
-		LogSQLError(err)
-		return nil, err
+func generateSessionID() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
 	}
-	defer rows.Close()
+	return hex.EncodeToString(bytes), nil
+}
 
-	var groups []models.Group
-	for rows.Next() {
-		var group models.Group
-		err := rows.Scan(
-			&group.ID, &group.Name, &group.Description, &group.ReadGroups, &group.WriteGroups, &group.CreatedAt)
-		if err != nil {
+// Exported for testing
+func GenerateSessionIDForTest() (string, error) {
+	return generateSessionID()
+}
+
+// User and Group Management Functions
+
+func (d *Database) initializeUsers() error {
+	// Create default groups
+	groups := []models.Group{
+		{Name: "admin", Description: "Administrator group with full access"},
+		{Name: "customers", Description: "Customer group with limited access"},
+		{Name: "engineer", Description: "Engineer group with technical access"},
+		{Name: "everyone", Description: "Special group that includes all users including unauthenticated users"},
+	}
+
+	for _, group := range groups {
+		if err := d.createGroupIfNotExists(group); err != nil {
 			LogSQLError(err)
-			return nil, err
+			return err
 		}
-		groups = append(groups, group)
 	}
-	return groups, nil
-}
 
-func (d *Database) GetAllUsers() ([]models.User, error) {
-	rows, err := d.Query(`
-		SELECT id, username, email, password, read_groups, write_groups, created, modified
-		FROM _user
-		ORDER BY username`)
-	if err != nil {
-		LogSQLError(err)
-		return nil, err
+	// Create default users with hashed passwords
+	users := []struct {
+		user   models.User
+		groups []string
+	}{
+		{
+			user: models.User{
+				Username: "admin",
+				Email:    "adminuser@servicecompany.net",
+				Password: "", // Will be set below
+			},
+			groups: []string{"admin"},
+		},
+		{
+			user: models.User{
+				Username: "customer",
+				Email:    "customeruser@company.com",
+				Password: "", // Will be set below
+			},
+			groups: []string{"customers"},
+		},
+		{
+			user: models.User{
+				Username: "engineer",
+				Email:    "engineeruser@servicecompany.net",
+				Password: "", // Will be set below
+			},
+			groups: []string{"engineer"},
+		},
 	}
-	defer rows.Close()
 
-	var users []models.User
-	for rows.Next() {
-		var user models.User
-		err := rows.Scan(
-			&user.ID, &user.Username, &user.Email, &user.Password,
-			&user.ReadGroups, &user.WriteGroups, &user.CreatedAt, &user.UpdatedAt)
+	for _, userData := range users {
+		// Hash the password from environment
+		var plainPassword string
+		if userData.user.Username == "admin" {
+			plainPassword = os.Getenv("TEST_ADMIN_PASSWORD")
+		} else if userData.user.Username == "customer" {
+			plainPassword = os.Getenv("TEST_CUSTOMER_PASSWORD")
+		} else if userData.user.Username == "engineer" {
+			plainPassword = os.Getenv("TEST_ENGINEER_PASSWORD")
+			if plainPassword == "" {
+				plainPassword = "engineer" // Default password if not set in environment
+			}
+		}
+		
+		// Hash the password before storing
+		hashedPassword, err := auth.HashPassword(plainPassword)
 		if err != nil {
+			return fmt.Errorf("failed to hash password for user %s: %w", userData.user.Username, err)
+		}
+		userData.user.Password = hashedPassword
+		
+		if err := d.createUserIfNotExists(userData.user, userData.groups); err != nil {
 			LogSQLError(err)
-			return nil, err
+			return err
 		}
-		users = append(users, user)
 	}
-	return users, nil
-} 
-
-// Metadata operations
 
-// GetTableMetadata retrieves metadata for a specific table
-func (d *Database) GetTableMetadata(tableName string) (*models.TableMetadata, error) {
-	var metadata models.TableMetadata
-	err := d.QueryRow(`
-		SELECT id, table_name, display_name, description, read_groups, write_groups, created, modified
-		FROM _table_metadata WHERE table_name = ?`,
-		tableName).Scan(
-		&metadata.ID, &metadata.TableName, &metadata.DisplayName, &metadata.Description,
-		&metadata.ReadGroups, &metadata.WriteGroups, &metadata.CreatedAt, &metadata.UpdatedAt)
-	if err != nil {
-		LogSQLError(err)
-		return nil, err
-	}
-	return &metadata, nil
+	return nil
 }
 
-// GetAllTableMetadata retrieves metadata for all tables
-func (d *Database) GetAllTableMetadata() ([]models.TableMetadata, error) {
-	rows, err := d.Query(`
-		SELECT id, table_name, display_name, description, read_groups, write_groups, created, modified
-		FROM _table_metadata ORDER BY table_name`)
+func (d *Database) createGroupIfNotExists(group models.Group) error {
+	// Check if group exists
+	var count int
+	err := d.QueryRow("SELECT COUNT(*) FROM _group WHERE name = ?", group.Name).Scan(&count)
 	if err != nil {
 		LogSQLError(err)
-		return nil, err
+		return err
 	}
-	defer rows.Close()
 
-	var metadata []models.TableMetadata
-	for rows.Next() {
-		var item models.TableMetadata
-		err := rows.Scan(
-			&item.ID, &item.TableName, &item.DisplayName, &item.Description,
-			&item.ReadGroups, &item.WriteGroups, &item.CreatedAt, &item.UpdatedAt)
+	if count == 0 {
+		_, err = d.Exec(`
+			INSERT INTO _group (name, description)
+			VALUES (?, ?)`,
+			group.Name, group.Description)
 		if err != nil {
 			LogSQLError(err)
-			return nil, err
+			return err
 		}
-		metadata = append(metadata, item)
 	}
-	return metadata, nil
+
+	return nil
 }
 
-// CreateTableMetadata creates new table metadata
-func (d *Database) CreateTableMetadata(metadata *models.TableMetadata) error {
-	_, err := d.Exec(`
-		INSERT INTO _table_metadata (table_name, display_name, description, read_groups, write_groups)
-		VALUES (?, ?, ?, ?, ?)`,
-		metadata.TableName, metadata.DisplayName, metadata.Description,
-		metadata.ReadGroups, metadata.WriteGroups)
+func (d *Database) createUserIfNotExists(user models.User, groupNames []string) error {
+	// Check if user exists
+	var count int
+	err := d.QueryRow("SELECT COUNT(*) FROM _user WHERE username = ?", user.Username).Scan(&count)
 	if err != nil {
 		LogSQLError(err)
 		return err
 	}
+
+	if count == 0 {
+		// Create user
+		result, err := d.Exec(`
+			INSERT INTO _user (username, email, password)
+			VALUES (?, ?, ?)`,
+			user.Username, user.Email, user.Password)
+		if err != nil {
+			LogSQLError(err)
+			return err
+		}
+
+		userID, err := result.LastInsertId()
+		if err != nil {
+			LogSQLError(err)
+			return err
+		}
+
+		// Add user to groups
+		for _, groupName := range groupNames {
+			if err := d.addUserToGroup(int(userID), groupName); err != nil {
+				LogSQLError(err)
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
-// UpdateTableMetadata updates existing table metadata
-func (d *Database) UpdateTableMetadata(metadata *models.TableMetadata) error {
-	_, err := d.Exec(`
-		UPDATE _table_metadata 
-		SET display_name = ?, description = ?, read_groups = ?, write_groups = ?
-		WHERE table_name = ?`,
-		metadata.DisplayName, metadata.Description, metadata.ReadGroups, metadata.WriteGroups, metadata.TableName)
+func (d *Database) addUserToGroup(userID int, groupName string) error {
+	// Get group ID
+	var groupID int
+	err := d.QueryRow("SELECT id FROM _group WHERE name = ?", groupName).Scan(&groupID)
 	if err != nil {
 		LogSQLError(err)
 		return err
 	}
-	return nil
-}
 
-// GetFieldMetadata retrieves metadata for fields of a specific table
-func (d *Database) GetFieldMetadata(tableName string) ([]models.FieldMetadata, error) {
-	rows, err := d.Query(`
-		SELECT id, table_name, field_name, display_name, description, db_type, html_input_type,
-		       form_position, list_position, is_required, is_read_only, default_value, validation_rules,
-		       created, modified
-		FROM _field_metadata WHERE table_name = ? ORDER BY form_position, field_name`,
-		tableName)
+	// Check if user is already in group
+	var count int
+	err = d.QueryRow("SELECT COUNT(*) FROM _user_and_group WHERE user_id = ? AND group_id = ?", userID, groupID).Scan(&count)
 	if err != nil {
 		LogSQLError(err)
-		return nil, err
+		return err
 	}
-	defer rows.Close()
 
-	var metadata []models.FieldMetadata
-	for rows.Next() {
-		var item models.FieldMetadata
-		err := rows.Scan(
-			&item.ID, &item.TableName, &item.FieldName, &item.DisplayName, &item.Description,
-			&item.DBType, &item.HTMLInputType, &item.FormPosition, &item.ListPosition,
-			&item.IsRequired, &item.IsReadOnly, &item.DefaultValue, &item.ValidationRules,
-			&item.CreatedAt, &item.UpdatedAt)
+	if count == 0 {
+		_, err = d.Exec(`
+			INSERT INTO _user_and_group (user_id, group_id)
+			VALUES (?, ?)`,
+			userID, groupID)
 		if err != nil {
 			LogSQLError(err)
-			return nil, err
+			return err
+		}
+
+		// Privileges changed, so existing session IDs must not carry over
+		if err := d.MarkUserSessionsForRotation(userID); err != nil {
+			return err
 		}
-		metadata = append(metadata, item)
 	}
-	return metadata, nil
+
+	return nil
 }
 
-// GetFieldMetadataByField retrieves metadata for a specific field
-func (d *Database) GetFieldMetadataByField(tableName, fieldName string) (*models.FieldMetadata, error) {
-	var metadata models.FieldMetadata
-	err := d.QueryRow(`
-		SELECT id, table_name, field_name, display_name, description, db_type, html_input_type,
-		       form_position, list_position, is_required, is_read_only, default_value, validation_rules,
-		       created, modified
-		FROM _field_metadata WHERE table_name = ? AND field_name = ?`,
-		tableName, fieldName).Scan(
-		&metadata.ID, &metadata.TableName, &metadata.FieldName, &metadata.DisplayName, &metadata.Description,
-		&metadata.DBType, &metadata.HTMLInputType, &metadata.FormPosition, &metadata.ListPosition,
-		&metadata.IsRequired, &metadata.IsReadOnly, &metadata.DefaultValue, &metadata.ValidationRules,
-		&metadata.CreatedAt, &metadata.UpdatedAt)
+// defaultUserRecordGroups are the groups that may read and change a user record. Existing
+// rows are given them by the schema migration and new accounts get them when created.
+const defaultUserRecordGroups = `["admin", "engineer"]`
+
+// ErrGroupMissing is returned when a new account should join a group that does not exist
+var ErrGroupMissing = errors.New("group does not exist")
+
+// addNewUserToGroup adds a user created in tx to a group. A missing group is an error, so
+// the account is never created without it.
+func addNewUserToGroup(tx *sql.Tx, userID int, groupName string) error {
+	var groupID int
+	err := tx.QueryRow("SELECT id FROM _group WHERE name = ?", groupName).Scan(&groupID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %s", ErrGroupMissing, groupName)
+	}
 	if err != nil {
 		LogSQLError(err)
-		return nil, err
+		return err
 	}
-	return &metadata, nil
-}
 
-// CreateFieldMetadata creates new field metadata
-func (d *Database) CreateFieldMetadata(metadata *models.FieldMetadata) error {
-	// Start a transaction
-	tx, err := d.Begin()
+	_, err = tx.Exec("INSERT INTO _user_and_group (user_id, group_id) VALUES (?, ?)", userID, groupID)
 	if err != nil {
 		LogSQLError(err)
 		return err
 	}
-	defer tx.Rollback()
+	return nil
+}
 
-	// Check if field already exists in the database
-	exists, err := d.fieldExists(metadata.TableName, metadata.FieldName)
+// CreateUser creates a new user with a hashed password
+func (d *Database) CreateUser(username, email, password string) error {
+	// Add user to default group (customers)
+	_, err := d.CreateUserWithGroups(username, email, password, []string{"customers"})
+	return err
+}
+
+// CreateUserWithGroups creates an active user with a hashed password and adds them to each group
+func (d *Database) CreateUserWithGroups(username, email, password string, groupNames []string) (int, error) {
+	if err := d.passwordPolicy.Validate(password, username, email); err != nil {
+		return 0, err
+	}
+
+	// Hash the password before storing
+	hashedPassword, err := auth.HashPassword(password)
 	if err != nil {
-		return err
+		return 0, fmt.Errorf("failed to hash password: %w", err)
 	}
 
-	// If field doesn't exist in the database, add it
-	if !exists {
-		// Skip management fields that are handled separately
-		if metadata.FieldName != "id" && metadata.FieldName != "created" && metadata.FieldName != "modified" && 
-		   metadata.FieldName != "read_groups" && metadata.FieldName != "write_groups" {
-			
-			// Add the field to the actual table
-			alterSQL := "ALTER TABLE `" + metadata.TableName + "` ADD COLUMN `" + metadata.FieldName + "` " + metadata.DBType
-			
-			if metadata.IsRequired {
-				alterSQL += " NOT NULL"
-			} else {
-				alterSQL += " NULL"
-			}
-			
-			if metadata.DefaultValue != "" {
-				alterSQL += " DEFAULT '" + metadata.DefaultValue + "'"
-			}
-			
-			_, err = tx.Exec(alterSQL)
-			if err != nil {
-				LogSQLError(err)
-				return err
-			}
-		}
+	// Create user
+	result, err := d.Exec(`
+		INSERT INTO _user (username, email, password)
+		VALUES (?, ?, ?)`,
+		username, email, hashedPassword)
+	if err != nil {
+		LogSQLError(err)
+		return 0, fmt.Errorf("failed to create user: %w", err)
 	}
 
-	// Insert the field metadata
-	_, err = tx.Exec(`
-		INSERT INTO _field_metadata (table_name, field_name, display_name, description, db_type, html_input_type,
-		                           form_position, list_position, is_required, is_read_only, default_value, validation_rules)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		metadata.TableName, metadata.FieldName, metadata.DisplayName, metadata.Description,
-		metadata.DBType, metadata.HTMLInputType, metadata.FormPosition, metadata.ListPosition,
-		metadata.IsRequired, metadata.IsReadOnly, metadata.DefaultValue, metadata.ValidationRules)
+	userID, err := result.LastInsertId()
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return 0, fmt.Errorf("failed to get user ID: %w", err)
 	}
 
-	// Commit the transaction
-	return tx.Commit()
+	for _, groupName := range groupNames {
+		if err := d.addUserToGroup(int(userID), groupName); err != nil {
+			LogSQLError(err)
+			return 0, fmt.Errorf("failed to add user to group %s: %w", groupName, err)
+		}
+	}
+
+	return int(userID), nil
 }
 
-// UpdateFieldMetadata updates existing field metadata
-func (d *Database) UpdateFieldMetadata(metadata *models.FieldMetadata) error {
-	// Start a transaction
+// UpdateUserPassword updates a user's password with a new hash. Callers revoke the user's
+// sessions through the session store, so cached sessions end too.
+func (d *Database) UpdateUserPassword(userID int, newPassword string) error {
 	tx, err := d.Begin()
 	if err != nil {
 		LogSQLError(err)
@@ -1160,475 +1703,2173 @@ func (d *Database) UpdateFieldMetadata(metadata *models.FieldMetadata) error {
 	}
 	defer tx.Rollback()
 
-	// Get the current field metadata to compare changes
-	var currentMetadata models.FieldMetadata
-	err = tx.QueryRow(`
-		SELECT table_name, field_name, display_name, description, db_type, html_input_type,
-		       form_position, list_position, is_required, is_read_only, default_value, validation_rules
-		FROM _field_metadata 
-		WHERE table_name = ? AND field_name = ?`,
-		metadata.TableName, metadata.FieldName).Scan(
-		&currentMetadata.TableName, &currentMetadata.FieldName, &currentMetadata.DisplayName, &currentMetadata.Description,
-		&currentMetadata.DBType, &currentMetadata.HTMLInputType, &currentMetadata.FormPosition, &currentMetadata.ListPosition,
-		&currentMetadata.IsRequired, &currentMetadata.IsReadOnly, &currentMetadata.DefaultValue, &currentMetadata.ValidationRules)
+	if err := d.setUserPassword(tx, userID, newPassword); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		LogSQLError(err)
+		return err
+	}
+	return nil
+}
+
+// setUserPassword checks a new password against the policy and stores its hash in tx. The
+// user's row stays locked until tx ends, so concurrent changes cannot both pass the reuse check.
+func (d *Database) setUserPassword(tx *sql.Tx, userID int, newPassword string) error {
+	var username, email, currentHash string
+	err := tx.QueryRow("SELECT username, email, password FROM _user WHERE id = ? FOR UPDATE", userID).Scan(&username, &email, &currentHash)
 	if err != nil {
 		LogSQLError(err)
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+
+	// Enforce the password policy, including reuse of recent passwords
+	if err := d.passwordPolicy.Validate(newPassword, username, email); err != nil {
 		return err
 	}
+	if d.passwordPolicy != nil && d.passwordPolicy.HistorySize > 0 {
+		previousHashes, err := d.getPasswordHistory(tx, userID, d.passwordPolicy.HistorySize-1)
+		if err != nil {
+			return err
+		}
+		if err := d.passwordPolicy.ReusedPassword(newPassword, append([]string{currentHash}, previousHashes...)); err != nil {
+			return err
+		}
+	}
 
-	// Check if DBType has changed and update the database schema if needed
-	if currentMetadata.DBType != metadata.DBType || currentMetadata.IsRequired != metadata.IsRequired || 
-	   currentMetadata.DefaultValue != metadata.DefaultValue {
-		
-		// Skip management fields that shouldn't be modified
-		if metadata.FieldName != "id" && metadata.FieldName != "created" && metadata.FieldName != "modified" && 
-		   metadata.FieldName != "read_groups" && metadata.FieldName != "write_groups" {
-			
-			// Check if this field has indexes that need to be handled
-			indexes, err := d.getFieldIndexes(metadata.TableName, metadata.FieldName)
-			if err != nil {
-				LogSQLError(err)
-				return err
-			}
-			
-			// Drop indexes if they exist and we're changing to TEXT/BLOB type
-			if len(indexes) > 0 && (strings.Contains(strings.ToUpper(metadata.DBType), "TEXT") || 
-			   strings.Contains(strings.ToUpper(metadata.DBType), "BLOB")) {
-				for _, indexName := range indexes {
-					dropIndexSQL := "ALTER TABLE `" + metadata.TableName + "` DROP INDEX `" + indexName + "`"
-					_, err = tx.Exec(dropIndexSQL)
-					if err != nil {
-						LogSQLError(err)
-						return err
-					}
-				}
-			}
-			
-			// Update the field in the actual table
-			alterSQL := "ALTER TABLE `" + metadata.TableName + "` MODIFY COLUMN `" + metadata.FieldName + "` " + metadata.DBType
-			
-			if metadata.IsRequired {
-				alterSQL += " NOT NULL"
-			} else {
-				alterSQL += " NULL"
-			}
-			
-			if metadata.DefaultValue != "" {
-				alterSQL += " DEFAULT '" + metadata.DefaultValue + "'"
-			}
-			
-			_, err = tx.Exec(alterSQL)
-			if err != nil {
-				LogSQLError(err)
-				return err
-			}
-			
-			// Recreate indexes if they were dropped and we're not changing to TEXT/BLOB
-			if len(indexes) > 0 && !strings.Contains(strings.ToUpper(metadata.DBType), "TEXT") && 
-			   !strings.Contains(strings.ToUpper(metadata.DBType), "BLOB") {
-				for _, indexName := range indexes {
-					// For now, recreate as simple index - in a production system you might want to preserve the exact index type
-					createIndexSQL := "ALTER TABLE `" + metadata.TableName + "` ADD INDEX `" + indexName + "` (`" + metadata.FieldName + "`)"
-					_, err = tx.Exec(createIndexSQL)
-					if err != nil {
-						LogSQLError(err)
-						return err
-					}
-				}
-			}
-		}
+	// Hash the new password
+	hashedPassword, err := auth.HashPassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
 	}
 
-	// Update the field metadata
-	_, err = tx.Exec(`
-		UPDATE _field_metadata 
-		SET display_name = ?, description = ?, db_type = ?, html_input_type = ?,
-		    form_position = ?, list_position = ?, is_required = ?, is_read_only = ?,
-		    default_value = ?, validation_rules = ?
-		WHERE table_name = ? AND field_name = ?`,
-		metadata.DisplayName, metadata.Description, metadata.DBType, metadata.HTMLInputType,
-		metadata.FormPosition, metadata.ListPosition, metadata.IsRequired, metadata.IsReadOnly,
-		metadata.DefaultValue, metadata.ValidationRules, metadata.TableName, metadata.FieldName)
+	// Update the password in database
+	_, err = tx.Exec("UPDATE _user SET password = ? WHERE id = ?", hashedPassword, userID)
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return fmt.Errorf("failed to update password: %w", err)
 	}
 
-	// Commit the transaction
-	return tx.Commit()
+	// Remember the old password so it cannot be reused
+	return d.recordPasswordHistory(tx, userID, currentHash)
 }
 
-// GetTableRows retrieves rows from a specific table with pagination
-func (d *Database) GetTableRows(tableName string, page, pageSize int) ([]models.TableRow, int, error) {
-	// Get total count
-	var total int
-	err := d.QueryRow("SELECT COUNT(*) FROM `"+tableName+"`").Scan(&total)
-	if err != nil {
-		LogSQLError(err)
-		return nil, 0, err
+// getPasswordHistory returns up to limit of a user's previous password hashes, newest first
+func (d *Database) getPasswordHistory(tx *sql.Tx, userID, limit int) ([]string, error) {
+	if limit <= 0 {
+		return nil, nil
 	}
-
-	// Get rows with pagination
-	offset := (page - 1) * pageSize
-	rows, err := d.Query("SELECT * FROM `"+tableName+"` LIMIT ? OFFSET ?", pageSize, offset)
+	rows, err := tx.Query(`
+		SELECT password FROM _password_history
+		WHERE user_id = ? ORDER BY created DESC, id DESC LIMIT ?`,
+		userID, limit)
 	if err != nil {
 		LogSQLError(err)
-		return nil, 0, err
+		return nil, err
 	}
 	defer rows.Close()
 
-	// Get column names
-	columns, err := rows.Columns()
-	if err != nil {
-		LogSQLError(err)
-		return nil, 0, err
-	}
-
-	var tableRows []models.TableRow
+	var hashes []string
 	for rows.Next() {
-		// Create a slice of interface{} to hold the values
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for i := range values {
-			valuePtrs[i] = &values[i]
-		}
-
-		// Scan the row
-		err := rows.Scan(valuePtrs...)
-		if err != nil {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
 			LogSQLError(err)
-			return nil, 0, err
-		}
-
-		// Convert to map
-		rowData := make(map[string]interface{})
-		for i, col := range columns {
-			val := values[i]
-			if val != nil {
-				// Convert []uint8 to string for display
-				if b, ok := val.([]uint8); ok {
-					rowData[col] = string(b)
-				} else {
-					rowData[col] = val
-				}
-			}
-		}
-
-		// Get ID if it exists
-		id := 0
-		if idVal, exists := rowData["id"]; exists {
-			if idInt, ok := idVal.(int64); ok {
-				id = int(idInt)
-			} else if idInt, ok := idVal.(int); ok {
-				id = idInt
-			}
+			return nil, err
 		}
-
-		tableRows = append(tableRows, models.TableRow{
-			ID:   id,
-			Data: rowData,
-		})
+		hashes = append(hashes, hash)
 	}
-
-	return tableRows, total, nil
+	return hashes, nil
 }
 
-// GetTableRow retrieves a specific row from a table
-func (d *Database) GetTableRow(tableName string, id int) (*models.TableRow, error) {
-	rows, err := d.Query("SELECT * FROM `"+tableName+"` WHERE id = ?", id)
+// recordPasswordHistory stores a replaced password hash and prunes entries beyond the policy's history size
+func (d *Database) recordPasswordHistory(tx *sql.Tx, userID int, passwordHash string) error {
+	if d.passwordPolicy == nil || d.passwordPolicy.HistorySize <= 0 || passwordHash == "" {
+		return nil
+	}
+
+	_, err := tx.Exec("INSERT INTO _password_history (user_id, password) VALUES (?, ?)", userID, passwordHash)
 	if err != nil {
 		LogSQLError(err)
-		return nil, err
+		return fmt.Errorf("failed to record password history: %w", err)
 	}
-	defer rows.Close()
 
-	if !rows.Next() {
-		return nil, fmt.Errorf("row not found")
+	// The current password counts towards the history, so keep one fewer old hash
+	_, err = tx.Exec(`
+		DELETE FROM _password_history WHERE user_id = ? AND id NOT IN (
+			SELECT id FROM (
+				SELECT id FROM _password_history WHERE user_id = ? ORDER BY created DESC, id DESC LIMIT ?
+			) AS recent
+		)`,
+		userID, userID, d.passwordPolicy.HistorySize-1)
+	if err != nil {
+		LogSQLError(err)
+		return fmt.Errorf("failed to prune password history: %w", err)
 	}
+	return nil
+}
 
-	// Get column names
-	columns, err := rows.Columns()
+// ErrAccountNotActive is returned when the password is correct but the account is
+// still awaiting email verification or administrator approval
+var ErrAccountNotActive = errors.New("account is not active")
+
+func (d *Database) AuthenticateUser(username, password string) (*models.User, error) {
+	user, err := d.verifyUserPassword(username, password)
 	if err != nil {
-		LogSQLError(err)
 		return nil, err
 	}
 
-	// Create a slice of interface{} to hold the values
-	values := make([]interface{}, len(columns))
-	valuePtrs := make([]interface{}, len(columns))
-	for i := range values {
-		valuePtrs[i] = &values[i]
+	// Only active accounts may log in
+	if user.Status != models.UserStatusActive {
+		return nil, ErrAccountNotActive
 	}
 
-	// Scan the row
-	err = rows.Scan(valuePtrs...)
+	return user, nil
+}
+
+// verifyUserPassword checks a user's password regardless of account status
+func (d *Database) verifyUserPassword(username, password string) (*models.User, error) {
+	var user models.User
+	err := d.QueryRow(`
+		SELECT id, username, email, password, read_groups, write_groups, status, created, modified
+		FROM _user WHERE username = ?`,
+		username).Scan(
+		&user.ID, &user.Username, &user.Email, &user.Password,
+		&user.ReadGroups, &user.WriteGroups, &user.Status, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		LogSQLError(err)
 		return nil, err
 	}
 
-	// Convert to map
-	rowData := make(map[string]interface{})
-	for i, col := range columns {
-		val := values[i]
-		if val != nil {
-			// Convert []uint8 to string for display
-			if b, ok := val.([]uint8); ok {
-				rowData[col] = string(b)
-			} else {
-				rowData[col] = val
+	// Check if password is in plain text (for migration)
+	if !auth.IsHashFormat(user.Password) {
+		// Migrate plain text password to hash
+		if user.Password == password {
+			// Password matches plain text, migrate to hash
+			hashedPassword, err := auth.HashPassword(password)
+			if err != nil {
+				return nil, fmt.Errorf("failed to hash password: %w", err)
+			}
+			
+			// Update the password in database
+			_, err = d.Exec("UPDATE _user SET password = ? WHERE id = ?", hashedPassword, user.ID)
+			if err != nil {
+				LogSQLError(err)
+				return nil, fmt.Errorf("failed to update password hash: %w", err)
 			}
+			
+			user.Password = hashedPassword
+			return &user, nil
 		}
+		return nil, fmt.Errorf("invalid password")
 	}
 
-	return &models.TableRow{
-		ID:   id,
-		Data: rowData,
-	}, nil
-}
-
-// CreateTableRow creates a new row in a table
-func (d *Database) CreateTableRow(tableName string, data map[string]interface{}) error {
-	// Build dynamic INSERT query
-	var columns []string
-	var placeholders []string
-	var values []interface{}
-
-	for col, val := range data {
-		columns = append(columns, "`"+col+"`")
-		placeholders = append(placeholders, "?")
-		values = append(values, val)
-	}
-
-	query := "INSERT INTO `" + tableName + "` (" + strings.Join(columns, ", ") + ") VALUES (" + strings.Join(placeholders, ", ") + ")"
-	_, err := d.Exec(query, values...)
+	// Verify password against hash
+	valid, needsRehash, err := auth.VerifyPassword(password, user.Password)
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return nil, fmt.Errorf("failed to verify password: %w", err)
+	}
+	
+	if !valid {
+		return nil, fmt.Errorf("invalid password")
 	}
-	return nil
-}
-
-// UpdateTableRow updates an existing row in a table
-func (d *Database) UpdateTableRow(tableName string, id int, data map[string]interface{}) error {
-	// Build dynamic UPDATE query
-	var setClauses []string
-	var values []interface{}
 
-	for col, val := range data {
-		// Skip empty values for timestamp fields to let MySQL handle defaults
-		if col == "created" || col == "modified" {
-			if val == "" || val == nil {
-				continue // Skip empty timestamp values
-			}
+	// Upgrade hashes made with outdated parameters or a legacy algorithm while the
+	// plain password is available. A failed upgrade does not block the login.
+	if needsRehash {
+		if err := d.rehashUserPassword(&user, password); err != nil {
+			log.Printf("Failed to upgrade password hash for user %d: %v", user.ID, err)
 		}
-		
-		setClauses = append(setClauses, "`"+col+"` = ?")
-		values = append(values, val)
 	}
 
-	// Always update the modified timestamp
-	setClauses = append(setClauses, "`modified` = CURRENT_TIMESTAMP")
+	return &user, nil
+}
 
-	values = append(values, id)
-	query := "UPDATE `" + tableName + "` SET " + strings.Join(setClauses, ", ") + " WHERE id = ?"
-	_, err := d.Exec(query, values...)
+// rehashUserPassword replaces a user's stored hash with one using the current defaults
+func (d *Database) rehashUserPassword(user *models.User, password string) error {
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	// Only replace the hash that was verified, in case the password changed concurrently
+	_, err = d.Exec("UPDATE _user SET password = ? WHERE id = ? AND password = ?",
+		hashedPassword, user.ID, user.Password)
 	if err != nil {
 		LogSQLError(err)
 		return err
 	}
+
+	user.Password = hashedPassword
 	return nil
 }
 
-// DeleteTableRow deletes a row from a table
-func (d *Database) DeleteTableRow(tableName string, id int) error {
-	_, err := d.Exec("DELETE FROM `"+tableName+"` WHERE id = ?", id)
+func (d *Database) GetUserByID(userID int) (*models.User, error) {
+	var user models.User
+	err := d.QueryRow(`
+		SELECT id, username, email, password, read_groups, write_groups, status, created, modified
+		FROM _user WHERE id = ?`,
+		userID).Scan(
+		&user.ID, &user.Username, &user.Email, &user.Password,
+		&user.ReadGroups, &user.WriteGroups, &user.Status, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return nil, err
 	}
-	return nil
+	return &user, nil
 }
 
-// GetTableSchema retrieves the schema information for a table
-func (d *Database) GetTableSchema(tableName string) ([]string, error) {
-	rows, err := d.Query("DESCRIBE `" + tableName + "`")
+func (d *Database) GetUserGroups(userID int) ([]models.Group, error) {
+	rows, err := d.Query(`
+		SELECT g.id, g.name, g.description, g.read_groups, g.write_groups, g.created
+		FROM _group g
+		JOIN _user_and_group ug ON g.id = ug.group_id
+		WHERE ug.user_id = ?
+		ORDER BY g.name`,
+		userID)
 	if err != nil {
 		LogSQLError(err)
 		return nil, err
 	}
 	defer rows.Close()
 
-	var columns []string
+	var groups []models.Group
 	for rows.Next() {
-		var field, typ, null, key, defaultVal, extra string
-		err := rows.Scan(&field, &typ, &null, &key, &defaultVal, &extra)
+		var group models.Group
+		err := rows.Scan(
+			&group.ID, &group.Name, &group.Description, &group.ReadGroups, &group.WriteGroups, &group.CreatedAt)
 		if err != nil {
 			LogSQLError(err)
 			return nil, err
 		}
-		columns = append(columns, field)
+		groups = append(groups, group)
 	}
-
-	return columns, nil
+	return groups, nil
 }
 
-// initializeMetadata creates default metadata for existing tables
-func (d *Database) initializeMetadata() error {
-	// Initialize metadata for users table
-	usersMetadata := &models.TableMetadata{
-		TableName:   "_user",
-		DisplayName: "Users",
-		Description: "User accounts and authentication information",
-		ReadGroups:  `["admin", "engineer"]`,
-		WriteGroups: `["admin", "engineer"]`,
+// IsUserInGroup reports whether the user belongs to the group, either directly or
+// through membership of a group nested inside it
+func (d *Database) IsUserInGroup(userID int, groupName string) (bool, error) {
+	access, err := d.LoadAccess(userID)
+	if err != nil {
+		return false, err
 	}
+	return access.InGroup(groupName), nil
+}
 
-	if err := d.createTableMetadataIfNotExists(usersMetadata); err != nil {
+// ErrGroupCycle is returned when nesting a group would make it contain itself
+var ErrGroupCycle = errors.New("nesting these groups would create a cycle")
+
+// ExpandGroups returns the given groups plus every group that contains them, directly or
+// transitively. parents maps a group name to the names of the groups it is nested in.
+// Cycles in parents are tolerated; each group is visited once.
+func ExpandGroups(direct []string, parents map[string][]string) []string {
+	seen := make(map[string]bool, len(direct))
+	var expanded []string
+	queue := append([]string(nil), direct...)
+	for len(queue) > 0 {
+		group := queue[0]
+		queue = queue[1:]
+		if seen[group] {
+			continue
+		}
+		seen[group] = true
+		expanded = append(expanded, group)
+		queue = append(queue, parents[group]...)
+	}
+	return expanded
+}
+
+// IsNestedIn reports whether group is ancestor or is nested inside it, directly or transitively
+func IsNestedIn(parents map[string][]string, group, ancestor string) bool {
+	for _, name := range ExpandGroups([]string{group}, parents) {
+		if name == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// WouldCreateCycle reports whether nesting child inside parent would create a cycle,
+// which happens when parent is child itself or is already nested inside child
+func WouldCreateCycle(parents map[string][]string, parent, child string) bool {
+	return IsNestedIn(parents, parent, child)
+}
+
+// getGroupParents loads the nesting graph as a map from group name to the names of the
+// groups it is nested in
+func (d *Database) getGroupParents() (map[string][]string, error) {
+	rows, err := d.Query(groupParentsQuery)
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
+	}
+	return scanGroupParents(rows)
+}
+
+// lockGroupParents reads the nesting graph like getGroupParents and locks it until tx ends,
+// so concurrent changes to the graph wait for each other
+func lockGroupParents(tx *sql.Tx) (map[string][]string, error) {
+	rows, err := tx.Query(groupParentsQuery + " FOR UPDATE")
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
+	}
+	return scanGroupParents(rows)
+}
+
+const groupParentsQuery = `
+		SELECT child.name, parent.name
+		FROM _group_and_group gg
+		JOIN _group parent ON gg.group_id = parent.id
+		JOIN _group child ON gg.member_group_id = child.id`
+
+func scanGroupParents(rows *sql.Rows) (map[string][]string, error) {
+	defer rows.Close()
+
+	parents := make(map[string][]string)
+	for rows.Next() {
+		var child, parent string
+		if err := rows.Scan(&child, &parent); err != nil {
+			LogSQLError(err)
+			return nil, err
+		}
+		parents[child] = append(parents[child], parent)
+	}
+	return parents, nil
+}
+
+// GetUserEffectiveGroups returns the names of every group the user belongs to, including
+// groups inherited through nesting
+func (d *Database) GetUserEffectiveGroups(userID int) ([]string, error) {
+	direct, err := d.getUserDirectGroupNames(userID)
+	if err != nil {
+		return nil, err
+	}
+	return d.expandGroupNames(direct)
+}
+
+// getUserDirectGroupNames returns the names of the groups the user is a direct member of
+func (d *Database) getUserDirectGroupNames(userID int) ([]string, error) {
+	rows, err := d.Query(`
+		SELECT g.name
+		FROM _group g
+		JOIN _user_and_group ug ON g.id = ug.group_id
+		WHERE ug.user_id = ?`,
+		userID)
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var direct []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			LogSQLError(err)
+			return nil, err
+		}
+		direct = append(direct, name)
+	}
+	return direct, rows.Err()
+}
+
+// expandGroupNames adds every group the named groups are nested in, directly or indirectly
+func (d *Database) expandGroupNames(direct []string) ([]string, error) {
+	if len(direct) == 0 {
+		return nil, nil
+	}
+
+	parents, err := d.getGroupParents()
+	if err != nil {
+		return nil, err
+	}
+	return ExpandGroups(direct, parents), nil
+}
+
+// AddGroupToGroup nests child inside parent so that child's members also belong to parent
+func (d *Database) AddGroupToGroup(parentName, childName string) error {
+	if parentName == "everyone" || childName == "everyone" {
+		return fmt.Errorf("the everyone group cannot be nested")
+	}
+
+	// The cycle check and the insert share a transaction, so two concurrent nestings
+	// cannot each pass the check and together form a cycle
+	tx, err := d.Begin()
+	if err != nil {
 		LogSQLError(err)
 		return err
 	}
+	defer tx.Rollback()
 
-	// Initialize field metadata for users table
-	usersFields := []models.FieldMetadata{
-		{
-			TableName:     "_user",
-			FieldName:     "id",
-			DisplayName:   "ID",
-			Description:   "Unique identifier",
-			DBType:        "INT",
-			HTMLInputType: "number",
-			FormPosition:  0,
-			ListPosition:  0,
-			IsRequired:    true,
-			IsReadOnly:    true,
-		},
-		{
-			TableName:     "_user",
-			FieldName:     "username",
-			DisplayName:   "Username",
-			Description:   "User login name",
-			DBType:        "VARCHAR(255)",
-			HTMLInputType: "text",
-			FormPosition:  1,
-			ListPosition:  1,
-			IsRequired:    true,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_user",
-			FieldName:     "email",
-			DisplayName:   "Email",
-			Description:   "User email address",
-			DBType:        "VARCHAR(255)",
-			HTMLInputType: "email",
-			FormPosition:  2,
-			ListPosition:  2,
-			IsRequired:    true,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_user",
-			FieldName:     "password",
-			DisplayName:   "Password",
-			Description:   "Hashed password",
-			DBType:        "VARCHAR(255)",
-			HTMLInputType: "password",
-			FormPosition:  3,
-			ListPosition:  -1, // Don't show in list
-			IsRequired:    true,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_user",
-			FieldName:     "created",
-			DisplayName:   "Created",
-			Description:   "Account creation date",
-			DBType:        "TIMESTAMP",
-			HTMLInputType: "datetime-local",
-			FormPosition:  4,
-			ListPosition:  3,
-			IsRequired:    false,
-			IsReadOnly:    true,
-		},
-		{
-			TableName:     "_user",
-			FieldName:     "modified",
-			DisplayName:   "Modified",
-			Description:   "Last update date",
-			DBType:        "TIMESTAMP",
-			HTMLInputType: "datetime-local",
-			FormPosition:  5,
-			ListPosition:  4,
-			IsRequired:    false,
-			IsReadOnly:    true,
-		},
-		{
-			TableName:     "_user",
-			FieldName:     "read_groups",
-			DisplayName:   "Read Groups",
-			Description:   "JSON array of groups that can read this user",
-			DBType:        "TEXT",
-			HTMLInputType: "textarea",
-			FormPosition:  6,
-			ListPosition:  5,
-			IsRequired:    false,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_user",
-			FieldName:     "write_groups",
-			DisplayName:   "Write Groups",
-			Description:   "JSON array of groups that can write to this user",
-			DBType:        "TEXT",
-			HTMLInputType: "textarea",
-			FormPosition:  7,
-			ListPosition:  6,
-			IsRequired:    false,
-			IsReadOnly:    false,
-		},
+	parents, err := lockGroupParents(tx)
+	if err != nil {
+		return err
+	}
+	if WouldCreateCycle(parents, parentName, childName) {
+		return ErrGroupCycle
 	}
 
-	for _, field := range usersFields {
-		if err := d.createFieldMetadataIfNotExists(&field); err != nil {
+	result, err := tx.Exec(`
+		INSERT IGNORE INTO _group_and_group (group_id, member_group_id)
+		SELECT parent.id, child.id FROM _group parent, _group child
+		WHERE parent.name = ? AND child.name = ?`,
+		parentName, childName)
+	if err != nil {
+		LogSQLError(err)
+		return fmt.Errorf("failed to nest group: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		LogSQLError(err)
+		return err
+	}
+	if rows > 0 {
+		return d.markGroupMembersForRotation(childName)
+	}
+	return nil
+}
+
+// RemoveGroupFromGroup stops child being nested inside parent
+func (d *Database) RemoveGroupFromGroup(parentName, childName string) error {
+	result, err := d.Exec(`
+		DELETE gg FROM _group_and_group gg
+		JOIN _group parent ON gg.group_id = parent.id
+		JOIN _group child ON gg.member_group_id = child.id
+		WHERE parent.name = ? AND child.name = ?`,
+		parentName, childName)
+	if err != nil {
+		LogSQLError(err)
+		return fmt.Errorf("failed to remove nested group: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows > 0 {
+		return d.markGroupMembersForRotation(childName)
+	}
+	return nil
+}
+
+// GetSubgroups returns the groups nested directly inside a group, ordered by name
+func (d *Database) GetSubgroups(groupID int) ([]models.Group, error) {
+	return d.queryGroups(`
+		SELECT g.id, g.name, g.description, g.read_groups, g.write_groups, g.created
+		FROM _group g
+		JOIN _group_and_group gg ON g.id = gg.member_group_id
+		WHERE gg.group_id = ?
+		ORDER BY g.name`, groupID)
+}
+
+// GetParentGroups returns the groups a group is nested directly inside, ordered by name
+func (d *Database) GetParentGroups(groupID int) ([]models.Group, error) {
+	return d.queryGroups(`
+		SELECT g.id, g.name, g.description, g.read_groups, g.write_groups, g.created
+		FROM _group g
+		JOIN _group_and_group gg ON g.id = gg.group_id
+		WHERE gg.member_group_id = ?
+		ORDER BY g.name`, groupID)
+}
+
+// queryGroups runs a query selecting group columns and scans the results
+func (d *Database) queryGroups(query string, args ...interface{}) ([]models.Group, error) {
+	rows, err := d.Query(query, args...)
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []models.Group
+	for rows.Next() {
+		var group models.Group
+		err := rows.Scan(
+			&group.ID, &group.Name, &group.Description, &group.ReadGroups, &group.WriteGroups, &group.CreatedAt)
+		if err != nil {
 			LogSQLError(err)
-			return err
+			return nil, err
 		}
+		groups = append(groups, group)
 	}
+	return groups, nil
+}
 
-	// Initialize metadata for pages table
-	pagesMetadata := &models.TableMetadata{
-		TableName:   "_page",
-		DisplayName: "Pages",
-		Description: "Content pages and templates",
-		ReadGroups:  `["admin", "customers", "engineer", "everyone"]`,
-		WriteGroups: `["admin", "engineer"]`,
+// markGroupMembersForRotation flags the sessions of everyone who belongs to the group,
+// directly or through a group nested inside it, because their inherited privileges changed
+func (d *Database) markGroupMembersForRotation(groupName string) error {
+	d.invalidateAllAccess()
+	parents, err := d.getGroupParents()
+	if err != nil {
+		return err
 	}
 
-	if err := d.createTableMetadataIfNotExists(pagesMetadata); err != nil {
+	// The affected groups are the group itself and every group nested inside it
+	affected := []interface{}{groupName}
+	placeholders := []string{"?"}
+	for child := range parents {
+		if child != groupName && IsNestedIn(parents, child, groupName) {
+			affected = append(affected, child)
+			placeholders = append(placeholders, "?")
+		}
+	}
+
+	_, err = d.Exec(`
+		UPDATE _session SET rotate_pending = TRUE
+		WHERE is_active = TRUE AND user_id IN (
+			SELECT ug.user_id FROM _user_and_group ug
+			JOIN _group g ON ug.group_id = g.id
+			WHERE g.name IN (`+strings.Join(placeholders, ", ")+`))`,
+		affected...)
+	if err != nil {
 		LogSQLError(err)
 		return err
 	}
+	return nil
+}
 
-	// Initialize field metadata for pages table
-	pagesFields := []models.FieldMetadata{
-		{
-			TableName:     "_page",
-			FieldName:     "id",
-			DisplayName:   "ID",
-			Description:   "Unique identifier",
-			DBType:        "INT",
-			HTMLInputType: "number",
-			FormPosition:  0,
+func (d *Database) GetAllGroups() ([]models.Group, error) {
+	rows, err := d.Query(`
+		SELECT id, name, description, read_groups, write_groups, created
+		FROM _group
+		ORDER BY name`)
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []models.Group
+	for rows.Next() {
+		var group models.Group
+		err := rows.Scan(
+			&group.ID, &group.Name, &group.Description, &group.ReadGroups, &group.WriteGroups, &group.CreatedAt)
+		if err != nil {
+			LogSQLError(err)
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+func (d *Database) GetAllUsers() ([]models.User, error) {
+	rows, err := d.Query(`
+		SELECT id, username, email, password, read_groups, write_groups, status, created, modified
+		FROM _user
+		ORDER BY username`)
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		err := rows.Scan(
+			&user.ID, &user.Username, &user.Email, &user.Password,
+			&user.ReadGroups, &user.WriteGroups, &user.Status, &user.CreatedAt, &user.UpdatedAt)
+		if err != nil {
+			LogSQLError(err)
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+} 
+
+// ProtectedGroups are the built-in groups that cannot be deleted
+var ProtectedGroups = []string{"admin", "everyone"}
+
+// ErrProtectedGroup is returned when deleting a built-in group
+var ErrProtectedGroup = errors.New("built-in groups cannot be deleted")
+
+// isProtectedGroup reports whether the group name is in ProtectedGroups
+func isProtectedGroup(name string) bool {
+	for _, protected := range ProtectedGroups {
+		if name == protected {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateUser changes a user's username, email address and account status
+func (d *Database) UpdateUser(userID int, username, email, status string) error {
+	result, err := d.Exec(`
+		UPDATE _user SET username = ?, email = ?, status = ?
+		WHERE id = ?`,
+		username, email, status, userID)
+	if err != nil {
+		LogSQLError(err)
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		// MySQL reports zero rows when nothing changed, so check the user exists
+		if _, err := d.GetUserByID(userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteUser removes a user. Group memberships, sessions and tokens are removed by cascade.
+func (d *Database) DeleteUser(userID int) error {
+	result, err := d.Exec("DELETE FROM _user WHERE id = ?", userID)
+	if err != nil {
+		LogSQLError(err)
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	d.invalidateUserAccess(userID)
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AddUserToGroup adds a user to a group by name. Adding an existing member is not an error.
+func (d *Database) AddUserToGroup(userID int, groupName string) error {
+	return d.addUserToGroup(userID, groupName)
+}
+
+// RemoveUserFromGroup removes a user from a group by name. Removing a non-member is not an error.
+func (d *Database) RemoveUserFromGroup(userID int, groupName string) error {
+	result, err := d.Exec(`
+		DELETE ug FROM _user_and_group ug
+		JOIN _group g ON ug.group_id = g.id
+		WHERE ug.user_id = ? AND g.name = ?`,
+		userID, groupName)
+	if err != nil {
+		LogSQLError(err)
+		return fmt.Errorf("failed to remove user from group: %w", err)
+	}
+
+	// Privileges changed, so existing session IDs must not carry over
+	if rows, err := result.RowsAffected(); err == nil && rows > 0 {
+		if err := d.MarkUserSessionsForRotation(userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetGroupByID retrieves a group by ID
+func (d *Database) GetGroupByID(groupID int) (*models.Group, error) {
+	var group models.Group
+	err := d.QueryRow(`
+		SELECT id, name, description, read_groups, write_groups, created
+		FROM _group WHERE id = ?`,
+		groupID).Scan(
+		&group.ID, &group.Name, &group.Description, &group.ReadGroups, &group.WriteGroups, &group.CreatedAt)
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
+	}
+	return &group, nil
+}
+
+// CreateGroup creates a new group and returns its ID
+func (d *Database) CreateGroup(name, description string) (int, error) {
+	result, err := d.Exec(`
+		INSERT INTO _group (name, description)
+		VALUES (?, ?)`,
+		name, description)
+	if err != nil {
+		LogSQLError(err)
+		return 0, fmt.Errorf("failed to create group: %w", err)
+	}
+
+	groupID, err := result.LastInsertId()
+	if err != nil {
+		LogSQLError(err)
+		return 0, fmt.Errorf("failed to get group ID: %w", err)
+	}
+	return int(groupID), nil
+}
+
+// UpdateGroupDescription changes a group's description. Groups cannot be renamed: the
+// read_groups and write_groups of tables, pages and rows refer to them by name, and a
+// rename would silently change who those grant access to.
+func (d *Database) UpdateGroupDescription(groupID int, description string) error {
+	if _, err := d.GetGroupByID(groupID); err != nil {
+		return err
+	}
+
+	_, err := d.Exec("UPDATE _group SET description = ? WHERE id = ?", description, groupID)
+	if err != nil {
+		LogSQLError(err)
+		return fmt.Errorf("failed to update group: %w", err)
+	}
+	return nil
+}
+
+// DeleteGroup removes a group and its memberships. Built-in groups cannot be deleted.
+func (d *Database) DeleteGroup(groupID int) error {
+	group, err := d.GetGroupByID(groupID)
+	if err != nil {
+		return err
+	}
+	if isProtectedGroup(group.Name) {
+		return ErrProtectedGroup
+	}
+
+	// Members, including those of nested groups, lose privileges, so their session IDs must not carry over
+	if err := d.markGroupMembersForRotation(group.Name); err != nil {
+		return err
+	}
+
+	if _, err := d.Exec("DELETE FROM _group WHERE id = ?", groupID); err != nil {
+		LogSQLError(err)
+		return fmt.Errorf("failed to delete group: %w", err)
+	}
+	d.invalidateAllAccess()
+	return nil
+}
+
+// GetGroupMembers returns the users in a group, ordered by username
+func (d *Database) GetGroupMembers(groupID int) ([]models.User, error) {
+	rows, err := d.Query(`
+		SELECT u.id, u.username, u.email, u.status, u.created, u.modified
+		FROM _user u
+		JOIN _user_and_group ug ON u.id = ug.user_id
+		WHERE ug.group_id = ?
+		ORDER BY u.username`,
+		groupID)
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Status, &user.CreatedAt, &user.UpdatedAt); err != nil {
+			LogSQLError(err)
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// Permission operations
+
+// DefaultGroupPermissions are granted when the permission table is first created. They
+// reproduce the access the built-in groups had before permissions were configurable.
+var DefaultGroupPermissions = map[string][]string{
+	"admin": {
+		models.PermissionUsersManage,
+		models.PermissionConfigView,
+		models.PermissionConfigEdit,
+		models.PermissionSchemaEdit,
+		models.PermissionOrdersView,
+		models.PermissionPagesEdit,
+		models.PermissionPagesEditHTML,
+		models.PermissionPagesPublish,
+	},
+	"engineer": {
+		models.PermissionConfigView,
+		models.PermissionConfigEdit,
+		models.PermissionSchemaEdit,
+		models.PermissionTablesViewAll,
+		models.PermissionPagesEdit,
+		models.PermissionPagesEditHTML,
+	},
+	"customers": {
+		models.PermissionFAQView,
+		models.PermissionMagicLink,
+	},
+}
+
+// ErrInvalidPermission is returned when granting a malformed permission name
+var ErrInvalidPermission = errors.New("invalid permission name")
+
+// ErrProtectedPermission is returned when revoking users.manage from the admin group, which
+// would leave nobody able to manage permissions
+var ErrProtectedPermission = errors.New("the admin group cannot lose users.manage")
+
+// initializePermissions seeds DefaultGroupPermissions the first time the permission table
+// is empty, so later changes made through the admin UI are not overwritten on restart
+func (d *Database) initializePermissions() error {
+	var count int
+	if err := d.QueryRow("SELECT COUNT(*) FROM _group_permission").Scan(&count); err != nil {
+		LogSQLError(err)
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	for groupName, permissions := range DefaultGroupPermissions {
+		for _, permission := range permissions {
+			_, err := d.Exec(`
+				INSERT IGNORE INTO _group_permission (group_id, permission)
+				SELECT id, ? FROM _group WHERE name = ?`,
+				permission, groupName)
+			if err != nil {
+				LogSQLError(err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// getPermissionsForGroups returns the distinct permissions granted to any of the groups
+func (d *Database) getPermissionsForGroups(groups []string) ([]string, error) {
+	if len(groups) == 0 {
+		return nil, nil
+	}
+	args := make([]interface{}, len(groups))
+	placeholders := make([]string, len(groups))
+	for i, group := range groups {
+		args[i] = group
+		placeholders[i] = "?"
+	}
+
+	rows, err := d.Query(`
+		SELECT DISTINCT gp.permission
+		FROM _group_permission gp
+		JOIN _group g ON gp.group_id = g.id
+		WHERE g.name IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY gp.permission`,
+		args...)
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []string
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			LogSQLError(err)
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, nil
+}
+
+// GetUserPermissions returns the permissions granted to the user's groups. Table
+// permissions derived from table metadata are not included.
+func (d *Database) GetUserPermissions(userID int) ([]string, error) {
+	access, err := d.LoadAccess(userID)
+	if err != nil {
+		return nil, err
+	}
+	return access.Permissions, nil
+}
+
+// GetAllGroupPermissions returns every permission grant, ordered by group and permission
+func (d *Database) GetAllGroupPermissions() ([]models.GroupPermission, error) {
+	rows, err := d.Query(`
+		SELECT gp.id, gp.group_id, g.name, gp.permission, gp.created
+		FROM _group_permission gp
+		JOIN _group g ON gp.group_id = g.id
+		ORDER BY g.name, gp.permission`)
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants []models.GroupPermission
+	for rows.Next() {
+		var grant models.GroupPermission
+		if err := rows.Scan(&grant.ID, &grant.GroupID, &grant.GroupName, &grant.Permission, &grant.CreatedAt); err != nil {
+			LogSQLError(err)
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+	return grants, nil
+}
+
+// GrantPermission grants a permission to a group. Granting an existing permission is not
+// an error. Returns sql.ErrNoRows if the group does not exist.
+func (d *Database) GrantPermission(groupName, permission string) error {
+	if !models.ValidPermissionName(permission) {
+		return ErrInvalidPermission
+	}
+
+	var groupID int
+	if err := d.QueryRow("SELECT id FROM _group WHERE name = ?", groupName).Scan(&groupID); err != nil {
+		return err
+	}
+
+	result, err := d.Exec(
+		"INSERT IGNORE INTO _group_permission (group_id, permission) VALUES (?, ?)",
+		groupID, permission)
+	if err != nil {
+		LogSQLError(err)
+		return fmt.Errorf("failed to grant permission: %w", err)
+	}
+
+	// Privileges changed, so existing session IDs must not carry over
+	if rows, err := result.RowsAffected(); err == nil && rows > 0 {
+		return d.markGroupMembersForRotation(groupName)
+	}
+	return nil
+}
+
+// RevokePermission removes a permission from a group. Revoking a permission the group does
+// not hold is not an error.
+func (d *Database) RevokePermission(groupName, permission string) error {
+	if groupName == "admin" && permission == models.PermissionUsersManage {
+		return ErrProtectedPermission
+	}
+
+	result, err := d.Exec(`
+		DELETE gp FROM _group_permission gp
+		JOIN _group g ON gp.group_id = g.id
+		WHERE g.name = ? AND gp.permission = ?`,
+		groupName, permission)
+	if err != nil {
+		LogSQLError(err)
+		return fmt.Errorf("failed to revoke permission: %w", err)
+	}
+
+	if rows, err := result.RowsAffected(); err == nil && rows > 0 {
+		return d.markGroupMembersForRotation(groupName)
+	}
+	return nil
+}
+
+// Metadata operations
+
+// GetTableMetadata retrieves metadata for a specific table
+func (d *Database) GetTableMetadata(tableName string) (*models.TableMetadata, error) {
+	var metadata models.TableMetadata
+	err := d.QueryRow(`
+		SELECT id, table_name, display_name, description, read_groups, write_groups, created, modified
+		FROM _table_metadata WHERE table_name = ?`,
+		tableName).Scan(
+		&metadata.ID, &metadata.TableName, &metadata.DisplayName, &metadata.Description,
+		&metadata.ReadGroups, &metadata.WriteGroups, &metadata.CreatedAt, &metadata.UpdatedAt)
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// GetAllTableMetadata retrieves metadata for all tables
+func (d *Database) GetAllTableMetadata() ([]models.TableMetadata, error) {
+	rows, err := d.Query(`
+		SELECT id, table_name, display_name, description, read_groups, write_groups, created, modified
+		FROM _table_metadata ORDER BY table_name`)
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metadata []models.TableMetadata
+	for rows.Next() {
+		var item models.TableMetadata
+		err := rows.Scan(
+			&item.ID, &item.TableName, &item.DisplayName, &item.Description,
+			&item.ReadGroups, &item.WriteGroups, &item.CreatedAt, &item.UpdatedAt)
+		if err != nil {
+			LogSQLError(err)
+			return nil, err
+		}
+		metadata = append(metadata, item)
+	}
+	return metadata, nil
+}
+
+// CreateTableMetadata creates new table metadata
+func (d *Database) CreateTableMetadata(metadata *models.TableMetadata) error {
+	_, err := d.Exec(`
+		INSERT INTO _table_metadata (table_name, display_name, description, read_groups, write_groups)
+		VALUES (?, ?, ?, ?, ?)`,
+		metadata.TableName, metadata.DisplayName, metadata.Description,
+		metadata.ReadGroups, metadata.WriteGroups)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+	return nil
+}
+
+// UpdateTableMetadata updates existing table metadata
+func (d *Database) UpdateTableMetadata(metadata *models.TableMetadata) error {
+	_, err := d.Exec(`
+		UPDATE _table_metadata 
+		SET display_name = ?, description = ?, read_groups = ?, write_groups = ?
+		WHERE table_name = ?`,
+		metadata.DisplayName, metadata.Description, metadata.ReadGroups, metadata.WriteGroups, metadata.TableName)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+	return nil
+}
+
+// GetFieldMetadata retrieves metadata for fields of a specific table
+func (d *Database) GetFieldMetadata(tableName string) ([]models.FieldMetadata, error) {
+	rows, err := d.Query(`
+		SELECT id, table_name, field_name, display_name, description, db_type, html_input_type,
+		       form_position, list_position, is_required, is_read_only, default_value, validation_rules,
+		       created, modified
+		FROM _field_metadata WHERE table_name = ? ORDER BY form_position, field_name`,
+		tableName)
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metadata []models.FieldMetadata
+	for rows.Next() {
+		var item models.FieldMetadata
+		err := rows.Scan(
+			&item.ID, &item.TableName, &item.FieldName, &item.DisplayName, &item.Description,
+			&item.DBType, &item.HTMLInputType, &item.FormPosition, &item.ListPosition,
+			&item.IsRequired, &item.IsReadOnly, &item.DefaultValue, &item.ValidationRules,
+			&item.CreatedAt, &item.UpdatedAt)
+		if err != nil {
+			LogSQLError(err)
+			return nil, err
+		}
+		metadata = append(metadata, item)
+	}
+	return metadata, nil
+}
+
+// GetFieldMetadataByField retrieves metadata for a specific field
+func (d *Database) GetFieldMetadataByField(tableName, fieldName string) (*models.FieldMetadata, error) {
+	var metadata models.FieldMetadata
+	err := d.QueryRow(`
+		SELECT id, table_name, field_name, display_name, description, db_type, html_input_type,
+		       form_position, list_position, is_required, is_read_only, default_value, validation_rules,
+		       created, modified
+		FROM _field_metadata WHERE table_name = ? AND field_name = ?`,
+		tableName, fieldName).Scan(
+		&metadata.ID, &metadata.TableName, &metadata.FieldName, &metadata.DisplayName, &metadata.Description,
+		&metadata.DBType, &metadata.HTMLInputType, &metadata.FormPosition, &metadata.ListPosition,
+		&metadata.IsRequired, &metadata.IsReadOnly, &metadata.DefaultValue, &metadata.ValidationRules,
+		&metadata.CreatedAt, &metadata.UpdatedAt)
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// CreateFieldMetadata creates new field metadata
+func (d *Database) CreateFieldMetadata(metadata *models.FieldMetadata) error {
+	// Start a transaction
+	tx, err := d.Begin()
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// Check if field already exists in the database
+	exists, err := d.fieldExists(metadata.TableName, metadata.FieldName)
+	if err != nil {
+		return err
+	}
+
+	// If field doesn't exist in the database, add it
+	if !exists {
+		// Skip management fields that are handled separately
+		if metadata.FieldName != "id" && metadata.FieldName != "created" && metadata.FieldName != "modified" && 
+		   metadata.FieldName != "read_groups" && metadata.FieldName != "write_groups" {
+			
+			// Add the field to the actual table
+			alterSQL := "ALTER TABLE `" + metadata.TableName + "` ADD COLUMN `" + metadata.FieldName + "` " + metadata.DBType
+			
+			if metadata.IsRequired {
+				alterSQL += " NOT NULL"
+			} else {
+				alterSQL += " NULL"
+			}
+			
+			if metadata.DefaultValue != "" {
+				alterSQL += " DEFAULT '" + metadata.DefaultValue + "'"
+			}
+			
+			_, err = tx.Exec(alterSQL)
+			if err != nil {
+				LogSQLError(err)
+				return err
+			}
+		}
+	}
+
+	// Insert the field metadata
+	_, err = tx.Exec(`
+		INSERT INTO _field_metadata (table_name, field_name, display_name, description, db_type, html_input_type,
+		                           form_position, list_position, is_required, is_read_only, default_value, validation_rules)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		metadata.TableName, metadata.FieldName, metadata.DisplayName, metadata.Description,
+		metadata.DBType, metadata.HTMLInputType, metadata.FormPosition, metadata.ListPosition,
+		metadata.IsRequired, metadata.IsReadOnly, metadata.DefaultValue, metadata.ValidationRules)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Commit the transaction
+	return tx.Commit()
+}
+
+// UpdateFieldMetadata updates existing field metadata
+func (d *Database) UpdateFieldMetadata(metadata *models.FieldMetadata) error {
+	// Start a transaction
+	tx, err := d.Begin()
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// Get the current field metadata to compare changes
+	var currentMetadata models.FieldMetadata
+	err = tx.QueryRow(`
+		SELECT table_name, field_name, display_name, description, db_type, html_input_type,
+		       form_position, list_position, is_required, is_read_only, default_value, validation_rules
+		FROM _field_metadata 
+		WHERE table_name = ? AND field_name = ?`,
+		metadata.TableName, metadata.FieldName).Scan(
+		&currentMetadata.TableName, &currentMetadata.FieldName, &currentMetadata.DisplayName, &currentMetadata.Description,
+		&currentMetadata.DBType, &currentMetadata.HTMLInputType, &currentMetadata.FormPosition, &currentMetadata.ListPosition,
+		&currentMetadata.IsRequired, &currentMetadata.IsReadOnly, &currentMetadata.DefaultValue, &currentMetadata.ValidationRules)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Check if DBType has changed and update the database schema if needed
+	if currentMetadata.DBType != metadata.DBType || currentMetadata.IsRequired != metadata.IsRequired || 
+	   currentMetadata.DefaultValue != metadata.DefaultValue {
+		
+		// Skip management fields that shouldn't be modified
+		if metadata.FieldName != "id" && metadata.FieldName != "created" && metadata.FieldName != "modified" && 
+		   metadata.FieldName != "read_groups" && metadata.FieldName != "write_groups" {
+			
+			// Check if this field has indexes that need to be handled
+			indexes, err := d.getFieldIndexes(metadata.TableName, metadata.FieldName)
+			if err != nil {
+				LogSQLError(err)
+				return err
+			}
+			
+			// Drop indexes if they exist and we're changing to TEXT/BLOB type
+			if len(indexes) > 0 && (strings.Contains(strings.ToUpper(metadata.DBType), "TEXT") || 
+			   strings.Contains(strings.ToUpper(metadata.DBType), "BLOB")) {
+				for _, indexName := range indexes {
+					dropIndexSQL := "ALTER TABLE `" + metadata.TableName + "` DROP INDEX `" + indexName + "`"
+					_, err = tx.Exec(dropIndexSQL)
+					if err != nil {
+						LogSQLError(err)
+						return err
+					}
+				}
+			}
+			
+			// Update the field in the actual table
+			alterSQL := "ALTER TABLE `" + metadata.TableName + "` MODIFY COLUMN `" + metadata.FieldName + "` " + metadata.DBType
+			
+			if metadata.IsRequired {
+				alterSQL += " NOT NULL"
+			} else {
+				alterSQL += " NULL"
+			}
+			
+			if metadata.DefaultValue != "" {
+				alterSQL += " DEFAULT '" + metadata.DefaultValue + "'"
+			}
+			
+			_, err = tx.Exec(alterSQL)
+			if err != nil {
+				LogSQLError(err)
+				return err
+			}
+			
+			// Recreate indexes if they were dropped and we're not changing to TEXT/BLOB
+			if len(indexes) > 0 && !strings.Contains(strings.ToUpper(metadata.DBType), "TEXT") && 
+			   !strings.Contains(strings.ToUpper(metadata.DBType), "BLOB") {
+				for _, indexName := range indexes {
+					// For now, recreate as simple index - in a production system you might want to preserve the exact index type
+					createIndexSQL := "ALTER TABLE `" + metadata.TableName + "` ADD INDEX `" + indexName + "` (`" + metadata.FieldName + "`)"
+					_, err = tx.Exec(createIndexSQL)
+					if err != nil {
+						LogSQLError(err)
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	// Update the field metadata
+	_, err = tx.Exec(`
+		UPDATE _field_metadata 
+		SET display_name = ?, description = ?, db_type = ?, html_input_type = ?,
+		    form_position = ?, list_position = ?, is_required = ?, is_read_only = ?,
+		    default_value = ?, validation_rules = ?
+		WHERE table_name = ? AND field_name = ?`,
+		metadata.DisplayName, metadata.Description, metadata.DBType, metadata.HTMLInputType,
+		metadata.FormPosition, metadata.ListPosition, metadata.IsRequired, metadata.IsReadOnly,
+		metadata.DefaultValue, metadata.ValidationRules, metadata.TableName, metadata.FieldName)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Commit the transaction
+	return tx.Commit()
+}
+
+// GetTableRows retrieves rows from a specific table with pagination
+func (d *Database) GetTableRows(tableName string, page, pageSize int) ([]models.TableRow, int, error) {
+	// Get total count
+	var total int
+	err := d.QueryRow("SELECT COUNT(*) FROM `"+tableName+"`").Scan(&total)
+	if err != nil {
+		LogSQLError(err)
+		return nil, 0, err
+	}
+
+	// Get rows with pagination
+	offset := (page - 1) * pageSize
+	rows, err := d.Query("SELECT * FROM `"+tableName+"` LIMIT ? OFFSET ?", pageSize, offset)
+	if err != nil {
+		LogSQLError(err)
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	// Get column names
+	columns, err := rows.Columns()
+	if err != nil {
+		LogSQLError(err)
+		return nil, 0, err
+	}
+
+	var tableRows []models.TableRow
+	for rows.Next() {
+		// Create a slice of interface{} to hold the values
+		values := make([]interface{}, len(columns))
+		valuePtrs := make([]interface{}, len(columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+
+		// Scan the row
+		err := rows.Scan(valuePtrs...)
+		if err != nil {
+			LogSQLError(err)
+			return nil, 0, err
+		}
+
+		// Convert to map
+		rowData := make(map[string]interface{})
+		for i, col := range columns {
+			val := values[i]
+			if val != nil {
+				// Convert []uint8 to string for display
+				if b, ok := val.([]uint8); ok {
+					rowData[col] = string(b)
+				} else {
+					rowData[col] = val
+				}
+			}
+		}
+
+		// Get ID if it exists
+		id := 0
+		if idVal, exists := rowData["id"]; exists {
+			if idInt, ok := idVal.(int64); ok {
+				id = int(idInt)
+			} else if idInt, ok := idVal.(int); ok {
+				id = idInt
+			}
+		}
+
+		tableRows = append(tableRows, models.TableRow{
+			ID:   id,
+			Data: rowData,
+		})
+	}
+
+	return tableRows, total, nil
+}
+
+// GetTableRow retrieves a specific row from a table
+func (d *Database) GetTableRow(tableName string, id int) (*models.TableRow, error) {
+	rows, err := d.Query("SELECT * FROM `"+tableName+"` WHERE id = ?", id)
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, fmt.Errorf("row not found")
+	}
+
+	// Get column names
+	columns, err := rows.Columns()
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
+	}
+
+	// Create a slice of interface{} to hold the values
+	values := make([]interface{}, len(columns))
+	valuePtrs := make([]interface{}, len(columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+
+	// Scan the row
+	err = rows.Scan(valuePtrs...)
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
+	}
+
+	// Convert to map
+	rowData := make(map[string]interface{})
+	for i, col := range columns {
+		val := values[i]
+		if val != nil {
+			// Convert []uint8 to string for display
+			if b, ok := val.([]uint8); ok {
+				rowData[col] = string(b)
+			} else {
+				rowData[col] = val
+			}
+		}
+	}
+
+	return &models.TableRow{
+		ID:   id,
+		Data: rowData,
+	}, nil
+}
+
+// CreateTableRow creates a new row in a table
+func (d *Database) CreateTableRow(tableName string, data map[string]interface{}) error {
+	// Build dynamic INSERT query
+	var columns []string
+	var placeholders []string
+	var values []interface{}
+
+	for col, val := range data {
+		columns = append(columns, "`"+col+"`")
+		placeholders = append(placeholders, "?")
+		values = append(values, val)
+	}
+
+	query := "INSERT INTO `" + tableName + "` (" + strings.Join(columns, ", ") + ") VALUES (" + strings.Join(placeholders, ", ") + ")"
+	_, err := d.Exec(query, values...)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+	return nil
+}
+
+// UpdateTableRow updates an existing row in a table
+func (d *Database) UpdateTableRow(tableName string, id int, data map[string]interface{}) error {
+	// Build dynamic UPDATE query
+	var setClauses []string
+	var values []interface{}
+
+	for col, val := range data {
+		// Skip empty values for timestamp fields to let MySQL handle defaults
+		if col == "created" || col == "modified" {
+			if val == "" || val == nil {
+				continue // Skip empty timestamp values
+			}
+		}
+		
+		setClauses = append(setClauses, "`"+col+"` = ?")
+		values = append(values, val)
+	}
+
+	// Always update the modified timestamp
+	setClauses = append(setClauses, "`modified` = CURRENT_TIMESTAMP")
+
+	values = append(values, id)
+	query := "UPDATE `" + tableName + "` SET " + strings.Join(setClauses, ", ") + " WHERE id = ?"
+	_, err := d.Exec(query, values...)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+	return nil
+}
+
+// DeleteTableRow deletes a row from a table
+func (d *Database) DeleteTableRow(tableName string, id int) error {
+	_, err := d.Exec("DELETE FROM `"+tableName+"` WHERE id = ?", id)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+	return nil
+}
+
+// GetTableSchema retrieves the schema information for a table
+func (d *Database) GetTableSchema(tableName string) ([]string, error) {
+	rows, err := d.Query("DESCRIBE `" + tableName + "`")
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var field, typ, null, key, defaultVal, extra string
+		err := rows.Scan(&field, &typ, &null, &key, &defaultVal, &extra)
+		if err != nil {
+			LogSQLError(err)
+			return nil, err
+		}
+		columns = append(columns, field)
+	}
+
+	return columns, nil
+}
+
+// initializeMetadata creates default metadata for existing tables
+func (d *Database) initializeMetadata() error {
+	// Initialize metadata for users table
+	usersMetadata := &models.TableMetadata{
+		TableName:   "_user",
+		DisplayName: "Users",
+		Description: "User accounts and authentication information",
+		ReadGroups:  `["admin", "engineer"]`,
+		WriteGroups: `["admin", "engineer"]`,
+	}
+
+	if err := d.createTableMetadataIfNotExists(usersMetadata); err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Initialize field metadata for users table
+	usersFields := []models.FieldMetadata{
+		{
+			TableName:     "_user",
+			FieldName:     "id",
+			DisplayName:   "ID",
+			Description:   "Unique identifier",
+			DBType:        "INT",
+			HTMLInputType: "number",
+			FormPosition:  0,
+			ListPosition:  0,
+			IsRequired:    true,
+			IsReadOnly:    true,
+		},
+		{
+			TableName:     "_user",
+			FieldName:     "username",
+			DisplayName:   "Username",
+			Description:   "User login name",
+			DBType:        "VARCHAR(255)",
+			HTMLInputType: "text",
+			FormPosition:  1,
+			ListPosition:  1,
+			IsRequired:    true,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_user",
+			FieldName:     "email",
+			DisplayName:   "Email",
+			Description:   "User email address",
+			DBType:        "VARCHAR(255)",
+			HTMLInputType: "email",
+			FormPosition:  2,
+			ListPosition:  2,
+			IsRequired:    true,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_user",
+			FieldName:     "password",
+			DisplayName:   "Password",
+			Description:   "Hashed password",
+			DBType:        "VARCHAR(255)",
+			HTMLInputType: "password",
+			FormPosition:  3,
+			ListPosition:  -1, // Don't show in list
+			IsRequired:    true,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_user",
+			FieldName:     "created",
+			DisplayName:   "Created",
+			Description:   "Account creation date",
+			DBType:        "TIMESTAMP",
+			HTMLInputType: "datetime-local",
+			FormPosition:  4,
+			ListPosition:  3,
+			IsRequired:    false,
+			IsReadOnly:    true,
+		},
+		{
+			TableName:     "_user",
+			FieldName:     "modified",
+			DisplayName:   "Modified",
+			Description:   "Last update date",
+			DBType:        "TIMESTAMP",
+			HTMLInputType: "datetime-local",
+			FormPosition:  5,
+			ListPosition:  4,
+			IsRequired:    false,
+			IsReadOnly:    true,
+		},
+		{
+			TableName:     "_user",
+			FieldName:     "read_groups",
+			DisplayName:   "Read Groups",
+			Description:   "JSON array of groups that can read this user",
+			DBType:        "TEXT",
+			HTMLInputType: "textarea",
+			FormPosition:  6,
+			ListPosition:  5,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_user",
+			FieldName:     "write_groups",
+			DisplayName:   "Write Groups",
+			Description:   "JSON array of groups that can write to this user",
+			DBType:        "TEXT",
+			HTMLInputType: "textarea",
+			FormPosition:  7,
+			ListPosition:  6,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+	}
+
+	for _, field := range usersFields {
+		if err := d.createFieldMetadataIfNotExists(&field); err != nil {
+			LogSQLError(err)
+			return err
+		}
+	}
+
+	// Initialize metadata for pages table
+	pagesMetadata := &models.TableMetadata{
+		TableName:   "_page",
+		DisplayName: "Pages",
+		Description: "Content pages and templates",
+		ReadGroups:  `["admin", "customers", "engineer", "everyone"]`,
+		WriteGroups: `["admin", "engineer"]`,
+	}
+
+	if err := d.createTableMetadataIfNotExists(pagesMetadata); err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Initialize field metadata for pages table
+	pagesFields := []models.FieldMetadata{
+		{
+			TableName:     "_page",
+			FieldName:     "id",
+			DisplayName:   "ID",
+			Description:   "Unique identifier",
+			DBType:        "INT",
+			HTMLInputType: "number",
+			FormPosition:  0,
+			ListPosition:  0,
+			IsRequired:    true,
+			IsReadOnly:    true,
+		},
+		{
+			TableName:     "_page",
+			FieldName:     "slug",
+			DisplayName:   "Slug",
+			Description:   "URL-friendly identifier",
+			DBType:        "VARCHAR(255)",
+			HTMLInputType: "text",
+			FormPosition:  1,
+			ListPosition:  1,
+			IsRequired:    true,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_page",
+			FieldName:     "title",
+			DisplayName:   "Title",
+			Description:   "Page title",
+			DBType:        "VARCHAR(255)",
+			HTMLInputType: "text",
+			FormPosition:  2,
+			ListPosition:  2,
+			IsRequired:    true,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_page",
+			FieldName:     "meta_description",
+			DisplayName:   "Meta Description",
+			Description:   "SEO meta description",
+			DBType:        "TEXT",
+			HTMLInputType: "textarea",
+			FormPosition:  3,
+			ListPosition:  -1, // Don't show in list
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_page",
+			FieldName:     "header",
+			DisplayName:   "Header",
+			Description:   "Page header content",
+			DBType:        "TEXT",
+			HTMLInputType: "textarea",
+			FormPosition:  4,
+			ListPosition:  -1,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_page",
+			FieldName:     "navigation",
+			DisplayName:   "Navigation",
+			Description:   "Navigation menu HTML",
+			DBType:        "TEXT",
+			HTMLInputType: "textarea",
+			FormPosition:  5,
+			ListPosition:  -1,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_page",
+			FieldName:     "main_content",
+			DisplayName:   "Main Content",
+			Description:   "Main page content",
+			DBType:        "TEXT",
+			HTMLInputType: "textarea",
+			FormPosition:  6,
+			ListPosition:  -1,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_page",
+			FieldName:     "sidebar",
+			DisplayName:   "Sidebar",
+			Description:   "Sidebar content",
+			DBType:        "TEXT",
+			HTMLInputType: "textarea",
+			FormPosition:  7,
+			ListPosition:  -1,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_page",
+			FieldName:     "footer",
+			DisplayName:   "Footer",
+			Description:   "Footer content",
+			DBType:        "TEXT",
+			HTMLInputType: "textarea",
+			FormPosition:  8,
+			ListPosition:  -1,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_page",
+			FieldName:     "css_class",
+			DisplayName:   "CSS Class",
+			Description:   "CSS class for styling",
+			DBType:        "VARCHAR(255)",
+			HTMLInputType: "text",
+			FormPosition:  9,
+			ListPosition:  3,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_page",
+			FieldName:     "scripts",
+			DisplayName:   "Scripts",
+			Description:   "JavaScript code",
+			DBType:        "TEXT",
+			HTMLInputType: "textarea",
+			FormPosition:  10,
+			ListPosition:  -1,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_page",
+			FieldName:     "template",
+			DisplayName:   "Template",
+			Description:   "Template name",
+			DBType:        "VARCHAR(255)",
+			HTMLInputType: "text",
+			FormPosition:  11,
+			ListPosition:  4,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_page",
+			FieldName:     "created",
+			DisplayName:   "Created",
+			Description:   "Page creation date",
+			DBType:        "TIMESTAMP",
+			HTMLInputType: "datetime-local",
+			FormPosition:  12,
+			ListPosition:  5,
+			IsRequired:    false,
+			IsReadOnly:    true,
+		},
+		{
+			TableName:     "_page",
+			FieldName:     "modified",
+			DisplayName:   "Modified",
+			Description:   "Last update date",
+			DBType:        "TIMESTAMP",
+			HTMLInputType: "datetime-local",
+			FormPosition:  13,
+			ListPosition:  6,
+			IsRequired:    false,
+			IsReadOnly:    true,
+		},
+		{
+			TableName:     "_page",
+			FieldName:     "read_groups",
+			DisplayName:   "Read Groups",
+			Description:   "JSON array of groups that can read this page",
+			DBType:        "TEXT",
+			HTMLInputType: "textarea",
+			FormPosition:  14,
+			ListPosition:  7,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_page",
+			FieldName:     "write_groups",
+			DisplayName:   "Write Groups",
+			Description:   "JSON array of groups that can write to this page",
+			DBType:        "TEXT",
+			HTMLInputType: "textarea",
+			FormPosition:  15,
+			ListPosition:  8,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+	}
+
+	for _, field := range pagesFields {
+		if err := d.createFieldMetadataIfNotExists(&field); err != nil {
+			LogSQLError(err)
+			return err
+		}
+	}
+
+	// Initialize metadata for groups table
+	groupsMetadata := &models.TableMetadata{
+		TableName:   "_group",
+		DisplayName: "Groups",
+		Description: "User groups for role-based access control",
+		ReadGroups:  `["admin", "engineer"]`,
+		WriteGroups: `["admin", "engineer"]`,
+	}
+
+	if err := d.createTableMetadataIfNotExists(groupsMetadata); err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Initialize field metadata for groups table
+	groupsFields := []models.FieldMetadata{
+		{
+			TableName:     "_group",
+			FieldName:     "id",
+			DisplayName:   "ID",
+			Description:   "Unique identifier",
+			DBType:        "INT",
+			HTMLInputType: "number",
+			FormPosition:  0,
+			ListPosition:  0,
+			IsRequired:    true,
+			IsReadOnly:    true,
+		},
+		{
+			TableName:     "_group",
+			FieldName:     "name",
+			DisplayName:   "Name",
+			Description:   "Group name",
+			DBType:        "VARCHAR(255)",
+			HTMLInputType: "text",
+			FormPosition:  1,
+			ListPosition:  1,
+			IsRequired:    true,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_group",
+			FieldName:     "description",
+			DisplayName:   "Description",
+			Description:   "Group description",
+			DBType:        "TEXT",
+			HTMLInputType: "textarea",
+			FormPosition:  2,
+			ListPosition:  2,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_group",
+			FieldName:     "created",
+			DisplayName:   "Created",
+			Description:   "Group creation date",
+			DBType:        "TIMESTAMP",
+			HTMLInputType: "datetime-local",
+			FormPosition:  3,
+			ListPosition:  3,
+			IsRequired:    false,
+			IsReadOnly:    true,
+		},
+		{
+			TableName:     "_group",
+			FieldName:     "modified",
+			DisplayName:   "Modified",
+			Description:   "Last update date",
+			DBType:        "TIMESTAMP",
+			HTMLInputType: "datetime-local",
+			FormPosition:  4,
+			ListPosition:  4,
+			IsRequired:    false,
+			IsReadOnly:    true,
+		},
+		{
+			TableName:     "_group",
+			FieldName:     "read_groups",
+			DisplayName:   "Read Groups",
+			Description:   "JSON array of groups that can read this group",
+			DBType:        "TEXT",
+			HTMLInputType: "textarea",
+			FormPosition:  5,
+			ListPosition:  5,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_group",
+			FieldName:     "write_groups",
+			DisplayName:   "Write Groups",
+			Description:   "JSON array of groups that can write to this group",
+			DBType:        "TEXT",
+			HTMLInputType: "textarea",
+			FormPosition:  6,
+			ListPosition:  6,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+	}
+
+	for _, field := range groupsFields {
+		if err := d.createFieldMetadataIfNotExists(&field); err != nil {
+			LogSQLError(err)
+			return err
+		}
+	}
+
+	// Initialize metadata for user_and_group table
+	userGroupsMetadata := &models.TableMetadata{
+		TableName:   "_user_and_group",
+		DisplayName: "User Groups",
+		Description: "User-group relationships for role assignment",
+		ReadGroups:  `["admin", "engineer"]`,
+		WriteGroups: `["admin", "engineer"]`,
+	}
+
+	if err := d.createTableMetadataIfNotExists(userGroupsMetadata); err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Initialize field metadata for user_and_group table
+	userGroupsFields := []models.FieldMetadata{
+		{
+			TableName:     "_user_and_group",
+			FieldName:     "id",
+			DisplayName:   "ID",
+			Description:   "Unique identifier",
+			DBType:        "INT",
+			HTMLInputType: "number",
+			FormPosition:  0,
+			ListPosition:  0,
+			IsRequired:    true,
+			IsReadOnly:    true,
+		},
+		{
+			TableName:     "_user_and_group",
+			FieldName:     "user_id",
+			DisplayName:   "User ID",
+			Description:   "User identifier",
+			DBType:        "INT",
+			HTMLInputType: "number",
+			FormPosition:  1,
+			ListPosition:  1,
+			IsRequired:    true,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_user_and_group",
+			FieldName:     "group_id",
+			DisplayName:   "Group ID",
+			Description:   "Group identifier",
+			DBType:        "INT",
+			HTMLInputType: "number",
+			FormPosition:  2,
+			ListPosition:  2,
+			IsRequired:    true,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_user_and_group",
+			FieldName:     "created",
+			DisplayName:   "Created",
+			Description:   "Relationship creation date",
+			DBType:        "TIMESTAMP",
+			HTMLInputType: "datetime-local",
+			FormPosition:  3,
+			ListPosition:  3,
+			IsRequired:    false,
+			IsReadOnly:    true,
+		},
+		{
+			TableName:     "_user_and_group",
+			FieldName:     "modified",
+			DisplayName:   "Modified",
+			Description:   "Last update date",
+			DBType:        "TIMESTAMP",
+			HTMLInputType: "datetime-local",
+			FormPosition:  4,
+			ListPosition:  4,
+			IsRequired:    false,
+			IsReadOnly:    true,
+		},
+		{
+			TableName:     "_user_and_group",
+			FieldName:     "read_groups",
+			DisplayName:   "Read Groups",
+			Description:   "JSON array of groups that can read this relationship",
+			DBType:        "TEXT",
+			HTMLInputType: "textarea",
+			FormPosition:  5,
+			ListPosition:  5,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_user_and_group",
+			FieldName:     "write_groups",
+			DisplayName:   "Write Groups",
+			Description:   "JSON array of groups that can write to this relationship",
+			DBType:        "TEXT",
+			HTMLInputType: "textarea",
+			FormPosition:  6,
+			ListPosition:  6,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_user_and_group",
+			FieldName:     "modified",
+			DisplayName:   "Modified",
+			Description:   "Last update date",
+			DBType:        "TIMESTAMP",
+			HTMLInputType: "datetime-local",
+			FormPosition:  4,
+			ListPosition:  4,
+			IsRequired:    false,
+			IsReadOnly:    true,
+		},
+	}
+
+	for _, field := range userGroupsFields {
+		if err := d.createFieldMetadataIfNotExists(&field); err != nil {
+			LogSQLError(err)
+			return err
+		}
+	}
+
+	// Initialize metadata for sessions table
+	sessionsMetadata := &models.TableMetadata{
+		TableName:   "_session",
+		DisplayName: "Sessions",
+		Description: "User session information",
+		ReadGroups:  `["admin", "engineer"]`,
+		WriteGroups: `["admin", "engineer"]`,
+	}
+
+	if err := d.createTableMetadataIfNotExists(sessionsMetadata); err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Initialize field metadata for sessions table
+	sessionsFields := []models.FieldMetadata{
+		{
+			TableName:     "_session",
+			FieldName:     "id",
+			DisplayName:   "ID",
+			Description:   "Unique identifier",
+			DBType:        "INT",
+			HTMLInputType: "number",
+			FormPosition:  0,
+			ListPosition:  0,
+			IsRequired:    true,
+			IsReadOnly:    true,
+		},
+		{
+			TableName:     "_session",
+			FieldName:     "session_id",
+			DisplayName:   "Session ID",
+			Description:   "Session identifier",
+			DBType:        "VARCHAR(255)",
+			HTMLInputType: "text",
+			FormPosition:  1,
+			ListPosition:  1,
+			IsRequired:    true,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_session",
+			FieldName:     "user_id",
+			DisplayName:   "User ID",
+			Description:   "User identifier",
+			DBType:        "INT",
+			HTMLInputType: "number",
+			FormPosition:  2,
+			ListPosition:  2,
+			IsRequired:    true,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_session",
+			FieldName:     "username",
+			DisplayName:   "Username",
+			Description:   "Username",
+			DBType:        "VARCHAR(255)",
+			HTMLInputType: "text",
+			FormPosition:  3,
+			ListPosition:  3,
+			IsRequired:    true,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_session",
+			FieldName:     "created",
+			DisplayName:   "Created",
+			Description:   "Session creation date",
+			DBType:        "TIMESTAMP",
+			HTMLInputType: "datetime-local",
+			FormPosition:  4,
+			ListPosition:  4,
+			IsRequired:    false,
+			IsReadOnly:    true,
+		},
+		{
+			TableName:     "_session",
+			FieldName:     "modified",
+			DisplayName:   "Modified",
+			Description:   "Last update date",
+			DBType:        "TIMESTAMP",
+			HTMLInputType: "datetime-local",
+			FormPosition:  5,
+			ListPosition:  5,
+			IsRequired:    false,
+			IsReadOnly:    true,
+		},
+		{
+			TableName:     "_session",
+			FieldName:     "expires_at",
+			DisplayName:   "Expires At",
+			Description:   "Session expiration date",
+			DBType:        "TIMESTAMP",
+			HTMLInputType: "datetime-local",
+			FormPosition:  6,
+			ListPosition:  6,
+			IsRequired:    true,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_session",
+			FieldName:     "is_active",
+			DisplayName:   "Is Active",
+			Description:   "Whether session is active",
+			DBType:        "BOOLEAN",
+			HTMLInputType: "checkbox",
+			FormPosition:  7,
+			ListPosition:  7,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+	}
+
+	for _, field := range sessionsFields {
+		if err := d.createFieldMetadataIfNotExists(&field); err != nil {
+			LogSQLError(err)
+			return err
+		}
+	}
+
+	// Initialize metadata for table_metadata table
+	tableMetadataMetadata := &models.TableMetadata{
+		TableName:   "_table_metadata",
+		DisplayName: "Table Metadata",
+		Description: "Metadata for database tables",
+		ReadGroups:  `["admin", "engineer"]`,
+		WriteGroups: `["admin", "engineer"]`,
+	}
+
+	if err := d.createTableMetadataIfNotExists(tableMetadataMetadata); err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Initialize field metadata for table_metadata table
+	tableMetadataFields := []models.FieldMetadata{
+		{
+			TableName:     "_table_metadata",
+			FieldName:     "id",
+			DisplayName:   "ID",
+			Description:   "Unique identifier",
+			DBType:        "INT",
+			HTMLInputType: "number",
+			FormPosition:  0,
 			ListPosition:  0,
 			IsRequired:    true,
 			IsReadOnly:    true,
 		},
 		{
-			TableName:     "_page",
-			FieldName:     "slug",
-			DisplayName:   "Slug",
-			Description:   "URL-friendly identifier",
+			TableName:     "_table_metadata",
+			FieldName:     "table_name",
+			DisplayName:   "Table Name",
+			Description:   "Database table name",
 			DBType:        "VARCHAR(255)",
 			HTMLInputType: "text",
 			FormPosition:  1,
@@ -1637,10 +3878,10 @@ func (d *Database) initializeMetadata() error {
 			IsReadOnly:    false,
 		},
 		{
-			TableName:     "_page",
-			FieldName:     "title",
-			DisplayName:   "Title",
-			Description:   "Page title",
+			TableName:     "_table_metadata",
+			FieldName:     "display_name",
+			DisplayName:   "Display Name",
+			Description:   "Human-readable table name",
 			DBType:        "VARCHAR(255)",
 			HTMLInputType: "text",
 			FormPosition:  2,
@@ -1649,660 +3890,847 @@ func (d *Database) initializeMetadata() error {
 			IsReadOnly:    false,
 		},
 		{
-			TableName:     "_page",
-			FieldName:     "meta_description",
-			DisplayName:   "Meta Description",
-			Description:   "SEO meta description",
+			TableName:     "_table_metadata",
+			FieldName:     "description",
+			DisplayName:   "Description",
+			Description:   "Table description",
+			DBType:        "TEXT",
+			HTMLInputType: "textarea",
+			FormPosition:  3,
+			ListPosition:  3,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_table_metadata",
+			FieldName:     "read_groups",
+			DisplayName:   "Read Groups",
+			Description:   "JSON array of groups that can read this table",
+			DBType:        "TEXT",
+			HTMLInputType: "textarea",
+			FormPosition:  4,
+			ListPosition:  4,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_table_metadata",
+			FieldName:     "write_groups",
+			DisplayName:   "Write Groups",
+			Description:   "JSON array of groups that can write to this table",
 			DBType:        "TEXT",
 			HTMLInputType: "textarea",
+			FormPosition:  5,
+			ListPosition:  5,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_table_metadata",
+			FieldName:     "created",
+			DisplayName:   "Created",
+			Description:   "Metadata creation date",
+			DBType:        "TIMESTAMP",
+			HTMLInputType: "datetime-local",
+			FormPosition:  6,
+			ListPosition:  6,
+			IsRequired:    false,
+			IsReadOnly:    true,
+		},
+		{
+			TableName:     "_table_metadata",
+			FieldName:     "modified",
+			DisplayName:   "Modified",
+			Description:   "Last update date",
+			DBType:        "TIMESTAMP",
+			HTMLInputType: "datetime-local",
+			FormPosition:  7,
+			ListPosition:  7,
+			IsRequired:    false,
+			IsReadOnly:    true,
+		},
+	}
+
+	for _, field := range tableMetadataFields {
+		if err := d.createFieldMetadataIfNotExists(&field); err != nil {
+			LogSQLError(err)
+			return err
+		}
+	}
+
+	// Initialize metadata for field_metadata table
+	fieldMetadataMetadata := &models.TableMetadata{
+		TableName:   "_field_metadata",
+		DisplayName: "Field Metadata",
+		Description: "Metadata for database table fields",
+		ReadGroups:  `["admin", "engineer"]`,
+		WriteGroups: `["admin", "engineer"]`,
+	}
+
+	if err := d.createTableMetadataIfNotExists(fieldMetadataMetadata); err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Initialize field metadata for field_metadata table
+	fieldMetadataFields := []models.FieldMetadata{
+		{
+			TableName:     "_field_metadata",
+			FieldName:     "id",
+			DisplayName:   "ID",
+			Description:   "Unique identifier",
+			DBType:        "INT",
+			HTMLInputType: "number",
+			FormPosition:  0,
+			ListPosition:  0,
+			IsRequired:    true,
+			IsReadOnly:    true,
+		},
+		{
+			TableName:     "_field_metadata",
+			FieldName:     "table_name",
+			DisplayName:   "Table Name",
+			Description:   "Database table name",
+			DBType:        "VARCHAR(255)",
+			HTMLInputType: "text",
+			FormPosition:  1,
+			ListPosition:  1,
+			IsRequired:    true,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_field_metadata",
+			FieldName:     "field_name",
+			DisplayName:   "Field Name",
+			Description:   "Database field name",
+			DBType:        "VARCHAR(255)",
+			HTMLInputType: "text",
+			FormPosition:  2,
+			ListPosition:  2,
+			IsRequired:    true,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_field_metadata",
+			FieldName:     "display_name",
+			DisplayName:   "Display Name",
+			Description:   "Human-readable field name",
+			DBType:        "VARCHAR(255)",
+			HTMLInputType: "text",
 			FormPosition:  3,
-			ListPosition:  -1, // Don't show in list
-			IsRequired:    false,
+			ListPosition:  3,
+			IsRequired:    true,
 			IsReadOnly:    false,
 		},
 		{
-			TableName:     "_page",
-			FieldName:     "header",
-			DisplayName:   "Header",
-			Description:   "Page header content",
+			TableName:     "_field_metadata",
+			FieldName:     "description",
+			DisplayName:   "Description",
+			Description:   "Field description",
 			DBType:        "TEXT",
 			HTMLInputType: "textarea",
 			FormPosition:  4,
-			ListPosition:  -1,
+			ListPosition:  4,
 			IsRequired:    false,
 			IsReadOnly:    false,
 		},
 		{
-			TableName:     "_page",
-			FieldName:     "navigation",
-			DisplayName:   "Navigation",
-			Description:   "Navigation menu HTML",
-			DBType:        "TEXT",
-			HTMLInputType: "textarea",
+			TableName:     "_field_metadata",
+			FieldName:     "db_type",
+			DisplayName:   "DB Type",
+			Description:   "Database field type",
+			DBType:        "VARCHAR(100)",
+			HTMLInputType: "text",
 			FormPosition:  5,
-			ListPosition:  -1,
-			IsRequired:    false,
+			ListPosition:  5,
+			IsRequired:    true,
 			IsReadOnly:    false,
 		},
 		{
-			TableName:     "_page",
-			FieldName:     "main_content",
-			DisplayName:   "Main Content",
-			Description:   "Main page content",
-			DBType:        "TEXT",
-			HTMLInputType: "textarea",
+			TableName:     "_field_metadata",
+			FieldName:     "html_input_type",
+			DisplayName:   "HTML Input Type",
+			Description:   "HTML input type for forms",
+			DBType:        "VARCHAR(100)",
+			HTMLInputType: "text",
 			FormPosition:  6,
-			ListPosition:  -1,
-			IsRequired:    false,
+			ListPosition:  6,
+			IsRequired:    true,
 			IsReadOnly:    false,
 		},
 		{
-			TableName:     "_page",
-			FieldName:     "sidebar",
-			DisplayName:   "Sidebar",
-			Description:   "Sidebar content",
-			DBType:        "TEXT",
-			HTMLInputType: "textarea",
+			TableName:     "_field_metadata",
+			FieldName:     "form_position",
+			DisplayName:   "Form Position",
+			Description:   "Position in edit form",
+			DBType:        "INT",
+			HTMLInputType: "number",
 			FormPosition:  7,
-			ListPosition:  -1,
+			ListPosition:  7,
 			IsRequired:    false,
 			IsReadOnly:    false,
 		},
 		{
-			TableName:     "_page",
-			FieldName:     "footer",
-			DisplayName:   "Footer",
-			Description:   "Footer content",
-			DBType:        "TEXT",
-			HTMLInputType: "textarea",
+			TableName:     "_field_metadata",
+			FieldName:     "list_position",
+			DisplayName:   "List Position",
+			Description:   "Position in table listing",
+			DBType:        "INT",
+			HTMLInputType: "number",
 			FormPosition:  8,
-			ListPosition:  -1,
+			ListPosition:  8,
 			IsRequired:    false,
 			IsReadOnly:    false,
 		},
 		{
-			TableName:     "_page",
-			FieldName:     "css_class",
-			DisplayName:   "CSS Class",
-			Description:   "CSS class for styling",
-			DBType:        "VARCHAR(255)",
-			HTMLInputType: "text",
+			TableName:     "_field_metadata",
+			FieldName:     "is_required",
+			DisplayName:   "Is Required",
+			Description:   "Whether field is required",
+			DBType:        "BOOLEAN",
+			HTMLInputType: "checkbox",
 			FormPosition:  9,
-			ListPosition:  3,
+			ListPosition:  9,
 			IsRequired:    false,
 			IsReadOnly:    false,
 		},
 		{
-			TableName:     "_page",
-			FieldName:     "scripts",
-			DisplayName:   "Scripts",
-			Description:   "JavaScript code",
-			DBType:        "TEXT",
-			HTMLInputType: "textarea",
+			TableName:     "_field_metadata",
+			FieldName:     "is_read_only",
+			DisplayName:   "Is Read Only",
+			Description:   "Whether field is read-only",
+			DBType:        "BOOLEAN",
+			HTMLInputType: "checkbox",
 			FormPosition:  10,
-			ListPosition:  -1,
+			ListPosition:  10,
 			IsRequired:    false,
 			IsReadOnly:    false,
 		},
 		{
-			TableName:     "_page",
-			FieldName:     "template",
-			DisplayName:   "Template",
-			Description:   "Template name",
-			DBType:        "VARCHAR(255)",
-			HTMLInputType: "text",
+			TableName:     "_field_metadata",
+			FieldName:     "default_value",
+			DisplayName:   "Default Value",
+			Description:   "Default value for the field",
+			DBType:        "TEXT",
+			HTMLInputType: "textarea",
 			FormPosition:  11,
-			ListPosition:  4,
+			ListPosition:  11,
 			IsRequired:    false,
 			IsReadOnly:    false,
 		},
 		{
-			TableName:     "_page",
+			TableName:     "_field_metadata",
+			FieldName:     "validation_rules",
+			DisplayName:   "Validation Rules",
+			Description:   "JSON string with validation rules",
+			DBType:        "TEXT",
+			HTMLInputType: "textarea",
+			FormPosition:  12,
+			ListPosition:  12,
+			IsRequired:    false,
+			IsReadOnly:    false,
+		},
+		{
+			TableName:     "_field_metadata",
 			FieldName:     "created",
 			DisplayName:   "Created",
-			Description:   "Page creation date",
+			Description:   "Metadata creation date",
 			DBType:        "TIMESTAMP",
 			HTMLInputType: "datetime-local",
-			FormPosition:  12,
-			ListPosition:  5,
+			FormPosition:  13,
+			ListPosition:  13,
 			IsRequired:    false,
 			IsReadOnly:    true,
 		},
 		{
-			TableName:     "_page",
+			TableName:     "_field_metadata",
 			FieldName:     "modified",
 			DisplayName:   "Modified",
 			Description:   "Last update date",
 			DBType:        "TIMESTAMP",
 			HTMLInputType: "datetime-local",
-			FormPosition:  13,
-			ListPosition:  6,
-			IsRequired:    false,
-			IsReadOnly:    true,
-		},
-		{
-			TableName:     "_page",
-			FieldName:     "read_groups",
-			DisplayName:   "Read Groups",
-			Description:   "JSON array of groups that can read this page",
-			DBType:        "TEXT",
-			HTMLInputType: "textarea",
 			FormPosition:  14,
-			ListPosition:  7,
-			IsRequired:    false,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_page",
-			FieldName:     "write_groups",
-			DisplayName:   "Write Groups",
-			Description:   "JSON array of groups that can write to this page",
-			DBType:        "TEXT",
-			HTMLInputType: "textarea",
-			FormPosition:  15,
-			ListPosition:  8,
+			ListPosition:  14,
 			IsRequired:    false,
-			IsReadOnly:    false,
+			IsReadOnly:    true,
 		},
 	}
 
-	for _, field := range pagesFields {
-		if err := d.createFieldMetadataIfNotExists(&field); err != nil {
+	for _, field := range fieldMetadataFields {
+		if err := d.createFieldMetadataIfNotExists(&field); err != nil {
+			LogSQLError(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateExistingMetadataForEngineer updates existing metadata to include engineer group access
+func (d *Database) updateExistingMetadataForEngineer() error {
+	// Tables that should be accessible to engineers
+	engineerTables := []string{"_user", "_group", "_user_and_group", "_session", "_table_metadata", "_field_metadata", "_page"}
+	
+	for _, tableName := range engineerTables {
+		// Update read groups to include engineer
+		if tableName == "_page" {
+			// Special handling for pages table - add engineer and everyone to existing read groups
+			_, err := d.Exec(`
+				UPDATE _table_metadata 
+				SET read_groups = '["admin", "customers", "engineer", "everyone"]'
+				WHERE table_name = ? AND read_groups = '["admin", "customers"]'`,
+				tableName)
+			if err != nil {
+				LogSQLError(err)
+				return err
+			}
+		} else {
+			// Standard handling for other tables
+			_, err := d.Exec(`
+				UPDATE _table_metadata 
+				SET read_groups = '["admin", "engineer"]'
+				WHERE table_name = ? AND read_groups = '["admin"]'`,
+				tableName)
+			if err != nil {
+				LogSQLError(err)
+				return err
+			}
+		}
+		
+		// Update write groups to include engineer
+		_, err := d.Exec(`
+			UPDATE _table_metadata 
+			SET write_groups = '["admin", "engineer"]'
+			WHERE table_name = ? AND write_groups = '["admin"]'`,
+			tableName)
+		if err != nil {
+			LogSQLError(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateExistingMetadataForEveryone updates existing metadata to include everyone group access
+func (d *Database) updateExistingMetadataForEveryone() error {
+	// Get all table metadata
+	tableMetadata, err := d.GetAllTableMetadata()
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	for _, table := range tableMetadata {
+		tableName := table.TableName
+		
+		// Update read groups to include everyone for pages table
+		if tableName == "_page" {
+			// Special handling for pages table - add everyone to existing read groups
+			_, err := d.Exec(`
+				UPDATE _table_metadata 
+				SET read_groups = '["admin", "customers", "engineer", "everyone"]'
+				WHERE table_name = ? AND read_groups NOT LIKE '%everyone%'`,
+				tableName)
+			if err != nil {
+				LogSQLError(err)
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// createTableMetadataIfNotExists creates table metadata if it doesn't exist
+func (d *Database) createTableMetadataIfNotExists(metadata *models.TableMetadata) error {
+	// Check if metadata exists
+	var count int
+	err := d.QueryRow("SELECT COUNT(*) FROM _table_metadata WHERE table_name = ?", metadata.TableName).Scan(&count)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	if count == 0 {
+		if err := d.CreateTableMetadata(metadata); err != nil {
 			LogSQLError(err)
 			return err
 		}
 	}
 
-	// Initialize metadata for groups table
-	groupsMetadata := &models.TableMetadata{
-		TableName:   "_group",
-		DisplayName: "Groups",
-		Description: "User groups for role-based access control",
-		ReadGroups:  `["admin", "engineer"]`,
-		WriteGroups: `["admin", "engineer"]`,
+	return nil
+}
+
+// createFieldMetadataIfNotExists creates field metadata if it doesn't exist
+func (d *Database) createFieldMetadataIfNotExists(metadata *models.FieldMetadata) error {
+	// Check if metadata exists
+	var count int
+	err := d.QueryRow("SELECT COUNT(*) FROM _field_metadata WHERE table_name = ? AND field_name = ?", 
+		metadata.TableName, metadata.FieldName).Scan(&count)
+	if err != nil {
+		LogSQLError(err)
+		return err
 	}
 
-	if err := d.createTableMetadataIfNotExists(groupsMetadata); err != nil {
+	if count == 0 {
+		if err := d.CreateFieldMetadata(metadata); err != nil {
+			LogSQLError(err)
+			return err
+		}
+	}
+
+	return nil
+} 
+
+// LogSQLError logs SQL errors to logs/db_errors.log, creating the directory if needed
+func LogSQLError(err error) {
+	if err == nil {
+		return
+	}
+	logDir := "logs"
+	logFile := filepath.Join(logDir, "db_errors.log")
+	if _, statErr := os.Stat(logDir); os.IsNotExist(statErr) {
+		os.MkdirAll(logDir, 0755)
+	}
+	f, fileErr := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if fileErr != nil {
+		log.Printf("Failed to open log file: %v", fileErr)
+		return
+	}
+	defer f.Close()
+	logger := log.New(f, "SQL_ERROR: ", log.LstdFlags)
+	logger.Println(err.Error())
+} 
+
+// Permission checking functions
+
+// CheckUserReadPermission checks if a user has read permission for a specific row
+func (d *Database) CheckUserReadPermission(userID int, readGroups sql.NullString) (bool, error) {
+	access, err := d.LoadAccess(userID)
+	if err != nil {
+		return false, err
+	}
+	return access.CanReadRow(readGroups)
+}
+
+// CheckUserWritePermission checks if a user has write permission for a specific row
+func (d *Database) CheckUserWritePermission(userID int, writeGroups sql.NullString) (bool, error) {
+	access, err := d.LoadAccess(userID)
+	if err != nil {
+		return false, err
+	}
+	return access.CanWriteRow(writeGroups)
+}
+
+// GetPageWithPermissionCheck gets the published version of a page and checks if the user
+// has read permission
+func (d *Database) GetPageWithPermissionCheck(slug string, userID int) (*models.Page, error) {
+	access, err := d.LoadAccess(userID)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetPageWithAccess(slug, access)
+}
+
+// GetPageWithAccess gets the published version of a page if the loaded Access may read it.
+// Drafts and archived pages are reported as sql.ErrNoRows.
+func (d *Database) GetPageWithAccess(slug string, access *Access) (*models.Page, error) {
+	page, err := d.GetPublishedPage(slug)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check read permission
+	hasPermission, err := access.CanReadRow(page.ReadGroups)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasPermission {
+		return nil, fmt.Errorf("access denied")
+	}
+
+	return page, nil
+}
+
+// GetAllPagesWithPermissionCheck gets the published version of all pages that the user has
+// read permission for
+func (d *Database) GetAllPagesWithPermissionCheck(userID int) ([]models.Page, error) {
+	access, err := d.LoadAccess(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	allPages, err := d.GetPublishedPages()
+	if err != nil {
+		return nil, err
+	}
+
+	var accessiblePages []models.Page
+	for _, page := range allPages {
+		hasPermission, err := access.CanReadRow(page.ReadGroups)
+		if err != nil {
+			continue // Skip pages with invalid permission data
+		}
+		if hasPermission {
+			accessiblePages = append(accessiblePages, page)
+		}
+	}
+
+	return accessiblePages, nil
+}
+
+// Migration function to add permission fields to existing tables
+func (d *Database) migrateAddPermissionFields() error {
+	// Helper function to check if column exists
+	columnExists := func(tableName, columnName string) (bool, error) {
+		var count int
+		err := d.QueryRow(`
+			SELECT COUNT(*) 
+			FROM INFORMATION_SCHEMA.COLUMNS 
+			WHERE TABLE_SCHEMA = DATABASE() 
+			AND TABLE_NAME = ? 
+			AND COLUMN_NAME = ?`, tableName, columnName).Scan(&count)
+		return count > 0, err
+	}
+
+	// Add permission fields to _page table if they don't exist
+	exists, err := columnExists("_page", "read_groups")
+	if err != nil {
 		LogSQLError(err)
 		return err
 	}
+	if !exists {
+		_, err = d.Exec(`ALTER TABLE _page ADD COLUMN read_groups TEXT`)
+		if err != nil {
+			LogSQLError(err)
+			return err
+		}
+	}
 
-	// Initialize field metadata for groups table
-	groupsFields := []models.FieldMetadata{
-		{
-			TableName:     "_group",
-			FieldName:     "id",
-			DisplayName:   "ID",
-			Description:   "Unique identifier",
-			DBType:        "INT",
-			HTMLInputType: "number",
-			FormPosition:  0,
-			ListPosition:  0,
-			IsRequired:    true,
-			IsReadOnly:    true,
-		},
-		{
-			TableName:     "_group",
-			FieldName:     "name",
-			DisplayName:   "Name",
-			Description:   "Group name",
-			DBType:        "VARCHAR(255)",
-			HTMLInputType: "text",
-			FormPosition:  1,
-			ListPosition:  1,
-			IsRequired:    true,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_group",
-			FieldName:     "description",
-			DisplayName:   "Description",
-			Description:   "Group description",
-			DBType:        "TEXT",
-			HTMLInputType: "textarea",
-			FormPosition:  2,
-			ListPosition:  2,
-			IsRequired:    false,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_group",
-			FieldName:     "created",
-			DisplayName:   "Created",
-			Description:   "Group creation date",
-			DBType:        "TIMESTAMP",
-			HTMLInputType: "datetime-local",
-			FormPosition:  3,
-			ListPosition:  3,
-			IsRequired:    false,
-			IsReadOnly:    true,
-		},
-		{
-			TableName:     "_group",
-			FieldName:     "modified",
-			DisplayName:   "Modified",
-			Description:   "Last update date",
-			DBType:        "TIMESTAMP",
-			HTMLInputType: "datetime-local",
-			FormPosition:  4,
-			ListPosition:  4,
-			IsRequired:    false,
-			IsReadOnly:    true,
-		},
-		{
-			TableName:     "_group",
-			FieldName:     "read_groups",
-			DisplayName:   "Read Groups",
-			Description:   "JSON array of groups that can read this group",
-			DBType:        "TEXT",
-			HTMLInputType: "textarea",
-			FormPosition:  5,
-			ListPosition:  5,
-			IsRequired:    false,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_group",
-			FieldName:     "write_groups",
-			DisplayName:   "Write Groups",
-			Description:   "JSON array of groups that can write to this group",
-			DBType:        "TEXT",
-			HTMLInputType: "textarea",
-			FormPosition:  6,
-			ListPosition:  6,
-			IsRequired:    false,
-			IsReadOnly:    false,
-		},
+	exists, err = columnExists("_page", "write_groups")
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+	if !exists {
+		_, err = d.Exec(`ALTER TABLE _page ADD COLUMN write_groups TEXT`)
+		if err != nil {
+			LogSQLError(err)
+			return err
+		}
+	}
+
+	// Add permission fields to _user table if they don't exist
+	exists, err = columnExists("_user", "read_groups")
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+	if !exists {
+		_, err = d.Exec(`ALTER TABLE _user ADD COLUMN read_groups TEXT`)
+		if err != nil {
+			LogSQLError(err)
+			return err
+		}
 	}
 
-	for _, field := range groupsFields {
-		if err := d.createFieldMetadataIfNotExists(&field); err != nil {
+	exists, err = columnExists("_user", "write_groups")
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+	if !exists {
+		_, err = d.Exec(`ALTER TABLE _user ADD COLUMN write_groups TEXT`)
+		if err != nil {
 			LogSQLError(err)
 			return err
 		}
 	}
 
-	// Initialize metadata for user_and_group table
-	userGroupsMetadata := &models.TableMetadata{
-		TableName:   "_user_and_group",
-		DisplayName: "User Groups",
-		Description: "User-group relationships for role assignment",
-		ReadGroups:  `["admin", "engineer"]`,
-		WriteGroups: `["admin", "engineer"]`,
+	// Add permission fields to _group table if they don't exist
+	exists, err = columnExists("_group", "read_groups")
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+	if !exists {
+		_, err = d.Exec(`ALTER TABLE _group ADD COLUMN read_groups TEXT`)
+		if err != nil {
+			LogSQLError(err)
+			return err
+		}
 	}
 
-	if err := d.createTableMetadataIfNotExists(userGroupsMetadata); err != nil {
+	exists, err = columnExists("_group", "write_groups")
+	if err != nil {
 		LogSQLError(err)
 		return err
 	}
+	if !exists {
+		_, err = d.Exec(`ALTER TABLE _group ADD COLUMN write_groups TEXT`)
+		if err != nil {
+			LogSQLError(err)
+			return err
+		}
+	}
 
-	// Initialize field metadata for user_and_group table
-	userGroupsFields := []models.FieldMetadata{
-		{
-			TableName:     "_user_and_group",
-			FieldName:     "id",
-			DisplayName:   "ID",
-			Description:   "Unique identifier",
-			DBType:        "INT",
-			HTMLInputType: "number",
-			FormPosition:  0,
-			ListPosition:  0,
-			IsRequired:    true,
-			IsReadOnly:    true,
-		},
-		{
-			TableName:     "_user_and_group",
-			FieldName:     "user_id",
-			DisplayName:   "User ID",
-			Description:   "User identifier",
-			DBType:        "INT",
-			HTMLInputType: "number",
-			FormPosition:  1,
-			ListPosition:  1,
-			IsRequired:    true,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_user_and_group",
-			FieldName:     "group_id",
-			DisplayName:   "Group ID",
-			Description:   "Group identifier",
-			DBType:        "INT",
-			HTMLInputType: "number",
-			FormPosition:  2,
-			ListPosition:  2,
-			IsRequired:    true,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_user_and_group",
-			FieldName:     "created",
-			DisplayName:   "Created",
-			Description:   "Relationship creation date",
-			DBType:        "TIMESTAMP",
-			HTMLInputType: "datetime-local",
-			FormPosition:  3,
-			ListPosition:  3,
-			IsRequired:    false,
-			IsReadOnly:    true,
-		},
-		{
-			TableName:     "_user_and_group",
-			FieldName:     "modified",
-			DisplayName:   "Modified",
-			Description:   "Last update date",
-			DBType:        "TIMESTAMP",
-			HTMLInputType: "datetime-local",
-			FormPosition:  4,
-			ListPosition:  4,
-			IsRequired:    false,
-			IsReadOnly:    true,
-		},
-		{
-			TableName:     "_user_and_group",
-			FieldName:     "read_groups",
-			DisplayName:   "Read Groups",
-			Description:   "JSON array of groups that can read this relationship",
-			DBType:        "TEXT",
-			HTMLInputType: "textarea",
-			FormPosition:  5,
-			ListPosition:  5,
-			IsRequired:    false,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_user_and_group",
-			FieldName:     "write_groups",
-			DisplayName:   "Write Groups",
-			Description:   "JSON array of groups that can write to this relationship",
-			DBType:        "TEXT",
-			HTMLInputType: "textarea",
-			FormPosition:  6,
-			ListPosition:  6,
-			IsRequired:    false,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_user_and_group",
-			FieldName:     "modified",
-			DisplayName:   "Modified",
-			Description:   "Last update date",
-			DBType:        "TIMESTAMP",
-			HTMLInputType: "datetime-local",
-			FormPosition:  4,
-			ListPosition:  4,
-			IsRequired:    false,
-			IsReadOnly:    true,
-		},
+	// Add permission fields to _session table if they don't exist
+	exists, err = columnExists("_session", "read_groups")
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+	if !exists {
+		_, err = d.Exec(`ALTER TABLE _session ADD COLUMN read_groups TEXT`)
+		if err != nil {
+			LogSQLError(err)
+			return err
+		}
 	}
 
-	for _, field := range userGroupsFields {
-		if err := d.createFieldMetadataIfNotExists(&field); err != nil {
+	exists, err = columnExists("_session", "write_groups")
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+	if !exists {
+		_, err = d.Exec(`ALTER TABLE _session ADD COLUMN write_groups TEXT`)
+		if err != nil {
 			LogSQLError(err)
 			return err
 		}
 	}
 
-	// Initialize metadata for sessions table
-	sessionsMetadata := &models.TableMetadata{
-		TableName:   "_session",
-		DisplayName: "Sessions",
-		Description: "User session information",
-		ReadGroups:  `["admin", "engineer"]`,
-		WriteGroups: `["admin", "engineer"]`,
+	// Add permission fields to _user_and_group table if they don't exist
+	exists, err = columnExists("_user_and_group", "read_groups")
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+	if !exists {
+		_, err = d.Exec(`ALTER TABLE _user_and_group ADD COLUMN read_groups TEXT`)
+		if err != nil {
+			LogSQLError(err)
+			return err
+		}
+	}
+
+	exists, err = columnExists("_user_and_group", "write_groups")
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+	if !exists {
+		_, err = d.Exec(`ALTER TABLE _user_and_group ADD COLUMN write_groups TEXT`)
+		if err != nil {
+			LogSQLError(err)
+			return err
+		}
+	}
+
+	// Update existing pages with default permissions
+	_, err = d.Exec(`
+		UPDATE _page SET 
+		read_groups = '["everyone"]',
+		write_groups = '["admin", "engineer"]'
+		WHERE read_groups IS NULL OR read_groups = ''`)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Update existing users with default permissions
+	_, err = d.Exec(`
+		UPDATE _user SET 
+		read_groups = ?,
+		write_groups = ?
+		WHERE read_groups IS NULL OR read_groups = ''`,
+		defaultUserRecordGroups, defaultUserRecordGroups)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Update existing groups with default permissions
+	_, err = d.Exec(`
+		UPDATE _group SET 
+		read_groups = '["admin", "engineer"]',
+		write_groups = '["admin", "engineer"]'
+		WHERE read_groups IS NULL OR read_groups = ''`)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Update existing sessions with default permissions
+	_, err = d.Exec(`
+		UPDATE _session SET 
+		read_groups = '["admin", "engineer"]',
+		write_groups = '["admin", "engineer"]'
+		WHERE read_groups IS NULL OR read_groups = ''`)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	// Update existing user_and_group records with default permissions
+	_, err = d.Exec(`
+		UPDATE _user_and_group SET 
+		read_groups = '["admin", "engineer"]',
+		write_groups = '["admin", "engineer"]'
+		WHERE read_groups IS NULL OR read_groups = ''`)
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+
+	return nil
+}
+
+// DeleteTableMetadata deletes table metadata and all related field metadata
+func (d *Database) DeleteTableMetadata(tableName string) error {
+	// Start a transaction
+	tx, err := d.Begin()
+	if err != nil {
+		LogSQLError(err)
+		return err
 	}
+	defer tx.Rollback()
 
-	if err := d.createTableMetadataIfNotExists(sessionsMetadata); err != nil {
+	// Delete all field metadata for this table
+	_, err = tx.Exec("DELETE FROM _field_metadata WHERE table_name = ?", tableName)
+	if err != nil {
 		LogSQLError(err)
 		return err
 	}
 
-	// Initialize field metadata for sessions table
-	sessionsFields := []models.FieldMetadata{
-		{
-			TableName:     "_session",
-			FieldName:     "id",
-			DisplayName:   "ID",
-			Description:   "Unique identifier",
-			DBType:        "INT",
-			HTMLInputType: "number",
-			FormPosition:  0,
-			ListPosition:  0,
-			IsRequired:    true,
-			IsReadOnly:    true,
-		},
-		{
-			TableName:     "_session",
-			FieldName:     "session_id",
-			DisplayName:   "Session ID",
-			Description:   "Session identifier",
-			DBType:        "VARCHAR(255)",
-			HTMLInputType: "text",
-			FormPosition:  1,
-			ListPosition:  1,
-			IsRequired:    true,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_session",
-			FieldName:     "user_id",
-			DisplayName:   "User ID",
-			Description:   "User identifier",
-			DBType:        "INT",
-			HTMLInputType: "number",
-			FormPosition:  2,
-			ListPosition:  2,
-			IsRequired:    true,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_session",
-			FieldName:     "username",
-			DisplayName:   "Username",
-			Description:   "Username",
-			DBType:        "VARCHAR(255)",
-			HTMLInputType: "text",
-			FormPosition:  3,
-			ListPosition:  3,
-			IsRequired:    true,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_session",
-			FieldName:     "created",
-			DisplayName:   "Created",
-			Description:   "Session creation date",
-			DBType:        "TIMESTAMP",
-			HTMLInputType: "datetime-local",
-			FormPosition:  4,
-			ListPosition:  4,
-			IsRequired:    false,
-			IsReadOnly:    true,
-		},
-		{
-			TableName:     "_session",
-			FieldName:     "modified",
-			DisplayName:   "Modified",
-			Description:   "Last update date",
-			DBType:        "TIMESTAMP",
-			HTMLInputType: "datetime-local",
-			FormPosition:  5,
-			ListPosition:  5,
-			IsRequired:    false,
-			IsReadOnly:    true,
-		},
-		{
-			TableName:     "_session",
-			FieldName:     "expires_at",
-			DisplayName:   "Expires At",
-			Description:   "Session expiration date",
-			DBType:        "TIMESTAMP",
-			HTMLInputType: "datetime-local",
-			FormPosition:  6,
-			ListPosition:  6,
-			IsRequired:    true,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_session",
-			FieldName:     "is_active",
-			DisplayName:   "Is Active",
-			Description:   "Whether session is active",
-			DBType:        "BOOLEAN",
-			HTMLInputType: "checkbox",
-			FormPosition:  7,
-			ListPosition:  7,
-			IsRequired:    false,
-			IsReadOnly:    false,
-		},
+	// Delete table metadata
+	_, err = tx.Exec("DELETE FROM _table_metadata WHERE table_name = ?", tableName)
+	if err != nil {
+		LogSQLError(err)
+		return err
 	}
 
-	for _, field := range sessionsFields {
-		if err := d.createFieldMetadataIfNotExists(&field); err != nil {
-			LogSQLError(err)
-			return err
-		}
+	// Drop the actual table
+	_, err = tx.Exec("DROP TABLE IF EXISTS `" + tableName + "`")
+	if err != nil {
+		LogSQLError(err)
+		return err
 	}
 
-	// Initialize metadata for table_metadata table
-	tableMetadataMetadata := &models.TableMetadata{
-		TableName:   "_table_metadata",
-		DisplayName: "Table Metadata",
-		Description: "Metadata for database tables",
-		ReadGroups:  `["admin", "engineer"]`,
-		WriteGroups: `["admin", "engineer"]`,
-	}
+	// Commit the transaction
+	return tx.Commit()
+}
 
-	if err := d.createTableMetadataIfNotExists(tableMetadataMetadata); err != nil {
+// DeleteFieldMetadata deletes metadata for a specific field
+func (d *Database) DeleteFieldMetadata(tableName, fieldName string) error {
+	// Start a transaction
+	tx, err := d.Begin()
+	if err != nil {
 		LogSQLError(err)
 		return err
 	}
+	defer tx.Rollback()
 
-	// Initialize field metadata for table_metadata table
-	tableMetadataFields := []models.FieldMetadata{
-		{
-			TableName:     "_table_metadata",
-			FieldName:     "id",
-			DisplayName:   "ID",
-			Description:   "Unique identifier",
-			DBType:        "INT",
-			HTMLInputType: "number",
-			FormPosition:  0,
-			ListPosition:  0,
-			IsRequired:    true,
-			IsReadOnly:    true,
-		},
-		{
-			TableName:     "_table_metadata",
-			FieldName:     "table_name",
-			DisplayName:   "Table Name",
-			Description:   "Database table name",
-			DBType:        "VARCHAR(255)",
-			HTMLInputType: "text",
-			FormPosition:  1,
-			ListPosition:  1,
-			IsRequired:    true,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_table_metadata",
-			FieldName:     "display_name",
-			DisplayName:   "Display Name",
-			Description:   "Human-readable table name",
-			DBType:        "VARCHAR(255)",
-			HTMLInputType: "text",
-			FormPosition:  2,
-			ListPosition:  2,
-			IsRequired:    true,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_table_metadata",
-			FieldName:     "description",
-			DisplayName:   "Description",
-			Description:   "Table description",
-			DBType:        "TEXT",
-			HTMLInputType: "textarea",
-			FormPosition:  3,
-			ListPosition:  3,
-			IsRequired:    false,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_table_metadata",
-			FieldName:     "read_groups",
-			DisplayName:   "Read Groups",
-			Description:   "JSON array of groups that can read this table",
-			DBType:        "TEXT",
-			HTMLInputType: "textarea",
-			FormPosition:  4,
-			ListPosition:  4,
-			IsRequired:    false,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_table_metadata",
-			FieldName:     "write_groups",
-			DisplayName:   "Write Groups",
-			Description:   "JSON array of groups that can write to this table",
-			DBType:        "TEXT",
-			HTMLInputType: "textarea",
-			FormPosition:  5,
-			ListPosition:  5,
-			IsRequired:    false,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_table_metadata",
-			FieldName:     "created",
-			DisplayName:   "Created",
-			Description:   "Metadata creation date",
-			DBType:        "TIMESTAMP",
-			HTMLInputType: "datetime-local",
-			FormPosition:  6,
-			ListPosition:  6,
-			IsRequired:    false,
-			IsReadOnly:    true,
-		},
-		{
-			TableName:     "_table_metadata",
-			FieldName:     "modified",
-			DisplayName:   "Modified",
-			Description:   "Last update date",
-			DBType:        "TIMESTAMP",
-			HTMLInputType: "datetime-local",
-			FormPosition:  7,
-			ListPosition:  7,
-			IsRequired:    false,
-			IsReadOnly:    true,
-		},
+	// Skip management fields that shouldn't be deleted from the database
+	if fieldName != "id" && fieldName != "created" && fieldName != "modified" && 
+	   fieldName != "read_groups" && fieldName != "write_groups" {
+		
+		// Check if field exists in the database
+		exists, err := d.fieldExists(tableName, fieldName)
+		if err != nil {
+			return err
+		}
+
+		// If field exists in the database, remove it
+		if exists {
+			alterSQL := "ALTER TABLE `" + tableName + "` DROP COLUMN `" + fieldName + "`"
+			_, err = tx.Exec(alterSQL)
+			if err != nil {
+				LogSQLError(err)
+				return err
+			}
+		}
+	}
+
+	// Delete the field metadata
+	_, err = tx.Exec("DELETE FROM _field_metadata WHERE table_name = ? AND field_name = ?", tableName, fieldName)
+	if err != nil {
+		LogSQLError(err)
+		return err
 	}
 
-	for _, field := range tableMetadataFields {
-		if err := d.createFieldMetadataIfNotExists(&field); err != nil {
-			LogSQLError(err)
-			return err
+	// Commit the transaction
+	return tx.Commit()
+}
+
+// CreateTableWithMetadata creates a new table with metadata and field metadata
+func (d *Database) CreateTableWithMetadata(tableName, displayName, description, readGroups, writeGroups string, fields []models.FieldMetadata) error {
+	// Start a transaction
+	tx, err := d.Begin()
+	if err != nil {
+		LogSQLError(err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// Create the actual table
+	createTableSQL := "CREATE TABLE `" + tableName + "` ("
+	createTableSQL += "id INT AUTO_INCREMENT PRIMARY KEY, "
+	
+	// Add management fields
+	createTableSQL += "created TIMESTAMP DEFAULT CURRENT_TIMESTAMP, "
+	createTableSQL += "modified TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, "
+	createTableSQL += "read_groups TEXT, "
+	createTableSQL += "write_groups TEXT"
+	
+	// Add custom fields
+	for _, field := range fields {
+		if field.FieldName != "id" && field.FieldName != "created" && field.FieldName != "modified" && 
+		   field.FieldName != "read_groups" && field.FieldName != "write_groups" {
+			createTableSQL += ", `" + field.FieldName + "` " + field.DBType
+			if field.IsRequired {
+				createTableSQL += " NOT NULL"
+			}
+			if field.DefaultValue != "" {
+				createTableSQL += " DEFAULT '" + field.DefaultValue + "'"
+			}
 		}
 	}
+	
+	createTableSQL += ") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci"
 
-	// Initialize metadata for field_metadata table
-	fieldMetadataMetadata := &models.TableMetadata{
-		TableName:   "_field_metadata",
-		DisplayName: "Field Metadata",
-		Description: "Metadata for database table fields",
-		ReadGroups:  `["admin", "engineer"]`,
-		WriteGroups: `["admin", "engineer"]`,
+	_, err = tx.Exec(createTableSQL)
+	if err != nil {
+		LogSQLError(err)
+		return err
 	}
 
-	if err := d.createTableMetadataIfNotExists(fieldMetadataMetadata); err != nil {
+	// Create table metadata
+	tableMetadata := &models.TableMetadata{
+		TableName:   tableName,
+		DisplayName: displayName,
+		Description: description,
+		ReadGroups:  readGroups,
+		WriteGroups: writeGroups,
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO _table_metadata (table_name, display_name, description, read_groups, write_groups)
+		VALUES (?, ?, ?, ?, ?)`,
+		tableMetadata.TableName, tableMetadata.DisplayName, tableMetadata.Description,
+		tableMetadata.ReadGroups, tableMetadata.WriteGroups)
+	if err != nil {
 		LogSQLError(err)
 		return err
 	}
 
-	// Initialize field metadata for field_metadata table
-	fieldMetadataFields := []models.FieldMetadata{
+	// Create management field metadata
+	managementFields := []models.FieldMetadata{
 		{
-			TableName:     "_field_metadata",
+			TableName:     tableName,
 			FieldName:     "id",
 			DisplayName:   "ID",
 			Description:   "Unique identifier",
@@ -2314,938 +4742,867 @@ func (d *Database) initializeMetadata() error {
 			IsReadOnly:    true,
 		},
 		{
-			TableName:     "_field_metadata",
-			FieldName:     "table_name",
-			DisplayName:   "Table Name",
-			Description:   "Database table name",
-			DBType:        "VARCHAR(255)",
-			HTMLInputType: "text",
-			FormPosition:  1,
-			ListPosition:  1,
-			IsRequired:    true,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_field_metadata",
-			FieldName:     "field_name",
-			DisplayName:   "Field Name",
-			Description:   "Database field name",
-			DBType:        "VARCHAR(255)",
-			HTMLInputType: "text",
-			FormPosition:  2,
-			ListPosition:  2,
-			IsRequired:    true,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_field_metadata",
-			FieldName:     "display_name",
-			DisplayName:   "Display Name",
-			Description:   "Human-readable field name",
-			DBType:        "VARCHAR(255)",
-			HTMLInputType: "text",
-			FormPosition:  3,
-			ListPosition:  3,
-			IsRequired:    true,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_field_metadata",
-			FieldName:     "description",
-			DisplayName:   "Description",
-			Description:   "Field description",
-			DBType:        "TEXT",
-			HTMLInputType: "textarea",
-			FormPosition:  4,
-			ListPosition:  4,
-			IsRequired:    false,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_field_metadata",
-			FieldName:     "db_type",
-			DisplayName:   "DB Type",
-			Description:   "Database field type",
-			DBType:        "VARCHAR(100)",
-			HTMLInputType: "text",
-			FormPosition:  5,
-			ListPosition:  5,
-			IsRequired:    true,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_field_metadata",
-			FieldName:     "html_input_type",
-			DisplayName:   "HTML Input Type",
-			Description:   "HTML input type for forms",
-			DBType:        "VARCHAR(100)",
-			HTMLInputType: "text",
-			FormPosition:  6,
-			ListPosition:  6,
-			IsRequired:    true,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_field_metadata",
-			FieldName:     "form_position",
-			DisplayName:   "Form Position",
-			Description:   "Position in edit form",
-			DBType:        "INT",
-			HTMLInputType: "number",
-			FormPosition:  7,
-			ListPosition:  7,
-			IsRequired:    false,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_field_metadata",
-			FieldName:     "list_position",
-			DisplayName:   "List Position",
-			Description:   "Position in table listing",
-			DBType:        "INT",
-			HTMLInputType: "number",
-			FormPosition:  8,
-			ListPosition:  8,
-			IsRequired:    false,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_field_metadata",
-			FieldName:     "is_required",
-			DisplayName:   "Is Required",
-			Description:   "Whether field is required",
-			DBType:        "BOOLEAN",
-			HTMLInputType: "checkbox",
-			FormPosition:  9,
-			ListPosition:  9,
+			TableName:     tableName,
+			FieldName:     "created",
+			DisplayName:   "Created",
+			Description:   "Record creation date",
+			DBType:        "TIMESTAMP",
+			HTMLInputType: "datetime-local",
+			FormPosition:  -1, // Don't show in form
+			ListPosition:  -1, // Don't show in list
 			IsRequired:    false,
-			IsReadOnly:    false,
+			IsReadOnly:    true,
 		},
 		{
-			TableName:     "_field_metadata",
-			FieldName:     "is_read_only",
-			DisplayName:   "Is Read Only",
-			Description:   "Whether field is read-only",
-			DBType:        "BOOLEAN",
-			HTMLInputType: "checkbox",
-			FormPosition:  10,
-			ListPosition:  10,
+			TableName:     tableName,
+			FieldName:     "modified",
+			DisplayName:   "Modified",
+			Description:   "Last update date",
+			DBType:        "TIMESTAMP",
+			HTMLInputType: "datetime-local",
+			FormPosition:  -1, // Don't show in form
+			ListPosition:  -1, // Don't show in list
 			IsRequired:    false,
-			IsReadOnly:    false,
+			IsReadOnly:    true,
 		},
 		{
-			TableName:     "_field_metadata",
-			FieldName:     "default_value",
-			DisplayName:   "Default Value",
-			Description:   "Default value for the field",
+			TableName:     tableName,
+			FieldName:     "read_groups",
+			DisplayName:   "Read Groups",
+			Description:   "JSON array of groups that can read this record",
 			DBType:        "TEXT",
 			HTMLInputType: "textarea",
-			FormPosition:  11,
-			ListPosition:  11,
+			FormPosition:  -1, // Don't show in form
+			ListPosition:  -1, // Don't show in list
 			IsRequired:    false,
 			IsReadOnly:    false,
 		},
 		{
-			TableName:     "_field_metadata",
-			FieldName:     "validation_rules",
-			DisplayName:   "Validation Rules",
-			Description:   "JSON string with validation rules",
+			TableName:     tableName,
+			FieldName:     "write_groups",
+			DisplayName:   "Write Groups",
+			Description:   "JSON array of groups that can write to this record",
 			DBType:        "TEXT",
 			HTMLInputType: "textarea",
-			FormPosition:  12,
-			ListPosition:  12,
-			IsRequired:    false,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     "_field_metadata",
-			FieldName:     "created",
-			DisplayName:   "Created",
-			Description:   "Metadata creation date",
-			DBType:        "TIMESTAMP",
-			HTMLInputType: "datetime-local",
-			FormPosition:  13,
-			ListPosition:  13,
-			IsRequired:    false,
-			IsReadOnly:    true,
-		},
-		{
-			TableName:     "_field_metadata",
-			FieldName:     "modified",
-			DisplayName:   "Modified",
-			Description:   "Last update date",
-			DBType:        "TIMESTAMP",
-			HTMLInputType: "datetime-local",
-			FormPosition:  14,
-			ListPosition:  14,
+			FormPosition:  -1, // Don't show in form
+			ListPosition:  -1, // Don't show in list
 			IsRequired:    false,
-			IsReadOnly:    true,
+			IsReadOnly:    false,
 		},
 	}
 
-	for _, field := range fieldMetadataFields {
-		if err := d.createFieldMetadataIfNotExists(&field); err != nil {
+	// Insert management field metadata
+	for _, field := range managementFields {
+		_, err = tx.Exec(`
+			INSERT INTO _field_metadata (table_name, field_name, display_name, description, db_type, html_input_type,
+			                           form_position, list_position, is_required, is_read_only, default_value, validation_rules)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			field.TableName, field.FieldName, field.DisplayName, field.Description,
+			field.DBType, field.HTMLInputType, field.FormPosition, field.ListPosition,
+			field.IsRequired, field.IsReadOnly, field.DefaultValue, field.ValidationRules)
+		if err != nil {
 			LogSQLError(err)
 			return err
 		}
 	}
 
-	return nil
-}
-
-// updateExistingMetadataForEngineer updates existing metadata to include engineer group access
-func (d *Database) updateExistingMetadataForEngineer() error {
-	// Tables that should be accessible to engineers
-	engineerTables := []string{"_user", "_group", "_user_and_group", "_session", "_table_metadata", "_field_metadata", "_page"}
-	
-	for _, tableName := range engineerTables {
-		// Update read groups to include engineer
-		if tableName == "_page" {
-			// Special handling for pages table - add engineer and everyone to existing read groups
-			_, err := d.Exec(`
-				UPDATE _table_metadata 
-				SET read_groups = '["admin", "customers", "engineer", "everyone"]'
-				WHERE table_name = ? AND read_groups = '["admin", "customers"]'`,
-				tableName)
-			if err != nil {
-				LogSQLError(err)
-				return err
-			}
-		} else {
-			// Standard handling for other tables
-			_, err := d.Exec(`
-				UPDATE _table_metadata 
-				SET read_groups = '["admin", "engineer"]'
-				WHERE table_name = ? AND read_groups = '["admin"]'`,
-				tableName)
+	// Insert custom field metadata
+	for _, field := range fields {
+		if field.FieldName != "id" && field.FieldName != "created" && field.FieldName != "modified" && 
+		   field.FieldName != "read_groups" && field.FieldName != "write_groups" {
+			_, err = tx.Exec(`
+				INSERT INTO _field_metadata (table_name, field_name, display_name, description, db_type, html_input_type,
+				                           form_position, list_position, is_required, is_read_only, default_value, validation_rules)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				field.TableName, field.FieldName, field.DisplayName, field.Description,
+				field.DBType, field.HTMLInputType, field.FormPosition, field.ListPosition,
+				field.IsRequired, field.IsReadOnly, field.DefaultValue, field.ValidationRules)
 			if err != nil {
 				LogSQLError(err)
 				return err
 			}
 		}
-		
-		// Update write groups to include engineer
-		_, err := d.Exec(`
-			UPDATE _table_metadata 
-			SET write_groups = '["admin", "engineer"]'
-			WHERE table_name = ? AND write_groups = '["admin"]'`,
-			tableName)
-		if err != nil {
-			LogSQLError(err)
-			return err
-		}
 	}
 
-	return nil
+	// Commit the transaction
+	return tx.Commit()
 }
 
-// updateExistingMetadataForEveryone updates existing metadata to include everyone group access
-func (d *Database) updateExistingMetadataForEveryone() error {
-	// Get all table metadata
-	tableMetadata, err := d.GetAllTableMetadata()
-	if err != nil {
-		LogSQLError(err)
-		return err
-	}
-
-	for _, table := range tableMetadata {
-		tableName := table.TableName
-		
-		// Update read groups to include everyone for pages table
-		if tableName == "_page" {
-			// Special handling for pages table - add everyone to existing read groups
-			_, err := d.Exec(`
-				UPDATE _table_metadata 
-				SET read_groups = '["admin", "customers", "engineer", "everyone"]'
-				WHERE table_name = ? AND read_groups NOT LIKE '%everyone%'`,
-				tableName)
-			if err != nil {
-				LogSQLError(err)
-				return err
-			}
-		}
-	}
+// Password Reset Functions
 
-	return nil
+// hashToken returns the form a password reset, sign-in, verification or invitation token is
+// stored in. Only the hash is kept so a leaked table cannot be used to follow the links.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
-// createTableMetadataIfNotExists creates table metadata if it doesn't exist
-func (d *Database) createTableMetadataIfNotExists(metadata *models.TableMetadata) error {
-	// Check if metadata exists
-	var count int
-	err := d.QueryRow("SELECT COUNT(*) FROM _table_metadata WHERE table_name = ?", metadata.TableName).Scan(&count)
-	if err != nil {
-		LogSQLError(err)
+// CreatePasswordResetToken stores a hash of a new password reset token for a user and
+// invalidates any reset tokens issued to them before
+func (d *Database) CreatePasswordResetToken(userID int, email string, token string, expiresAt time.Time) error {
+	if err := d.InvalidatePasswordResetTokens(userID); err != nil {
 		return err
 	}
-
-	if count == 0 {
-		if err := d.CreateTableMetadata(metadata); err != nil {
-			LogSQLError(err)
-			return err
-		}
+	_, err := d.Exec(`
+		INSERT INTO _password_reset_token (user_id, token, email, expires_at)
+		VALUES (?, ?, ?, ?)`,
+		userID, hashToken(token), email, expiresAt)
+	if err != nil {
+		LogSQLError(err)
+		return fmt.Errorf("failed to create password reset token: %w", err)
 	}
-
 	return nil
 }
 
-// createFieldMetadataIfNotExists creates field metadata if it doesn't exist
-func (d *Database) createFieldMetadataIfNotExists(metadata *models.FieldMetadata) error {
-	// Check if metadata exists
-	var count int
-	err := d.QueryRow("SELECT COUNT(*) FROM _field_metadata WHERE table_name = ? AND field_name = ?", 
-		metadata.TableName, metadata.FieldName).Scan(&count)
+// GetPasswordResetToken retrieves a password reset token by the token string sent to the user.
+// The returned Token field holds the stored hash.
+func (d *Database) GetPasswordResetToken(token string) (*models.PasswordResetToken, error) {
+	var resetToken models.PasswordResetToken
+	err := d.QueryRow(`
+		SELECT id, user_id, token, email, expires_at, used, created, modified
+		FROM _password_reset_token WHERE token = ?`,
+		hashToken(token)).Scan(
+		&resetToken.ID, &resetToken.UserID, &resetToken.Token, &resetToken.Email,
+		&resetToken.ExpiresAt, &resetToken.Used, &resetToken.CreatedAt, &resetToken.UpdatedAt)
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return nil, err
 	}
+	return &resetToken, nil
+}
 
-	if count == 0 {
-		if err := d.CreateFieldMetadata(metadata); err != nil {
-			LogSQLError(err)
-			return err
-		}
+// MarkPasswordResetTokenUsed marks a password reset token as used
+func (d *Database) MarkPasswordResetTokenUsed(token string) error {
+	_, err := d.Exec("UPDATE _password_reset_token SET used = TRUE WHERE token = ?", hashToken(token))
+	if err != nil {
+		LogSQLError(err)
+		return fmt.Errorf("failed to mark token as used: %w", err)
 	}
-
 	return nil
-} 
-
-// LogSQLError logs SQL errors to logs/db_errors.log, creating the directory if needed
-func LogSQLError(err error) {
-	if err == nil {
-		return
-	}
-	logDir := "logs"
-	logFile := filepath.Join(logDir, "db_errors.log")
-	if _, statErr := os.Stat(logDir); os.IsNotExist(statErr) {
-		os.MkdirAll(logDir, 0755)
-	}
-	f, fileErr := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if fileErr != nil {
-		log.Printf("Failed to open log file: %v", fileErr)
-		return
-	}
-	defer f.Close()
-	logger := log.New(f, "SQL_ERROR: ", log.LstdFlags)
-	logger.Println(err.Error())
-} 
+}
 
-// Permission checking functions
+// ErrPasswordResetInvalid is returned when a password reset link has expired or has
+// already been used
+var ErrPasswordResetInvalid = errors.New("password reset link is invalid, expired or already used")
 
-// CheckUserReadPermission checks if a user has read permission for a specific row
-func (d *Database) CheckUserReadPermission(userID int, readGroups sql.NullString) (bool, error) {
-	groupsStr := ""
-	if readGroups.Valid {
-		groupsStr = readGroups.String
-	}
-	if groupsStr == "" {
-		return true, nil // No restrictions
+// ResetPassword consumes a password reset token and sets its user's password in one
+// transaction, returning the user's ID. The token is claimed before the password is touched,
+// so of two concurrent requests only one sets a password. The user's other reset links stop
+// working too. A password the policy rejects leaves the token unused.
+func (d *Database) ResetPassword(token, newPassword string) (int, error) {
+	tx, err := d.Begin()
+	if err != nil {
+		LogSQLError(err)
+		return 0, err
 	}
+	defer tx.Rollback()
 
-	// Parse read groups
-	var groups []string
-	if err := json.Unmarshal([]byte(groupsStr), &groups); err != nil {
-		return false, fmt.Errorf("failed to parse read groups: %w", err)
+	result, err := tx.Exec(`
+		UPDATE _password_reset_token SET used = TRUE
+		WHERE token = ? AND used = FALSE AND expires_at > ?`,
+		hashToken(token), time.Now())
+	if err != nil {
+		LogSQLError(err)
+		return 0, fmt.Errorf("failed to mark token as used: %w", err)
 	}
-
-	// Check if user is in any of the read groups
-	for _, group := range groups {
-		// Everyone is automatically in the 'everyone' group
-		if group == "everyone" {
-			return true, nil
-		}
-		// For authenticated users, check their groups
-		if inGroup, _ := d.IsUserInGroup(userID, group); inGroup {
-			return true, nil
-		}
+	if affected, err := result.RowsAffected(); err != nil || affected != 1 {
+		return 0, ErrPasswordResetInvalid
 	}
 
-	return false, nil
-}
-
-// CheckUserWritePermission checks if a user has write permission for a specific row
-func (d *Database) CheckUserWritePermission(userID int, writeGroups sql.NullString) (bool, error) {
-	groupsStr := ""
-	if writeGroups.Valid {
-		groupsStr = writeGroups.String
+	var userID int
+	if err := tx.QueryRow("SELECT user_id FROM _password_reset_token WHERE token = ?", hashToken(token)).Scan(&userID); err != nil {
+		LogSQLError(err)
+		return 0, err
 	}
-	if groupsStr == "" {
-		return true, nil // No restrictions
+	if err := d.setUserPassword(tx, userID, newPassword); err != nil {
+		return 0, err
 	}
-
-	// Parse write groups
-	var groups []string
-	if err := json.Unmarshal([]byte(groupsStr), &groups); err != nil {
-		return false, fmt.Errorf("failed to parse write groups: %w", err)
+	if _, err := tx.Exec("UPDATE _password_reset_token SET used = TRUE WHERE user_id = ? AND used = FALSE", userID); err != nil {
+		LogSQLError(err)
+		return 0, fmt.Errorf("failed to invalidate reset tokens: %w", err)
 	}
 
-	// Check if user is in any of the write groups
-	for _, group := range groups {
-		// Everyone is automatically in the 'everyone' group
-		if group == "everyone" {
-			return true, nil
-		}
-		// For authenticated users, check their groups
-		if inGroup, _ := d.IsUserInGroup(userID, group); inGroup {
-			return true, nil
-		}
+	if err := tx.Commit(); err != nil {
+		LogSQLError(err)
+		return 0, err
 	}
-
-	return false, nil
+	return userID, nil
 }
 
-// GetPageWithPermissionCheck gets a page and checks if the user has read permission
-func (d *Database) GetPageWithPermissionCheck(slug string, userID int) (*models.Page, error) {
-	page, err := d.GetPage(slug)
+// InvalidatePasswordResetTokens marks every outstanding password reset token of a user as used
+func (d *Database) InvalidatePasswordResetTokens(userID int) error {
+	_, err := d.Exec("UPDATE _password_reset_token SET used = TRUE WHERE user_id = ? AND used = FALSE", userID)
 	if err != nil {
-		return nil, err
+		LogSQLError(err)
+		return fmt.Errorf("failed to invalidate reset tokens: %w", err)
 	}
+	return nil
+}
 
-	// Check read permission
-	hasPermission, err := d.CheckUserReadPermission(userID, page.ReadGroups)
+// CountRecentPasswordResetTokens returns how many reset tokens were issued to a user since the given time
+func (d *Database) CountRecentPasswordResetTokens(userID int, since time.Time) (int, error) {
+	var count int
+	err := d.QueryRow("SELECT COUNT(*) FROM _password_reset_token WHERE user_id = ? AND created >= ?", userID, since).Scan(&count)
 	if err != nil {
-		return nil, err
+		LogSQLError(err)
+		return 0, err
 	}
+	return count, nil
+}
 
-	if !hasPermission {
-		return nil, fmt.Errorf("access denied")
+// CleanupExpiredPasswordResetTokens removes password reset tokens that expired more than a
+// day ago. Recently expired tokens are kept so they still count towards request throttling.
+func (d *Database) CleanupExpiredPasswordResetTokens() error {
+	_, err := d.Exec("DELETE FROM _password_reset_token WHERE expires_at < NOW() - INTERVAL 1 DAY")
+	if err != nil {
+		LogSQLError(err)
+		return fmt.Errorf("failed to cleanup expired tokens: %w", err)
 	}
-
-	return page, nil
+	return nil
 }
 
-// GetAllPagesWithPermissionCheck gets all pages that the user has read permission for
-func (d *Database) GetAllPagesWithPermissionCheck(userID int) ([]models.Page, error) {
-	allPages, err := d.GetAllPages()
+// GetUserByEmail retrieves a user by email address
+func (d *Database) GetUserByEmail(email string) (*models.User, error) {
+	var user models.User
+	err := d.QueryRow(`
+		SELECT id, username, email, password, read_groups, write_groups, created, modified
+		FROM _user WHERE email = ?`,
+		email).Scan(
+		&user.ID, &user.Username, &user.Email, &user.Password,
+		&user.ReadGroups, &user.WriteGroups, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
+		LogSQLError(err)
 		return nil, err
 	}
-
-	var accessiblePages []models.Page
-	for _, page := range allPages {
-		hasPermission, err := d.CheckUserReadPermission(userID, page.ReadGroups)
-		if err != nil {
-			continue // Skip pages with invalid permission data
-		}
-		if hasPermission {
-			accessiblePages = append(accessiblePages, page)
-		}
-	}
-
-	return accessiblePages, nil
+	return &user, nil
 }
 
-// Migration function to add permission fields to existing tables
-func (d *Database) migrateAddPermissionFields() error {
-	// Helper function to check if column exists
-	columnExists := func(tableName, columnName string) (bool, error) {
-		var count int
-		err := d.QueryRow(`
-			SELECT COUNT(*) 
-			FROM INFORMATION_SCHEMA.COLUMNS 
-			WHERE TABLE_SCHEMA = DATABASE() 
-			AND TABLE_NAME = ? 
-			AND COLUMN_NAME = ?`, tableName, columnName).Scan(&count)
-		return count > 0, err
+// Registration Functions
+
+// UserExists reports whether a user already has the given username or email
+func (d *Database) UserExists(username, email string) (bool, error) {
+	var count int
+	err := d.QueryRow("SELECT COUNT(*) FROM _user WHERE username = ? OR email = ?", username, email).Scan(&count)
+	if err != nil {
+		LogSQLError(err)
+		return false, err
 	}
+	return count > 0, nil
+}
 
-	// Add permission fields to _page table if they don't exist
-	exists, err := columnExists("_page", "read_groups")
+// UserConflicts reports whether a user other than excludeUserID already has the username
+// and whether one already has the email address. Pass excludeUserID 0 for a new user.
+func (d *Database) UserConflicts(username, email string, excludeUserID int) (usernameTaken, emailTaken bool, err error) {
+	var usernames, emails int
+	err = d.QueryRow(`
+		SELECT COALESCE(SUM(username = ?), 0), COALESCE(SUM(email = ?), 0)
+		FROM _user WHERE (username = ? OR email = ?) AND id <> ?`,
+		username, email, username, email, excludeUserID).Scan(&usernames, &emails)
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return false, false, err
 	}
-	if !exists {
-		_, err = d.Exec(`ALTER TABLE _page ADD COLUMN read_groups TEXT`)
-		if err != nil {
-			LogSQLError(err)
-			return err
-		}
+	return usernames > 0, emails > 0, nil
+}
+
+// RegisterUser creates a self-registered user with the given status and adds them to groupName
+func (d *Database) RegisterUser(username, email, password, groupName, status string) (int, error) {
+	if err := d.passwordPolicy.Validate(password, username, email); err != nil {
+		return 0, err
 	}
 
-	exists, err = columnExists("_page", "write_groups")
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		return 0, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	// The account and its group membership are created together
+	tx, err := d.Begin()
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return 0, err
 	}
-	if !exists {
-		_, err = d.Exec(`ALTER TABLE _page ADD COLUMN write_groups TEXT`)
-		if err != nil {
-			LogSQLError(err)
-			return err
-		}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO _user (username, email, password, read_groups, write_groups, status)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		username, email, hashedPassword, defaultUserRecordGroups, defaultUserRecordGroups, status)
+	if err != nil {
+		LogSQLError(err)
+		return 0, fmt.Errorf("failed to create user: %w", err)
 	}
 
-	// Add permission fields to _user table if they don't exist
-	exists, err = columnExists("_user", "read_groups")
+	userID, err := result.LastInsertId()
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return 0, fmt.Errorf("failed to get user ID: %w", err)
 	}
-	if !exists {
-		_, err = d.Exec(`ALTER TABLE _user ADD COLUMN read_groups TEXT`)
-		if err != nil {
-			LogSQLError(err)
-			return err
-		}
+
+	if err := addNewUserToGroup(tx, int(userID), groupName); err != nil {
+		return 0, fmt.Errorf("failed to add user to default group: %w", err)
 	}
 
-	exists, err = columnExists("_user", "write_groups")
-	if err != nil {
+	if err := tx.Commit(); err != nil {
 		LogSQLError(err)
-		return err
+		return 0, err
 	}
-	if !exists {
-		_, err = d.Exec(`ALTER TABLE _user ADD COLUMN write_groups TEXT`)
-		if err != nil {
-			LogSQLError(err)
-			return err
-		}
+	return int(userID), nil
+}
+
+// SetUserStatus changes a user's account status
+func (d *Database) SetUserStatus(userID int, status string) error {
+	_, err := d.Exec("UPDATE _user SET status = ? WHERE id = ?", status, userID)
+	if err != nil {
+		LogSQLError(err)
+		return fmt.Errorf("failed to update user status: %w", err)
 	}
+	return nil
+}
 
-	// Add permission fields to _group table if they don't exist
-	exists, err = columnExists("_group", "read_groups")
+// GetUsersByStatus returns every user with the given account status, oldest first
+func (d *Database) GetUsersByStatus(status string) ([]models.User, error) {
+	rows, err := d.Query(`
+		SELECT id, username, email, status, created
+		FROM _user WHERE status = ?
+		ORDER BY created`,
+		status)
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return nil, err
 	}
-	if !exists {
-		_, err = d.Exec(`ALTER TABLE _group ADD COLUMN read_groups TEXT`)
-		if err != nil {
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Status, &user.CreatedAt); err != nil {
 			LogSQLError(err)
-			return err
+			return nil, err
 		}
+		users = append(users, user)
 	}
+	return users, nil
+}
 
-	exists, err = columnExists("_group", "write_groups")
+// DeletePendingUser removes a registration that was never activated
+func (d *Database) DeletePendingUser(userID int) error {
+	result, err := d.Exec("DELETE FROM _user WHERE id = ? AND status <> ?", userID, models.UserStatusActive)
 	if err != nil {
 		LogSQLError(err)
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
 		return err
 	}
-	if !exists {
-		_, err = d.Exec(`ALTER TABLE _group ADD COLUMN write_groups TEXT`)
-		if err != nil {
-			LogSQLError(err)
-			return err
-		}
+	if rowsAffected == 0 {
+		return fmt.Errorf("pending user not found")
 	}
+	return nil
+}
 
-	// Add permission fields to _session table if they don't exist
-	exists, err = columnExists("_session", "read_groups")
+// CreateEmailVerificationToken stores a hash of a new email verification token for a user
+func (d *Database) CreateEmailVerificationToken(userID int, email string, token string, expiresAt time.Time) error {
+	_, err := d.Exec(`
+		INSERT INTO _email_verification_token (user_id, token, email, expires_at)
+		VALUES (?, ?, ?, ?)`,
+		userID, hashToken(token), email, expiresAt)
 	if err != nil {
 		LogSQLError(err)
-		return err
-	}
-	if !exists {
-		_, err = d.Exec(`ALTER TABLE _session ADD COLUMN read_groups TEXT`)
-		if err != nil {
-			LogSQLError(err)
-			return err
-		}
+		return fmt.Errorf("failed to create email verification token: %w", err)
 	}
+	return nil
+}
 
-	exists, err = columnExists("_session", "write_groups")
+// GetEmailVerificationToken retrieves an email verification token by the token string sent
+// to the user. The returned Token field holds the stored hash.
+func (d *Database) GetEmailVerificationToken(token string) (*models.EmailVerificationToken, error) {
+	var verificationToken models.EmailVerificationToken
+	err := d.QueryRow(`
+		SELECT id, user_id, token, email, expires_at, used, created, modified
+		FROM _email_verification_token WHERE token = ?`,
+		hashToken(token)).Scan(
+		&verificationToken.ID, &verificationToken.UserID, &verificationToken.Token, &verificationToken.Email,
+		&verificationToken.ExpiresAt, &verificationToken.Used, &verificationToken.CreatedAt, &verificationToken.UpdatedAt)
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return nil, err
 	}
-	if !exists {
-		_, err = d.Exec(`ALTER TABLE _session ADD COLUMN write_groups TEXT`)
-		if err != nil {
-			LogSQLError(err)
-			return err
-		}
+	return &verificationToken, nil
+}
+
+// ErrVerificationInvalid is returned when an email verification link has expired, has
+// already been used or belongs to an account no longer waiting for verification
+var ErrVerificationInvalid = errors.New("verification link is invalid, expired or already used")
+
+// VerifyEmail consumes an email verification token and moves its user from
+// pending_verification to status, returning the user's ID. The token is claimed first, so
+// of two concurrent clicks only one verifies, and an old link cannot change the status of an
+// account that has since been verified, approved or suspended.
+func (d *Database) VerifyEmail(token, status string) (int, error) {
+	tx, err := d.Begin()
+	if err != nil {
+		LogSQLError(err)
+		return 0, err
 	}
+	defer tx.Rollback()
 
-	// Add permission fields to _user_and_group table if they don't exist
-	exists, err = columnExists("_user_and_group", "read_groups")
+	result, err := tx.Exec(`
+		UPDATE _email_verification_token SET used = TRUE
+		WHERE token = ? AND used = FALSE AND expires_at > ?`,
+		hashToken(token), time.Now())
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return 0, fmt.Errorf("failed to mark token as used: %w", err)
 	}
-	if !exists {
-		_, err = d.Exec(`ALTER TABLE _user_and_group ADD COLUMN read_groups TEXT`)
-		if err != nil {
-			LogSQLError(err)
-			return err
-		}
+	if affected, err := result.RowsAffected(); err != nil || affected != 1 {
+		return 0, ErrVerificationInvalid
 	}
 
-	exists, err = columnExists("_user_and_group", "write_groups")
+	var userID int
+	if err := tx.QueryRow("SELECT user_id FROM _email_verification_token WHERE token = ?", hashToken(token)).Scan(&userID); err != nil {
+		LogSQLError(err)
+		return 0, err
+	}
+	result, err = tx.Exec("UPDATE _user SET status = ? WHERE id = ? AND status = ?",
+		status, userID, models.UserStatusPendingVerification)
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return 0, fmt.Errorf("failed to update user status: %w", err)
 	}
-	if !exists {
-		_, err = d.Exec(`ALTER TABLE _user_and_group ADD COLUMN write_groups TEXT`)
-		if err != nil {
-			LogSQLError(err)
-			return err
-		}
+	if affected, err := result.RowsAffected(); err != nil || affected != 1 {
+		return 0, ErrVerificationInvalid
 	}
 
-	// Update existing pages with default permissions
-	_, err = d.Exec(`
-		UPDATE _page SET 
-		read_groups = '["everyone"]',
-		write_groups = '["admin", "engineer"]'
-		WHERE read_groups IS NULL OR read_groups = ''`)
+	if err := tx.Commit(); err != nil {
+		LogSQLError(err)
+		return 0, err
+	}
+	return userID, nil
+}
+
+// CleanupExpiredEmailVerificationTokens removes expired email verification tokens
+func (d *Database) CleanupExpiredEmailVerificationTokens() error {
+	_, err := d.Exec("DELETE FROM _email_verification_token WHERE expires_at < NOW()")
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return fmt.Errorf("failed to cleanup expired tokens: %w", err)
 	}
+	return nil
+}
 
-	// Update existing users with default permissions
+// Magic link errors, returned by ConsumeMagicLinkToken
+var (
+	ErrMagicLinkInvalid      = errors.New("sign-in link is invalid, expired or already used")
+	ErrMagicLinkOtherBrowser = errors.New("sign-in link was requested from another browser")
+)
+
+// CreateMagicLinkToken stores hashes of a new sign-in token and of the key held by the
+// browser that requested it. Earlier unused sign-in links for the user stop working.
+func (d *Database) CreateMagicLinkToken(userID int, token, browserKey string, expiresAt time.Time) error {
+	_, err := d.Exec("UPDATE _magic_link_token SET used = TRUE WHERE user_id = ? AND used = FALSE", userID)
+	if err != nil {
+		LogSQLError(err)
+		return fmt.Errorf("failed to invalidate sign-in links: %w", err)
+	}
 	_, err = d.Exec(`
-		UPDATE _user SET 
-		read_groups = '["admin", "engineer"]',
-		write_groups = '["admin", "engineer"]'
-		WHERE read_groups IS NULL OR read_groups = ''`)
+		INSERT INTO _magic_link_token (user_id, token, browser_key, expires_at)
+		VALUES (?, ?, ?, ?)`,
+		userID, hashToken(token), hashToken(browserKey), expiresAt)
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return fmt.Errorf("failed to create sign-in link: %w", err)
 	}
+	return nil
+}
 
-	// Update existing groups with default permissions
-	_, err = d.Exec(`
-		UPDATE _group SET 
-		read_groups = '["admin", "engineer"]',
-		write_groups = '["admin", "engineer"]'
-		WHERE read_groups IS NULL OR read_groups = ''`)
+// ConsumeMagicLinkToken marks a sign-in token as used and returns its user. The token must be
+// unused, unexpired and presented together with the key of the browser that requested it.
+// A token presented from another browser is left unused.
+func (d *Database) ConsumeMagicLinkToken(token, browserKey string) (int, error) {
+	var id, userID int
+	var storedKey string
+	var expiresAt time.Time
+	var used bool
+	err := d.QueryRow(`
+		SELECT id, user_id, browser_key, expires_at, used
+		FROM _magic_link_token WHERE token = ?`,
+		hashToken(token)).Scan(&id, &userID, &storedKey, &expiresAt, &used)
+	if err == sql.ErrNoRows {
+		return 0, ErrMagicLinkInvalid
+	}
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return 0, err
+	}
+	if used || !expiresAt.After(time.Now()) {
+		return 0, ErrMagicLinkInvalid
+	}
+	if subtle.ConstantTimeCompare([]byte(storedKey), []byte(hashToken(browserKey))) != 1 {
+		return 0, ErrMagicLinkOtherBrowser
 	}
 
-	// Update existing sessions with default permissions
-	_, err = d.Exec(`
-		UPDATE _session SET 
-		read_groups = '["admin", "engineer"]',
-		write_groups = '["admin", "engineer"]'
-		WHERE read_groups IS NULL OR read_groups = ''`)
+	// Only one request can flip used, so the link signs in at most once
+	result, err := d.Exec("UPDATE _magic_link_token SET used = TRUE WHERE id = ? AND used = FALSE", id)
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return 0, err
 	}
+	if affected, err := result.RowsAffected(); err != nil || affected != 1 {
+		return 0, ErrMagicLinkInvalid
+	}
+	return userID, nil
+}
 
-	// Update existing user_and_group records with default permissions
-	_, err = d.Exec(`
-		UPDATE _user_and_group SET 
-		read_groups = '["admin", "engineer"]',
-		write_groups = '["admin", "engineer"]'
-		WHERE read_groups IS NULL OR read_groups = ''`)
+// CountRecentMagicLinkTokens returns how many sign-in links were issued to a user since the given time
+func (d *Database) CountRecentMagicLinkTokens(userID int, since time.Time) (int, error) {
+	var count int
+	err := d.QueryRow("SELECT COUNT(*) FROM _magic_link_token WHERE user_id = ? AND created >= ?", userID, since).Scan(&count)
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return 0, err
 	}
+	return count, nil
+}
 
+// CleanupExpiredMagicLinkTokens removes sign-in links that expired more than a day ago
+func (d *Database) CleanupExpiredMagicLinkTokens() error {
+	_, err := d.Exec("DELETE FROM _magic_link_token WHERE expires_at < NOW() - INTERVAL 1 DAY")
+	if err != nil {
+		LogSQLError(err)
+		return fmt.Errorf("failed to cleanup expired sign-in links: %w", err)
+	}
 	return nil
 }
 
-// DeleteTableMetadata deletes table metadata and all related field metadata
-func (d *Database) DeleteTableMetadata(tableName string) error {
-	// Start a transaction
-	tx, err := d.Begin()
+// ErrInvitationInvalid is returned when an invitation has expired, been revoked or already been used
+var ErrInvitationInvalid = errors.New("invitation is invalid, expired or already used")
+
+// invitationColumns is the column list read by scanInvitation
+const invitationColumns = `
+	i.id, i.email, i.token, i.group_names, COALESCE(i.invited_by, 0), COALESCE(u.username, ''),
+	i.expires_at, i.accepted, COALESCE(i.accepted_user_id, 0), i.revoked, i.created, i.modified`
+
+// scanInvitation reads an invitation selected with invitationColumns
+func scanInvitation(row rowScanner) (*models.Invitation, error) {
+	var invitation models.Invitation
+	var groupNames sql.NullString
+	err := row.Scan(&invitation.ID, &invitation.Email, &invitation.Token, &groupNames,
+		&invitation.InvitedBy, &invitation.InvitedByName, &invitation.ExpiresAt, &invitation.Accepted,
+		&invitation.AcceptedUserID, &invitation.Revoked, &invitation.CreatedAt, &invitation.UpdatedAt)
 	if err != nil {
-		LogSQLError(err)
-		return err
+		return nil, err
 	}
-	defer tx.Rollback()
+	if groupNames.Valid && groupNames.String != "" {
+		if err := json.Unmarshal([]byte(groupNames.String), &invitation.Groups); err != nil {
+			return nil, fmt.Errorf("failed to parse invitation groups: %w", err)
+		}
+	}
+	return &invitation, nil
+}
 
-	// Delete all field metadata for this table
-	_, err = tx.Exec("DELETE FROM _field_metadata WHERE table_name = ?", tableName)
+// CreateInvitation stores a new invitation for the given email address and groups. Only a
+// hash of the token is kept.
+func (d *Database) CreateInvitation(email string, groupNames []string, invitedBy int, token string, expiresAt time.Time) (int, error) {
+	if groupNames == nil {
+		groupNames = []string{}
+	}
+	groupsJSON, err := json.Marshal(groupNames)
 	if err != nil {
-		LogSQLError(err)
-		return err
+		return 0, fmt.Errorf("failed to encode invitation groups: %w", err)
 	}
 
-	// Delete table metadata
-	_, err = tx.Exec("DELETE FROM _table_metadata WHERE table_name = ?", tableName)
+	result, err := d.Exec(`
+		INSERT INTO _invitation (email, token, group_names, invited_by, expires_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		email, hashToken(token), string(groupsJSON), invitedBy, expiresAt)
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return 0, fmt.Errorf("failed to create invitation: %w", err)
 	}
 
-	// Drop the actual table
-	_, err = tx.Exec("DROP TABLE IF EXISTS `" + tableName + "`")
+	id, err := result.LastInsertId()
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return 0, fmt.Errorf("failed to get invitation ID: %w", err)
 	}
-
-	// Commit the transaction
-	return tx.Commit()
+	return int(id), nil
 }
 
-// DeleteFieldMetadata deletes metadata for a specific field
-func (d *Database) DeleteFieldMetadata(tableName, fieldName string) error {
-	// Start a transaction
-	tx, err := d.Begin()
+// GetInvitation retrieves an invitation by ID
+func (d *Database) GetInvitation(id int) (*models.Invitation, error) {
+	invitation, err := scanInvitation(d.QueryRow(`
+		SELECT`+invitationColumns+`
+		FROM _invitation i LEFT JOIN _user u ON u.id = i.invited_by
+		WHERE i.id = ?`, id))
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return nil, err
 	}
-	defer tx.Rollback()
+	return invitation, nil
+}
 
-	// Skip management fields that shouldn't be deleted from the database
-	if fieldName != "id" && fieldName != "created" && fieldName != "modified" && 
-	   fieldName != "read_groups" && fieldName != "write_groups" {
-		
-		// Check if field exists in the database
-		exists, err := d.fieldExists(tableName, fieldName)
-		if err != nil {
-			return err
-		}
+// GetInvitationByToken retrieves an invitation by the token in its link. The returned Token
+// field holds the stored hash.
+func (d *Database) GetInvitationByToken(token string) (*models.Invitation, error) {
+	invitation, err := scanInvitation(d.QueryRow(`
+		SELECT`+invitationColumns+`
+		FROM _invitation i LEFT JOIN _user u ON u.id = i.invited_by
+		WHERE i.token = ?`, hashToken(token)))
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
+	}
+	return invitation, nil
+}
 
-		// If field exists in the database, remove it
-		if exists {
-			alterSQL := "ALTER TABLE `" + tableName + "` DROP COLUMN `" + fieldName + "`"
-			_, err = tx.Exec(alterSQL)
-			if err != nil {
-				LogSQLError(err)
-				return err
-			}
+// GetOpenInvitations returns invitations that have been neither accepted nor revoked,
+// newest first. Expired invitations are included so they can be resent.
+func (d *Database) GetOpenInvitations() ([]models.Invitation, error) {
+	rows, err := d.Query(`
+		SELECT` + invitationColumns + `
+		FROM _invitation i LEFT JOIN _user u ON u.id = i.invited_by
+		WHERE i.accepted = FALSE AND i.revoked = FALSE
+		ORDER BY i.created DESC, i.id DESC`)
+	if err != nil {
+		LogSQLError(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []models.Invitation
+	for rows.Next() {
+		invitation, err := scanInvitation(rows)
+		if err != nil {
+			LogSQLError(err)
+			return nil, err
 		}
+		invitations = append(invitations, *invitation)
 	}
+	return invitations, nil
+}
 
-	// Delete the field metadata
-	_, err = tx.Exec("DELETE FROM _field_metadata WHERE table_name = ? AND field_name = ?", tableName, fieldName)
+// HasOpenInvitation reports whether an unexpired invitation is already waiting for the email address
+func (d *Database) HasOpenInvitation(email string) (bool, error) {
+	var count int
+	err := d.QueryRow(`
+		SELECT COUNT(*) FROM _invitation
+		WHERE email = ? AND accepted = FALSE AND revoked = FALSE AND expires_at > NOW()`,
+		email).Scan(&count)
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return false, err
 	}
+	return count > 0, nil
+}
 
-	// Commit the transaction
-	return tx.Commit()
+// RenewInvitation replaces an open invitation's token and expiry so it can be resent.
+// The previous link stops working.
+func (d *Database) RenewInvitation(id int, token string, expiresAt time.Time) error {
+	result, err := d.Exec(`
+		UPDATE _invitation SET token = ?, expires_at = ?
+		WHERE id = ? AND accepted = FALSE AND revoked = FALSE`,
+		hashToken(token), expiresAt, id)
+	if err != nil {
+		LogSQLError(err)
+		return fmt.Errorf("failed to renew invitation: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return ErrInvitationInvalid
+	}
+	return nil
 }
 
-// CreateTableWithMetadata creates a new table with metadata and field metadata
-func (d *Database) CreateTableWithMetadata(tableName, displayName, description, readGroups, writeGroups string, fields []models.FieldMetadata) error {
-	// Start a transaction
-	tx, err := d.Begin()
+// RevokeInvitation cancels an open invitation
+func (d *Database) RevokeInvitation(id int) error {
+	result, err := d.Exec(`
+		UPDATE _invitation SET revoked = TRUE
+		WHERE id = ? AND accepted = FALSE AND revoked = FALSE`, id)
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return fmt.Errorf("failed to revoke invitation: %w", err)
 	}
-	defer tx.Rollback()
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return ErrInvitationInvalid
+	}
+	return nil
+}
 
-	// Create the actual table
-	createTableSQL := "CREATE TABLE `" + tableName + "` ("
-	createTableSQL += "id INT AUTO_INCREMENT PRIMARY KEY, "
-	
-	// Add management fields
-	createTableSQL += "created TIMESTAMP DEFAULT CURRENT_TIMESTAMP, "
-	createTableSQL += "modified TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP, "
-	createTableSQL += "read_groups TEXT, "
-	createTableSQL += "write_groups TEXT"
-	
-	// Add custom fields
-	for _, field := range fields {
-		if field.FieldName != "id" && field.FieldName != "created" && field.FieldName != "modified" && 
-		   field.FieldName != "read_groups" && field.FieldName != "write_groups" {
-			createTableSQL += ", `" + field.FieldName + "` " + field.DBType
-			if field.IsRequired {
-				createTableSQL += " NOT NULL"
-			}
-			if field.DefaultValue != "" {
-				createTableSQL += " DEFAULT '" + field.DefaultValue + "'"
-			}
+// AcceptInvitation creates an active account for the invitee with the invitation's groups
+// and marks the invitation used. It fails with ErrGroupMissing if one of the groups has
+// since been deleted. Claiming the invitation and creating the user happen in
+// one transaction, so a link can only ever create one account.
+func (d *Database) AcceptInvitation(token, username, password string) (int, error) {
+	invitation, err := d.GetInvitationByToken(token)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrInvitationInvalid
 		}
+		return 0, err
+	}
+	if !invitation.IsPending() {
+		return 0, ErrInvitationInvalid
 	}
-	
-	createTableSQL += ") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci"
 
-	_, err = tx.Exec(createTableSQL)
+	if err := d.passwordPolicy.Validate(password, username, invitation.Email); err != nil {
+		return 0, err
+	}
+
+	hashedPassword, err := auth.HashPassword(password)
 	if err != nil {
-		LogSQLError(err)
-		return err
+		return 0, fmt.Errorf("failed to hash password: %w", err)
 	}
 
-	// Create table metadata
-	tableMetadata := &models.TableMetadata{
-		TableName:   tableName,
-		DisplayName: displayName,
-		Description: description,
-		ReadGroups:  readGroups,
-		WriteGroups: writeGroups,
+	tx, err := d.Begin()
+	if err != nil {
+		LogSQLError(err)
+		return 0, err
 	}
+	defer tx.Rollback()
 
-	_, err = tx.Exec(`
-		INSERT INTO _table_metadata (table_name, display_name, description, read_groups, write_groups)
-		VALUES (?, ?, ?, ?, ?)`,
-		tableMetadata.TableName, tableMetadata.DisplayName, tableMetadata.Description,
-		tableMetadata.ReadGroups, tableMetadata.WriteGroups)
+	result, err := tx.Exec(`
+		UPDATE _invitation SET accepted = TRUE
+		WHERE id = ? AND token = ? AND accepted = FALSE AND revoked = FALSE AND expires_at > NOW()`,
+		invitation.ID, hashToken(token))
 	if err != nil {
 		LogSQLError(err)
-		return err
+		return 0, fmt.Errorf("failed to claim invitation: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return 0, ErrInvitationInvalid
 	}
 
-	// Create management field metadata
-	managementFields := []models.FieldMetadata{
-		{
-			TableName:     tableName,
-			FieldName:     "id",
-			DisplayName:   "ID",
-			Description:   "Unique identifier",
-			DBType:        "INT",
-			HTMLInputType: "number",
-			FormPosition:  0,
-			ListPosition:  0,
-			IsRequired:    true,
-			IsReadOnly:    true,
-		},
-		{
-			TableName:     tableName,
-			FieldName:     "created",
-			DisplayName:   "Created",
-			Description:   "Record creation date",
-			DBType:        "TIMESTAMP",
-			HTMLInputType: "datetime-local",
-			FormPosition:  -1, // Don't show in form
-			ListPosition:  -1, // Don't show in list
-			IsRequired:    false,
-			IsReadOnly:    true,
-		},
-		{
-			TableName:     tableName,
-			FieldName:     "modified",
-			DisplayName:   "Modified",
-			Description:   "Last update date",
-			DBType:        "TIMESTAMP",
-			HTMLInputType: "datetime-local",
-			FormPosition:  -1, // Don't show in form
-			ListPosition:  -1, // Don't show in list
-			IsRequired:    false,
-			IsReadOnly:    true,
-		},
-		{
-			TableName:     tableName,
-			FieldName:     "read_groups",
-			DisplayName:   "Read Groups",
-			Description:   "JSON array of groups that can read this record",
-			DBType:        "TEXT",
-			HTMLInputType: "textarea",
-			FormPosition:  -1, // Don't show in form
-			ListPosition:  -1, // Don't show in list
-			IsRequired:    false,
-			IsReadOnly:    false,
-		},
-		{
-			TableName:     tableName,
-			FieldName:     "write_groups",
-			DisplayName:   "Write Groups",
-			Description:   "JSON array of groups that can write to this record",
-			DBType:        "TEXT",
-			HTMLInputType: "textarea",
-			FormPosition:  -1, // Don't show in form
-			ListPosition:  -1, // Don't show in list
-			IsRequired:    false,
-			IsReadOnly:    false,
-		},
+	result, err = tx.Exec(`
+		INSERT INTO _user (username, email, password, read_groups, write_groups, status)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		username, invitation.Email, hashedPassword, defaultUserRecordGroups, defaultUserRecordGroups, models.UserStatusActive)
+	if err != nil {
+		LogSQLError(err)
+		return 0, fmt.Errorf("failed to create user: %w", err)
+	}
+	userID, err := result.LastInsertId()
+	if err != nil {
+		LogSQLError(err)
+		return 0, fmt.Errorf("failed to get user ID: %w", err)
 	}
 
-	// Insert management field metadata
-	for _, field := range managementFields {
-		_, err = tx.Exec(`
-			INSERT INTO _field_metadata (table_name, field_name, display_name, description, db_type, html_input_type,
-			                           form_position, list_position, is_required, is_read_only, default_value, validation_rules)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-			field.TableName, field.FieldName, field.DisplayName, field.Description,
-			field.DBType, field.HTMLInputType, field.FormPosition, field.ListPosition,
-			field.IsRequired, field.IsReadOnly, field.DefaultValue, field.ValidationRules)
-		if err != nil {
-			LogSQLError(err)
-			return err
+	for _, groupName := range invitation.Groups {
+		if err := addNewUserToGroup(tx, int(userID), groupName); err != nil {
+			return 0, fmt.Errorf("failed to add user to group %s: %w", groupName, err)
 		}
 	}
 
-	// Insert custom field metadata
-	for _, field := range fields {
-		if field.FieldName != "id" && field.FieldName != "created" && field.FieldName != "modified" && 
-		   field.FieldName != "read_groups" && field.FieldName != "write_groups" {
-			_, err = tx.Exec(`
-				INSERT INTO _field_metadata (table_name, field_name, display_name, description, db_type, html_input_type,
-				                           form_position, list_position, is_required, is_read_only, default_value, validation_rules)
-				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-				field.TableName, field.FieldName, field.DisplayName, field.Description,
-				field.DBType, field.HTMLInputType, field.FormPosition, field.ListPosition,
-				field.IsRequired, field.IsReadOnly, field.DefaultValue, field.ValidationRules)
-			if err != nil {
-				LogSQLError(err)
-				return err
-			}
-		}
+	if _, err := tx.Exec("UPDATE _invitation SET accepted_user_id = ? WHERE id = ?", userID, invitation.ID); err != nil {
+		LogSQLError(err)
+		return 0, fmt.Errorf("failed to record invitation acceptance: %w", err)
 	}
 
-	// Commit the transaction
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		LogSQLError(err)
+		return 0, err
+	}
+	return int(userID), nil
 }
 
-// Password Reset Functions
+// Audit log operations
 
-// CreatePasswordResetToken creates a new password reset token for a user
-func (d *Database) CreatePasswordResetToken(userID int, email string, token string, expiresAt time.Time) error {
+// RecordAuditEvent appends an event to the audit log
+func (d *Database) RecordAuditEvent(event *models.AuditEvent) error {
 	_, err := d.Exec(`
-		INSERT INTO _password_reset_token (user_id, token, email, expires_at)
-		VALUES (?, ?, ?, ?)`,
-		userID, token, email, expiresAt)
+		INSERT INTO _audit_log (event_type, actor_id, actor_name, target_user_id, target_name, details, ip_address, user_agent)
+		VALUES (?, NULLIF(?, 0), ?, NULLIF(?, 0), ?, ?, ?, ?)`,
+		event.EventType, event.ActorID, event.ActorName, event.TargetUserID, event.TargetName,
+		event.Details, event.IPAddress, event.UserAgent)
 	if err != nil {
 		LogSQLError(err)
-		return fmt.Errorf("failed to create password reset token: %w", err)
+		return err
 	}
 	return nil
 }
 
-// GetPasswordResetToken retrieves a password reset token by token string
-func (d *Database) GetPasswordResetToken(token string) (*models.PasswordResetToken, error) {
-	var resetToken models.PasswordResetToken
-	err := d.QueryRow(`
-		SELECT id, user_id, token, email, expires_at, used, created, modified
-		FROM _password_reset_token WHERE token = ?`,
-		token).Scan(
-		&resetToken.ID, &resetToken.UserID, &resetToken.Token, &resetToken.Email,
-		&resetToken.ExpiresAt, &resetToken.Used, &resetToken.CreatedAt, &resetToken.UpdatedAt)
-	if err != nil {
-		LogSQLError(err)
-		return nil, err
+// GetAuditEvents returns the events matching the filter, newest first, along with the
+// total number of matches for pagination
+func (d *Database) GetAuditEvents(filter models.AuditFilter, limit, offset int) ([]models.AuditEvent, int, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.EventType != "" {
+		conditions = append(conditions, "event_type = ?")
+		args = append(args, filter.EventType)
+	}
+	if filter.Username != "" {
+		conditions = append(conditions, "(actor_name = ? OR target_name = ?)")
+		args = append(args, filter.Username, filter.Username)
+	}
+	if filter.IPAddress != "" {
+		conditions = append(conditions, "ip_address = ?")
+		args = append(args, filter.IPAddress)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "created >= ?")
+		args = append(args, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "created < ?")
+		args = append(args, filter.Until)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
 	}
-	return &resetToken, nil
-}
 
-// MarkPasswordResetTokenUsed marks a password reset token as used
-func (d *Database) MarkPasswordResetTokenUsed(token string) error {
-	_, err := d.Exec("UPDATE _password_reset_token SET used = TRUE WHERE token = ?", token)
-	if err != nil {
+	var total int
+	if err := d.QueryRow("SELECT COUNT(*) FROM _audit_log"+where, args...).Scan(&total); err != nil {
 		LogSQLError(err)
-		return fmt.Errorf("failed to mark token as used: %w", err)
+		return nil, 0, err
 	}
-	return nil
-}
 
-// CleanupExpiredPasswordResetTokens removes expired password reset tokens
-func (d *Database) CleanupExpiredPasswordResetTokens() error {
-	_, err := d.Exec("DELETE FROM _password_reset_token WHERE expires_at < NOW()")
+	rows, err := d.Query(`
+		SELECT id, event_type, actor_id, actor_name, target_user_id, target_name, details, ip_address, user_agent, created
+		FROM _audit_log`+where+`
+		ORDER BY created DESC, id DESC
+		LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...)
 	if err != nil {
 		LogSQLError(err)
-		return fmt.Errorf("failed to cleanup expired tokens: %w", err)
+		return nil, 0, err
 	}
-	return nil
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var event models.AuditEvent
+		var actorID, targetID sql.NullInt64
+		var actorName, targetName, details, ipAddress, userAgent sql.NullString
+		if err := rows.Scan(&event.ID, &event.EventType, &actorID, &actorName, &targetID, &targetName,
+			&details, &ipAddress, &userAgent, &event.CreatedAt); err != nil {
+			LogSQLError(err)
+			return nil, 0, err
+		}
+		event.ActorID = int(actorID.Int64)
+		event.ActorName = actorName.String
+		event.TargetUserID = int(targetID.Int64)
+		event.TargetName = targetName.String
+		event.Details = details.String
+		event.IPAddress = ipAddress.String
+		event.UserAgent = userAgent.String
+		events = append(events, event)
+	}
+	return events, total, nil
 }
 
-// GetUserByEmail retrieves a user by email address
-func (d *Database) GetUserByEmail(email string) (*models.User, error) {
-	var user models.User
-	err := d.QueryRow(`
-		SELECT id, username, email, password, read_groups, write_groups, created, modified
-		FROM _user WHERE email = ?`,
-		email).Scan(
-		&user.ID, &user.Username, &user.Email, &user.Password,
-		&user.ReadGroups, &user.WriteGroups, &user.CreatedAt, &user.UpdatedAt)
+// CleanupAuditLog deletes events older than the retention period. A retention of zero or
+// less keeps events forever.
+func (d *Database) CleanupAuditLog(retention time.Duration) error {
+	if retention <= 0 {
+		return nil
+	}
+	_, err := d.Exec("DELETE FROM _audit_log WHERE created < ?", time.Now().Add(-retention))
 	if err != nil {
 		LogSQLError(err)
-		return nil, err
+		return err
 	}
-	return &user, nil
+	return nil
 }
 
 // migrateUpdateDBTypes updates existing db_type values to include proper length specifications
@@ -3283,6 +5640,44 @@ func (d *Database) migrateUpdateDBTypes() error {
 	return nil
 }
 
+// migrateAddSessionClientFields adds last-seen, client and lifetime columns to existing _session tables
+func (d *Database) migrateAddSessionClientFields() error {
+	return d.addColumnsIfMissing("_session", []columnDefinition{
+		{"last_seen", "TIMESTAMP NULL"},
+		{"ip_address", "VARCHAR(64)"},
+		{"user_agent", "TEXT"},
+		{"absolute_expires_at", "TIMESTAMP NULL"},
+		{"remember_me", "BOOLEAN DEFAULT FALSE"},
+		{"rotate_pending", "BOOLEAN DEFAULT FALSE"},
+		{"impersonator_id", "INT NULL"},
+	})
+}
+
+// columnDefinition describes a column added to an existing table by a migration
+type columnDefinition struct {
+	name       string
+	definition string
+}
+
+// addColumnsIfMissing adds each column that does not already exist on the table
+func (d *Database) addColumnsIfMissing(tableName string, columns []columnDefinition) error {
+	for _, column := range columns {
+		exists, err := d.fieldExists(tableName, column.name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			_, err = d.Exec("ALTER TABLE " + tableName + " ADD COLUMN " + column.name + " " + column.definition)
+			if err != nil {
+				LogSQLError(err)
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 // getCurrentFieldType gets the current data type of a field in the database
 func (d *Database) getCurrentFieldType(tableName, fieldName string) (string, error) {
 	var dataType string
@@ -0,0 +1,142 @@
+// Package diff compares texts line by line for side-by-side display
+package diff
+
+import (
+	"strings"
+)
+
+// Kind says how a row of a side-by-side diff differs between the two texts
+type Kind string
+
+const (
+	Equal   Kind = "equal"
+	Changed Kind = "changed"
+	Removed Kind = "removed"
+	Added   Kind = "added"
+)
+
+// Row is one line of a side-by-side diff. Removed rows have no right side and added rows
+// no left side. Line numbers start at 1 and are zero on a missing side.
+type Row struct {
+	Kind      Kind
+	Left      string
+	Right     string
+	LeftLine  int
+	RightLine int
+}
+
+// maxCells bounds the size of the comparison table. Texts differing in more lines than
+// this allows are shown as wholly replaced.
+const maxCells = 4000000
+
+// Lines compares a and b line by line. Runs of removed lines followed by added lines are
+// paired up as changed rows, so edits to a line sit side by side.
+func Lines(a, b string) []Row {
+	left, right := splitLines(a), splitLines(b)
+
+	// Lines shared at the start and end need no comparison
+	prefix := 0
+	for prefix < len(left) && prefix < len(right) && left[prefix] == right[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(left)-prefix && suffix < len(right)-prefix &&
+		left[len(left)-1-suffix] == right[len(right)-1-suffix] {
+		suffix++
+	}
+
+	var rows []Row
+	for i := 0; i < prefix; i++ {
+		rows = append(rows, Row{Kind: Equal, Left: left[i], Right: right[i], LeftLine: i + 1, RightLine: i + 1})
+	}
+	rows = append(rows, compare(left[prefix:len(left)-suffix], right[prefix:len(right)-suffix], prefix, prefix)...)
+	for i := suffix; i > 0; i-- {
+		l, r := len(left)-i, len(right)-i
+		rows = append(rows, Row{Kind: Equal, Left: left[l], Right: right[r], LeftLine: l + 1, RightLine: r + 1})
+	}
+	return rows
+}
+
+// HasChanges reports whether any row differs
+func HasChanges(rows []Row) bool {
+	for _, row := range rows {
+		if row.Kind != Equal {
+			return true
+		}
+	}
+	return false
+}
+
+// splitLines splits text into lines, ignoring a final newline and carriage returns
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// compare diffs two runs of lines using their longest common subsequence. leftStart and
+// rightStart are the number of lines before each run, for line numbering.
+func compare(left, right []string, leftStart, rightStart int) []Row {
+	n, m := len(left), len(right)
+	if n*m > maxCells {
+		return pairUp(nil, left, right, leftStart, rightStart)
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of left[i:] and right[j:]
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if left[i] == right[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var rows []Row
+	var removed, added []string
+	removedAt, addedAt := leftStart, rightStart
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && left[i] == right[j]:
+			rows = pairUp(rows, removed, added, removedAt, addedAt)
+			removed, added = nil, nil
+			rows = append(rows, Row{Kind: Equal, Left: left[i], Right: right[j], LeftLine: leftStart + i + 1, RightLine: rightStart + j + 1})
+			i++
+			j++
+			removedAt, addedAt = leftStart+i, rightStart+j
+		case j == m || (i < n && lcs[i+1][j] >= lcs[i][j+1]):
+			removed = append(removed, left[i])
+			i++
+		default:
+			added = append(added, right[j])
+			j++
+		}
+	}
+	return pairUp(rows, removed, added, removedAt, addedAt)
+}
+
+// pairUp appends a run of removed and added lines to rows, pairing them as changed rows
+// first. removedAt and addedAt are the number of lines before each run.
+func pairUp(rows []Row, removed, added []string, removedAt, addedAt int) []Row {
+	for k := 0; k < len(removed) || k < len(added); k++ {
+		switch {
+		case k < len(removed) && k < len(added):
+			rows = append(rows, Row{Kind: Changed, Left: removed[k], Right: added[k], LeftLine: removedAt + k + 1, RightLine: addedAt + k + 1})
+		case k < len(removed):
+			rows = append(rows, Row{Kind: Removed, Left: removed[k], LeftLine: removedAt + k + 1})
+		default:
+			rows = append(rows, Row{Kind: Added, Right: added[k], RightLine: addedAt + k + 1})
+		}
+	}
+	return rows
+}
@@ -9,6 +9,7 @@ import (
 	"encoding/base64"
 	"encoding/pem"
 	"fmt"
+	"html"
 	"net/smtp"
 	"os"
 	"strings"
@@ -134,6 +135,188 @@ This email was sent from Sting Ray CMS. Please do not reply to this email.`, res
 	return e.sendEmail(toEmail, subject, textBody, htmlBody)
 }
 
+func (e *EmailService) SendVerificationEmail(toEmail, verifyURL string) error {
+	subject := "Verify Your Email Address - Sting Ray CMS"
+
+	htmlBody := fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Verify Your Email</title>
+</head>
+<body style="font-family: Arial, sans-serif; line-height: 1.6; color: #333;">
+    <div style="max-width: 600px; margin: 0 auto; padding: 20px;">
+        <h2 style="color: #007cba;">Verify Your Email Address</h2>
+        <p>Hello,</p>
+        <p>Thanks for creating a Sting Ray CMS account.</p>
+        <p>Click the button below to confirm your email address:</p>
+        <div style="text-align: center; margin: 30px 0;">
+            <a href="%s" style="background-color: #007cba; color: white; padding: 12px 24px; text-decoration: none; border-radius: 4px; display: inline-block;">Verify Email</a>
+        </div>
+        <p>If the button doesn't work, you can copy and paste this link into your browser:</p>
+        <p style="word-break: break-all; color: #666;">%s</p>
+        <p><strong>This link will expire in 24 hours.</strong></p>
+        <p>If you didn't create this account, please ignore this email.</p>
+        <hr style="margin: 30px 0; border: none; border-top: 1px solid #eee;">
+        <p style="color: #666; font-size: 12px;">This email was sent from Sting Ray CMS. Please do not reply to this email.</p>
+    </div>
+</body>
+</html>`, verifyURL, verifyURL)
+
+	textBody := fmt.Sprintf(`Verify Your Email Address
+
+Hello,
+
+Thanks for creating a Sting Ray CMS account.
+
+Click the link below to confirm your email address:
+%s
+
+This link will expire in 24 hours.
+
+If you didn't create this account, please ignore this email.
+
+---
+This email was sent from Sting Ray CMS. Please do not reply to this email.`, verifyURL)
+
+	return e.sendEmail(toEmail, subject, textBody, htmlBody)
+}
+
+func (e *EmailService) SendAccountApprovedEmail(toEmail, loginURL string) error {
+	subject := "Your Account Has Been Approved - Sting Ray CMS"
+
+	htmlBody := fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Account Approved</title>
+</head>
+<body style="font-family: Arial, sans-serif; line-height: 1.6; color: #333;">
+    <div style="max-width: 600px; margin: 0 auto; padding: 20px;">
+        <h2 style="color: #007cba;">Account Approved</h2>
+        <p>Hello,</p>
+        <p>An administrator has approved your Sting Ray CMS account. You can now log in.</p>
+        <div style="text-align: center; margin: 30px 0;">
+            <a href="%s" style="background-color: #007cba; color: white; padding: 12px 24px; text-decoration: none; border-radius: 4px; display: inline-block;">Log In</a>
+        </div>
+        <hr style="margin: 30px 0; border: none; border-top: 1px solid #eee;">
+        <p style="color: #666; font-size: 12px;">This email was sent from Sting Ray CMS. Please do not reply to this email.</p>
+    </div>
+</body>
+</html>`, loginURL)
+
+	textBody := fmt.Sprintf(`Account Approved
+
+Hello,
+
+An administrator has approved your Sting Ray CMS account. You can now log in:
+%s
+
+---
+This email was sent from Sting Ray CMS. Please do not reply to this email.`, loginURL)
+
+	return e.sendEmail(toEmail, subject, textBody, htmlBody)
+}
+
+func (e *EmailService) SendInvitationEmail(toEmail, inviteURL, invitedBy string) error {
+	subject := "You've Been Invited to Sting Ray CMS"
+
+	htmlBody := fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Invitation</title>
+</head>
+<body style="font-family: Arial, sans-serif; line-height: 1.6; color: #333;">
+    <div style="max-width: 600px; margin: 0 auto; padding: 20px;">
+        <h2 style="color: #007cba;">You've Been Invited</h2>
+        <p>Hello,</p>
+        <p>%s has invited you to join Sting Ray CMS.</p>
+        <p>Click the button below to choose a username and password:</p>
+        <div style="text-align: center; margin: 30px 0;">
+            <a href="%s" style="background-color: #007cba; color: white; padding: 12px 24px; text-decoration: none; border-radius: 4px; display: inline-block;">Accept Invitation</a>
+        </div>
+        <p>If the button doesn't work, you can copy and paste this link into your browser:</p>
+        <p style="word-break: break-all; color: #666;">%s</p>
+        <p><strong>This link will expire in 7 days and can only be used once.</strong></p>
+        <p>If you weren't expecting this invitation, please ignore this email.</p>
+        <hr style="margin: 30px 0; border: none; border-top: 1px solid #eee;">
+        <p style="color: #666; font-size: 12px;">This email was sent from Sting Ray CMS. Please do not reply to this email.</p>
+    </div>
+</body>
+</html>`, html.EscapeString(invitedBy), inviteURL, inviteURL)
+
+	textBody := fmt.Sprintf(`You've Been Invited
+
+Hello,
+
+%s has invited you to join Sting Ray CMS.
+
+Click the link below to choose a username and password:
+%s
+
+This link will expire in 7 days and can only be used once.
+
+If you weren't expecting this invitation, please ignore this email.
+
+---
+This email was sent from Sting Ray CMS. Please do not reply to this email.`, invitedBy, inviteURL)
+
+	return e.sendEmail(toEmail, subject, textBody, htmlBody)
+}
+
+func (e *EmailService) SendMagicLinkEmail(toEmail, loginURL string, validFor time.Duration) error {
+	subject := "Your Sign-In Link - Sting Ray CMS"
+	minutes := int(validFor.Minutes())
+
+	htmlBody := fmt.Sprintf(`
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Sign In</title>
+</head>
+<body style="font-family: Arial, sans-serif; line-height: 1.6; color: #333;">
+    <div style="max-width: 600px; margin: 0 auto; padding: 20px;">
+        <h2 style="color: #007cba;">Sign In to Sting Ray CMS</h2>
+        <p>Hello,</p>
+        <p>You asked for a link to sign in to your Sting Ray CMS account without a password.</p>
+        <p>Open it in the same browser you requested it from:</p>
+        <div style="text-align: center; margin: 30px 0;">
+            <a href="%s" style="background-color: #007cba; color: white; padding: 12px 24px; text-decoration: none; border-radius: 4px; display: inline-block;">Sign In</a>
+        </div>
+        <p>If the button doesn't work, you can copy and paste this link into your browser:</p>
+        <p style="word-break: break-all; color: #666;">%s</p>
+        <p><strong>This link will expire in %d minutes and can only be used once.</strong></p>
+        <p>If you didn't ask to sign in, please ignore this email.</p>
+        <hr style="margin: 30px 0; border: none; border-top: 1px solid #eee;">
+        <p style="color: #666; font-size: 12px;">This email was sent from Sting Ray CMS. Please do not reply to this email.</p>
+    </div>
+</body>
+</html>`, loginURL, loginURL, minutes)
+
+	textBody := fmt.Sprintf(`Sign In to Sting Ray CMS
+
+Hello,
+
+You asked for a link to sign in to your Sting Ray CMS account without a password.
+
+Open this link in the same browser you requested it from:
+%s
+
+This link will expire in %d minutes and can only be used once.
+
+If you didn't ask to sign in, please ignore this email.
+
+---
+This email was sent from Sting Ray CMS. Please do not reply to this email.`, loginURL, minutes)
+
+	return e.sendEmail(toEmail, subject, textBody, htmlBody)
+}
+
 func (e *EmailService) sendEmail(toEmail, subject, textBody, htmlBody string) error {
 	// Build email headers
 	headers := make(map[string]string)
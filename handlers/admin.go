@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"stingray/database"
+	"stingray/models"
+	"strconv"
+	"strings"
+)
+
+// adminNavigation is the navigation bar shown on admin pages
+const adminNavigation = `<a href="/">Home</a> | <a href="/user/profile">Profile</a> | <a href="/admin/sessions">Sessions</a> | <a href="/admin/registrations">Registrations</a> | <a href="/admin/invitations">Invitations</a> | <a href="/admin/permissions">Permissions</a> | <a href="/admin/impersonate">Log In As</a> | <a href="/admin/audit-log">Audit Log</a> | <a href="/metadata/tables">Database Tables</a> | <a href="/user/logout">Logout</a>`
+
+// AdminHandler handles administrative pages
+type AdminHandler struct {
+	db *database.Database
+	sm *SessionMiddleware
+}
+
+// NewAdminHandler creates a new admin handler
+func NewAdminHandler(db *database.Database, sm *SessionMiddleware) *AdminHandler {
+	return &AdminHandler{
+		db: db,
+		sm: sm,
+	}
+}
+
+// HandleSessions lists active sessions for every user, or a single user when user_id is given
+func (h *AdminHandler) HandleSessions(w http.ResponseWriter, r *http.Request) {
+	var sessions []models.Session
+	var err error
+	filterUserID := 0
+	if userIDStr := r.URL.Query().Get("user_id"); userIDStr != "" {
+		filterUserID, err = strconv.Atoi(userIDStr)
+		if err != nil {
+			RenderMessage(w, "Invalid User", "Invalid User", "error", "Invalid user_id parameter.", "/admin/sessions", "Back to Sessions", http.StatusBadRequest)
+			return
+		}
+		sessions, err = h.db.GetActiveUserSessions(filterUserID)
+	} else {
+		sessions, err = h.db.GetAllActiveSessions()
+	}
+	if err != nil {
+		database.LogSQLError(err)
+		http.Error(w, "Error fetching sessions", http.StatusInternalServerError)
+		return
+	}
+
+	currentSessionID := ""
+	if principal := h.sm.Principal(r); principal.Authenticated() {
+		currentSessionID = principal.Session.SessionID
+	}
+
+	contentTemplate := `<h1>Active Sessions</h1>
+			{{if .FilterUserID}}
+			<p>Showing sessions for user #{{.FilterUserID}}. <a href="/admin/sessions">Show all users</a></p>
+			<form method="POST" action="/admin/sessions/revoke-all" data-confirm="Sign this user out of every session?">
+				<input type="hidden" name="user_id" value="{{.FilterUserID}}">
+				<button type="submit" class="btn btn-danger">Revoke All Sessions for This User</button>
+			</form>
+			{{end}}
+			<table class="data-table">
+				<thead>
+					<tr><th>User</th><th>Created</th><th>Last Seen</th><th>Expires</th><th>IP Address</th><th>User Agent</th><th>Actions</th></tr>
+				</thead>
+				<tbody>
+					{{range .Sessions}}
+					<tr>
+						<td><a href="/admin/sessions?user_id={{.UserID}}">{{.Username}}</a>{{if .IsImpersonated}} <em>(impersonated)</em>{{end}}</td>
+						<td>{{.CreatedAt.Format "2006-01-02 15:04:05"}}</td>
+						<td>{{.LastSeen.Format "2006-01-02 15:04:05"}}</td>
+						<td>{{.ExpiresAt.Format "2006-01-02 15:04:05"}}</td>
+						<td>{{.IPAddress}}</td>
+						<td>{{.UserAgent}}</td>
+						<td>
+							{{if eq .SessionID $.CurrentSessionID}}
+							<strong>Your session</strong>
+							{{else}}
+							<form method="POST" action="/admin/sessions/revoke" style="display: inline;">
+								<input type="hidden" name="user_id" value="{{.UserID}}">
+								<input type="hidden" name="session" value="{{.ID}}">
+								<button type="submit" class="btn btn-danger">Revoke</button>
+							</form>
+							{{end}}
+						</td>
+					</tr>
+					{{else}}
+					<tr><td colspan="7">No active sessions.</td></tr>
+					{{end}}
+				</tbody>
+			</table>`
+
+	contentData := map[string]interface{}{
+		"Sessions":         sessions,
+		"FilterUserID":     filterUserID,
+		"CurrentSessionID": currentSessionID,
+	}
+
+	renderContentPage(w, "Active Sessions - Sting Ray", "Session Management", adminNavigation, contentTemplate, contentData)
+}
+
+// HandleRevokeSession revokes a single session belonging to any user
+func (h *AdminHandler) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		RenderMessage(w, "405 Method Not Allowed", "Method Not Allowed", "error", "Only POST is allowed for this endpoint.", "/admin/sessions", "Back to Sessions", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil {
+		RenderMessage(w, "Invalid User", "Invalid User", "error", "Invalid user_id parameter.", "/admin/sessions", "Back to Sessions", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.Atoi(r.FormValue("session"))
+	if err != nil {
+		RenderMessage(w, "Invalid Session", "Invalid Session", "error", "The session to revoke was not specified.", "/admin/sessions", "Back to Sessions", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.sm.store.InvalidateUserSession(userID, id); err != nil {
+		database.LogSQLError(err)
+		RenderMessage(w, "Revoke Failed", "Revoke Failed", "error", "The session could not be revoked.", "/admin/sessions", "Back to Sessions", http.StatusNotFound)
+		return
+	}
+
+	recordAuditEvent(h.db, h.sm, nil, r, models.AuditEvent{
+		EventType:    models.AuditSessionRevoked,
+		TargetUserID: userID,
+		Details:      "session " + strconv.Itoa(id),
+	})
+	http.Redirect(w, r, "/admin/sessions?user_id="+strconv.Itoa(userID), http.StatusSeeOther)
+}
+
+// HandleRevokeUserSessions revokes every session belonging to a user
+func (h *AdminHandler) HandleRevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		RenderMessage(w, "405 Method Not Allowed", "Method Not Allowed", "error", "Only POST is allowed for this endpoint.", "/admin/sessions", "Back to Sessions", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil {
+		RenderMessage(w, "Invalid User", "Invalid User", "error", "Invalid user_id parameter.", "/admin/sessions", "Back to Sessions", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.sm.store.InvalidateAllUserSessions(userID); err != nil {
+		database.LogSQLError(err)
+		RenderMessage(w, "Revoke Failed", "Revoke Failed", "error", "The sessions could not be revoked.", "/admin/sessions", "Back to Sessions", http.StatusInternalServerError)
+		return
+	}
+
+	recordAuditEvent(h.db, h.sm, nil, r, models.AuditEvent{
+		EventType:    models.AuditSessionRevoked,
+		TargetUserID: userID,
+		Details:      "all sessions",
+	})
+	http.Redirect(w, r, "/admin/sessions", http.StatusSeeOther)
+}
+
+// HandlePermissions shows which permissions each group holds, with forms to grant and revoke them
+func (h *AdminHandler) HandlePermissions(w http.ResponseWriter, r *http.Request) {
+	h.renderPermissions(w, "", http.StatusOK)
+}
+
+// HandleGrantPermission grants a permission to a group
+func (h *AdminHandler) HandleGrantPermission(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		RenderMessage(w, "405 Method Not Allowed", "Method Not Allowed", "error", "Only POST is allowed for this endpoint.", "/admin/permissions", "Back to Permissions", http.StatusMethodNotAllowed)
+		return
+	}
+
+	groupName := r.FormValue("group")
+	permission := strings.TrimSpace(r.FormValue("permission"))
+	if err := h.db.GrantPermission(groupName, permission); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			h.renderPermissions(w, "Group not found.", http.StatusNotFound)
+		case errors.Is(err, database.ErrInvalidPermission):
+			h.renderPermissions(w, "Permission names are dot-separated segments of letters, digits, underscores, hyphens or *.", http.StatusBadRequest)
+		default:
+			database.LogSQLError(err)
+			h.renderPermissions(w, "The permission could not be granted.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	recordAuditEvent(h.db, h.sm, nil, r, models.AuditEvent{
+		EventType: models.AuditPermissionGranted,
+		Details:   groupName + ": " + permission,
+	})
+	http.Redirect(w, r, "/admin/permissions", http.StatusSeeOther)
+}
+
+// HandleRevokePermission removes a permission from a group
+func (h *AdminHandler) HandleRevokePermission(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		RenderMessage(w, "405 Method Not Allowed", "Method Not Allowed", "error", "Only POST is allowed for this endpoint.", "/admin/permissions", "Back to Permissions", http.StatusMethodNotAllowed)
+		return
+	}
+
+	groupName := r.FormValue("group")
+	permission := r.FormValue("permission")
+	if err := h.db.RevokePermission(groupName, permission); err != nil {
+		if errors.Is(err, database.ErrProtectedPermission) {
+			h.renderPermissions(w, "The admin group always keeps users.manage.", http.StatusBadRequest)
+			return
+		}
+		database.LogSQLError(err)
+		h.renderPermissions(w, "The permission could not be revoked.", http.StatusInternalServerError)
+		return
+	}
+
+	recordAuditEvent(h.db, h.sm, nil, r, models.AuditEvent{
+		EventType: models.AuditPermissionRevoked,
+		Details:   groupName + ": " + permission,
+	})
+	http.Redirect(w, r, "/admin/permissions", http.StatusSeeOther)
+}
+
+// renderPermissions shows the group permission mapping and the grant form
+func (h *AdminHandler) renderPermissions(w http.ResponseWriter, errorMessage string, status int) {
+	groups, err := h.db.GetAllGroups()
+	if err != nil {
+		database.LogSQLError(err)
+		http.Error(w, "Error fetching groups", http.StatusInternalServerError)
+		return
+	}
+	grants, err := h.db.GetAllGroupPermissions()
+	if err != nil {
+		database.LogSQLError(err)
+		http.Error(w, "Error fetching permissions", http.StatusInternalServerError)
+		return
+	}
+	tables, err := h.db.GetAllTableMetadata()
+	if err != nil {
+		database.LogSQLError(err)
+		http.Error(w, "Error fetching table metadata", http.StatusInternalServerError)
+		return
+	}
+
+	type groupPermissions struct {
+		Name        string
+		Permissions []string
+	}
+	var mapping []groupPermissions
+	for _, group := range groups {
+		entry := groupPermissions{Name: group.Name}
+		for _, grant := range grants {
+			if grant.GroupName == group.Name {
+				entry.Permissions = append(entry.Permissions, grant.Permission)
+			}
+		}
+		mapping = append(mapping, entry)
+	}
+
+	// Suggest the known permissions plus a read and write permission for every table
+	suggestions := []string{"table.*.read", "table.*.write"}
+	for _, permission := range models.KnownPermissions {
+		suggestions = append(suggestions, permission.Name)
+	}
+	for _, table := range tables {
+		suggestions = append(suggestions,
+			models.TablePermission(table.TableName, "read"),
+			models.TablePermission(table.TableName, "write"))
+	}
+
+	contentTemplate := `<h1>Permissions</h1>
+			{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+			<div class="card">
+				<h2>Grant a Permission</h2>
+				<form method="POST" action="/admin/permissions/grant">
+					<div class="form-group">
+						<label for="group">Group:</label>
+						<select id="group" name="group" required>
+							{{range .Mapping}}<option value="{{.Name}}">{{.Name}}</option>{{end}}
+						</select>
+					</div>
+					<div class="form-group">
+						<label for="permission">Permission:</label>
+						<input type="text" id="permission" name="permission" list="permission-suggestions" required>
+						<datalist id="permission-suggestions">
+							{{range .Suggestions}}<option value="{{.}}">{{end}}
+						</datalist>
+					</div>
+					<button type="submit" class="btn">Grant</button>
+				</form>
+			</div>
+			<h2>Group Permissions</h2>
+			<table class="data-table">
+				<thead>
+					<tr><th>Group</th><th>Permissions</th></tr>
+				</thead>
+				<tbody>
+					{{range .Mapping}}
+					{{$group := .Name}}
+					<tr>
+						<td>{{.Name}}</td>
+						<td>
+							{{range .Permissions}}
+							<form method="POST" action="/admin/permissions/revoke" style="display: inline;" data-confirm="Revoke {{.}} from {{$group}}?">
+								<input type="hidden" name="group" value="{{$group}}">
+								<input type="hidden" name="permission" value="{{.}}">
+								<code>{{.}}</code> <button type="submit" class="btn btn-danger">Revoke</button>
+							</form>
+							{{else}}
+							<em>None</em>
+							{{end}}
+						</td>
+					</tr>
+					{{end}}
+				</tbody>
+			</table>
+			<h2>Known Permissions</h2>
+			<p>Table access uses <code>table.&lt;name&gt;.read</code> and <code>table.&lt;name&gt;.write</code>, which are also granted by each table's read and write groups. A <code>*</code> segment matches any segment, and <code>*</code> on its own grants everything.</p>
+			<table class="data-table">
+				<thead>
+					<tr><th>Permission</th><th>Description</th></tr>
+				</thead>
+				<tbody>
+					{{range .Known}}
+					<tr><td><code>{{.Name}}</code></td><td>{{.Description}}</td></tr>
+					{{end}}
+				</tbody>
+			</table>`
+
+	contentData := map[string]interface{}{
+		"Error":       errorMessage,
+		"Mapping":     mapping,
+		"Suggestions": suggestions,
+		"Known":       models.KnownPermissions,
+	}
+
+	renderContentPageStatus(w, status, "Permissions - Sting Ray", "Permissions", adminNavigation, contentTemplate, contentData)
+}
@@ -1,27 +1,36 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/mail"
 	"strconv"
+	"strings"
+	"stingray/auth"
 	"stingray/database"
+	"stingray/email"
 	"stingray/models"
 	"stingray/config"
 )
 
 // APIHandler handles API requests
 type APIHandler struct {
-	db  *database.Database
-	rm  *RoleMiddleware
-	cfg *config.Config
+	db    *database.Database
+	rm    *RoleMiddleware
+	cfg   *config.Config
+	email *email.EmailService
 }
 
 // NewAPIHandler creates a new API handler
-func NewAPIHandler(db *database.Database, cfg *config.Config) *APIHandler {
+func NewAPIHandler(db *database.Database, sm *SessionMiddleware, cfg *config.Config) *APIHandler {
 	return &APIHandler{
-		db:  db,
-		rm:  NewRoleMiddleware(db),
-		cfg: cfg,
+		db:    db,
+		rm:    NewRoleMiddleware(db, sm),
+		cfg:   cfg,
+		email: newEmailService(cfg, nil),
 	}
 }
 
@@ -33,7 +42,7 @@ type APIResponse struct {
 	Error   string      `json:"error,omitempty"`
 }
 
-// HandleGetUsers returns all users (admin only)
+// HandleGetUsers returns all users (requires users.manage)
 func (h *APIHandler) HandleGetUsers(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -41,7 +50,7 @@ func (h *APIHandler) HandleGetUsers(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check admin permissions
-	h.rm.RequireAdmin(func(w http.ResponseWriter, r *http.Request) {
+	h.rm.RequireAPIPermission(models.PermissionUsersManage)(func(w http.ResponseWriter, r *http.Request) {
 		users, err := h.db.GetAllUsers()
 		if err != nil {
 			database.LogSQLError(err)
@@ -84,7 +93,7 @@ func (h *APIHandler) HandleGetUsers(w http.ResponseWriter, r *http.Request) {
 	})(w, r)
 }
 
-// HandleGetGroups returns all groups (admin only)
+// HandleGetGroups returns all groups (requires users.manage)
 func (h *APIHandler) HandleGetGroups(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -92,7 +101,7 @@ func (h *APIHandler) HandleGetGroups(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check admin permissions
-	h.rm.RequireAdmin(func(w http.ResponseWriter, r *http.Request) {
+	h.rm.RequireAPIPermission(models.PermissionUsersManage)(func(w http.ResponseWriter, r *http.Request) {
 		groups, err := h.db.GetAllGroups()
 		if err != nil {
 			database.LogSQLError(err)
@@ -115,7 +124,7 @@ func (h *APIHandler) HandleGetGroups(w http.ResponseWriter, r *http.Request) {
 	})(w, r)
 }
 
-// HandleGetUserGroups returns groups for a specific user (admin only)
+// HandleGetUserGroups returns groups for a specific user (requires users.manage)
 func (h *APIHandler) HandleGetUserGroups(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -123,7 +132,7 @@ func (h *APIHandler) HandleGetUserGroups(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Check admin permissions
-	h.rm.RequireAdmin(func(w http.ResponseWriter, r *http.Request) {
+	h.rm.RequireAPIPermission(models.PermissionUsersManage)(func(w http.ResponseWriter, r *http.Request) {
 		userIDStr := r.URL.Query().Get("user_id")
 		if userIDStr == "" {
 			response := APIResponse{
@@ -178,61 +187,34 @@ func (h *APIHandler) HandleGetCurrentUser(w http.ResponseWriter, r *http.Request
 	}
 
 	// Check authentication
-	h.rm.RequireAuth(func(w http.ResponseWriter, r *http.Request) {
-		session, err := h.rm.sm.GetSessionFromRequest(r)
-		if err != nil {
-			response := APIResponse{
-				Success: false,
-				Error:   "Failed to get session",
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(response)
-			return
-		}
-
-		user, err := h.db.GetUserByID(session.UserID)
-		if err != nil {
-			database.LogSQLError(err)
-			response := APIResponse{
-				Success: false,
-				Error:   "Failed to get user: " + err.Error(),
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(response)
-			return
-		}
+	h.rm.RequireAPIAuth(func(w http.ResponseWriter, r *http.Request) {
+		principal := h.rm.sm.Principal(r)
+		user, groups := principal.User, principal.Groups
 
-		groups, err := h.db.GetUserGroups(user.ID)
+		permissions, err := h.db.GetUserPermissions(user.ID)
 		if err != nil {
-			database.LogSQLError(err)
-			response := APIResponse{
-				Success: false,
-				Error:   "Failed to get user groups: " + err.Error(),
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(response)
+			writeAPIStoreError(w, err, "User not found", "Failed to get user permissions")
 			return
 		}
 
 		type UserInfo struct {
-			ID        int           `json:"id"`
-			Username  string        `json:"username"`
-			Email     string        `json:"email"`
-			CreatedAt string        `json:"created_at"`
-			UpdatedAt string        `json:"updated_at"`
-			Groups    []models.Group `json:"groups"`
+			ID          int            `json:"id"`
+			Username    string         `json:"username"`
+			Email       string         `json:"email"`
+			CreatedAt   string         `json:"created_at"`
+			UpdatedAt   string         `json:"updated_at"`
+			Groups      []models.Group `json:"groups"`
+			Permissions []string       `json:"permissions"`
 		}
 
 		userInfo := UserInfo{
-			ID:        user.ID,
-			Username:  user.Username,
-			Email:     user.Email,
-			CreatedAt: user.CreatedAt.Format("2006-01-02 15:04:05"),
-			UpdatedAt: user.UpdatedAt.Format("2006-01-02 15:04:05"),
-			Groups:    groups,
+			ID:          user.ID,
+			Username:    user.Username,
+			Email:       user.Email,
+			CreatedAt:   user.CreatedAt.Format("2006-01-02 15:04:05"),
+			UpdatedAt:   user.UpdatedAt.Format("2006-01-02 15:04:05"),
+			Groups:      groups,
+			Permissions: permissions,
 		}
 
 		response := APIResponse{
@@ -251,7 +233,7 @@ func (h *APIHandler) HandleReloadEnv(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.rm.RequireAdmin(func(w http.ResponseWriter, r *http.Request) {
+	h.rm.RequireAPIPermission(models.PermissionConfigEdit)(func(w http.ResponseWriter, r *http.Request) {
 		success, errMsg := ReloadEnvConfig(h.cfg)
 		if !success {
 			response := APIResponse{
@@ -270,4 +252,741 @@ func (h *APIHandler) HandleReloadEnv(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(response)
 	})(w, r)
-} 
\ No newline at end of file
+}
+
+// writeAPIResponse encodes an APIResponse with the given status code
+func writeAPIResponse(w http.ResponseWriter, status int, response APIResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// writeAPIError writes a failed APIResponse with the given status code
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeAPIResponse(w, status, APIResponse{Success: false, Error: message})
+}
+
+// writeAPIStoreError maps a database error to a 404 for missing rows and a 500 otherwise
+func writeAPIStoreError(w http.ResponseWriter, err error, notFound, failed string) {
+	if errors.Is(err, sql.ErrNoRows) {
+		writeAPIError(w, http.StatusNotFound, notFound)
+		return
+	}
+	database.LogSQLError(err)
+	writeAPIError(w, http.StatusInternalServerError, failed)
+}
+
+// apiUser is the API representation of a user; passwords are never returned
+type apiUser struct {
+	ID        int            `json:"id"`
+	Username  string         `json:"username"`
+	Email     string         `json:"email"`
+	Status    string         `json:"status"`
+	CreatedAt string         `json:"created_at"`
+	UpdatedAt string         `json:"updated_at"`
+	Groups    []models.Group `json:"groups,omitempty"`
+	// EffectiveGroups includes groups inherited through nesting
+	EffectiveGroups []string `json:"effective_groups,omitempty"`
+}
+
+// newAPIUser converts a user for an API response
+func newAPIUser(user *models.User, groups []models.Group) apiUser {
+	return apiUser{
+		ID:        user.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		Status:    user.Status,
+		CreatedAt: user.CreatedAt.Format("2006-01-02 15:04:05"),
+		UpdatedAt: user.UpdatedAt.Format("2006-01-02 15:04:05"),
+		Groups:    groups,
+	}
+}
+
+// HandleUsers lists users on GET and creates a user on POST (requires users.manage).
+//
+// POST body: {"username": "...", "email": "...", "password": "...", "groups": ["..."]}
+func (h *APIHandler) HandleUsers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		h.HandleGetUsers(w, r)
+	case "POST":
+		h.rm.RequireAPIPermission(models.PermissionUsersManage)(h.createUser)(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *APIHandler) createUser(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Username string   `json:"username"`
+		Email    string   `json:"email"`
+		Password string   `json:"password"`
+		Groups   []string `json:"groups"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	request.Username = strings.TrimSpace(request.Username)
+	request.Email = strings.TrimSpace(request.Email)
+	if request.Username == "" || request.Email == "" || request.Password == "" {
+		writeAPIError(w, http.StatusBadRequest, "username, email and password are required")
+		return
+	}
+	if _, err := mail.ParseAddress(request.Email); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
+	if ok := h.checkGroupsExist(w, request.Groups); !ok {
+		return
+	}
+
+	exists, err := h.db.UserExists(request.Username, request.Email)
+	if err != nil {
+		writeAPIStoreError(w, err, "", "Failed to check existing users")
+		return
+	}
+	if exists {
+		writeAPIError(w, http.StatusConflict, "A user with that username or email already exists")
+		return
+	}
+
+	userID, err := h.db.CreateUserWithGroups(request.Username, request.Email, request.Password, request.Groups)
+	if policyErr, ok := auth.IsPolicyError(err); ok {
+		writeAPIError(w, http.StatusBadRequest, policyErr.Error())
+		return
+	}
+	if err != nil {
+		writeAPIStoreError(w, err, "", "Failed to create user")
+		return
+	}
+
+	h.writeUser(w, http.StatusCreated, userID, "User created successfully")
+}
+
+// HandleUser manages a single user (requires users.manage):
+//
+//	GET    /api/users/{id}                  user with groups
+//	PUT    /api/users/{id}                  {"username", "email", "status"}; omitted fields are unchanged
+//	DELETE /api/users/{id}                  delete the user
+//	POST   /api/users/{id}/password         {"password"} sets a new password
+//	POST   /api/users/{id}/password-reset   emails the user a password reset link
+//	GET    /api/users/{id}/groups           the user's groups
+//	POST   /api/users/{id}/groups           {"groups": [...]} adds memberships
+//	DELETE /api/users/{id}/groups/{group}   removes a membership
+func (h *APIHandler) HandleUser(w http.ResponseWriter, r *http.Request) {
+	h.rm.RequireAPIPermission(models.PermissionUsersManage)(func(w http.ResponseWriter, r *http.Request) {
+		pathParts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/users/"), "/"), "/")
+		userID, err := strconv.Atoi(pathParts[0])
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "Invalid user ID")
+			return
+		}
+
+		action := ""
+		if len(pathParts) > 1 {
+			action = pathParts[1]
+		}
+
+		switch {
+		case action == "" && r.Method == "GET":
+			h.writeUser(w, http.StatusOK, userID, "")
+		case action == "" && r.Method == "PUT":
+			h.updateUser(w, r, userID)
+		case action == "" && r.Method == "DELETE":
+			h.deleteUser(w, r, userID)
+		case action == "password" && r.Method == "POST":
+			h.setUserPassword(w, r, userID)
+		case action == "password-reset" && r.Method == "POST":
+			h.sendUserPasswordReset(w, r, userID)
+		case action == "groups" && len(pathParts) == 2 && r.Method == "GET":
+			h.writeUserGroups(w, userID)
+		case action == "groups" && len(pathParts) == 2 && r.Method == "POST":
+			h.addUserGroups(w, r, userID)
+		case action == "groups" && len(pathParts) == 3 && r.Method == "DELETE":
+			h.removeUserGroup(w, r, userID, pathParts[2])
+		default:
+			writeAPIError(w, http.StatusNotFound, "Unknown user endpoint or method")
+		}
+	})(w, r)
+}
+
+// writeUser responds with a user and their groups
+func (h *APIHandler) writeUser(w http.ResponseWriter, status, userID int, message string) {
+	user, err := h.db.GetUserByID(userID)
+	if err != nil {
+		writeAPIStoreError(w, err, "User not found", "Failed to get user")
+		return
+	}
+	groups, err := h.db.GetUserGroups(userID)
+	if err != nil {
+		writeAPIStoreError(w, err, "", "Failed to get user groups")
+		return
+	}
+	effectiveGroups, err := h.db.GetUserEffectiveGroups(userID)
+	if err != nil {
+		writeAPIStoreError(w, err, "", "Failed to get user groups")
+		return
+	}
+	data := newAPIUser(user, groups)
+	data.EffectiveGroups = effectiveGroups
+	writeAPIResponse(w, status, APIResponse{Success: true, Message: message, Data: data})
+}
+
+func (h *APIHandler) updateUser(w http.ResponseWriter, r *http.Request, userID int) {
+	var request struct {
+		Username *string `json:"username"`
+		Email    *string `json:"email"`
+		Status   *string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	user, err := h.db.GetUserByID(userID)
+	if err != nil {
+		writeAPIStoreError(w, err, "User not found", "Failed to get user")
+		return
+	}
+
+	username, emailAddress, status := user.Username, user.Email, user.Status
+	if request.Username != nil {
+		username = strings.TrimSpace(*request.Username)
+	}
+	if request.Email != nil {
+		emailAddress = strings.TrimSpace(*request.Email)
+	}
+	if request.Status != nil {
+		status = *request.Status
+	}
+
+	if username == "" || emailAddress == "" {
+		writeAPIError(w, http.StatusBadRequest, "username and email cannot be empty")
+		return
+	}
+	if _, err := mail.ParseAddress(emailAddress); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
+	switch status {
+	case models.UserStatusActive, models.UserStatusPendingVerification, models.UserStatusPendingApproval:
+	default:
+		writeAPIError(w, http.StatusBadRequest, "Invalid status")
+		return
+	}
+	if status != models.UserStatusActive && h.isCurrentUser(r, userID) {
+		writeAPIError(w, http.StatusBadRequest, "You cannot deactivate your own account")
+		return
+	}
+	usernameTaken, emailTaken, err := h.db.UserConflicts(username, emailAddress, userID)
+	if err != nil {
+		writeAPIStoreError(w, err, "", "Failed to check existing users")
+		return
+	}
+	if usernameTaken {
+		writeAPIError(w, http.StatusConflict, "Another user already has that username")
+		return
+	}
+	if emailTaken {
+		writeAPIError(w, http.StatusConflict, "Another user already has that email address")
+		return
+	}
+
+	if err := h.db.UpdateUser(userID, username, emailAddress, status); err != nil {
+		writeAPIStoreError(w, err, "User not found", "Failed to update user")
+		return
+	}
+
+	// Accounts that can no longer log in lose their sessions
+	if status != models.UserStatusActive {
+		if err := h.rm.sm.store.InvalidateAllUserSessions(userID); err != nil {
+			database.LogSQLError(err)
+		} else {
+			h.recordAudit(r, models.AuditSessionRevoked, userID, "all sessions: account "+status)
+		}
+	}
+
+	h.writeUser(w, http.StatusOK, userID, "User updated successfully")
+}
+
+func (h *APIHandler) deleteUser(w http.ResponseWriter, r *http.Request, userID int) {
+	if h.isCurrentUser(r, userID) {
+		writeAPIError(w, http.StatusBadRequest, "You cannot delete your own account")
+		return
+	}
+
+	if err := h.rm.sm.store.InvalidateAllUserSessions(userID); err != nil {
+		database.LogSQLError(err)
+	}
+	if err := h.db.DeleteUser(userID); err != nil {
+		writeAPIStoreError(w, err, "User not found", "Failed to delete user")
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, APIResponse{Success: true, Message: "User deleted successfully"})
+}
+
+func (h *APIHandler) setUserPassword(w http.ResponseWriter, r *http.Request, userID int) {
+	var request struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Password == "" {
+		writeAPIError(w, http.StatusBadRequest, "password is required")
+		return
+	}
+
+	// UpdateUserPassword enforces the password policy
+	err := h.db.UpdateUserPassword(userID, request.Password)
+	if policyErr, ok := auth.IsPolicyError(err); ok {
+		writeAPIError(w, http.StatusBadRequest, policyErr.Error())
+		return
+	}
+	if err != nil {
+		writeAPIStoreError(w, err, "User not found", "Failed to set password")
+		return
+	}
+
+	// A password change logs the user out everywhere
+	if err := h.rm.sm.store.InvalidateAllUserSessions(userID); err != nil {
+		database.LogSQLError(err)
+	}
+	h.recordAudit(r, models.AuditPasswordChanged, userID, "set by administrator")
+
+	writeAPIResponse(w, http.StatusOK, APIResponse{Success: true, Message: "Password updated successfully"})
+}
+
+func (h *APIHandler) sendUserPasswordReset(w http.ResponseWriter, r *http.Request, userID int) {
+	user, err := h.db.GetUserByID(userID)
+	if err != nil {
+		writeAPIStoreError(w, err, "User not found", "Failed to get user")
+		return
+	}
+
+	if h.email == nil && !h.cfg.DevShowTokenLinks {
+		writeAPIError(w, http.StatusServiceUnavailable, "Email is not configured; no reset link was sent")
+		return
+	}
+
+	token, err := createPasswordResetToken(h.db, user.ID, user.Email)
+	if err != nil {
+		writeAPIStoreError(w, err, "", "Failed to create reset token")
+		return
+	}
+
+	resetURL := h.cfg.PublicURL("/user/password-reset-confirm?token=" + token)
+	if h.email == nil {
+		// Development fallback for when email service is not available
+		writeAPIResponse(w, http.StatusOK, APIResponse{
+			Success: true,
+			Message: "Email is not configured; share the reset link with the user",
+			Data:    map[string]string{"reset_url": resetURL},
+		})
+		return
+	}
+
+	if err := h.email.SendPasswordResetEmail(user.Email, resetURL); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "Failed to send reset email: "+err.Error())
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, APIResponse{Success: true, Message: "Password reset email sent to " + user.Email})
+}
+
+// recordAudit writes an event performed through the API by the signed-in user to the audit log
+func (h *APIHandler) recordAudit(r *http.Request, eventType string, targetUserID int, details string) {
+	recordAuditEvent(h.db, h.rm.sm, nil, r, models.AuditEvent{
+		EventType:    eventType,
+		TargetUserID: targetUserID,
+		Details:      details,
+	})
+}
+
+func (h *APIHandler) writeUserGroups(w http.ResponseWriter, userID int) {
+	if _, err := h.db.GetUserByID(userID); err != nil {
+		writeAPIStoreError(w, err, "User not found", "Failed to get user")
+		return
+	}
+	groups, err := h.db.GetUserGroups(userID)
+	if err != nil {
+		writeAPIStoreError(w, err, "", "Failed to get user groups")
+		return
+	}
+	writeAPIResponse(w, http.StatusOK, APIResponse{Success: true, Data: groups})
+}
+
+func (h *APIHandler) addUserGroups(w http.ResponseWriter, r *http.Request, userID int) {
+	var request struct {
+		Groups []string `json:"groups"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Groups) == 0 {
+		writeAPIError(w, http.StatusBadRequest, "groups is required")
+		return
+	}
+	if ok := h.checkGroupsExist(w, request.Groups); !ok {
+		return
+	}
+	if _, err := h.db.GetUserByID(userID); err != nil {
+		writeAPIStoreError(w, err, "User not found", "Failed to get user")
+		return
+	}
+
+	for _, groupName := range request.Groups {
+		if err := h.db.AddUserToGroup(userID, groupName); err != nil {
+			writeAPIStoreError(w, err, "", "Failed to add user to group "+groupName)
+			return
+		}
+		h.recordAudit(r, models.AuditGroupMemberAdded, userID, groupName)
+	}
+
+	h.writeUserGroups(w, userID)
+}
+
+func (h *APIHandler) removeUserGroup(w http.ResponseWriter, r *http.Request, userID int, groupName string) {
+	if lockedOut, err := h.removalLocksOut(r, userID, groupName); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "Failed to check your permissions")
+		return
+	} else if lockedOut {
+		writeAPIError(w, http.StatusBadRequest, "You cannot remove yourself from a group that gives you the users.manage permission")
+		return
+	}
+	if err := h.db.RemoveUserFromGroup(userID, groupName); err != nil {
+		writeAPIStoreError(w, err, "", "Failed to remove user from group")
+		return
+	}
+	h.recordAudit(r, models.AuditGroupMemberRemoved, userID, groupName)
+	h.writeUserGroups(w, userID)
+}
+
+// HandleGroups lists groups on GET and creates a group on POST (requires users.manage).
+//
+// POST body: {"name": "...", "description": "..."}
+func (h *APIHandler) HandleGroups(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		h.HandleGetGroups(w, r)
+	case "POST":
+		h.rm.RequireAPIPermission(models.PermissionUsersManage)(h.createGroup)(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *APIHandler) createGroup(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" {
+		writeAPIError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	groups, err := h.db.GetAllGroups()
+	if err != nil {
+		writeAPIStoreError(w, err, "", "Failed to retrieve groups")
+		return
+	}
+	if groupExists(groups, request.Name) {
+		writeAPIError(w, http.StatusConflict, "A group with that name already exists")
+		return
+	}
+
+	groupID, err := h.db.CreateGroup(request.Name, request.Description)
+	if err != nil {
+		writeAPIStoreError(w, err, "", "Failed to create group")
+		return
+	}
+
+	group, err := h.db.GetGroupByID(groupID)
+	if err != nil {
+		writeAPIStoreError(w, err, "Group not found", "Failed to get group")
+		return
+	}
+	writeAPIResponse(w, http.StatusCreated, APIResponse{Success: true, Message: "Group created successfully", Data: group})
+}
+
+// HandleGroup manages a single group (requires users.manage):
+//
+//	GET    /api/groups/{id}                       group with its members, subgroups and parent groups
+//	PUT    /api/groups/{id}                       {"name", "description"}; omitted fields are unchanged
+//	DELETE /api/groups/{id}                       delete the group and its memberships
+//	POST   /api/groups/{id}/subgroups             {"groups": [...]} nests groups inside this one
+//	DELETE /api/groups/{id}/subgroups/{group}     stops nesting a group inside this one
+func (h *APIHandler) HandleGroup(w http.ResponseWriter, r *http.Request) {
+	h.rm.RequireAPIPermission(models.PermissionUsersManage)(func(w http.ResponseWriter, r *http.Request) {
+		pathParts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/groups/"), "/"), "/")
+		groupID, err := strconv.Atoi(pathParts[0])
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "Invalid group ID")
+			return
+		}
+
+		group, err := h.db.GetGroupByID(groupID)
+		if err != nil {
+			writeAPIStoreError(w, err, "Group not found", "Failed to get group")
+			return
+		}
+
+		if len(pathParts) > 1 {
+			switch {
+			case pathParts[1] == "subgroups" && len(pathParts) == 2 && r.Method == "POST":
+				h.addSubgroups(w, r, group)
+			case pathParts[1] == "subgroups" && len(pathParts) == 3 && r.Method == "DELETE":
+				if err := h.db.RemoveGroupFromGroup(group.Name, pathParts[2]); err != nil {
+					writeAPIStoreError(w, err, "", "Failed to remove nested group")
+					return
+				}
+				h.recordAudit(r, models.AuditGroupUnnested, 0, pathParts[2]+" from "+group.Name)
+				h.writeGroup(w, http.StatusOK, group, "Nested group removed")
+			default:
+				writeAPIError(w, http.StatusNotFound, "Unknown group endpoint or method")
+			}
+			return
+		}
+
+		switch r.Method {
+		case "GET":
+			h.writeGroup(w, http.StatusOK, group, "")
+
+		case "PUT":
+			var request struct {
+				Name        *string `json:"name"`
+				Description *string `json:"description"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				writeAPIError(w, http.StatusBadRequest, "Invalid JSON")
+				return
+			}
+			// Tables, pages and rows grant access by group name, so a rename would change them
+			if request.Name != nil && strings.TrimSpace(*request.Name) != group.Name {
+				writeAPIError(w, http.StatusBadRequest, "Groups cannot be renamed because read and write groups refer to them by name; create a new group instead")
+				return
+			}
+			description := group.Description
+			if request.Description != nil {
+				description = *request.Description
+			}
+
+			if err := h.db.UpdateGroupDescription(groupID, description); err != nil {
+				writeAPIStoreError(w, err, "Group not found", "Failed to update group")
+				return
+			}
+			updated, err := h.db.GetGroupByID(groupID)
+			if err != nil {
+				writeAPIStoreError(w, err, "Group not found", "Failed to get group")
+				return
+			}
+			writeAPIResponse(w, http.StatusOK, APIResponse{Success: true, Message: "Group updated successfully", Data: updated})
+
+		case "DELETE":
+			err := h.db.DeleteGroup(groupID)
+			if errors.Is(err, database.ErrProtectedGroup) {
+				writeAPIError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if err != nil {
+				writeAPIStoreError(w, err, "Group not found", "Failed to delete group")
+				return
+			}
+			writeAPIResponse(w, http.StatusOK, APIResponse{Success: true, Message: "Group deleted successfully"})
+
+		default:
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	})(w, r)
+}
+
+// writeGroup responds with a group, its direct members, the groups nested inside it and
+// the groups it is nested inside
+func (h *APIHandler) writeGroup(w http.ResponseWriter, status int, group *models.Group, message string) {
+	members, err := h.db.GetGroupMembers(group.ID)
+	if err != nil {
+		writeAPIStoreError(w, err, "", "Failed to get group members")
+		return
+	}
+	subgroups, err := h.db.GetSubgroups(group.ID)
+	if err != nil {
+		writeAPIStoreError(w, err, "", "Failed to get nested groups")
+		return
+	}
+	parentGroups, err := h.db.GetParentGroups(group.ID)
+	if err != nil {
+		writeAPIStoreError(w, err, "", "Failed to get parent groups")
+		return
+	}
+
+	users := make([]apiUser, len(members))
+	for i := range members {
+		users[i] = newAPIUser(&members[i], nil)
+	}
+	writeAPIResponse(w, status, APIResponse{Success: true, Message: message, Data: map[string]interface{}{
+		"group":     group,
+		"members":   users,
+		"subgroups": subgroups,
+		"member_of": parentGroups,
+	}})
+}
+
+func (h *APIHandler) addSubgroups(w http.ResponseWriter, r *http.Request, group *models.Group) {
+	var request struct {
+		Groups []string `json:"groups"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Groups) == 0 {
+		writeAPIError(w, http.StatusBadRequest, "groups is required")
+		return
+	}
+	if ok := h.checkGroupsExist(w, request.Groups); !ok {
+		return
+	}
+
+	for _, child := range request.Groups {
+		err := h.db.AddGroupToGroup(group.Name, child)
+		if errors.Is(err, database.ErrGroupCycle) {
+			writeAPIError(w, http.StatusConflict, fmt.Sprintf("Cannot nest %s inside %s: %v", child, group.Name, err))
+			return
+		}
+		if err != nil {
+			writeAPIStoreError(w, err, "", "Failed to nest group "+child)
+			return
+		}
+		h.recordAudit(r, models.AuditGroupNested, 0, child+" in "+group.Name)
+	}
+
+	h.writeGroup(w, http.StatusOK, group, "Nested groups added")
+}
+
+// membershipChange is one user/group pair in a bulk membership request
+type membershipChange struct {
+	UserID int    `json:"user_id"`
+	Group  string `json:"group"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HandleBulkMembership adds or removes every listed user to or from every listed group (requires users.manage).
+//
+// POST body: {"action": "add" | "remove", "user_ids": [1, 2], "groups": ["engineer"]}
+//
+// Each pair is applied independently; pairs that fail are reported in data.failed.
+func (h *APIHandler) HandleBulkMembership(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.rm.RequireAPIPermission(models.PermissionUsersManage)(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Action  string   `json:"action"`
+			UserIDs []int    `json:"user_ids"`
+			Groups  []string `json:"groups"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if request.Action != "add" && request.Action != "remove" {
+			writeAPIError(w, http.StatusBadRequest, `action must be "add" or "remove"`)
+			return
+		}
+		if len(request.UserIDs) == 0 || len(request.Groups) == 0 {
+			writeAPIError(w, http.StatusBadRequest, "user_ids and groups are required")
+			return
+		}
+		if ok := h.checkGroupsExist(w, request.Groups); !ok {
+			return
+		}
+
+		var applied, failed []membershipChange
+		for _, userID := range request.UserIDs {
+			for _, groupName := range request.Groups {
+				change := membershipChange{UserID: userID, Group: groupName}
+
+				var err error
+				if _, lookupErr := h.db.GetUserByID(userID); lookupErr != nil {
+					err = fmt.Errorf("user not found")
+				} else if request.Action == "add" {
+					err = h.db.AddUserToGroup(userID, groupName)
+				} else if lockedOut, checkErr := h.removalLocksOut(r, userID, groupName); checkErr != nil {
+					err = fmt.Errorf("failed to check your permissions")
+				} else if lockedOut {
+					err = fmt.Errorf("you cannot remove yourself from a group that gives you the users.manage permission")
+				} else {
+					err = h.db.RemoveUserFromGroup(userID, groupName)
+				}
+
+				if err != nil {
+					change.Error = err.Error()
+					failed = append(failed, change)
+				} else {
+					applied = append(applied, change)
+					eventType := models.AuditGroupMemberAdded
+					if request.Action == "remove" {
+						eventType = models.AuditGroupMemberRemoved
+					}
+					h.recordAudit(r, eventType, userID, groupName)
+				}
+			}
+		}
+
+		status := http.StatusOK
+		if len(applied) == 0 {
+			status = http.StatusBadRequest
+		}
+		writeAPIResponse(w, status, APIResponse{
+			Success: len(failed) == 0,
+			Message: fmt.Sprintf("%d membership changes applied, %d failed", len(applied), len(failed)),
+			Data: map[string]interface{}{
+				"applied": applied,
+				"failed":  failed,
+			},
+		})
+	})(w, r)
+}
+
+// checkGroupsExist writes a 400 response and returns false if any group name is unknown
+func (h *APIHandler) checkGroupsExist(w http.ResponseWriter, groupNames []string) bool {
+	if len(groupNames) == 0 {
+		return true
+	}
+	groups, err := h.db.GetAllGroups()
+	if err != nil {
+		writeAPIStoreError(w, err, "", "Failed to retrieve groups")
+		return false
+	}
+	for _, groupName := range groupNames {
+		if !groupExists(groups, groupName) {
+			writeAPIError(w, http.StatusBadRequest, "Unknown group: "+groupName)
+			return false
+		}
+	}
+	return true
+}
+
+// removalLocksOut reports whether removing userID from a group would take users.manage
+// away from the administrator making the request, leaving them unable to undo it
+func (h *APIHandler) removalLocksOut(r *http.Request, userID int, groupName string) (bool, error) {
+	if !h.isCurrentUser(r, userID) {
+		return false, nil
+	}
+	access, err := h.db.AccessWithoutGroup(userID, groupName)
+	if err != nil {
+		return false, err
+	}
+	allowed, err := h.db.Can(access, models.PermissionUsersManage)
+	if err != nil {
+		return false, err
+	}
+	return !allowed, nil
+}
+
+// isCurrentUser reports whether userID belongs to the administrator making the request
+func (h *APIHandler) isCurrentUser(r *http.Request, userID int) bool {
+	principal := h.rm.sm.Principal(r)
+	return principal.Authenticated() && principal.UserID() == userID
+}
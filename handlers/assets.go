@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+)
+
+// assetPrefix is where static assets are served. They are the same for every visitor, so
+// their requests do not load the user and groups.
+const assetPrefix = "/assets/"
+
+// SiteScriptPath is where the shared site script is served from. Being same-origin it is
+// allowed by the Content-Security-Policy without a nonce.
+const SiteScriptPath = assetPrefix + "stingray.js"
+
+// siteScriptTag loads the shared site script; add it to the head of pages that use it
+const siteScriptTag = `<script src="` + SiteScriptPath + `" defer></script>`
+
+// siteScript replaces inline event handlers, which the Content-Security-Policy blocks.
+// Forms with a data-confirm attribute ask for confirmation before they are submitted.
+const siteScript = `document.addEventListener('submit', function (event) {
+	var message = event.target.getAttribute('data-confirm');
+	if (message && !window.confirm(message)) {
+		event.preventDefault();
+	}
+}, true);
+`
+
+// HandleSiteScript serves the shared site script
+func HandleSiteScript(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write([]byte(siteScript))
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"stingray/database"
+	"stingray/logging"
+	"stingray/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditLogPageSize is the number of events shown per page of the audit log
+const AuditLogPageSize = 50
+
+// AuditHandler shows the security event log to administrators
+type AuditHandler struct {
+	db *database.Database
+}
+
+// NewAuditHandler creates a new audit log handler
+func NewAuditHandler(db *database.Database) *AuditHandler {
+	return &AuditHandler{db: db}
+}
+
+// recordAuditEvent writes an event to the audit log with the request's IP address and user
+// agent. When the event has no actor, the signed-in user making the request is recorded.
+// Failures are only logged so auditing never breaks the action being audited.
+func recordAuditEvent(db *database.Database, sm *SessionMiddleware, logger *logging.Logger, r *http.Request, event models.AuditEvent) {
+	if event.ActorID == 0 && event.ActorName == "" && sm != nil {
+		if principal := sm.Principal(r); principal.Authenticated() {
+			event.ActorID = principal.UserID()
+			event.ActorName = principal.Username()
+		}
+	}
+	event.IPAddress = ClientIP(r)
+	event.UserAgent = r.UserAgent()
+
+	if err := db.RecordAuditEvent(&event); err != nil && logger != nil {
+		logger.LogError("Failed to record %s for user %d: %v", event.EventType, event.TargetUserID, err)
+	}
+}
+
+// ParseAuditFilter reads the audit log filter from query parameters. since and until are
+// dates (YYYY-MM-DD); until includes the whole day.
+func ParseAuditFilter(query url.Values) (models.AuditFilter, error) {
+	filter := models.AuditFilter{
+		EventType: query.Get("event_type"),
+		Username:  strings.TrimSpace(query.Get("username")),
+		IPAddress: strings.TrimSpace(query.Get("ip")),
+	}
+	if filter.EventType != "" {
+		known := false
+		for _, eventType := range models.AuditEventTypes {
+			if eventType == filter.EventType {
+				known = true
+			}
+		}
+		if !known {
+			return filter, fmt.Errorf("unknown event type %q", filter.EventType)
+		}
+	}
+	if since := query.Get("since"); since != "" {
+		day, err := time.ParseInLocation("2006-01-02", since, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("invalid since date %q", since)
+		}
+		filter.Since = day
+	}
+	if until := query.Get("until"); until != "" {
+		day, err := time.ParseInLocation("2006-01-02", until, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("invalid until date %q", until)
+		}
+		filter.Until = day.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
+
+// HandleAuditLog lists security events, filtered by event type, user, IP address and date
+func (h *AuditHandler) HandleAuditLog(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter, err := ParseAuditFilter(query)
+	errorMessage := ""
+	status := http.StatusOK
+	if err != nil {
+		errorMessage = "Invalid filter: " + err.Error()
+		status = http.StatusBadRequest
+		filter = models.AuditFilter{}
+	}
+
+	page, _ := strconv.Atoi(query.Get("page"))
+	if page <= 0 {
+		page = 1
+	}
+	events, total, err := h.db.GetAuditEvents(filter, AuditLogPageSize, (page-1)*AuditLogPageSize)
+	if err != nil {
+		database.LogSQLError(err)
+		http.Error(w, "Error fetching audit log", http.StatusInternalServerError)
+		return
+	}
+
+	// Pagination links keep the current filter
+	pageURL := func(p int) string {
+		values := url.Values{}
+		for _, key := range []string{"event_type", "username", "ip", "since", "until"} {
+			if value := query.Get(key); value != "" {
+				values.Set(key, value)
+			}
+		}
+		values.Set("page", strconv.Itoa(p))
+		return "/admin/audit-log?" + values.Encode()
+	}
+	previousURL, nextURL := "", ""
+	if page > 1 {
+		previousURL = pageURL(page - 1)
+	}
+	if page*AuditLogPageSize < total {
+		nextURL = pageURL(page + 1)
+	}
+
+	contentTemplate := `<h1>Audit Log</h1>
+			{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+			<div class="card">
+				<form method="GET" action="/admin/audit-log">
+					<div class="form-group">
+						<label for="event_type">Event:</label>
+						<select id="event_type" name="event_type">
+							<option value="">All events</option>
+							{{range .EventTypes}}<option value="{{.}}"{{if eq . $.EventType}} selected{{end}}>{{.}}</option>{{end}}
+						</select>
+					</div>
+					<div class="form-group">
+						<label for="username">User:</label>
+						<input type="text" id="username" name="username" value="{{.Username}}">
+					</div>
+					<div class="form-group">
+						<label for="ip">IP Address:</label>
+						<input type="text" id="ip" name="ip" value="{{.IP}}">
+					</div>
+					<div class="form-group">
+						<label for="since">From:</label>
+						<input type="date" id="since" name="since" value="{{.Since}}">
+						<label for="until">To:</label>
+						<input type="date" id="until" name="until" value="{{.Until}}">
+					</div>
+					<button type="submit" class="btn">Filter</button>
+					<a href="/admin/audit-log" class="btn btn-secondary">Clear</a>
+				</form>
+			</div>
+			<p>{{.Total}} matching events.</p>
+			<table class="data-table">
+				<thead>
+					<tr><th>Time</th><th>Event</th><th>Actor</th><th>Target</th><th>Details</th><th>IP Address</th><th>User Agent</th></tr>
+				</thead>
+				<tbody>
+					{{range .Events}}
+					<tr>
+						<td>{{.CreatedAt.Format "2006-01-02 15:04:05"}}</td>
+						<td>{{.EventType}}</td>
+						<td>{{if .ActorName}}{{.ActorName}}{{else if .ActorID}}#{{.ActorID}}{{end}}</td>
+						<td>{{if .TargetName}}{{.TargetName}}{{else if .TargetUserID}}#{{.TargetUserID}}{{end}}</td>
+						<td>{{.Details}}</td>
+						<td>{{.IPAddress}}</td>
+						<td>{{.UserAgent}}</td>
+					</tr>
+					{{else}}
+					<tr><td colspan="7">No events.</td></tr>
+					{{end}}
+				</tbody>
+			</table>
+			<p>
+				{{if .PreviousURL}}<a href="{{.PreviousURL}}" class="btn">Newer</a>{{end}}
+				{{if .NextURL}}<a href="{{.NextURL}}" class="btn">Older</a>{{end}}
+			</p>`
+
+	contentData := map[string]interface{}{
+		"Error":       errorMessage,
+		"EventTypes":  models.AuditEventTypes,
+		"EventType":   query.Get("event_type"),
+		"Username":    query.Get("username"),
+		"IP":          query.Get("ip"),
+		"Since":       query.Get("since"),
+		"Until":       query.Get("until"),
+		"Events":      events,
+		"Total":       total,
+		"PreviousURL": previousURL,
+		"NextURL":     nextURL,
+	}
+
+	renderContentPageStatus(w, status, "Audit Log - Sting Ray", "Audit Log", adminNavigation, contentTemplate, contentData)
+}
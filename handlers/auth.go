@@ -3,8 +3,11 @@ package handlers
 import (
 	"html/template"
 	"net/http"
+	"strconv"
+	"stingray/auth"
 	"stingray/database"
 	"stingray/logging"
+	"stingray/models"
 	"stingray/templates"
 )
 
@@ -16,10 +19,10 @@ type AuthHandler struct {
 }
 
 // NewAuthHandler creates a new auth handler
-func NewAuthHandler(db *database.Database, logger *logging.Logger) *AuthHandler {
+func NewAuthHandler(db *database.Database, sm *SessionMiddleware, logger *logging.Logger) *AuthHandler {
 	return &AuthHandler{
 		db:     db,
-		sm:     NewSessionMiddleware(db),
+		sm:     sm,
 		logger: logger,
 	}
 }
@@ -32,7 +35,7 @@ func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	page, err := h.db.GetPage("login")
+	page, err := h.db.GetPublishedPage("login")
 	if err != nil {
 		database.LogSQLError(err)
 		h.logger.LogError("Login page not found: %v", err)
@@ -40,7 +43,7 @@ func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	html, err := templates.RenderPage(page)
+	html, err := templates.RenderPage(page, CSPNonce(r))
 	if err != nil {
 		http.Error(w, "Error rendering page", http.StatusInternalServerError)
 		return
@@ -59,6 +62,7 @@ func (h *AuthHandler) HandleLoginPost(w http.ResponseWriter, r *http.Request) {
 
 	username := r.FormValue("username")
 	password := r.FormValue("password")
+	rememberMe := r.FormValue("remember_me") != ""
 
 	var data struct {
 		Title       string
@@ -73,10 +77,7 @@ func (h *AuthHandler) HandleLoginPost(w http.ResponseWriter, r *http.Request) {
 	data.Footer = "© 2025 StingRay"
 
 	// Get remote address for logging
-	remoteAddr := r.RemoteAddr
-	if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
-		remoteAddr = forwardedFor
-	}
+	remoteAddr := ClientIP(r)
 
 	// Authenticate user against database
 	user, err := h.db.AuthenticateUser(username, password)
@@ -91,11 +92,21 @@ func (h *AuthHandler) HandleLoginPost(w http.ResponseWriter, r *http.Request) {
 		data.Header = "Login Failed"
 		data.HeaderClass = "error"
 		data.Message = "Invalid username or password."
+		reason := "invalid credentials"
+		if err == database.ErrAccountNotActive {
+			data.Message = "Your account is not active yet. Please verify your email address or wait for an administrator to approve it."
+			reason = "account not active"
+		}
+		recordAuditEvent(h.db, nil, h.logger, r, models.AuditEvent{
+			EventType:  models.AuditLoginFailure,
+			TargetName: username,
+			Details:    reason,
+		})
 		data.ButtonURL = "/user/login"
 		data.ButtonText = "Try Again"
 	} else {
 		// Create session
-		session, err := h.db.CreateSession(user.ID, user.Username, SessionDuration)
+		err := h.sm.StartSession(w, r, user, rememberMe)
 		if err != nil {
 			database.LogSQLError(err)
 			// Log failed login attempt (authentication succeeded but session creation failed)
@@ -107,16 +118,26 @@ func (h *AuthHandler) HandleLoginPost(w http.ResponseWriter, r *http.Request) {
 			data.Header = "Login Error"
 			data.HeaderClass = "error"
 			data.Message = "Failed to create session. Please try again."
+			recordAuditEvent(h.db, nil, h.logger, r, models.AuditEvent{
+				EventType:    models.AuditLoginFailure,
+				TargetUserID: user.ID,
+				TargetName:   user.Username,
+				Details:      "session could not be created",
+			})
 			data.ButtonURL = "/user/login"
 			data.ButtonText = "Try Again"
 		} else {
-			// Set session cookie
-			h.sm.SetSessionCookie(w, session.SessionID)
-			
 			// Log successful login
 			if h.logger != nil {
 				h.logger.LogLogin(username, remoteAddr, true)
 			}
+			recordAuditEvent(h.db, nil, h.logger, r, models.AuditEvent{
+				EventType:    models.AuditLoginSuccess,
+				ActorID:      user.ID,
+				ActorName:    user.Username,
+				TargetUserID: user.ID,
+				TargetName:   user.Username,
+			})
 			
 			data.Title = "Login Success - Sting Ray"
 			data.MetaDescription = "Login successful"
@@ -145,9 +166,30 @@ func (h *AuthHandler) HandleLoginPost(w http.ResponseWriter, r *http.Request) {
 // HandleLogout handles user logout
 func (h *AuthHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
 	// Get session from request
-	if session, err := h.sm.GetSessionFromRequest(r); err == nil {
+	if principal := h.sm.Principal(r); principal.Authenticated() {
+		session := principal.Session
+
 		// Invalidate session in database
-		h.db.InvalidateSession(session.SessionID)
+		h.sm.store.Invalidate(session)
+
+		recordAuditEvent(h.db, nil, h.logger, r, models.AuditEvent{
+			EventType:    models.AuditLogout,
+			ActorID:      session.UserID,
+			ActorName:    session.Username,
+			TargetUserID: session.UserID,
+			TargetName:   session.Username,
+		})
+
+		// Logging out of an impersonation session also ends the impersonation
+		if session.IsImpersonated() {
+			recordAuditEvent(h.db, nil, h.logger, r, models.AuditEvent{
+				EventType:    models.AuditImpersonationStop,
+				ActorID:      session.ImpersonatorID,
+				TargetUserID: session.UserID,
+				TargetName:   session.Username,
+				Details:      "logged out",
+			})
+		}
 	}
 
 	// Clear session cookie
@@ -159,48 +201,237 @@ func (h *AuthHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
 
 // HandleProfile shows user profile page (requires authentication)
 func (h *AuthHandler) HandleProfile(w http.ResponseWriter, r *http.Request) {
-	session, err := h.sm.GetSessionFromRequest(r)
+	principal := h.sm.Principal(r)
+	if !principal.Authenticated() {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	session := principal.Session
+
+	notice := ""
+	if r.URL.Query().Get("password") == "changed" {
+		notice = "Your password has been changed and your other sessions have been signed out."
+	}
+	h.renderProfile(w, session, nil, notice, http.StatusOK)
+}
+
+// renderProfile renders the profile page with optional password form errors or a notice
+func (h *AuthHandler) renderProfile(w http.ResponseWriter, session *models.Session, passwordErrors []string, notice string, status int) {
+	sessions, err := h.db.GetActiveUserSessions(session.UserID)
 	if err != nil {
+		database.LogSQLError(err)
+		RenderMessage(w, "Profile Error", "Profile Error", "error", "Failed to load your sessions.", "/", "Go Home", http.StatusInternalServerError)
+		return
+	}
+
+	contentTemplate := `<h1>Welcome, {{.Username}}</h1>
+			{{if .Notice}}<p class="success">{{.Notice}}</p>{{end}}
+			<div class="card">
+				<p><strong>User ID:</strong> {{.UserID}}</p>
+				<p><strong>Logged in since:</strong> {{.LoginTime}}</p>
+			</div>
+
+			<h2>Change Password</h2>
+			{{if .PasswordErrors}}
+			<div class="error">
+				<p>Your password was not changed:</p>
+				<ul>{{range .PasswordErrors}}<li>{{.}}</li>{{end}}</ul>
+			</div>
+			{{end}}
+			<div class="card">
+				<form method="POST" action="/user/password">
+					<div class="form-group">
+						<label for="current_password">Current Password:</label>
+						<input type="password" id="current_password" name="current_password" required>
+					</div>
+					<div class="form-group">
+						<label for="password">New Password:</label>
+						<input type="password" id="password" name="password" required>
+					</div>
+					<div class="form-group">
+						<label for="confirm_password">Confirm New Password:</label>
+						<input type="password" id="confirm_password" name="confirm_password" required>
+					</div>
+					{{if .Requirements}}
+					<p>Your new password must meet these requirements:</p>
+					<ul>{{range .Requirements}}<li>{{.}}</li>{{end}}</ul>
+					{{end}}
+					<button type="submit" class="btn">Change Password</button>
+				</form>
+			</div>
+
+			<h2>Active Sessions</h2>
+			<p>These are the browsers and devices currently signed in to your account. Revoke any session you don't recognize.</p>
+			<table class="data-table">
+				<thead>
+					<tr><th>Created</th><th>Last Seen</th><th>IP Address</th><th>User Agent</th><th>Actions</th></tr>
+				</thead>
+				<tbody>
+					{{range .Sessions}}
+					<tr>
+						<td>{{.CreatedAt.Format "2006-01-02 15:04:05"}}</td>
+						<td>{{.LastSeen.Format "2006-01-02 15:04:05"}}</td>
+						<td>{{.IPAddress}}</td>
+						<td>{{.UserAgent}}</td>
+						<td>
+							{{if eq .SessionID $.CurrentSessionID}}
+							<strong>This session</strong>
+							{{else}}
+							<form method="POST" action="/user/sessions/revoke" style="display: inline;">
+								<input type="hidden" name="session" value="{{.ID}}">
+								<button type="submit" class="btn btn-danger">Revoke</button>
+							</form>
+							{{end}}
+						</td>
+					</tr>
+					{{end}}
+				</tbody>
+			</table>
+
+			<form method="POST" action="/user/sessions/revoke-all" style="margin-top: 2rem;" data-confirm="This will sign you out on every device, including this one. Continue?">
+				<button type="submit" class="btn btn-danger">Log Out Everywhere</button>
+			</form>`
+
+	contentData := map[string]interface{}{
+		"Username":         session.Username,
+		"UserID":           session.UserID,
+		"LoginTime":        session.CreatedAt.Format("2006-01-02 15:04:05"),
+		"Sessions":         sessions,
+		"CurrentSessionID": session.SessionID,
+		"Notice":           notice,
+		"PasswordErrors":   passwordErrors,
+		"Requirements":     h.db.PasswordPolicy().Requirements(),
+	}
+
+	renderContentPageStatus(w, status, "User Profile - Sting Ray", "User Profile", userNavigation, contentTemplate, contentData)
+}
+
+// HandleChangePassword changes the current user's password from the profile page. The
+// password change signs out every session, so a fresh session is issued for this browser.
+func (h *AuthHandler) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		RenderMessage(w, "405 Method Not Allowed", "Method Not Allowed", "error", "Only POST is allowed for this endpoint.", "/user/profile", "Back to Profile", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal := h.sm.Principal(r)
+	if !principal.Authenticated() {
 		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 		return
 	}
+	session := principal.Session
 
-	var data struct {
-		Title       string
-		MetaDescription string
-		Header      string
-		HeaderClass string
-		Message     string
-		ButtonURL   string
-		ButtonText  string
-		Footer      string
-		Username    string
-		UserID      int
-		LoginTime   string
-	}
-	
-	data.Title = "User Profile - Sting Ray"
-	data.MetaDescription = "User profile page"
-	data.Header = "User Profile"
-	data.HeaderClass = "success"
-	data.Message = "Welcome to your profile page!"
-	data.ButtonURL = "/"
-	data.ButtonText = "Go Home"
-	data.Footer = "© 2025 StingRay"
-	data.Username = session.Username
-	data.UserID = session.UserID
-	data.LoginTime = session.CreatedAt.Format("2006-01-02 15:04:05")
+	password := r.FormValue("password")
+	if password == "" {
+		h.renderProfile(w, session, []string{"New password is required."}, "", http.StatusBadRequest)
+		return
+	}
+	if password != r.FormValue("confirm_password") {
+		h.renderProfile(w, session, []string{"New passwords do not match."}, "", http.StatusBadRequest)
+		return
+	}
+	if _, err := h.db.AuthenticateUser(session.Username, r.FormValue("current_password")); err != nil {
+		h.renderProfile(w, session, []string{"Current password is incorrect."}, "", http.StatusBadRequest)
+		return
+	}
 
-	tmplContent, err := templates.LoadTemplate("message")
+	if err := h.db.UpdateUserPassword(session.UserID, password); err != nil {
+		if policyErr, ok := auth.IsPolicyError(err); ok {
+			h.renderProfile(w, session, policyErr.Violations, "", http.StatusBadRequest)
+			return
+		}
+		database.LogSQLError(err)
+		h.renderProfile(w, session, []string{"Failed to update password. Please try again."}, "", http.StatusInternalServerError)
+		return
+	}
+
+	recordAuditEvent(h.db, h.sm, h.logger, r, models.AuditEvent{
+		EventType:    models.AuditPasswordChanged,
+		TargetUserID: session.UserID,
+		TargetName:   session.Username,
+	})
+
+	// A password change logs the user out everywhere; keep this browser signed in
+	if err := h.sm.store.InvalidateAllUserSessions(session.UserID); err != nil {
+		database.LogSQLError(err)
+	}
+	idleTimeout, absoluteLifetime := h.sm.policy.Lifetimes(session.RememberMe)
+	newSession, err := h.sm.store.Create(session.UserID, session.Username, idleTimeout, absoluteLifetime, session.RememberMe, ClientIP(r), r.UserAgent())
+	if err == nil {
+		err = h.sm.SetSessionCookie(w, newSession)
+	}
 	if err != nil {
-		http.Error(w, "Message template not found", http.StatusInternalServerError)
+		h.sm.ClearSessionCookie(w)
+		RenderMessage(w, "Password Changed", "Password Changed", "success", "Your password has been changed. Please log in again.", "/user/login", "Login", http.StatusOK)
 		return
 	}
-	tmpl, err := template.New("message").Parse(tmplContent)
+
+	http.Redirect(w, r, "/user/profile?password=changed", http.StatusSeeOther)
+}
+
+// HandleRevokeSession revokes one of the current user's other sessions
+func (h *AuthHandler) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		RenderMessage(w, "405 Method Not Allowed", "Method Not Allowed", "error", "Only POST is allowed for this endpoint.", "/user/profile", "Back to Profile", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal := h.sm.Principal(r)
+	if !principal.Authenticated() {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	session := principal.Session
+
+	id, err := strconv.Atoi(r.FormValue("session"))
 	if err != nil {
-		http.Error(w, "Error parsing message template", http.StatusInternalServerError)
+		RenderMessage(w, "Invalid Session", "Invalid Session", "error", "The session to revoke was not specified.", "/user/profile", "Back to Profile", http.StatusBadRequest)
 		return
 	}
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	tmpl.Execute(w, data)
-} 
\ No newline at end of file
+
+	if err := h.sm.store.InvalidateUserSession(session.UserID, id); err != nil {
+		database.LogSQLError(err)
+		RenderMessage(w, "Revoke Failed", "Revoke Failed", "error", "The session could not be revoked.", "/user/profile", "Back to Profile", http.StatusNotFound)
+		return
+	}
+
+	recordAuditEvent(h.db, h.sm, h.logger, r, models.AuditEvent{
+		EventType:    models.AuditSessionRevoked,
+		TargetUserID: session.UserID,
+		TargetName:   session.Username,
+		Details:      "session " + strconv.Itoa(id),
+	})
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// HandleRevokeAllSessions logs the current user out of every session, including this one
+func (h *AuthHandler) HandleRevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		RenderMessage(w, "405 Method Not Allowed", "Method Not Allowed", "error", "Only POST is allowed for this endpoint.", "/user/profile", "Back to Profile", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal := h.sm.Principal(r)
+	if !principal.Authenticated() {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	session := principal.Session
+
+	if err := h.sm.store.InvalidateAllUserSessions(session.UserID); err != nil {
+		database.LogSQLError(err)
+		RenderMessage(w, "Revoke Failed", "Revoke Failed", "error", "Your sessions could not be revoked. Please try again.", "/user/profile", "Back to Profile", http.StatusInternalServerError)
+		return
+	}
+
+	recordAuditEvent(h.db, nil, h.logger, r, models.AuditEvent{
+		EventType:    models.AuditSessionRevoked,
+		ActorID:      session.UserID,
+		ActorName:    session.Username,
+		TargetUserID: session.UserID,
+		TargetName:   session.Username,
+		Details:      "all sessions",
+	})
+	h.sm.ClearSessionCookie(w)
+	RenderMessage(w, "Logged Out Everywhere", "Logged Out Everywhere", "success", "All of your sessions have been signed out.", "/user/login", "Login", http.StatusOK)
+}
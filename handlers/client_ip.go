@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientIPKey is the request context key holding the resolved client IP address
+type clientIPKey struct{}
+
+// ClientIPResolver works out the address of the client behind any trusted reverse proxies.
+// Forwarding headers are only believed when the request arrives from a trusted proxy, and
+// are read right to left so a client cannot spoof its address by prepending entries.
+type ClientIPResolver struct {
+	trusted []*net.IPNet
+}
+
+// NewClientIPResolver creates a resolver trusting the comma-separated CIDRs or single IP
+// addresses in proxies. An empty list trusts no proxy, so the peer address is always used.
+func NewClientIPResolver(proxies string) (*ClientIPResolver, error) {
+	resolver := &ClientIPResolver{}
+	for _, entry := range strings.Split(proxies, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			if ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		resolver.trusted = append(resolver.trusted, network)
+	}
+	return resolver, nil
+}
+
+// Middleware resolves the client IP once and stores it in the request context for ClientIP
+func (c *ClientIPResolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPKey{}, c.Resolve(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Resolve returns the client IP address for a request. Starting from the peer, each hop
+// that is a trusted proxy is replaced by the address it says it forwarded for, until an
+// untrusted or malformed hop is reached.
+func (c *ClientIPResolver) Resolve(r *http.Request) string {
+	client := peerIP(r.RemoteAddr)
+	if !c.isTrusted(client) {
+		return client
+	}
+
+	hops := forwardedFor(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseHopIP(hops[i])
+		if hop == "" {
+			break
+		}
+		client = hop
+		if !c.isTrusted(hop) {
+			break
+		}
+	}
+	return client
+}
+
+func (c *ClientIPResolver) isTrusted(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range c.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the client IP address resolved by ClientIPResolver.Middleware, or the
+// peer address when the request did not pass through it
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return peerIP(r.RemoteAddr)
+}
+
+// peerIP strips the port from a RemoteAddr
+func peerIP(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+// forwardedFor returns the client chain from the Forwarded header, or from X-Forwarded-For
+// when there is no Forwarded header, ordered from the original client to the nearest proxy
+func forwardedFor(header http.Header) []string {
+	var hops []string
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		for _, element := range strings.Split(strings.Join(values, ","), ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hop = strings.Trim(value, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+		return hops
+	}
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// parseHopIP returns the IP address of a forwarding hop, which may carry a port or be an
+// IPv6 address in brackets, or "" when the hop is obfuscated, unknown or malformed
+func parseHopIP(hop string) string {
+	hop = strings.TrimSpace(hop)
+	if strings.HasPrefix(hop, "[") {
+		end := strings.Index(hop, "]")
+		if end < 0 {
+			return ""
+		}
+		hop = hop[1:end]
+	} else if strings.Count(hop, ":") == 1 {
+		hop = hop[:strings.Index(hop, ":")]
+	}
+	ip := net.ParseIP(hop)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
@@ -1,12 +1,19 @@
 package handlers
 
 import (
+	"fmt"
 	"html/template"
 	"net/http"
+	"strings"
 	"stingray/templates"
 	"stingray/config"
+	"stingray/email"
+	"stingray/logging"
 )
 
+// userNavigation is the navigation bar shown on account and admin pages
+const userNavigation = `<a href="/">Home</a> | <a href="/page/about">About</a> | <a href="/user/profile">Profile</a> | <a href="/user/logout">Logout</a>`
+
 // ReloadEnvConfig reloads the .env file and updates the config pointer
 func ReloadEnvConfig(cfg *config.Config) (success bool, errMsg string) {
 	newCfg := config.LoadConfig()
@@ -51,4 +58,66 @@ func RenderMessage(w http.ResponseWriter, title, header, headerClass, message, b
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(status)
 	tmpl.Execute(w, data)
-} 
\ No newline at end of file
+} 
+
+// renderContentPage executes a content template and renders the result inside the metadata layout
+func renderContentPage(w http.ResponseWriter, title, header, navigation, contentTemplate string, contentData interface{}) {
+	renderContentPageStatus(w, http.StatusOK, title, header, navigation, contentTemplate, contentData)
+}
+
+// renderContentPageStatus is renderContentPage with an explicit HTTP status code
+func renderContentPageStatus(w http.ResponseWriter, status int, title, header, navigation, contentTemplate string, contentData interface{}) {
+	contentTmpl, err := template.New("content").Parse(contentTemplate)
+	if err != nil {
+		http.Error(w, "Error parsing content template", http.StatusInternalServerError)
+		return
+	}
+
+	var contentBuffer strings.Builder
+	if err := contentTmpl.Execute(&contentBuffer, contentData); err != nil {
+		http.Error(w, "Error executing content template", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Title":           title,
+		"MetaDescription": header,
+		"Header":          header,
+		"Navigation":      template.HTML(navigation),
+		"MainContent":     template.HTML(contentBuffer.String()),
+		"Sidebar":         "",
+		"Footer":          "© 2025 StingRay",
+		"CSSClass":        "metadata",
+		"Scripts":         "",
+	}
+
+	html, err := templates.RenderMetadataPage(data)
+	if err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(html))
+}
+
+// newEmailService creates the email service from configuration, returning nil when it
+// cannot be initialized so callers can fall back to showing links directly
+func newEmailService(cfg *config.Config, logger *logging.Logger) *email.EmailService {
+	emailService, err := email.NewEmailService(
+		cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword,
+		cfg.FromEmail, cfg.FromName, cfg.DKIMPrivateKeyFile, cfg.DKIMSelector, cfg.DKIMDomain,
+	)
+	if err != nil {
+		// Log error but continue without email service
+		if logger != nil {
+			logger.LogError("Failed to initialize email service: %v", err)
+		} else {
+			fmt.Printf("Warning: Failed to initialize email service: %v\n", err)
+		}
+		return nil
+	}
+	return emailService
+}
+
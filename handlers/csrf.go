@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"regexp"
+	"stingray/logging"
+)
+
+const (
+	CSRFCookieName = "stingray_csrf"
+	CSRFFormField  = "csrf_token"
+	CSRFHeaderName = "X-CSRF-Token"
+)
+
+type csrfContextKey struct{}
+
+// CSRFMiddleware protects state-changing requests using the double-submit cookie pattern.
+// A random token is stored in a cookie and must be echoed back in the csrf_token form
+// field or the X-CSRF-Token header on every POST, PUT, PATCH and DELETE request.
+type CSRFMiddleware struct {
+	logger        *logging.Logger
+	secureCookies bool // Set the Secure flag on the token cookie
+}
+
+// NewCSRFMiddleware creates a new CSRF middleware. The token cookie gets the Secure flag
+// when secureCookies is set.
+func NewCSRFMiddleware(logger *logging.Logger, secureCookies bool) *CSRFMiddleware {
+	return &CSRFMiddleware{logger: logger, secureCookies: secureCookies}
+}
+
+// Protect verifies the CSRF token on unsafe requests and injects the token into every
+// POST form of HTML responses. Other responses are passed through unbuffered.
+func (m *CSRFMiddleware) Protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		if cookie, err := r.Cookie(CSRFCookieName); err == nil && len(cookie.Value) == 64 {
+			token = cookie.Value
+		}
+		if token == "" {
+			newToken, err := generateCSRFToken()
+			if err != nil {
+				http.Error(w, "Failed to generate CSRF token", http.StatusInternalServerError)
+				return
+			}
+			token = newToken
+			http.SetCookie(w, &http.Cookie{
+				Name:     CSRFCookieName,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: false, // Readable by scripts so API clients can send the header
+				Secure:   m.secureCookies,
+				SameSite: http.SameSiteStrictMode,
+			})
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), csrfContextKey{}, token))
+
+		if !isSafeMethod(r.Method) && !validCSRFToken(r, token) {
+			if m.logger != nil {
+				m.logger.LogError("CSRF validation failed for %s %s", r.Method, r.URL.Path)
+			}
+			RenderMessage(w, "403 Forbidden", "Request Rejected", "error",
+				"The form you submitted has expired or is invalid. Please go back, reload the page and try again.", "/", "Go Home", http.StatusForbidden)
+			return
+		}
+
+		cw := newHTMLRewriter(w, func(html []byte) []byte {
+			return InjectCSRFField(html, token)
+		})
+		next.ServeHTTP(cw, r)
+		cw.finish()
+	})
+}
+
+// CSRFToken returns the CSRF token associated with the request
+func CSRFToken(r *http.Request) string {
+	if token, ok := r.Context().Value(csrfContextKey{}).(string); ok {
+		return token
+	}
+	return ""
+}
+
+// CSRFField returns a hidden form input carrying the request's CSRF token
+func CSRFField(r *http.Request) template.HTML {
+	return template.HTML(csrfInput(CSRFToken(r)))
+}
+
+var postFormPattern = regexp.MustCompile(`(?is)<form\b[^>]*>`)
+var postMethodPattern = regexp.MustCompile(`(?i)\bmethod\s*=\s*["']?post\b`)
+
+// InjectCSRFField adds a hidden csrf_token input to every POST form in the given HTML
+func InjectCSRFField(html []byte, token string) []byte {
+	if token == "" || !bytes.Contains(bytes.ToLower(html), []byte("<form")) {
+		return html
+	}
+	input := []byte(csrfInput(token))
+	return postFormPattern.ReplaceAllFunc(html, func(tag []byte) []byte {
+		if !postMethodPattern.Match(tag) {
+			return tag
+		}
+		result := make([]byte, 0, len(tag)+len(input))
+		result = append(result, tag...)
+		return append(result, input...)
+	})
+}
+
+func csrfInput(token string) string {
+	return `<input type="hidden" name="` + CSRFFormField + `" value="` + template.HTMLEscapeString(token) + `">`
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+func validCSRFToken(r *http.Request, token string) bool {
+	submitted := r.Header.Get(CSRFHeaderName)
+	if submitted == "" {
+		submitted = r.FormValue(CSRFFormField)
+	}
+	if submitted == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) == 1
+}
+
+func generateCSRFToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
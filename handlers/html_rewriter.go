@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+// htmlRewriter lets middleware change HTML pages before they are sent. text/html responses
+// are buffered and passed through rewrite when the handler returns; every other response,
+// such as assets and JSON, goes straight to the client. Responses without a Content-Type
+// are sniffed from their first write, as net/http would.
+type htmlRewriter struct {
+	http.ResponseWriter
+	rewrite   func(html []byte) []byte
+	status    int  // Status code held until the content type is known
+	decided   bool // Whether the content type has been checked
+	buffering bool // Whether the response is HTML held in buf
+	buf       bytes.Buffer
+}
+
+// newHTMLRewriter wraps w so HTML responses are passed through rewrite. Call finish once
+// the handler has returned.
+func newHTMLRewriter(w http.ResponseWriter, rewrite func(html []byte) []byte) *htmlRewriter {
+	return &htmlRewriter{ResponseWriter: w, rewrite: rewrite}
+}
+
+func (hw *htmlRewriter) WriteHeader(code int) {
+	if hw.decided || hw.status != 0 {
+		return
+	}
+	if code < 200 {
+		// Informational responses precede the real one
+		hw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	hw.status = code
+	if hw.Header().Get("Content-Type") != "" {
+		hw.decide(nil)
+	}
+}
+
+func (hw *htmlRewriter) Write(b []byte) (int, error) {
+	if !hw.decided {
+		hw.decide(b)
+	}
+	if hw.buffering {
+		return hw.buf.Write(b)
+	}
+	return hw.ResponseWriter.Write(b)
+}
+
+// Flush sends what has been written so far. HTML buffered until then is rewritten and
+// sent, and the rest of the page is passed through unchanged.
+func (hw *htmlRewriter) Flush() {
+	if !hw.decided {
+		hw.decide(nil)
+	}
+	if hw.buffering {
+		hw.sendBuffered()
+	}
+	if f, ok := hw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (hw *htmlRewriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+// decide checks whether the response is HTML to buffer, sniffing the first write when no
+// Content-Type was set. Other responses get their status code sent straight away.
+func (hw *htmlRewriter) decide(first []byte) {
+	hw.decided = true
+	if hw.status == 0 {
+		hw.status = http.StatusOK
+	}
+	header := hw.Header()
+	contentType := header.Get("Content-Type")
+	if contentType == "" && len(first) > 0 && header.Get("Content-Encoding") == "" {
+		contentType = http.DetectContentType(first)
+		header.Set("Content-Type", contentType)
+	}
+	hw.buffering = strings.HasPrefix(contentType, "text/html") && header.Get("Content-Encoding") == ""
+	if !hw.buffering {
+		hw.ResponseWriter.WriteHeader(hw.status)
+	}
+}
+
+// sendBuffered rewrites and sends the buffered HTML, passing later writes through
+func (hw *htmlRewriter) sendBuffered() {
+	hw.buffering = false
+	hw.Header().Del("Content-Length")
+	hw.ResponseWriter.WriteHeader(hw.status)
+	hw.ResponseWriter.Write(hw.rewrite(hw.buf.Bytes()))
+	hw.buf.Reset()
+}
+
+// finish sends a buffered HTML page, or the status code of a response with no body
+func (hw *htmlRewriter) finish() {
+	if !hw.decided {
+		hw.decide(nil)
+	}
+	if hw.buffering {
+		hw.sendBuffered()
+	}
+}
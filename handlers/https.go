@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HSTS adds a Strict-Transport-Security header to every response so browsers only reach
+// the site over HTTPS for maxAge. It is only installed when the server terminates TLS.
+func HSTS(maxAge time.Duration, includeSubdomains bool, next http.Handler) http.Handler {
+	value := "max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+	if includeSubdomains {
+		value += "; includeSubDomains"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RedirectToHTTPS answers every plain HTTP request with a permanent redirect to the same
+// path over HTTPS. When publicBaseURL is an https:// URL the redirect goes there, so the
+// target never depends on the client-controlled Host header; otherwise it goes to the
+// request's host on the HTTPS port.
+func RedirectToHTTPS(httpsPort, publicBaseURL string) http.Handler {
+	base := strings.TrimRight(publicBaseURL, "/")
+	if !strings.HasPrefix(strings.ToLower(base), "https://") {
+		base = ""
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if base != "" {
+			http.Redirect(w, r, base+r.URL.RequestURI(), http.StatusMovedPermanently)
+			return
+		}
+
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil {
+			host = "[" + host + "]"
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
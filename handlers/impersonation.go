@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"regexp"
+	"stingray/database"
+	"stingray/logging"
+	"stingray/models"
+	"strconv"
+	"time"
+)
+
+// ImpersonationDuration caps how long an administrator can act as another user
+const ImpersonationDuration = 1 * time.Hour
+
+// ImpersonationHandler lets administrators sign in as another user to see what they see
+type ImpersonationHandler struct {
+	db     *database.Database
+	sm     *SessionMiddleware
+	logger *logging.Logger
+}
+
+// NewImpersonationHandler creates a new impersonation handler
+func NewImpersonationHandler(db *database.Database, sm *SessionMiddleware, logger *logging.Logger) *ImpersonationHandler {
+	return &ImpersonationHandler{
+		db:     db,
+		sm:     sm,
+		logger: logger,
+	}
+}
+
+// HandleImpersonation lists users with a button to log in as each of them
+func (h *ImpersonationHandler) HandleImpersonation(w http.ResponseWriter, r *http.Request) {
+	h.renderUsers(w, r, "", http.StatusOK)
+}
+
+// HandleStartImpersonation replaces the administrator's session with a marked session as
+// the chosen user. The administrator's own session ends and is recreated on return.
+func (h *ImpersonationHandler) HandleStartImpersonation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		RenderMessage(w, "405 Method Not Allowed", "Method Not Allowed", "error", "Only POST is allowed for this endpoint.", "/admin/impersonate", "Back to Users", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal := h.sm.Principal(r)
+	if !principal.Authenticated() {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	session := principal.Session
+	if session.IsImpersonated() {
+		h.renderUsers(w, r, "Return to your own account before impersonating someone else.", http.StatusConflict)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil {
+		h.renderUsers(w, r, "Invalid user_id parameter.", http.StatusBadRequest)
+		return
+	}
+	if userID == session.UserID {
+		h.renderUsers(w, r, "You cannot impersonate yourself.", http.StatusBadRequest)
+		return
+	}
+	target, err := h.db.GetUserByID(userID)
+	if err != nil {
+		h.renderUsers(w, r, "User not found.", http.StatusNotFound)
+		return
+	}
+	if target.Status != models.UserStatusActive {
+		h.renderUsers(w, r, "Only active users can be impersonated.", http.StatusBadRequest)
+		return
+	}
+	if h.managesUsers(target.ID) {
+		h.renderUsers(w, r, "Users who can manage users cannot be impersonated.", http.StatusForbidden)
+		return
+	}
+
+	idleTimeout, _ := h.sm.policy.Lifetimes(false)
+	if idleTimeout > ImpersonationDuration {
+		idleTimeout = ImpersonationDuration
+	}
+	impersonation, err := h.db.CreateImpersonationSession(target.ID, target.Username, session.UserID,
+		idleTimeout, ImpersonationDuration, ClientIP(r), r.UserAgent())
+	if err != nil {
+		database.LogSQLError(err)
+		h.renderUsers(w, r, "The impersonation session could not be created.", http.StatusInternalServerError)
+		return
+	}
+
+	h.sm.store.Invalidate(session)
+	if err := h.sm.SetSessionCookie(w, impersonation); err != nil {
+		h.renderUsers(w, r, "The impersonation session could not be started.", http.StatusInternalServerError)
+		return
+	}
+
+	h.recordAudit(r, models.AuditImpersonationStart, session.UserID, session.Username, target.ID, target.Username, "")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// HandleStopImpersonation ends an impersonation session and signs the administrator back
+// in to their own account
+func (h *ImpersonationHandler) HandleStopImpersonation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		RenderMessage(w, "405 Method Not Allowed", "Method Not Allowed", "error", "Only POST is allowed for this endpoint.", "/", "Go Home", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal := h.sm.Principal(r)
+	if !principal.Authenticated() {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	session := principal.Session
+	if !session.IsImpersonated() {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	h.sm.store.Invalidate(session)
+	h.sm.ClearSessionCookie(w)
+
+	admin, err := h.db.GetUserByID(session.ImpersonatorID)
+	if err != nil || admin.Status != models.UserStatusActive {
+		h.recordAudit(r, models.AuditImpersonationStop, session.ImpersonatorID, "", session.UserID, session.Username, "administrator account unavailable")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	idleTimeout, absoluteLifetime := h.sm.policy.Lifetimes(false)
+	adminSession, err := h.sm.store.Create(admin.ID, admin.Username, idleTimeout, absoluteLifetime, false, ClientIP(r), r.UserAgent())
+	if err != nil {
+		database.LogSQLError(err)
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	h.sm.SetSessionCookie(w, adminSession)
+
+	h.recordAudit(r, models.AuditImpersonationStop, admin.ID, admin.Username, session.UserID, session.Username, "")
+	http.Redirect(w, r, "/admin/impersonate", http.StatusSeeOther)
+}
+
+// managesUsers reports whether a user holds users.manage. Lookup errors count as holding
+// it, so such users are never impersonated.
+func (h *ImpersonationHandler) managesUsers(userID int) bool {
+	access, err := h.db.LoadAccess(userID)
+	if err != nil {
+		return true
+	}
+	allowed, err := h.db.Can(access, models.PermissionUsersManage)
+	return err != nil || allowed
+}
+
+// recordAudit writes an impersonation event to the audit log
+func (h *ImpersonationHandler) recordAudit(r *http.Request, eventType string, actorID int, actorName string, targetID int, targetName, details string) {
+	recordAuditEvent(h.db, nil, h.logger, r, models.AuditEvent{
+		EventType:    eventType,
+		ActorID:      actorID,
+		ActorName:    actorName,
+		TargetUserID: targetID,
+		TargetName:   targetName,
+		Details:      details,
+	})
+}
+
+// renderUsers shows every user with a button to log in as them
+func (h *ImpersonationHandler) renderUsers(w http.ResponseWriter, r *http.Request, errorMessage string, status int) {
+	users, err := h.db.GetAllUsers()
+	if err != nil {
+		database.LogSQLError(err)
+		http.Error(w, "Error fetching users", http.StatusInternalServerError)
+		return
+	}
+
+	currentUserID := h.sm.Principal(r).UserID()
+
+	type userRow struct {
+		models.User
+		Reason string // Why the user cannot be impersonated, if they cannot
+	}
+	var rows []userRow
+	for _, user := range users {
+		row := userRow{User: user}
+		switch {
+		case user.ID == currentUserID:
+			row.Reason = "This is you"
+		case user.Status != models.UserStatusActive:
+			row.Reason = "Not active"
+		default:
+			if h.managesUsers(user.ID) {
+				row.Reason = "Administrator"
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	contentTemplate := `<h1>Log In As User</h1>
+			{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+			<p>Impersonating a user shows the site exactly as they see it for up to {{.Duration}}. Changing passwords, revoking sessions and saving configuration are blocked while impersonating, and every start and stop is recorded in the audit log.</p>
+			<table class="data-table">
+				<thead>
+					<tr><th>Username</th><th>Email</th><th>Status</th><th>Actions</th></tr>
+				</thead>
+				<tbody>
+					{{range .Users}}
+					<tr>
+						<td>{{.Username}}</td>
+						<td>{{.Email}}</td>
+						<td>{{.Status}}</td>
+						<td>
+							{{if .Reason}}
+							<em>{{.Reason}}</em>
+							{{else}}
+							<form method="POST" action="/admin/impersonate/start" style="display: inline;">
+								<input type="hidden" name="user_id" value="{{.ID}}">
+								<button type="submit" class="btn">Log in as {{.Username}}</button>
+							</form>
+							{{end}}
+						</td>
+					</tr>
+					{{end}}
+				</tbody>
+			</table>`
+
+	contentData := map[string]interface{}{
+		"Error":    errorMessage,
+		"Users":    rows,
+		"Duration": ImpersonationDuration.String(),
+	}
+
+	renderContentPageStatus(w, status, "Log In As User - Sting Ray", "Log In As User", adminNavigation, contentTemplate, contentData)
+}
+
+// IsImpersonating reports whether the request belongs to an impersonation session
+func (m *SessionMiddleware) IsImpersonating(r *http.Request) bool {
+	principal := m.Principal(r)
+	return principal.Authenticated() && principal.Session.IsImpersonated()
+}
+
+// BlockWhileImpersonating rejects state-changing requests made from an impersonation
+// session, so administrators cannot change a user's credentials or settings as them
+func (m *SessionMiddleware) BlockWhileImpersonating(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isSafeMethod(r.Method) && m.IsImpersonating(r) {
+			RenderMessage(w, "Not Allowed", "Not Allowed While Impersonating", "error",
+				"This action is disabled while you are logged in as another user. Return to your account first.", "/", "Go Home", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// ImpersonationBanner adds a banner with a "return to my account" button to every HTML
+// page served from an impersonation session
+func (m *SessionMiddleware) ImpersonationBanner(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal := m.Principal(r)
+		if !principal.Authenticated() || !principal.Session.IsImpersonated() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		username := principal.Username()
+		bw := newHTMLRewriter(w, func(html []byte) []byte {
+			return InjectImpersonationBanner(html, username)
+		})
+		next.ServeHTTP(bw, r)
+		bw.finish()
+	})
+}
+
+var bodyTagPattern = regexp.MustCompile(`(?is)<body\b[^>]*>`)
+
+// InjectImpersonationBanner inserts the impersonation banner right after the opening body tag
+func InjectImpersonationBanner(html []byte, username string) []byte {
+	loc := bodyTagPattern.FindIndex(html)
+	if loc == nil {
+		return html
+	}
+	banner := []byte(impersonationBanner(username))
+	result := make([]byte, 0, len(html)+len(banner))
+	result = append(result, html[:loc[1]]...)
+	result = append(result, banner...)
+	return append(result, html[loc[1]:]...)
+}
+
+func impersonationBanner(username string) string {
+	return `<div class="impersonation-banner" style="position: sticky; top: 0; z-index: 1000; background: #fff3cd; color: #664d03; border-bottom: 1px solid #ffecb5; padding: 0.5rem 1rem; text-align: center;">` +
+		`You are logged in as <strong>` + template.HTMLEscapeString(username) + `</strong>. ` +
+		`<form method="POST" action="/user/impersonation/stop" style="display: inline;"><button type="submit" class="btn">Return to my account</button></form>` +
+		`</div>`
+}
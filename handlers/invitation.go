@@ -0,0 +1,441 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"stingray/auth"
+	"stingray/config"
+	"stingray/database"
+	"stingray/email"
+	"stingray/logging"
+	"stingray/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InvitationDuration is how long an invitation link stays valid
+const InvitationDuration = 7 * 24 * time.Hour
+
+// InvitationHandler lets administrators invite people to create accounts
+type InvitationHandler struct {
+	db     *database.Database
+	cfg    *config.Config
+	email  *email.EmailService
+	logger *logging.Logger
+	sm     *SessionMiddleware
+}
+
+// NewInvitationHandler creates a new invitation handler
+func NewInvitationHandler(db *database.Database, sm *SessionMiddleware, cfg *config.Config, logger *logging.Logger) *InvitationHandler {
+	return &InvitationHandler{
+		db:     db,
+		cfg:    cfg,
+		email:  newEmailService(cfg, logger),
+		logger: logger,
+		sm:     sm,
+	}
+}
+
+// HandleInvitations lists open invitations and shows the form for sending a new one
+func (h *InvitationHandler) HandleInvitations(w http.ResponseWriter, r *http.Request) {
+	h.renderInvitations(w, r, "", "", nil, http.StatusOK)
+}
+
+// HandleCreateInvitation creates an invitation and emails the link to the invitee
+func (h *InvitationHandler) HandleCreateInvitation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		RenderMessage(w, "405 Method Not Allowed", "Method Not Allowed", "error",
+			"Only POST is allowed for this endpoint.", "/admin/invitations", "Back to Invitations", http.StatusMethodNotAllowed)
+		return
+	}
+
+	principal := h.sm.Principal(r)
+	if !principal.Authenticated() {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	session := principal.Session
+
+	r.ParseForm()
+	emailAddress := strings.TrimSpace(r.FormValue("email"))
+	groupNames := r.Form["groups"]
+
+	if _, err := mail.ParseAddress(emailAddress); err != nil {
+		h.renderInvitations(w, r, "Please enter a valid email address.", emailAddress, groupNames, http.StatusBadRequest)
+		return
+	}
+
+	validGroups, err := h.db.GetAllGroups()
+	if err != nil {
+		database.LogSQLError(err)
+		http.Error(w, "Error fetching groups", http.StatusInternalServerError)
+		return
+	}
+	for _, groupName := range groupNames {
+		if !groupExists(validGroups, groupName) {
+			h.renderInvitations(w, r, fmt.Sprintf("Unknown group: %s", groupName), emailAddress, groupNames, http.StatusBadRequest)
+			return
+		}
+	}
+
+	exists, err := h.db.UserExists("", emailAddress)
+	if err != nil {
+		database.LogSQLError(err)
+		http.Error(w, "Error checking existing users", http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		h.renderInvitations(w, r, "An account with that email address already exists.", emailAddress, groupNames, http.StatusConflict)
+		return
+	}
+
+	open, err := h.db.HasOpenInvitation(emailAddress)
+	if err != nil {
+		database.LogSQLError(err)
+		http.Error(w, "Error checking invitations", http.StatusInternalServerError)
+		return
+	}
+	if open {
+		h.renderInvitations(w, r, "That email address already has a pending invitation. Resend it from the list below.", emailAddress, groupNames, http.StatusConflict)
+		return
+	}
+
+	token, err := generateResetToken()
+	if err != nil {
+		http.Error(w, "Failed to generate invitation token", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := h.db.CreateInvitation(emailAddress, groupNames, session.UserID, token, time.Now().Add(InvitationDuration)); err != nil {
+		database.LogSQLError(err)
+		http.Error(w, "Failed to create invitation", http.StatusInternalServerError)
+		return
+	}
+
+	h.sendInvitation(w, r, emailAddress, token, session.Username)
+}
+
+// HandleResendInvitation issues a fresh link for an open invitation and emails it again.
+// The previous link stops working.
+func (h *InvitationHandler) HandleResendInvitation(w http.ResponseWriter, r *http.Request) {
+	invitation, ok := h.invitationFromForm(w, r)
+	if !ok {
+		return
+	}
+
+	token, err := generateResetToken()
+	if err != nil {
+		http.Error(w, "Failed to generate invitation token", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.db.RenewInvitation(invitation.ID, token, time.Now().Add(InvitationDuration)); err != nil {
+		database.LogSQLError(err)
+		RenderMessage(w, "Resend Failed", "Resend Failed", "error",
+			"The invitation could not be resent.", "/admin/invitations", "Back to Invitations", http.StatusInternalServerError)
+		return
+	}
+
+	invitedBy := invitation.InvitedByName
+	if principal := h.sm.Principal(r); principal.Authenticated() {
+		invitedBy = principal.Username()
+	}
+	h.sendInvitation(w, r, invitation.Email, token, invitedBy)
+}
+
+// HandleRevokeInvitation cancels an open invitation
+func (h *InvitationHandler) HandleRevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	invitation, ok := h.invitationFromForm(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.db.RevokeInvitation(invitation.ID); err != nil {
+		database.LogSQLError(err)
+		RenderMessage(w, "Revoke Failed", "Revoke Failed", "error",
+			"The invitation could not be revoked.", "/admin/invitations", "Back to Invitations", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/invitations", http.StatusSeeOther)
+}
+
+// HandleAcceptInvitation shows the account form for an invitation link and creates the account
+func (h *InvitationHandler) HandleAcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	invitation, err := h.db.GetInvitationByToken(token)
+	if token == "" || err != nil || !invitation.IsPending() {
+		RenderMessage(w, "Invalid Invitation", "Invalid Invitation", "error",
+			"This invitation link is invalid, has expired or has already been used. Ask an administrator to send a new one.",
+			"/", "Go Home", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == "GET" {
+		h.renderAcceptForm(w, invitation, token, "", "", http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		RenderMessage(w, "405 Method Not Allowed", "Method Not Allowed", "error",
+			"Only POST is allowed for this endpoint.", "/", "Go Home", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := strings.TrimSpace(r.FormValue("username"))
+	password := r.FormValue("password")
+	confirmPassword := r.FormValue("confirm_password")
+
+	if username == "" || password == "" {
+		h.renderAcceptForm(w, invitation, token, "Username and password are required.", username, http.StatusBadRequest)
+		return
+	}
+	if password != confirmPassword {
+		h.renderAcceptForm(w, invitation, token, "Passwords do not match.", username, http.StatusBadRequest)
+		return
+	}
+
+	usernameTaken, emailTaken, err := h.db.UserConflicts(username, invitation.Email, 0)
+	if err != nil {
+		database.LogSQLError(err)
+		h.renderAcceptForm(w, invitation, token, "Account creation failed. Please try again.", username, http.StatusInternalServerError)
+		return
+	}
+	// Another username would not help when the invited address already has an account
+	if emailTaken {
+		RenderMessage(w, "Account Already Exists", "Account Already Exists", "error",
+			"An account with the invited email address already exists. Log in to it, or reset its password if you have forgotten it.",
+			"/user/login", "Login", http.StatusConflict)
+		return
+	}
+	if usernameTaken {
+		h.renderAcceptForm(w, invitation, token, "That username is already taken.", username, http.StatusConflict)
+		return
+	}
+
+	_, err = h.db.AcceptInvitation(token, username, password)
+	if policyErr, ok := auth.IsPolicyError(err); ok {
+		h.renderAcceptForm(w, invitation, token, policyErr.Error(), username, http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, database.ErrInvitationInvalid) {
+		RenderMessage(w, "Invalid Invitation", "Invalid Invitation", "error",
+			"This invitation has expired or has already been used. Ask an administrator to send a new one.",
+			"/", "Go Home", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, database.ErrGroupMissing) {
+		h.logger.LogError("Invitation %d names a group that no longer exists: %v", invitation.ID, err)
+		RenderMessage(w, "Invalid Invitation", "Invalid Invitation", "error",
+			"This invitation refers to a group that no longer exists. Ask an administrator to send a new one.",
+			"/", "Go Home", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		database.LogSQLError(err)
+		h.renderAcceptForm(w, invitation, token, "Account creation failed. Please try again.", username, http.StatusInternalServerError)
+		return
+	}
+
+	RenderMessage(w, "Account Created", "Account Created", "success",
+		"Your account has been created. You can now log in.", "/user/login", "Login", http.StatusOK)
+}
+
+// sendInvitation emails the invitation link, or shows it to the administrator when email is unavailable
+func (h *InvitationHandler) sendInvitation(w http.ResponseWriter, r *http.Request, emailAddress, token, invitedBy string) {
+	inviteURL := h.cfg.PublicURL("/user/accept-invite?token=" + token)
+	if h.email == nil && !h.cfg.DevShowTokenLinks {
+		RenderMessage(w, "Email Error", "Email Error", "error",
+			"The invitation was saved but email is not configured, so it could not be sent. Configure SMTP and resend it from the invitations list.",
+			"/admin/invitations", "Back to Invitations", http.StatusServiceUnavailable)
+		return
+	}
+	if h.email == nil {
+		// Development fallback for when email service is not available
+		RenderMessage(w, "Invitation Created", "Invitation Created", "success",
+			fmt.Sprintf("Email is not configured, so share this invitation link with %s yourself: %s", emailAddress, inviteURL),
+			"/admin/invitations", "Back to Invitations", http.StatusOK)
+		return
+	}
+
+	if err := h.email.SendInvitationEmail(emailAddress, inviteURL, invitedBy); err != nil {
+		h.logger.LogError("Failed to send invitation email: %v", err)
+		RenderMessage(w, "Email Error", "Email Error", "error",
+			"The invitation was saved but the email could not be sent. Try resending it from the invitations list.",
+			"/admin/invitations", "Back to Invitations", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/invitations?sent="+url.QueryEscape(emailAddress), http.StatusSeeOther)
+}
+
+// invitationFromForm loads the open invitation named by the posted invitation_id, rendering
+// an error and returning false unless the request is a POST for an invitation that can still change
+func (h *InvitationHandler) invitationFromForm(w http.ResponseWriter, r *http.Request) (*models.Invitation, bool) {
+	if r.Method != "POST" {
+		RenderMessage(w, "405 Method Not Allowed", "Method Not Allowed", "error",
+			"Only POST is allowed for this endpoint.", "/admin/invitations", "Back to Invitations", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	id, err := strconv.Atoi(r.FormValue("invitation_id"))
+	if err != nil {
+		RenderMessage(w, "Invalid Invitation", "Invalid Invitation", "error",
+			"Invalid invitation_id parameter.", "/admin/invitations", "Back to Invitations", http.StatusBadRequest)
+		return nil, false
+	}
+
+	invitation, err := h.db.GetInvitation(id)
+	if err != nil || invitation.Accepted || invitation.Revoked {
+		RenderMessage(w, "Invitation Not Found", "Invitation Not Found", "error",
+			"That invitation has already been accepted or revoked.", "/admin/invitations", "Back to Invitations", http.StatusNotFound)
+		return nil, false
+	}
+	return invitation, true
+}
+
+// renderInvitations shows the invitation form and the list of open invitations
+func (h *InvitationHandler) renderInvitations(w http.ResponseWriter, r *http.Request, errorMessage, emailAddress string, selectedGroups []string, status int) {
+	invitations, err := h.db.GetOpenInvitations()
+	if err != nil {
+		database.LogSQLError(err)
+		http.Error(w, "Error fetching invitations", http.StatusInternalServerError)
+		return
+	}
+	groups, err := h.db.GetAllGroups()
+	if err != nil {
+		database.LogSQLError(err)
+		http.Error(w, "Error fetching groups", http.StatusInternalServerError)
+		return
+	}
+
+	type groupOption struct {
+		Name     string
+		Selected bool
+	}
+	var groupOptions []groupOption
+	for _, group := range groups {
+		if group.Name == "everyone" {
+			continue
+		}
+		selected := false
+		for _, name := range selectedGroups {
+			if name == group.Name {
+				selected = true
+			}
+		}
+		groupOptions = append(groupOptions, groupOption{Name: group.Name, Selected: selected})
+	}
+
+	contentTemplate := `<h1>Invitations</h1>
+			{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+			{{if .Sent}}<p class="success">Invitation sent to {{.Sent}}.</p>{{end}}
+			<div class="card">
+				<h2>Invite a User</h2>
+				<form method="POST" action="/admin/invitations/create">
+					<div class="form-group">
+						<label for="email">Email Address:</label>
+						<input type="email" id="email" name="email" value="{{.Email}}" required>
+					</div>
+					<div class="form-group">
+						<label>Groups:</label>
+						{{range .Groups}}
+						<label><input type="checkbox" name="groups" value="{{.Name}}"{{if .Selected}} checked{{end}}> {{.Name}}</label>
+						{{end}}
+					</div>
+					<button type="submit" class="btn">Send Invitation</button>
+				</form>
+			</div>
+			<h2>Pending Invitations</h2>
+			<table class="data-table">
+				<thead>
+					<tr><th>Email</th><th>Groups</th><th>Invited By</th><th>Sent</th><th>Expires</th><th>Actions</th></tr>
+				</thead>
+				<tbody>
+					{{range .Invitations}}
+					<tr>
+						<td>{{.Email}}</td>
+						<td>{{range $i, $g := .Groups}}{{if $i}}, {{end}}{{$g}}{{end}}</td>
+						<td>{{.InvitedByName}}</td>
+						<td>{{.UpdatedAt.Format "2006-01-02 15:04:05"}}</td>
+						<td>{{if .IsPending}}{{.ExpiresAt.Format "2006-01-02 15:04:05"}}{{else}}<strong>Expired</strong>{{end}}</td>
+						<td>
+							<form method="POST" action="/admin/invitations/resend" style="display: inline;">
+								<input type="hidden" name="invitation_id" value="{{.ID}}">
+								<button type="submit" class="btn">Resend</button>
+							</form>
+							<form method="POST" action="/admin/invitations/revoke" style="display: inline;" data-confirm="Revoke this invitation?">
+								<input type="hidden" name="invitation_id" value="{{.ID}}">
+								<button type="submit" class="btn btn-danger">Revoke</button>
+							</form>
+						</td>
+					</tr>
+					{{else}}
+					<tr><td colspan="6">No pending invitations.</td></tr>
+					{{end}}
+				</tbody>
+			</table>`
+
+	contentData := map[string]interface{}{
+		"Error":       errorMessage,
+		"Sent":        r.URL.Query().Get("sent"),
+		"Email":       emailAddress,
+		"Groups":      groupOptions,
+		"Invitations": invitations,
+	}
+
+	renderContentPageStatus(w, status, "Invitations - Sting Ray", "Invitations", adminNavigation, contentTemplate, contentData)
+}
+
+// renderAcceptForm shows the form an invitee uses to choose a username and password
+func (h *InvitationHandler) renderAcceptForm(w http.ResponseWriter, invitation *models.Invitation, token, errorMessage, username string, status int) {
+	contentTemplate := `<h1>Accept Invitation</h1>
+			{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+			<div class="card">
+				<p>You've been invited to join Sting Ray CMS as <strong>{{.Email}}</strong>. Choose a username and password to finish creating your account.</p>
+				<form action="/user/accept-invite" method="post">
+					<input type="hidden" name="token" value="{{.Token}}">
+					<div class="form-group">
+						<label for="username">Username:</label>
+						<input type="text" id="username" name="username" value="{{.Username}}" required>
+					</div>
+					<div class="form-group">
+						<label for="password">Password:</label>
+						<input type="password" id="password" name="password" required>
+					</div>
+					<div class="form-group">
+						<label for="confirm_password">Confirm Password:</label>
+						<input type="password" id="confirm_password" name="confirm_password" required>
+					</div>
+					{{if .Requirements}}
+					<p>Your password must meet these requirements:</p>
+					<ul>{{range .Requirements}}<li>{{.}}</li>{{end}}</ul>
+					{{end}}
+					<button type="submit" class="btn">Create Account</button>
+				</form>
+			</div>`
+
+	contentData := map[string]interface{}{
+		"Error":        errorMessage,
+		"Email":        invitation.Email,
+		"Token":        token,
+		"Username":     username,
+		"Requirements": h.db.PasswordPolicy().Requirements(),
+	}
+
+	renderContentPageStatus(w, status, "Accept Invitation - Sting Ray", "Accept Invitation", guestNavigation, contentTemplate, contentData)
+}
+
+// groupExists reports whether a group with the given name is in the list
+func groupExists(groups []models.Group, name string) bool {
+	for _, group := range groups {
+		if group.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -28,15 +28,10 @@ func (lm *LoggingMiddleware) Wrap(next http.HandlerFunc) http.HandlerFunc {
 		
 		// Log the access
 		duration := time.Since(start)
-		remoteAddr := r.RemoteAddr
-		if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
-			remoteAddr = forwardedFor
-		}
-		
 		lm.logger.LogAccess(
 			r.Method,
 			r.URL.Path,
-			remoteAddr,
+			ClientIP(r),
 			r.UserAgent(),
 			wrappedWriter.statusCode,
 			duration,
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"stingray/config"
+	"stingray/database"
+	"stingray/email"
+	"stingray/logging"
+	"stingray/models"
+	"strings"
+	"time"
+)
+
+// MagicLinkCookieName is the cookie that binds a sign-in link to the browser that requested it
+const MagicLinkCookieName = "stingray_magic_link"
+
+// MagicLinkHandler lets users whose groups hold login.magic_link sign in with an emailed link
+type MagicLinkHandler struct {
+	db     *database.Database
+	cfg    *config.Config
+	email  *email.EmailService
+	sm     *SessionMiddleware
+	logger *logging.Logger
+}
+
+// NewMagicLinkHandler creates a new magic link handler
+func NewMagicLinkHandler(db *database.Database, sm *SessionMiddleware, cfg *config.Config, logger *logging.Logger) *MagicLinkHandler {
+	return &MagicLinkHandler{
+		db:     db,
+		cfg:    cfg,
+		email:  newEmailService(cfg, logger),
+		sm:     sm,
+		logger: logger,
+	}
+}
+
+// HandleMagicLinkRequest shows the "email me a sign-in link" form on GET and sends the link on POST.
+// The response is the same whether or not the address belongs to an account that may use links.
+func (h *MagicLinkHandler) HandleMagicLinkRequest(w http.ResponseWriter, r *http.Request) {
+	if h.sm.IsAuthenticated(r) {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if r.Method == "GET" {
+		h.renderRequestForm(w, "", http.StatusOK)
+		return
+	}
+	if r.Method != "POST" {
+		RenderMessage(w, "405 Method Not Allowed", "Method Not Allowed", "error",
+			"Only GET and POST are allowed for this endpoint.", "/user/magic-link", "Back", http.StatusMethodNotAllowed)
+		return
+	}
+
+	emailAddress := strings.TrimSpace(r.FormValue("email"))
+	if emailAddress == "" {
+		h.renderRequestForm(w, "Email address is required.", http.StatusBadRequest)
+		return
+	}
+
+	// Every request gets a fresh browser key so the cookie does not reveal whether a link was sent
+	browserKey, err := generateResetToken()
+	if err != nil {
+		h.renderRequestForm(w, "The sign-in link could not be created. Please try again.", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     MagicLinkCookieName,
+		Value:    browserKey,
+		Path:     "/user/magic-link",
+		HttpOnly: true,
+		Secure:   h.cfg.SecureCookies(),
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   int(h.cfg.MagicLinkDuration.Seconds()),
+	})
+
+	sent := "If that address belongs to an account that can sign in with a link, one has been sent. Open it in this browser within " +
+		h.cfg.MagicLinkDuration.String() + "."
+	user, ok := h.eligibleUser(emailAddress)
+	if !ok {
+		RenderMessage(w, "Check Your Email", "Check Your Email", "success", sent, "/user/login", "Back to Login", http.StatusOK)
+		return
+	}
+	if h.throttled(user.ID) {
+		recordAuditEvent(h.db, nil, h.logger, r, models.AuditEvent{
+			EventType:    models.AuditMagicLinkRequested,
+			TargetUserID: user.ID,
+			TargetName:   user.Username,
+			Details:      "throttled",
+		})
+		RenderMessage(w, "Check Your Email", "Check Your Email", "success", sent, "/user/login", "Back to Login", http.StatusOK)
+		return
+	}
+
+	token, err := generateResetToken()
+	if err == nil {
+		err = h.db.CreateMagicLinkToken(user.ID, token, browserKey, time.Now().Add(h.cfg.MagicLinkDuration))
+	}
+	if err != nil {
+		database.LogSQLError(err)
+		h.renderRequestForm(w, "The sign-in link could not be created. Please try again.", http.StatusInternalServerError)
+		return
+	}
+	recordAuditEvent(h.db, nil, h.logger, r, models.AuditEvent{
+		EventType:    models.AuditMagicLinkRequested,
+		TargetUserID: user.ID,
+		TargetName:   user.Username,
+	})
+
+	loginURL := h.cfg.PublicURL("/user/magic-link/verify?token=" + token)
+	switch {
+	case h.email != nil:
+		if err := h.email.SendMagicLinkEmail(user.Email, loginURL, h.cfg.MagicLinkDuration); err != nil {
+			h.logger.LogError("Failed to send sign-in link email: %v", err)
+			h.renderRequestForm(w, "The sign-in link could not be sent. Please try again or log in with your password.", http.StatusInternalServerError)
+			return
+		}
+	case h.cfg.DevShowTokenLinks:
+		// Development fallback for when email service is not available
+		RenderMessage(w, "Check Your Email", "Check Your Email", "success",
+			fmt.Sprintf("%s For testing, you can use this link: %s", sent, loginURL), "/user/login", "Back to Login", http.StatusOK)
+		return
+	default:
+		h.logger.LogError("Sign-in link requested for user %d but email is not configured", user.ID)
+	}
+
+	RenderMessage(w, "Check Your Email", "Check Your Email", "success", sent, "/user/login", "Back to Login", http.StatusOK)
+}
+
+// HandleMagicLinkVerify signs the user in. GET only shows a confirmation button, so mail
+// scanners that prefetch links cannot use up the single-use token; POST consumes it.
+func (h *MagicLinkHandler) HandleMagicLinkVerify(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	if token == "" {
+		RenderMessage(w, "Invalid Sign-In Link", "Invalid Sign-In Link", "error",
+			"The sign-in link is invalid or missing.", "/user/magic-link", "Request a New Link", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == "GET" {
+		contentTemplate := `<h1>Sign In</h1>
+				<div class="card">
+					<p>Continue to sign in to your account.</p>
+					<form method="POST" action="/user/magic-link/verify">
+						<input type="hidden" name="token" value="{{.Token}}">
+						<button type="submit" class="btn">Sign In</button>
+					</form>
+				</div>`
+		renderContentPageStatus(w, http.StatusOK, "Sign In - Sting Ray", "Sign In", guestNavigation, contentTemplate, map[string]interface{}{"Token": token})
+		return
+	}
+	if r.Method != "POST" {
+		RenderMessage(w, "405 Method Not Allowed", "Method Not Allowed", "error",
+			"Only GET and POST are allowed for this endpoint.", "/user/login", "Back to Login", http.StatusMethodNotAllowed)
+		return
+	}
+
+	browserKey := ""
+	if cookie, err := r.Cookie(MagicLinkCookieName); err == nil {
+		browserKey = cookie.Value
+	}
+	userID, err := h.db.ConsumeMagicLinkToken(token, browserKey)
+	if errors.Is(err, database.ErrMagicLinkOtherBrowser) {
+		recordAuditEvent(h.db, nil, h.logger, r, models.AuditEvent{
+			EventType: models.AuditLoginFailure,
+			Details:   "magic link opened in another browser",
+		})
+		RenderMessage(w, "Wrong Browser", "Wrong Browser", "error",
+			"This sign-in link only works in the browser it was requested from. Open it there, or request a new link here.",
+			"/user/magic-link", "Request a New Link", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		RenderMessage(w, "Invalid Sign-In Link", "Invalid Sign-In Link", "error",
+			"This sign-in link is invalid, has expired or has already been used.", "/user/magic-link", "Request a New Link", http.StatusBadRequest)
+		return
+	}
+	h.clearBrowserKey(w)
+
+	// The account may have been suspended or lost the permission since the link was sent
+	user, err := h.db.GetUserByID(userID)
+	if err != nil || user.Status != models.UserStatusActive || !h.mayUseMagicLink(user.ID) {
+		recordAuditEvent(h.db, nil, h.logger, r, models.AuditEvent{
+			EventType:    models.AuditLoginFailure,
+			TargetUserID: userID,
+			Details:      "magic link no longer allowed",
+		})
+		RenderMessage(w, "Sign-In Not Allowed", "Sign-In Not Allowed", "error",
+			"This account can no longer sign in with a link. Please log in with your password.", "/user/login", "Back to Login", http.StatusForbidden)
+		return
+	}
+
+	if err := h.sm.StartSession(w, r, user, false); err != nil {
+		database.LogSQLError(err)
+		RenderMessage(w, "Login Error", "Login Error", "error",
+			"Failed to create session. Please try again.", "/user/login", "Back to Login", http.StatusInternalServerError)
+		return
+	}
+	if h.logger != nil {
+		h.logger.LogLogin(user.Username, ClientIP(r), true)
+	}
+	recordAuditEvent(h.db, nil, h.logger, r, models.AuditEvent{
+		EventType:    models.AuditLoginSuccess,
+		ActorID:      user.ID,
+		ActorName:    user.Username,
+		TargetUserID: user.ID,
+		TargetName:   user.Username,
+		Details:      "magic link",
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// eligibleUser returns the active account for an email address if one of its groups allows
+// magic link sign-in
+func (h *MagicLinkHandler) eligibleUser(emailAddress string) (*models.User, bool) {
+	user, err := h.db.GetUserByEmail(emailAddress)
+	if err != nil || user.Status != models.UserStatusActive {
+		return nil, false
+	}
+	return user, h.mayUseMagicLink(user.ID)
+}
+
+func (h *MagicLinkHandler) mayUseMagicLink(userID int) bool {
+	access, err := h.db.LoadAccess(userID)
+	if err != nil {
+		return false
+	}
+	allowed, err := h.db.Can(access, models.PermissionMagicLink)
+	return err == nil && allowed
+}
+
+// throttled reports whether an account has used up its emailed-link requests for the window.
+// Sign-in links share the password reset limits.
+func (h *MagicLinkHandler) throttled(userID int) bool {
+	if h.cfg.PasswordResetMaxRequests <= 0 {
+		return false
+	}
+	count, err := h.db.CountRecentMagicLinkTokens(userID, time.Now().Add(-h.cfg.PasswordResetWindow))
+	if err != nil {
+		return true
+	}
+	return count >= h.cfg.PasswordResetMaxRequests
+}
+
+// clearBrowserKey removes the browser binding cookie once its link has been used
+func (h *MagicLinkHandler) clearBrowserKey(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     MagicLinkCookieName,
+		Value:    "",
+		Path:     "/user/magic-link",
+		HttpOnly: true,
+		Secure:   h.cfg.SecureCookies(),
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   -1,
+	})
+}
+
+// renderRequestForm shows the form asking for the email address to send a sign-in link to
+func (h *MagicLinkHandler) renderRequestForm(w http.ResponseWriter, errorMessage string, status int) {
+	contentTemplate := `<h1>Email Me a Sign-In Link</h1>
+			{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+			<div class="card">
+				<p>Enter your email address and we'll send you a link that signs you in without a password. It works once, for {{.Duration}}, and only in this browser.</p>
+				<form action="/user/magic-link" method="post">
+					<div class="form-group">
+						<label for="email">Email Address:</label>
+						<input type="email" id="email" name="email" required>
+					</div>
+					<button type="submit" class="btn">Send Link</button>
+				</form>
+				<p><a href="/user/login">Log in with a password</a></p>
+			</div>`
+
+	contentData := map[string]interface{}{
+		"Error":    errorMessage,
+		"Duration": h.cfg.MagicLinkDuration.String(),
+	}
+
+	renderContentPageStatus(w, status, "Sign-In Link - Sting Ray", "Email Me a Sign-In Link", guestNavigation, contentTemplate, contentData)
+}
@@ -19,10 +19,10 @@ type MetadataHandler struct {
 }
 
 // NewMetadataHandler creates a new metadata handler
-func NewMetadataHandler(db *database.Database) *MetadataHandler {
+func NewMetadataHandler(db *database.Database, sm *SessionMiddleware) *MetadataHandler {
 	return &MetadataHandler{
 		db: db,
-		sm: NewSessionMiddleware(db),
+		sm: sm,
 	}
 }
 
@@ -36,22 +36,12 @@ func (h *MetadataHandler) HandleTableList(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Check if user is authenticated
-	isAuthenticated := h.sm.IsAuthenticated(r)
-	var userID int
-	var isEngineer, isAdmin bool
-	if isAuthenticated {
-		session, err := h.sm.GetSessionFromRequest(r)
-		if err == nil {
-			userID = session.UserID
-			// Check if user is in engineer or admin group
-			isEngineer, _ = h.db.IsUserInGroup(userID, "engineer")
-			isAdmin, _ = h.db.IsUserInGroup(userID, "admin")
-		}
-	}
+	// Engineer view lists every table; schema editors get create/edit/delete actions
+	canViewAll := h.sm.Can(r, models.PermissionTablesViewAll)
+	canEditSchema := h.sm.Can(r, models.PermissionSchemaEdit)
 
 	// Check if engineer mode is requested
-	engineerMode := r.URL.Query().Get("engineer") == "true" && isEngineer
+	engineerMode := r.URL.Query().Get("engineer") == "true" && canViewAll
 
 	// Filter tables based on user access
 	var accessibleTables []models.TableMetadata
@@ -59,40 +49,16 @@ func (h *MetadataHandler) HandleTableList(w http.ResponseWriter, r *http.Request
 		// In engineer mode, show all tables
 		accessibleTables = tableMetadata
 	} else {
-		// Normal mode - filter based on permissions
-		for _, table := range tableMetadata {
-			hasAccess := false
-			
-			// Parse read groups
-			var readGroups []string
-			if table.ReadGroups != "" {
-				if err := json.Unmarshal([]byte(table.ReadGroups), &readGroups); err != nil {
-					continue
-				}
-			}
-
-			// Check access
-			if len(readGroups) == 0 {
-				hasAccess = true // No restrictions
-			} else {
-				for _, group := range readGroups {
-					// Everyone is automatically in the 'everyone' group
-					if group == "everyone" {
-						hasAccess = true
-						break
-					}
-					// For authenticated users, check their groups
-					if isAuthenticated {
-						if inGroup, _ := h.db.IsUserInGroup(userID, group); inGroup {
-							hasAccess = true
-							break
-						}
-					}
-				}
-			}
-
-			if hasAccess {
-				accessibleTables = append(accessibleTables, table)
+		// Normal mode - filter based on read permissions, checked against the metadata
+		// already loaded so the number of queries does not grow with the number of tables
+		access, err := h.sm.Access(r)
+		if err != nil {
+			http.Error(w, "Error checking table permissions", http.StatusInternalServerError)
+			return
+		}
+		for i := range tableMetadata {
+			if allowed, err := access.CanAccessTable(&tableMetadata[i], "read"); err == nil && allowed {
+				accessibleTables = append(accessibleTables, tableMetadata[i])
 			}
 		}
 	}
@@ -101,7 +67,7 @@ func (h *MetadataHandler) HandleTableList(w http.ResponseWriter, r *http.Request
 	if r.URL.Query().Get("response_format") == "json" {
 		response := map[string]interface{}{
 			"tables":       accessibleTables,
-			"is_engineer":  isEngineer,
+			"is_engineer":  canViewAll,
 			"engineer_mode": engineerMode,
 		}
 		w.Header().Set("Content-Type", "application/json")
@@ -112,7 +78,7 @@ func (h *MetadataHandler) HandleTableList(w http.ResponseWriter, r *http.Request
 	// HTML response - use template system
 	mainContentTemplate := `<h1>Database Tables</h1>
 			
-			{{if .IsEngineer}}
+			{{if .CanViewAll}}
 			<div class="toggle-container">
 				<label class="toggle-label">View Mode:</label>
 				<div class="toggle-buttons">
@@ -127,7 +93,7 @@ func (h *MetadataHandler) HandleTableList(w http.ResponseWriter, r *http.Request
 					<button class="toggle-btn active" disabled>Admin View</button>
 					<button class="toggle-btn" disabled>Engineer View</button>
 				</div>
-				<small style="color: #6c757d; margin-top: 0.5rem; display: block;">Engineer view is only available to users in the Engineer group.</small>
+				<small style="color: #6c757d; margin-top: 0.5rem; display: block;">Engineer view requires the tables.view_all permission.</small>
 			</div>
 			{{end}}
 			
@@ -137,7 +103,7 @@ func (h *MetadataHandler) HandleTableList(w http.ResponseWriter, r *http.Request
 			</div>
 			{{end}}
 			
-			{{if or .IsEngineer .IsAdmin .EngineerMode}}
+			{{if .CanEditSchema}}
 			<div class="table-actions" style="margin-bottom: 2rem;">
 				<a href="/metadata/create-table" class="btn btn-success">Create Table</a>
 			</div>
@@ -152,9 +118,11 @@ func (h *MetadataHandler) HandleTableList(w http.ResponseWriter, r *http.Request
 					</div>
 					<div class="table-actions">
 						<a href="/metadata/table/{{.TableName}}" class="btn btn-primary">View Data</a>
-						{{if or $.IsEngineer $.IsAdmin $.EngineerMode}}
+						{{if $.CanEditSchema}}
 						<a href="/metadata/edit-table/{{.TableName}}" class="btn btn-secondary">Edit Metadata</a>
-						<a href="/metadata/delete-table/{{.TableName}}" class="btn btn-danger" onclick="return confirm('Are you sure you want to delete this table? This will permanently remove the table, its metadata, and all field metadata. This action cannot be undone.')">Delete</a>
+						<form method="POST" action="/metadata/delete-table/{{.TableName}}" style="display: inline;" data-confirm="Are you sure you want to delete this table? This will permanently remove the table, its metadata, and all field metadata. This action cannot be undone.">
+							<button type="submit" class="btn btn-danger">Delete</button>
+						</form>
 						{{end}}
 					</div>
 				</li>
@@ -170,10 +138,10 @@ func (h *MetadataHandler) HandleTableList(w http.ResponseWriter, r *http.Request
 
 	var contentBuffer strings.Builder
 	contentData := map[string]interface{}{
-		"Tables":       accessibleTables,
-		"IsEngineer":   isEngineer,
-		"IsAdmin":      isAdmin,
-		"EngineerMode": engineerMode,
+		"Tables":        accessibleTables,
+		"CanViewAll":    canViewAll,
+		"CanEditSchema": canEditSchema,
+		"EngineerMode":  engineerMode,
 	}
 	err = contentTmpl.Execute(&contentBuffer, contentData)
 	if err != nil {
@@ -195,8 +163,8 @@ func (h *MetadataHandler) HandleTableList(w http.ResponseWriter, r *http.Request
 		"CSSClass":       "metadata",
 		"Scripts":        "",
 		"Tables":         accessibleTables,
-		"IsEngineer":     isEngineer,
-		"IsAdmin":        isAdmin,
+		"CanViewAll":     canViewAll,
+		"CanEditSchema":  canEditSchema,
 		"EngineerMode":   engineerMode,
 	}
 
@@ -229,47 +197,8 @@ func (h *MetadataHandler) HandleTableData(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Check if user is authenticated
-	isAuthenticated := h.sm.IsAuthenticated(r)
-	var userID int
-	if isAuthenticated {
-		session, err := h.sm.GetSessionFromRequest(r)
-		if err != nil {
-			http.Error(w, "User not authenticated", http.StatusUnauthorized)
-			return
-		}
-		userID = session.UserID
-	}
-
-	// Parse read groups
-	var readGroups []string
-	if tableMetadata.ReadGroups != "" {
-		if err := json.Unmarshal([]byte(tableMetadata.ReadGroups), &readGroups); err != nil {
-			http.Error(w, "Error parsing read groups", http.StatusInternalServerError)
-			return
-		}
-	}
-
 	// Check if user has read access
-	hasAccess := false
-	if len(readGroups) == 0 {
-		hasAccess = true // No restrictions
-	} else {
-		for _, group := range readGroups {
-			// Everyone is automatically in the 'everyone' group
-			if group == "everyone" {
-				hasAccess = true
-				break
-			}
-			// For authenticated users, check their groups
-			if isAuthenticated {
-				if inGroup, _ := h.db.IsUserInGroup(userID, group); inGroup {
-					hasAccess = true
-					break
-				}
-			}
-		}
-	}
+	hasAccess := h.sm.Can(r, models.TablePermission(tableName, "read"))
 
 	if !hasAccess {
 		http.Error(w, "Access denied", http.StatusForbidden)
@@ -303,41 +232,10 @@ func (h *MetadataHandler) HandleTableData(w http.ResponseWriter, r *http.Request
 	}
 
 	// Check write permissions
-	var writeGroups []string
-	if tableMetadata.WriteGroups != "" {
-		if err := json.Unmarshal([]byte(tableMetadata.WriteGroups), &writeGroups); err != nil {
-			http.Error(w, "Error parsing write groups", http.StatusInternalServerError)
-			return
-		}
-	}
-
-	canEdit := false
-	canDelete := false
-	canCreate := false
-	if len(writeGroups) == 0 {
-		canEdit = true
-		canDelete = true
-		canCreate = true
-	} else {
-		for _, group := range writeGroups {
-			// Everyone is automatically in the 'everyone' group
-			if group == "everyone" {
-				canEdit = true
-				canDelete = true
-				canCreate = true
-				break
-			}
-			// For authenticated users, check their groups
-			if isAuthenticated {
-				if inGroup, _ := h.db.IsUserInGroup(userID, group); inGroup {
-					canEdit = true
-					canDelete = true
-					canCreate = true
-					break
-				}
-			}
-		}
-	}
+	canWrite := h.sm.Can(r, models.TablePermission(tableName, "write"))
+	canEdit := canWrite
+	canDelete := canWrite
+	canCreate := canWrite
 
 	// Check if JSON response is requested
 	if r.URL.Query().Get("response_format") == "json" {
@@ -385,6 +283,7 @@ func (h *MetadataHandler) HandleTableData(w http.ResponseWriter, r *http.Request
 			.pagination a:hover { background: #e9ecef; }
 			.pagination .current { background: #667eea; color: white; border-color: #667eea; }
 		</style>
+		` + siteScriptTag + `
 	</head>
 	<body>
 		<div class="container">
@@ -423,7 +322,9 @@ func (h *MetadataHandler) HandleTableData(w http.ResponseWriter, r *http.Request
 							<a href="/metadata/edit/{{$.TableName}}/{{$row.ID}}" class="btn btn-secondary">Edit</a>
 							{{end}}
 							{{if $.CanDelete}}
-							<a href="/metadata/delete/{{$.TableName}}/{{$row.ID}}" class="btn btn-danger" onclick="return confirm('Are you sure?')">Delete</a>
+							<form method="POST" action="/metadata/delete/{{$.TableName}}/{{$row.ID}}" style="display: inline;" data-confirm="Are you sure?">
+								<button type="submit" class="btn btn-danger">Delete</button>
+							</form>
 							{{end}}
 						</td>
 						{{end}}
@@ -479,6 +380,21 @@ func (h *MetadataHandler) HandleTableData(w http.ResponseWriter, r *http.Request
 	t.Execute(w, data)
 }
 
+// pageTables hold pages and their revisions. Their rows are only changed through the page
+// editor, which validates input, checks the HTML permission and records revisions.
+var pageTables = []string{"_page", "_page_revision"}
+
+// redirectPageTable sends requests for page rows to the page editor, reporting whether it did
+func redirectPageTable(w http.ResponseWriter, r *http.Request, tableName string) bool {
+	for _, pageTable := range pageTables {
+		if tableName == pageTable {
+			http.Redirect(w, r, "/page-editor", http.StatusSeeOther)
+			return true
+		}
+	}
+	return false
+}
+
 // HandleEditRow handles editing or creating table rows
 func (h *MetadataHandler) HandleEditRow(w http.ResponseWriter, r *http.Request) {
 	// Check if user is authenticated
@@ -498,6 +414,9 @@ func (h *MetadataHandler) HandleEditRow(w http.ResponseWriter, r *http.Request)
 	if len(pathParts) > 1 {
 		rowID = pathParts[1]
 	}
+	if redirectPageTable(w, r, tableName) {
+		return
+	}
 
 	// Get table metadata
 	tableMetadata, err := h.db.GetTableMetadata(tableName)
@@ -507,35 +426,8 @@ func (h *MetadataHandler) HandleEditRow(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Check user permissions
-	session, err := h.sm.GetSessionFromRequest(r)
-	if err != nil {
-		http.Error(w, "User not authenticated", http.StatusUnauthorized)
-		return
-	}
-	userID := session.UserID
-
-	// Parse write groups
-	var writeGroups []string
-	if tableMetadata.WriteGroups != "" {
-		if err := json.Unmarshal([]byte(tableMetadata.WriteGroups), &writeGroups); err != nil {
-			http.Error(w, "Error parsing write groups", http.StatusInternalServerError)
-			return
-		}
-	}
-
 	// Check if user has write access
-	hasAccess := false
-	if len(writeGroups) == 0 {
-		hasAccess = true // No restrictions
-	} else {
-		for _, group := range writeGroups {
-			if inGroup, _ := h.db.IsUserInGroup(userID, group); inGroup {
-				hasAccess = true
-				break
-			}
-		}
-	}
+	hasAccess := h.sm.Can(r, models.TablePermission(tableName, "write"))
 
 	if !hasAccess {
 		http.Error(w, "Access denied", http.StatusForbidden)
@@ -795,6 +687,9 @@ func (h *MetadataHandler) HandleDeleteRow(w http.ResponseWriter, r *http.Request
 	}
 	tableName := pathParts[0]
 	rowID := pathParts[1]
+	if redirectPageTable(w, r, tableName) {
+		return
+	}
 
 	// Get table metadata
 	tableMetadata, err := h.db.GetTableMetadata(tableName)
@@ -804,35 +699,8 @@ func (h *MetadataHandler) HandleDeleteRow(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Check user permissions
-	session, err := h.sm.GetSessionFromRequest(r)
-	if err != nil {
-		http.Error(w, "User not authenticated", http.StatusUnauthorized)
-		return
-	}
-	userID := session.UserID
-
-	// Parse write groups
-	var writeGroups []string
-	if tableMetadata.WriteGroups != "" {
-		if err := json.Unmarshal([]byte(tableMetadata.WriteGroups), &writeGroups); err != nil {
-			http.Error(w, "Error parsing write groups", http.StatusInternalServerError)
-			return
-		}
-	}
-
 	// Check if user has write access
-	hasAccess := false
-	if len(writeGroups) == 0 {
-		hasAccess = true // No restrictions
-	} else {
-		for _, group := range writeGroups {
-			if inGroup, _ := h.db.IsUserInGroup(userID, group); inGroup {
-				hasAccess = true
-				break
-			}
-		}
-	}
+	hasAccess := h.sm.Can(r, models.TablePermission(tableName, "write"))
 
 	if !hasAccess {
 		http.Error(w, "Access denied", http.StatusForbidden)
@@ -846,6 +714,14 @@ func (h *MetadataHandler) HandleDeleteRow(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// Deleting requires a POST; a GET shows a confirmation form instead
+	if r.Method != "POST" {
+		renderDeleteConfirmation(w, "Delete Row",
+			fmt.Sprintf("Are you sure you want to delete row %d from %s? This action cannot be undone.", id, tableMetadata.DisplayName),
+			r.URL.Path, fmt.Sprintf("/metadata/table/%s", tableName))
+		return
+	}
+
 	if err := h.db.DeleteTableRow(tableName, id); err != nil {
 		database.LogSQLError(err)
 		http.Error(w, "Error deleting row", http.StatusInternalServerError)
@@ -880,18 +756,8 @@ func (h *MetadataHandler) HandleEditTableMetadata(w http.ResponseWriter, r *http
 		return
 	}
 
-	// Check user permissions - only admin and engineer can edit table metadata
-	session, err := h.sm.GetSessionFromRequest(r)
-	if err != nil {
-		http.Error(w, "User not authenticated", http.StatusUnauthorized)
-		return
-	}
-	userID := session.UserID
-
-	isAdmin, _ := h.db.IsUserInGroup(userID, "admin")
-	isEngineer, _ := h.db.IsUserInGroup(userID, "engineer")
-
-	if !isAdmin && !isEngineer {
+	// Check user permissions - editing table metadata requires schema.edit
+	if !h.sm.Can(r, models.PermissionSchemaEdit) {
 		http.Error(w, "Access denied", http.StatusForbidden)
 		return
 	}
@@ -954,6 +820,7 @@ func (h *MetadataHandler) HandleEditTableMetadata(w http.ResponseWriter, r *http
 			.btn:hover { opacity: 0.8; }
 			.help-text { font-size: 0.9rem; color: #6c757d; margin-top: 0.25rem; }
 		</style>
+		` + siteScriptTag + `
 	</head>
 	<body>
 		<div class="container">
@@ -985,9 +852,11 @@ func (h *MetadataHandler) HandleEditTableMetadata(w http.ResponseWriter, r *http
 				<div class="form-group">
 					<button type="submit" class="btn btn-primary">Update Metadata</button>
 					<a href="/metadata/tables" class="btn btn-secondary">Cancel</a>
-					<a href="/metadata/delete-table/{{.TableName}}" class="btn btn-danger" onclick="return confirm('Are you sure you want to delete this table? This will permanently remove the table, its metadata, and all field metadata. This action cannot be undone.')">Delete Table</a>
 				</div>
 			</form>
+			<form method="POST" action="/metadata/delete-table/{{.TableName}}" data-confirm="Are you sure you want to delete this table? This will permanently remove the table, its metadata, and all field metadata. This action cannot be undone.">
+				<button type="submit" class="btn btn-danger">Delete Table</button>
+			</form>
 		</div>
 	</body>
 	</html>`
@@ -1026,18 +895,8 @@ func (h *MetadataHandler) HandleDeleteTable(w http.ResponseWriter, r *http.Reque
 	}
 	tableName := pathParts[0]
 
-	// Check user permissions - only admin and engineer can delete tables
-	session, err := h.sm.GetSessionFromRequest(r)
-	if err != nil {
-		http.Error(w, "User not authenticated", http.StatusUnauthorized)
-		return
-	}
-	userID := session.UserID
-
-	isAdmin, _ := h.db.IsUserInGroup(userID, "admin")
-	isEngineer, _ := h.db.IsUserInGroup(userID, "engineer")
-
-	if !isAdmin && !isEngineer {
+	// Check user permissions - deleting tables requires schema.edit
+	if !h.sm.Can(r, models.PermissionSchemaEdit) {
 		http.Error(w, "Access denied", http.StatusForbidden)
 		return
 	}
@@ -1051,6 +910,14 @@ func (h *MetadataHandler) HandleDeleteTable(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
+	// Deleting requires a POST; a GET shows a confirmation form instead
+	if r.Method != "POST" {
+		renderDeleteConfirmation(w, "Delete Table",
+			fmt.Sprintf("Are you sure you want to delete the table %s? This will permanently remove the table, its metadata, and all field metadata. This action cannot be undone.", tableName),
+			r.URL.Path, "/metadata/tables")
+		return
+	}
+
 	// Delete the table and all its metadata
 	if err := h.db.DeleteTableMetadata(tableName); err != nil {
 		database.LogSQLError(err)
@@ -1062,6 +929,54 @@ func (h *MetadataHandler) HandleDeleteTable(w http.ResponseWriter, r *http.Reque
 	http.Redirect(w, r, "/metadata/tables", http.StatusSeeOther)
 } 
 
+// renderDeleteConfirmation renders a confirmation page that submits the delete as a POST
+func renderDeleteConfirmation(w http.ResponseWriter, title, message, actionURL, cancelURL string) {
+	tmpl := `
+	<!DOCTYPE html>
+	<html lang="en">
+	<head>
+		<meta charset="UTF-8">
+		<meta name="viewport" content="width=device-width, initial-scale=1.0">
+		<title>{{.Title}} - Sting Ray</title>
+		<style>
+			body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; line-height: 1.6; color: #333; background: #f5f5f5; margin: 0; padding: 2rem; }
+			.container { max-width: 600px; margin: 0 auto; background: white; padding: 2rem; border-radius: 8px; box-shadow: 0 2px 10px rgba(0,0,0,0.1); text-align: center; }
+			h1 { color: #dc3545; margin-bottom: 1rem; }
+			.btn { padding: 0.75rem 1.5rem; border: none; border-radius: 4px; text-decoration: none; font-size: 1rem; cursor: pointer; margin-right: 0.5rem; }
+			.btn-secondary { background: #6c757d; color: white; }
+			.btn-danger { background: #dc3545; color: white; }
+			.btn:hover { opacity: 0.8; }
+		</style>
+	</head>
+	<body>
+		<div class="container">
+			<h1>{{.Title}}</h1>
+			<p>{{.Message}}</p>
+			<form method="POST" action="{{.ActionURL}}">
+				<button type="submit" class="btn btn-danger">Delete</button>
+				<a href="{{.CancelURL}}" class="btn btn-secondary">Cancel</a>
+			</form>
+		</div>
+	</body>
+	</html>`
+
+	t, err := template.New("delete_confirmation").Parse(tmpl)
+	if err != nil {
+		http.Error(w, "Error parsing template", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Title":     title,
+		"Message":   message,
+		"ActionURL": actionURL,
+		"CancelURL": cancelURL,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	t.Execute(w, data)
+}
+
 // HandleCreateTable handles creating new tables with metadata
 func (h *MetadataHandler) HandleCreateTable(w http.ResponseWriter, r *http.Request) {
 	// Check if user is authenticated
@@ -1070,18 +985,8 @@ func (h *MetadataHandler) HandleCreateTable(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Check user permissions - only admin and engineer can create tables
-	session, err := h.sm.GetSessionFromRequest(r)
-	if err != nil {
-		http.Error(w, "User not authenticated", http.StatusUnauthorized)
-		return
-	}
-	userID := session.UserID
-
-	isAdmin, _ := h.db.IsUserInGroup(userID, "admin")
-	isEngineer, _ := h.db.IsUserInGroup(userID, "engineer")
-
-	if !isAdmin && !isEngineer {
+	// Check user permissions - creating tables requires schema.edit
+	if !h.sm.Can(r, models.PermissionSchemaEdit) {
 		http.Error(w, "Access denied", http.StatusForbidden)
 		return
 	}
@@ -1253,7 +1158,7 @@ func (h *MetadataHandler) HandleCreateTable(w http.ResponseWriter, r *http.Reque
 					<div class="field-section" data-field-index="0">
 						<div class="field-header">
 							<h3>Field 1</h3>
-							<button type="button" class="remove-field" onclick="removeField(this)">Remove</button>
+							<button type="button" class="remove-field">Remove</button>
 						</div>
 						<div class="field-grid">
 							<div class="form-group">
@@ -1332,7 +1237,7 @@ func (h *MetadataHandler) HandleCreateTable(w http.ResponseWriter, r *http.Reque
 					</div>
 				</div>
 
-				<button type="button" class="add-field" onclick="addField()">Add Field</button>
+				<button type="button" class="add-field" id="addFieldButton">Add Field</button>
 
 				<div class="form-group" style="margin-top: 2rem;">
 					<button type="submit" class="btn btn-primary">Create Table</button>
@@ -1341,7 +1246,7 @@ func (h *MetadataHandler) HandleCreateTable(w http.ResponseWriter, r *http.Reque
 			</form>
 		</div>
 
-		<script>
+		<script nonce="{{.Nonce}}">
 			let fieldIndex = 1;
 
 			function addField() {
@@ -1353,7 +1258,7 @@ func (h *MetadataHandler) HandleCreateTable(w http.ResponseWriter, r *http.Reque
 				newField.innerHTML = 
 					'<div class="field-header">' +
 						'<h3>Field ' + (fieldIndex + 1) + '</h3>' +
-						'<button type="button" class="remove-field" onclick="removeField(this)">Remove</button>' +
+						'<button type="button" class="remove-field">Remove</button>' +
 					'</div>' +
 					'<div class="field-grid">' +
 						'<div class="form-group">' +
@@ -1440,6 +1345,13 @@ func (h *MetadataHandler) HandleCreateTable(w http.ResponseWriter, r *http.Reque
 					fieldSection.remove();
 				}
 			}
+
+			document.getElementById('addFieldButton').addEventListener('click', addField);
+			document.getElementById('fieldsContainer').addEventListener('click', function (event) {
+				if (event.target.classList.contains('remove-field')) {
+					removeField(event.target);
+				}
+			});
 		</script>
 	</body>
 	</html>`
@@ -1451,7 +1363,7 @@ func (h *MetadataHandler) HandleCreateTable(w http.ResponseWriter, r *http.Reque
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	t.Execute(w, nil)
+	t.Execute(w, map[string]string{"Nonce": CSPNonce(r)})
 } 
 
 // HandleFieldMetadata handles all field metadata operations via API
@@ -1462,18 +1374,8 @@ func (h *MetadataHandler) HandleFieldMetadata(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// Check if user has admin or engineer permissions
-	session, err := h.sm.GetSessionFromRequest(r)
-	if err != nil {
-		http.Error(w, "User not authenticated", http.StatusUnauthorized)
-		return
-	}
-	userID := session.UserID
-
-	isAdmin, _ := h.db.IsUserInGroup(userID, "admin")
-	isEngineer, _ := h.db.IsUserInGroup(userID, "engineer")
-
-	if !isAdmin && !isEngineer {
+	// Check user permissions - managing field metadata requires schema.edit
+	if !h.sm.Can(r, models.PermissionSchemaEdit) {
 		http.Error(w, "Access denied", http.StatusForbidden)
 		return
 	}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"net/http"
 	"stingray/database"
+	"stingray/models"
 )
 
 // RoleMiddleware handles role-based access control
@@ -12,10 +13,10 @@ type RoleMiddleware struct {
 }
 
 // NewRoleMiddleware creates a new role middleware
-func NewRoleMiddleware(db *database.Database) *RoleMiddleware {
+func NewRoleMiddleware(db *database.Database, sm *SessionMiddleware) *RoleMiddleware {
 	return &RoleMiddleware{
 		db: db,
-		sm: NewSessionMiddleware(db),
+		sm: sm,
 	}
 }
 
@@ -35,22 +36,36 @@ func (rm *RoleMiddleware) RequireGroup(groupName string) func(http.HandlerFunc)
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			// First check if user is authenticated
-			if !rm.sm.IsAuthenticated(r) {
+			principal := rm.sm.Principal(r)
+			if !principal.Authenticated() {
 				http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 				return
 			}
 
-			// Get session to get user ID
-			session, err := rm.sm.GetSessionFromRequest(r)
-			if err != nil {
+			// Check if user is in required group
+			access, err := rm.sm.Access(r)
+			if err != nil || !access.InGroup(groupName) {
+				RenderMessage(w, "Access Denied", "Access Denied", "error", 
+					"You do not have permission to access this page.", "/", "Go Home", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+	}
+}
+
+// RequirePermission middleware ensures the user holds a named permission
+func (rm *RoleMiddleware) RequirePermission(permission string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !rm.sm.IsAuthenticated(r) {
 				http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 				return
 			}
 
-			// Check if user is in required group
-			isInGroup, err := rm.db.IsUserInGroup(session.UserID, groupName)
-			if err != nil || !isInGroup {
-				RenderMessage(w, "Access Denied", "Access Denied", "error", 
+			if !rm.sm.Can(r, permission) {
+				RenderMessage(w, "Access Denied", "Access Denied", "error",
 					"You do not have permission to access this page.", "/", "Go Home", http.StatusForbidden)
 				return
 			}
@@ -60,12 +75,38 @@ func (rm *RoleMiddleware) RequireGroup(groupName string) func(http.HandlerFunc)
 	}
 }
 
-// RequireAdmin middleware ensures user is in admin group
+// RequireAPIAuth is RequireAuth for API endpoints: anonymous callers get a JSON 401
+// instead of a redirect to the login page
+func (rm *RoleMiddleware) RequireAPIAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !rm.sm.IsAuthenticated(r) {
+			writeAPIError(w, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// RequireAPIPermission is RequirePermission for API endpoints: failures are reported as
+// JSON 401 and 403 responses in the APIResponse envelope
+func (rm *RoleMiddleware) RequireAPIPermission(permission string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return rm.RequireAPIAuth(func(w http.ResponseWriter, r *http.Request) {
+			if !rm.sm.Can(r, permission) {
+				writeAPIError(w, http.StatusForbidden, "The "+permission+" permission is required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireAdmin middleware ensures the user can manage users and groups
 func (rm *RoleMiddleware) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
-	return rm.RequireGroup("admin")(next)
+	return rm.RequirePermission(models.PermissionUsersManage)(next)
 }
 
-// RequireCustomer middleware ensures user is in customers group
+// RequireCustomer middleware ensures the user can view customer pages
 func (rm *RoleMiddleware) RequireCustomer(next http.HandlerFunc) http.HandlerFunc {
-	return rm.RequireGroup("customers")(next)
-} 
\ No newline at end of file
+	return rm.RequirePermission(models.PermissionFAQView)(next)
+}
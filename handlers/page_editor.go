@@ -0,0 +1,867 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"stingray/database"
+	"stingray/logging"
+	"stingray/models"
+	"stingray/templates"
+	"strings"
+	"time"
+)
+
+// pageEditorNavigation is the navigation bar shown on page editor pages
+const pageEditorNavigation = userNavigation + ` | <a href="/page-editor">Pages</a>`
+
+// defaultPageTemplate is the template new pages use unless another is chosen
+const defaultPageTemplate = "modern"
+
+// Groups new pages get unless others are chosen, matching the built-in pages
+var (
+	defaultPageReadGroups  = []string{"everyone"}
+	defaultPageWriteGroups = []string{"admin", "engineer"}
+)
+
+// errPageNotWritable is returned when the user is not in a page's write groups
+var errPageNotWritable = errors.New("not in the page's write groups")
+
+// PageEditorHandler serves the page editor and the pages JSON API. Both require the
+// pages.edit permission, and a page can only be changed by members of its write groups.
+type PageEditorHandler struct {
+	db     *database.Database
+	sm     *SessionMiddleware
+	logger *logging.Logger
+}
+
+// NewPageEditorHandler creates a new page editor handler
+func NewPageEditorHandler(db *database.Database, sm *SessionMiddleware, logger *logging.Logger) *PageEditorHandler {
+	return &PageEditorHandler{
+		db:     db,
+		sm:     sm,
+		logger: logger,
+	}
+}
+
+// pageInput holds the editable fields of a page. Nil fields are left unchanged, so API
+// clients can update part of a page. Scripts cannot be edited, so content written in the
+// editor never runs script under the Content-Security-Policy.
+type pageInput struct {
+	Slug            *string   `json:"slug"`
+	Title           *string   `json:"title"`
+	MetaDescription *string   `json:"meta_description"`
+	Template        *string   `json:"template"`
+	ContentFormat   *string   `json:"content_format"`
+	Header          *string   `json:"header"`
+	Navigation      *string   `json:"navigation"`
+	MainContent     *string   `json:"main_content"`
+	Sidebar         *string   `json:"sidebar"`
+	Footer          *string   `json:"footer"`
+	CSSClass        *string   `json:"css_class"`
+	ReadGroups      *[]string `json:"read_groups"`
+	WriteGroups     *[]string `json:"write_groups"`
+}
+
+// pageInputFromForm reads every editable field from the page editor form. Group lists
+// are comma-separated.
+func pageInputFromForm(r *http.Request) pageInput {
+	field := func(name string) *string {
+		value := r.FormValue(name)
+		return &value
+	}
+	groups := func(name string) *[]string {
+		names := splitGroupList(r.FormValue(name))
+		return &names
+	}
+	return pageInput{
+		Slug:            field("slug"),
+		Title:           field("title"),
+		MetaDescription: field("meta_description"),
+		Template:        field("template"),
+		ContentFormat:   field("content_format"),
+		Header:          field("header"),
+		Navigation:      field("navigation"),
+		MainContent:     field("main_content"),
+		Sidebar:         field("sidebar"),
+		Footer:          field("footer"),
+		CSSClass:        field("css_class"),
+		ReadGroups:      groups("read_groups"),
+		WriteGroups:     groups("write_groups"),
+	}
+}
+
+// splitGroupList splits a comma-separated list of group names, dropping blanks
+func splitGroupList(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// pageInputError is a rejected page edit, reported with its HTTP status
+type pageInputError struct {
+	status  int
+	message string
+}
+
+func (e *pageInputError) Error() string {
+	return e.message
+}
+
+// pageErrorStatus maps an error from loading or saving a page to a status and a message
+// that can be shown to the user
+func pageErrorStatus(err error) (int, string) {
+	var inputErr *pageInputError
+	switch {
+	case errors.As(err, &inputErr):
+		return inputErr.status, inputErr.message
+	case errors.Is(err, sql.ErrNoRows):
+		return http.StatusNotFound, "Page not found."
+	case errors.Is(err, errPageNotWritable):
+		return http.StatusForbidden, "You are not in this page's write groups."
+	default:
+		database.LogSQLError(err)
+		return http.StatusInternalServerError, "The page could not be saved."
+	}
+}
+
+// applyPageInput copies the input onto page and validates the result. The editor must
+// stay in the write groups, so nobody can lock themselves out of a page.
+func (h *PageEditorHandler) applyPageInput(page *models.Page, input pageInput, access *database.Access) error {
+	before := *page
+	if input.Slug != nil {
+		page.Slug = strings.TrimSpace(*input.Slug)
+	}
+	if input.Title != nil {
+		page.Title = strings.TrimSpace(*input.Title)
+	}
+	if input.MetaDescription != nil {
+		page.MetaDescription = strings.TrimSpace(*input.MetaDescription)
+	}
+	if input.Template != nil {
+		page.Template = strings.TrimSpace(*input.Template)
+	}
+	if input.ContentFormat != nil {
+		page.ContentFormat = strings.TrimSpace(*input.ContentFormat)
+	}
+	if input.Header != nil {
+		page.Header = *input.Header
+	}
+	if input.Navigation != nil {
+		page.Navigation = *input.Navigation
+	}
+	if input.MainContent != nil {
+		page.MainContent = *input.MainContent
+	}
+	if input.Sidebar != nil {
+		page.Sidebar = *input.Sidebar
+	}
+	if input.Footer != nil {
+		page.Footer = *input.Footer
+	}
+	if input.CSSClass != nil {
+		page.CSSClass = strings.TrimSpace(*input.CSSClass)
+	}
+	if input.ReadGroups != nil {
+		page.ReadGroups = models.PageGroupsValue(*input.ReadGroups)
+	}
+	if input.WriteGroups != nil {
+		page.WriteGroups = models.PageGroupsValue(*input.WriteGroups)
+	}
+
+	if err := checkBuiltInSlug(&before, page); err != nil {
+		return err
+	}
+	if !models.ValidPageSlug(page.Slug) {
+		return &pageInputError{http.StatusBadRequest, "Slugs are 1 to 255 lowercase letters, digits, hyphens or underscores."}
+	}
+	if page.Title == "" {
+		return &pageInputError{http.StatusBadRequest, "A title is required."}
+	}
+	if !templates.TemplateExists(page.Template) {
+		return &pageInputError{http.StatusBadRequest, "Unknown template: " + page.Template}
+	}
+	if _, err := templates.DefaultSet.Parse(page.Template); err != nil {
+		return &pageInputError{http.StatusBadRequest, "The template cannot be used: " + err.Error()}
+	}
+	if !templates.DefaultSet.IsLayout(page.Template) {
+		return &pageInputError{http.StatusBadRequest, page.Template + " is a partial included by other templates, not a page template."}
+	}
+	if page.ContentFormat == "" {
+		page.ContentFormat = models.PageFormatHTML
+	}
+	if !models.ValidPageFormat(page.ContentFormat) {
+		return &pageInputError{http.StatusBadRequest, "Content format must be html or markdown."}
+	}
+	if err := checkHTMLContent(&before, page, access); err != nil {
+		return err
+	}
+
+	readGroups, err := models.PageGroups(page.ReadGroups)
+	if err != nil {
+		return &pageInputError{http.StatusBadRequest, "The read groups are not a valid list."}
+	}
+	writeGroups, err := models.PageGroups(page.WriteGroups)
+	if err != nil {
+		return &pageInputError{http.StatusBadRequest, "The write groups are not a valid list."}
+	}
+	if len(readGroups)+len(writeGroups) > 0 {
+		groups, err := h.db.GetAllGroups()
+		if err != nil {
+			return err
+		}
+		for _, name := range append(readGroups, writeGroups...) {
+			if !groupExists(groups, name) {
+				return &pageInputError{http.StatusBadRequest, "Unknown group: " + name}
+			}
+		}
+	}
+	if canWrite, _ := access.CanWriteRow(page.WriteGroups); !canWrite {
+		return &pageInputError{http.StatusBadRequest, "The write groups must include one of your groups, or you could not edit the page again."}
+	}
+	return nil
+}
+
+// checkBuiltInSlug refuses to rename a built-in page, which routes such as /user/login
+// load by slug. New pages have no slug before and are not affected.
+func checkBuiltInSlug(before, after *models.Page) error {
+	if before.Slug != after.Slug && database.IsBuiltInPage(before.Slug) {
+		return &pageInputError{http.StatusBadRequest, "The " + before.Slug + " page is built in and cannot be renamed."}
+	}
+	return nil
+}
+
+// htmlContentChanged reports whether after has HTML content that before did not: its
+// scripts differ, which run under the CSP nonce whatever the format, or it is in the html
+// format and either before was not or any region differs
+func htmlContentChanged(before, after *models.Page) bool {
+	if before.Scripts != after.Scripts {
+		return true
+	}
+	if after.ContentFormat != models.PageFormatHTML {
+		return false
+	}
+	return before.ContentFormat != models.PageFormatHTML ||
+		before.Header != after.Header ||
+		before.Navigation != after.Navigation ||
+		before.MainContent != after.MainContent ||
+		before.Sidebar != after.Sidebar ||
+		before.Footer != after.Footer
+}
+
+// checkHTMLContent refuses to save HTML content or scripts, which are rendered unsanitized,
+// for editors without pages.edit_html. They can still change other fields of HTML pages.
+func checkHTMLContent(before, after *models.Page, access *database.Access) error {
+	if htmlContentChanged(before, after) && !access.HasPermission(models.PermissionPagesEditHTML) {
+		return &pageInputError{http.StatusForbidden, "Writing HTML content requires the pages.edit_html permission. Use the Markdown format, which is sanitized."}
+	}
+	return nil
+}
+
+// defaultPageFormat is the content format of new pages: html for editors who may write it,
+// markdown for everyone else
+func defaultPageFormat(access *database.Access) string {
+	if access.HasPermission(models.PermissionPagesEditHTML) {
+		return models.PageFormatHTML
+	}
+	return models.PageFormatMarkdown
+}
+
+// writablePage loads a page the user may change
+func (h *PageEditorHandler) writablePage(slug string, access *database.Access) (*models.Page, error) {
+	page, err := h.db.GetPage(slug)
+	if err != nil {
+		return nil, err
+	}
+	if canWrite, _ := access.CanWriteRow(page.WriteGroups); !canWrite {
+		return nil, errPageNotWritable
+	}
+	return page, nil
+}
+
+// createPage validates and stores a new page, returning it as saved
+func (h *PageEditorHandler) createPage(r *http.Request, input pageInput, access *database.Access) (*models.Page, error) {
+	page := &models.Page{
+		Template:      defaultPageTemplate,
+		ContentFormat: defaultPageFormat(access),
+		ReadGroups:    models.PageGroupsValue(defaultPageReadGroups),
+		WriteGroups:   models.PageGroupsValue(defaultPageWriteGroups),
+	}
+	if err := h.applyPageInput(page, input, access); err != nil {
+		return page, err
+	}
+	if _, err := h.db.CreatePage(page, h.pageAuthor(r)); err != nil {
+		if errors.Is(err, database.ErrPageSlugTaken) {
+			return page, &pageInputError{http.StatusConflict, "A page with that slug already exists."}
+		}
+		return page, err
+	}
+	h.recordAudit(r, models.AuditPageCreated, page.Slug)
+	return h.savedPage(page)
+}
+
+// updatePage applies the input to a page the user may change and saves it, returning the
+// page as saved
+func (h *PageEditorHandler) updatePage(r *http.Request, page *models.Page, input pageInput, access *database.Access) (*models.Page, error) {
+	previousSlug := page.Slug
+	if err := h.applyPageInput(page, input, access); err != nil {
+		return page, err
+	}
+	if err := h.db.UpdatePage(page, h.pageAuthor(r)); err != nil {
+		if errors.Is(err, database.ErrPageSlugTaken) {
+			return page, &pageInputError{http.StatusConflict, "A page with that slug already exists."}
+		}
+		return page, err
+	}
+	details := page.Slug
+	if page.Slug != previousSlug {
+		details += " (renamed from " + previousSlug + ")"
+	}
+	h.recordAudit(r, models.AuditPageUpdated, details)
+	return h.savedPage(page)
+}
+
+// savedPage reads a page back after saving it, so timestamps are current. The page as
+// submitted is returned with any error.
+func (h *PageEditorHandler) savedPage(page *models.Page) (*models.Page, error) {
+	saved, err := h.db.GetPage(page.Slug)
+	if err != nil {
+		return page, err
+	}
+	return saved, nil
+}
+
+// deletePage removes a page the user may change. Built-in pages cannot be deleted.
+func (h *PageEditorHandler) deletePage(r *http.Request, page *models.Page) error {
+	if database.IsBuiltInPage(page.Slug) {
+		return &pageInputError{http.StatusBadRequest, "The " + page.Slug + " page is built in and cannot be deleted. Archive it to hide it from readers."}
+	}
+	if err := h.db.DeletePage(page.ID); err != nil {
+		return err
+	}
+	h.recordAudit(r, models.AuditPageDeleted, page.Slug)
+	return nil
+}
+
+// pageAuthor identifies the signed-in user as the author of a page revision
+func (h *PageEditorHandler) pageAuthor(r *http.Request) models.PageAuthor {
+	principal := h.sm.Principal(r)
+	return models.PageAuthor{ID: principal.UserID(), Name: principal.Username()}
+}
+
+// recordAudit writes a page change made by the signed-in user to the audit log
+func (h *PageEditorHandler) recordAudit(r *http.Request, eventType, details string) {
+	recordAuditEvent(h.db, h.sm, h.logger, r, models.AuditEvent{
+		EventType: eventType,
+		Details:   details,
+	})
+}
+
+// pageEditorRow is a page in the editor's page list
+type pageEditorRow struct {
+	Page        models.Page
+	StatusLabel string
+	CanWrite    bool
+}
+
+// HandlePageList lists the pages the user can read or change, with links to edit them
+func (h *PageEditorHandler) HandlePageList(w http.ResponseWriter, r *http.Request) {
+	access, err := h.sm.Access(r)
+	if err != nil {
+		RenderMessage(w, "500 Internal Server Error", "Server Error", "error", "Failed to check page permissions.", "/", "Go Home", http.StatusInternalServerError)
+		return
+	}
+	pages, err := h.db.GetAllPages()
+	if err != nil {
+		database.LogSQLError(err)
+		http.Error(w, "Error fetching pages", http.StatusInternalServerError)
+		return
+	}
+
+	var rows []pageEditorRow
+	for _, page := range pages {
+		canRead, _ := access.CanReadRow(page.ReadGroups)
+		canWrite, _ := access.CanWriteRow(page.WriteGroups)
+		if canRead || canWrite {
+			rows = append(rows, pageEditorRow{Page: page, StatusLabel: pageStatusLabels[page.Status], CanWrite: canWrite})
+		}
+	}
+
+	contentTemplate := `<h1>Pages</h1>
+			<p><a href="/page-editor/new" class="btn btn-primary">New Page</a></p>
+			<table class="data-table">
+				<thead>
+					<tr><th>Slug</th><th>Title</th><th>Template</th><th>Status</th><th>Live</th><th>Modified</th><th>Actions</th></tr>
+				</thead>
+				<tbody>
+					{{range .}}
+					<tr>
+						<td><a href="/page/{{.Page.Slug}}">{{.Page.Slug}}</a></td>
+						<td>{{.Page.Title}}</td>
+						<td>{{.Page.Template}}</td>
+						<td>{{.StatusLabel}}</td>
+						<td>{{if .Page.IsLive}}{{if .Page.PublishedRevision.Valid}}Revision {{.Page.PublishedRevision.Int64}}{{else}}Yes{{end}}{{else}}No{{end}}</td>
+						<td>{{.Page.Modified.Format "2006-01-02 15:04:05"}}</td>
+						<td>
+							{{if .CanWrite}}
+							<a href="/page-editor/edit/{{.Page.Slug}}" class="btn btn-secondary">Edit</a>
+							<a href="/page-editor/preview/{{.Page.Slug}}" class="btn btn-secondary">Preview</a>
+							<a href="/page-editor/history/{{.Page.Slug}}" class="btn btn-secondary">History</a>
+							<form method="POST" action="/page-editor/delete/{{.Page.Slug}}" style="display: inline;" data-confirm="Delete the page {{.Page.Slug}}?">
+								<button type="submit" class="btn btn-danger">Delete</button>
+							</form>
+							{{else}}
+							<em>Read only</em>
+							{{end}}
+						</td>
+					</tr>
+					{{else}}
+					<tr><td colspan="7">No pages.</td></tr>
+					{{end}}
+				</tbody>
+			</table>`
+
+	renderContentPage(w, "Pages - Sting Ray", "Page Editor", pageEditorNavigation, contentTemplate, rows)
+}
+
+// HandleNewPage shows the form for a new page and creates the page on POST
+func (h *PageEditorHandler) HandleNewPage(w http.ResponseWriter, r *http.Request) {
+	access, err := h.sm.Access(r)
+	if err != nil {
+		RenderMessage(w, "500 Internal Server Error", "Server Error", "error", "Failed to check page permissions.", "/", "Go Home", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method != "POST" {
+		page := &models.Page{
+			Template:      defaultPageTemplate,
+			ContentFormat: defaultPageFormat(access),
+			ReadGroups:    models.PageGroupsValue(defaultPageReadGroups),
+			WriteGroups:   models.PageGroupsValue(defaultPageWriteGroups),
+		}
+		h.renderPageForm(w, r, http.StatusOK, page, true, "")
+		return
+	}
+
+	page, err := h.createPage(r, pageInputFromForm(r), access)
+	if err != nil {
+		status, message := pageErrorStatus(err)
+		h.renderPageForm(w, r, status, page, true, message)
+		return
+	}
+	http.Redirect(w, r, "/page-editor/edit/"+page.Slug+"?saved=1", http.StatusSeeOther)
+}
+
+// HandleEditPage shows the form for an existing page and saves it on POST
+func (h *PageEditorHandler) HandleEditPage(w http.ResponseWriter, r *http.Request) {
+	access, err := h.sm.Access(r)
+	if err != nil {
+		RenderMessage(w, "500 Internal Server Error", "Server Error", "error", "Failed to check page permissions.", "/", "Go Home", http.StatusInternalServerError)
+		return
+	}
+	page, err := h.writablePage(strings.TrimPrefix(r.URL.Path, "/page-editor/edit/"), access)
+	if err != nil {
+		status, message := pageErrorStatus(err)
+		RenderMessage(w, http.StatusText(status), "Cannot Edit Page", "error", message, "/page-editor", "Back to Pages", status)
+		return
+	}
+
+	if r.Method != "POST" {
+		message := ""
+		if r.URL.Query().Get("saved") != "" {
+			message = "Page saved."
+		}
+		h.renderPageForm(w, r, http.StatusOK, page, false, message)
+		return
+	}
+
+	saved, err := h.updatePage(r, page, pageInputFromForm(r), access)
+	if err != nil {
+		status, message := pageErrorStatus(err)
+		h.renderPageForm(w, r, status, saved, false, message)
+		return
+	}
+	http.Redirect(w, r, "/page-editor/edit/"+saved.Slug+"?saved=1", http.StatusSeeOther)
+}
+
+// HandleDeletePage deletes a page
+func (h *PageEditorHandler) HandleDeletePage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		RenderMessage(w, "405 Method Not Allowed", "Method Not Allowed", "error", "Only POST is allowed for this endpoint.", "/page-editor", "Back to Pages", http.StatusMethodNotAllowed)
+		return
+	}
+	access, err := h.sm.Access(r)
+	if err != nil {
+		RenderMessage(w, "500 Internal Server Error", "Server Error", "error", "Failed to check page permissions.", "/", "Go Home", http.StatusInternalServerError)
+		return
+	}
+
+	page, err := h.writablePage(strings.TrimPrefix(r.URL.Path, "/page-editor/delete/"), access)
+	if err == nil {
+		err = h.deletePage(r, page)
+	}
+	if err != nil {
+		status, message := pageErrorStatus(err)
+		RenderMessage(w, http.StatusText(status), "Cannot Delete Page", "error", message, "/page-editor", "Back to Pages", status)
+		return
+	}
+	http.Redirect(w, r, "/page-editor", http.StatusSeeOther)
+}
+
+// renderPageForm shows the page editor form filled in with page, and for existing pages its
+// publishing controls. A message is shown as an error unless status is 200.
+func (h *PageEditorHandler) renderPageForm(w http.ResponseWriter, r *http.Request, status int, page *models.Page, isNew bool, message string) {
+	templateNames, err := templates.ListLayouts()
+	if err != nil {
+		http.Error(w, "Error listing templates", http.StatusInternalServerError)
+		return
+	}
+	readGroups, _ := models.PageGroups(page.ReadGroups)
+	writeGroups, _ := models.PageGroups(page.WriteGroups)
+
+	action := "/page-editor/new"
+	if !isNew {
+		action = "/page-editor/edit/" + url.PathEscape(page.Slug)
+	}
+
+	contentTemplate := `<h1>{{if .IsNew}}New Page{{else}}Edit Page{{end}}</h1>
+			{{if .Message}}<div class="card" style="color: {{if .IsError}}#dc3545{{else}}#28a745{{end}};">{{.Message}}</div>{{end}}
+			{{if not .IsNew}}<p><a href="/page/{{.Page.Slug}}">View page</a> | <a href="/page-editor/preview/{{.Page.Slug}}">Preview draft</a> | <a href="/page-editor/history/{{.Page.Slug}}">History</a></p>
+			<div class="card">
+				<h3>Publishing</h3>
+				<p>Status: <strong>{{.StatusLabel}}</strong>.
+					{{if .Page.IsLive}}{{if .Page.PublishedRevision.Valid}}Readers see revision {{.Page.PublishedRevision.Int64}}{{if ne .Page.Status "published"}}; this draft has unpublished changes{{end}}.{{else}}Readers see the content below.{{end}}{{else}}Readers cannot see this page.{{end}}
+					{{if .Page.PublishAt.Valid}}Publishes at {{.Page.PublishAt.Time.Format "2006-01-02 15:04"}}.{{end}}
+					{{if .Page.UnpublishAt.Valid}}Archives at {{.Page.UnpublishAt.Time.Format "2006-01-02 15:04"}}.{{end}}</p>
+				{{range .Transitions}}
+				<form method="POST" action="/page-editor/status/{{$.Page.Slug}}" style="display: inline;"{{if .Confirm}} data-confirm="{{.Confirm}}"{{end}}>
+					<input type="hidden" name="status" value="{{.Status}}">
+					<button type="submit" class="btn {{.Class}}">{{.Label}}</button>
+				</form>
+				{{end}}
+				{{if .CanPublish}}
+				<form method="POST" action="/page-editor/schedule/{{.Page.Slug}}" style="margin-top: 1rem;">
+					<div class="form-group">
+						<label for="publish_at">Publish at (server time; empty for no scheduled publish)</label>
+						<input type="datetime-local" id="publish_at" name="publish_at" value="{{.PublishAt}}">
+					</div>
+					<div class="form-group">
+						<label for="unpublish_at">Archive at (server time; empty for no scheduled archive)</label>
+						<input type="datetime-local" id="unpublish_at" name="unpublish_at" value="{{.UnpublishAt}}">
+					</div>
+					<button type="submit" class="btn btn-secondary">Save Schedule</button>
+				</form>
+				{{end}}
+			</div>{{end}}
+			<form method="POST" action="{{.Action}}">
+				<div class="form-group">
+					<label for="slug">Slug</label>
+					<input type="text" id="slug" name="slug" value="{{.Page.Slug}}" required pattern="[a-z0-9_\-]+">
+				</div>
+				<div class="form-group">
+					<label for="title">Title</label>
+					<input type="text" id="title" name="title" value="{{.Page.Title}}" required>
+				</div>
+				<div class="form-group">
+					<label for="meta_description">Meta Description</label>
+					<input type="text" id="meta_description" name="meta_description" value="{{.Page.MetaDescription}}">
+				</div>
+				<div class="form-group">
+					<label for="template">Template</label>
+					<select id="template" name="template">
+						{{range .Templates}}<option value="{{.}}"{{if eq . $.Page.Template}} selected{{end}}>{{.}}</option>{{end}}
+					</select>
+				</div>
+				<div class="form-group">
+					<label for="content_format">Content Format</label>
+					<select id="content_format" name="content_format">
+						<option value="html"{{if ne .Page.ContentFormat "markdown"}} selected{{end}}>HTML{{if not .CanEditHTML}} (changing the content requires pages.edit_html){{end}}</option>
+						<option value="markdown"{{if eq .Page.ContentFormat "markdown"}} selected{{end}}>Markdown (CommonMark with tables; HTML is sanitized)</option>
+					</select>
+				</div>
+				<div class="form-group">
+					<label for="css_class">CSS Class</label>
+					<input type="text" id="css_class" name="css_class" value="{{.Page.CSSClass}}">
+				</div>
+				<div class="form-group">
+					<label for="read_groups">Read Groups (comma-separated; empty means anyone)</label>
+					<input type="text" id="read_groups" name="read_groups" value="{{.ReadGroups}}">
+				</div>
+				<div class="form-group">
+					<label for="write_groups">Write Groups (comma-separated; empty means any page editor)</label>
+					<input type="text" id="write_groups" name="write_groups" value="{{.WriteGroups}}">
+				</div>
+				<div class="form-group">
+					<label for="header">Header</label>
+					<textarea id="header" name="header" rows="3">{{.Page.Header}}</textarea>
+				</div>
+				<div class="form-group">
+					<label for="navigation">Navigation</label>
+					<textarea id="navigation" name="navigation" rows="3">{{.Page.Navigation}}</textarea>
+				</div>
+				<div class="form-group">
+					<label for="main_content">Main Content</label>
+					<textarea id="main_content" name="main_content" rows="16">{{.Page.MainContent}}</textarea>
+				</div>
+				<div class="form-group">
+					<label for="sidebar">Sidebar</label>
+					<textarea id="sidebar" name="sidebar" rows="6">{{.Page.Sidebar}}</textarea>
+				</div>
+				<div class="form-group">
+					<label for="footer">Footer</label>
+					<textarea id="footer" name="footer" rows="3">{{.Page.Footer}}</textarea>
+				</div>
+				<button type="submit" class="btn btn-primary">{{if .IsNew}}Create Page{{else}}Save Page{{end}}</button>
+				<a href="/page-editor" class="btn btn-secondary">Cancel</a>
+			</form>`
+
+	canPublish := h.sm.Can(r, models.PermissionPagesPublish)
+	scheduleTime := func(t sql.NullTime) string {
+		if !t.Valid {
+			return ""
+		}
+		return t.Time.Local().Format(scheduleTimeLayout)
+	}
+
+	contentData := map[string]interface{}{
+		"Page":        page,
+		"IsNew":       isNew,
+		"Action":      action,
+		"Templates":   templateNames,
+		"ReadGroups":  strings.Join(readGroups, ", "),
+		"WriteGroups": strings.Join(writeGroups, ", "),
+		"Message":     message,
+		"IsError":     status != http.StatusOK,
+		"StatusLabel": pageStatusLabels[page.Status],
+		"Transitions": pageTransitions(page, canPublish),
+		"CanPublish":  canPublish,
+		"CanEditHTML": h.sm.Can(r, models.PermissionPagesEditHTML),
+		"PublishAt":   scheduleTime(page.PublishAt),
+		"UnpublishAt": scheduleTime(page.UnpublishAt),
+	}
+
+	title := "Edit Page - Sting Ray"
+	if isNew {
+		title = "New Page - Sting Ray"
+	}
+	renderContentPageStatus(w, status, title, "Page Editor", pageEditorNavigation, contentTemplate, contentData)
+}
+
+// apiPage is the API representation of a page
+type apiPage struct {
+	ID                int      `json:"id"`
+	Slug              string   `json:"slug"`
+	Title             string   `json:"title"`
+	MetaDescription   string   `json:"meta_description"`
+	Template          string   `json:"template"`
+	ContentFormat     string   `json:"content_format"`
+	Header            string   `json:"header"`
+	Navigation        string   `json:"navigation"`
+	MainContent       string   `json:"main_content"`
+	Sidebar           string   `json:"sidebar"`
+	Footer            string   `json:"footer"`
+	CSSClass          string   `json:"css_class"`
+	ReadGroups        []string `json:"read_groups"`
+	WriteGroups       []string `json:"write_groups"`
+	CanWrite          bool     `json:"can_write"`
+	Status            string   `json:"status"`
+	Live              bool     `json:"live"`
+	PublishedRevision int      `json:"published_revision,omitempty"`
+	PublishAt         string   `json:"publish_at,omitempty"`
+	UnpublishAt       string   `json:"unpublish_at,omitempty"`
+	Created           string   `json:"created"`
+	Modified          string   `json:"modified"`
+}
+
+// newAPIPage converts a page for an API response
+func newAPIPage(page *models.Page, access *database.Access) apiPage {
+	readGroups, _ := models.PageGroups(page.ReadGroups)
+	writeGroups, _ := models.PageGroups(page.WriteGroups)
+	canWrite, _ := access.CanWriteRow(page.WriteGroups)
+	scheduleTime := func(t sql.NullTime) string {
+		if !t.Valid {
+			return ""
+		}
+		return t.Time.Format(time.RFC3339)
+	}
+	return apiPage{
+		ID:                page.ID,
+		Slug:              page.Slug,
+		Title:             page.Title,
+		MetaDescription:   page.MetaDescription,
+		Template:          page.Template,
+		ContentFormat:     page.ContentFormat,
+		Header:            page.Header,
+		Navigation:        page.Navigation,
+		MainContent:       page.MainContent,
+		Sidebar:           page.Sidebar,
+		Footer:            page.Footer,
+		CSSClass:          page.CSSClass,
+		ReadGroups:        readGroups,
+		WriteGroups:       writeGroups,
+		CanWrite:          canWrite,
+		Status:            page.Status,
+		Live:              page.IsLive(),
+		PublishedRevision: int(page.PublishedRevision.Int64),
+		PublishAt:         scheduleTime(page.PublishAt),
+		UnpublishAt:       scheduleTime(page.UnpublishAt),
+		Created:           page.Created.Format("2006-01-02 15:04:05"),
+		Modified:          page.Modified.Format("2006-01-02 15:04:05"),
+	}
+}
+
+// apiAccess checks that the API caller is signed in and holds pages.edit, writing the
+// error response when not
+func (h *PageEditorHandler) apiAccess(w http.ResponseWriter, r *http.Request) (*database.Access, bool) {
+	if !h.sm.IsAuthenticated(r) {
+		writeAPIError(w, http.StatusUnauthorized, "Authentication required")
+		return nil, false
+	}
+	access, err := h.sm.Access(r)
+	if err != nil {
+		writeAPIStoreError(w, err, "", "Failed to check permissions")
+		return nil, false
+	}
+	if !h.sm.Can(r, models.PermissionPagesEdit) {
+		writeAPIError(w, http.StatusForbidden, "The pages.edit permission is required")
+		return nil, false
+	}
+	return access, true
+}
+
+// writeAPIPageError writes the API response for an error from loading or saving a page
+func writeAPIPageError(w http.ResponseWriter, err error) {
+	status, message := pageErrorStatus(err)
+	writeAPIError(w, status, message)
+}
+
+// HandleAPIPages lists the pages the caller can read on GET and creates a page on POST
+// (requires pages.edit).
+//
+// POST body: {"slug", "title", "meta_description", "template", "content_format", "header",
+// "navigation", "main_content", "sidebar", "footer", "css_class", "read_groups": [...],
+// "write_groups": [...]}. content_format is "html" (the default) or "markdown".
+func (h *PageEditorHandler) HandleAPIPages(w http.ResponseWriter, r *http.Request) {
+	access, ok := h.apiAccess(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		pages, err := h.db.GetAllPages()
+		if err != nil {
+			writeAPIStoreError(w, err, "", "Failed to retrieve pages")
+			return
+		}
+		result := []apiPage{}
+		for i := range pages {
+			if canRead, _ := access.CanReadRow(pages[i].ReadGroups); canRead {
+				result = append(result, newAPIPage(&pages[i], access))
+			}
+		}
+		writeAPIResponse(w, http.StatusOK, APIResponse{Success: true, Data: result})
+	case "POST":
+		var input pageInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		page, err := h.createPage(r, input, access)
+		if err != nil {
+			writeAPIPageError(w, err)
+			return
+		}
+		writeAPIResponse(w, http.StatusCreated, APIResponse{Success: true, Message: "Page created successfully", Data: newAPIPage(page, access)})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleAPIPage manages a single page (requires pages.edit):
+//
+//	GET    /api/pages/{slug}                              the page, if the caller is in its read groups
+//	PUT    /api/pages/{slug}                              fields as for POST /api/pages; omitted fields are unchanged
+//	DELETE /api/pages/{slug}                              delete the page
+//	GET    /api/pages/{slug}/revisions                    the page's revisions, newest first
+//	GET    /api/pages/{slug}/revisions/{revision}         one revision with its full snapshot
+//	POST   /api/pages/{slug}/revisions/{revision}/restore restore a revision as a new revision
+//	POST   /api/pages/{slug}/status                       {"status": "draft"|"in_review"|"published"|"archived"}
+//	POST   /api/pages/{slug}/schedule                     {"publish_at": RFC 3339 or null, "unpublish_at": RFC 3339 or null}
+//
+// Everything except reading the page requires membership of its write groups. Publishing,
+// archiving and scheduling also require pages.publish.
+func (h *PageEditorHandler) HandleAPIPage(w http.ResponseWriter, r *http.Request) {
+	access, ok := h.apiAccess(w, r)
+	if !ok {
+		return
+	}
+	pathParts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/pages/"), "/"), "/")
+	slug := pathParts[0]
+
+	switch {
+	case len(pathParts) == 1 && r.Method == "GET":
+		h.writeAPIPage(w, slug, access)
+	case len(pathParts) == 1 && r.Method == "PUT":
+		h.updateAPIPage(w, r, slug, access)
+	case len(pathParts) == 1 && r.Method == "DELETE":
+		page, err := h.writablePage(slug, access)
+		if err == nil {
+			err = h.deletePage(r, page)
+		}
+		if err != nil {
+			writeAPIPageError(w, err)
+			return
+		}
+		writeAPIResponse(w, http.StatusOK, APIResponse{Success: true, Message: "Page deleted successfully"})
+	case len(pathParts) == 2 && pathParts[1] == "revisions" && r.Method == "GET":
+		h.writeAPIRevisions(w, slug, access)
+	case len(pathParts) == 3 && pathParts[1] == "revisions" && r.Method == "GET":
+		h.writeAPIRevision(w, slug, pathParts[2], access)
+	case len(pathParts) == 4 && pathParts[1] == "revisions" && pathParts[3] == "restore" && r.Method == "POST":
+		h.restoreAPIRevision(w, r, slug, pathParts[2], access)
+	case len(pathParts) == 2 && pathParts[1] == "status" && r.Method == "POST":
+		h.setAPIPageStatus(w, r, slug, access)
+	case len(pathParts) == 2 && pathParts[1] == "schedule" && r.Method == "POST":
+		h.scheduleAPIPage(w, r, slug, access)
+	default:
+		writeAPIError(w, http.StatusNotFound, "Unknown page endpoint or method")
+	}
+}
+
+// writeAPIPage responds with a page the caller can read
+func (h *PageEditorHandler) writeAPIPage(w http.ResponseWriter, slug string, access *database.Access) {
+	page, err := h.db.GetPage(slug)
+	if err != nil {
+		writeAPIPageError(w, err)
+		return
+	}
+	if canRead, _ := access.CanReadRow(page.ReadGroups); !canRead {
+		writeAPIError(w, http.StatusForbidden, "You are not in this page's read groups.")
+		return
+	}
+	writeAPIResponse(w, http.StatusOK, APIResponse{Success: true, Data: newAPIPage(page, access)})
+}
+
+func (h *PageEditorHandler) updateAPIPage(w http.ResponseWriter, r *http.Request, slug string, access *database.Access) {
+	var input pageInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	page, err := h.writablePage(slug, access)
+	if err == nil {
+		page, err = h.updatePage(r, page, input, access)
+	}
+	if err != nil {
+		writeAPIPageError(w, err)
+		return
+	}
+	writeAPIResponse(w, http.StatusOK, APIResponse{Success: true, Message: "Page updated successfully", Data: newAPIPage(page, access)})
+}
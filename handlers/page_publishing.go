@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"stingray/database"
+	"stingray/models"
+	"stingray/templates"
+	"strings"
+	"time"
+)
+
+// pageStatusLabels names the page states for display
+var pageStatusLabels = map[string]string{
+	models.PageStatusDraft:     "Draft",
+	models.PageStatusInReview:  "In review",
+	models.PageStatusPublished: "Published",
+	models.PageStatusArchived:  "Archived",
+}
+
+// scheduleTimeLayout is the format of datetime-local form inputs
+const scheduleTimeLayout = "2006-01-02T15:04"
+
+// pageTransition is a button moving a page to another state
+type pageTransition struct {
+	Status  string
+	Label   string
+	Class   string
+	Confirm string
+}
+
+// canSetPageStatus reports whether a page can move from one state to another. Page editors
+// can submit drafts for review and take them back; publishing, archiving and reopening
+// archived pages need pages.publish.
+func canSetPageStatus(from, to string, canPublish bool) bool {
+	if canPublish {
+		return true
+	}
+	isDraft := func(status string) bool {
+		return status == models.PageStatusDraft || status == models.PageStatusInReview
+	}
+	return isDraft(from) && isDraft(to)
+}
+
+// pageTransitions lists the state changes offered for a page
+func pageTransitions(page *models.Page, canPublish bool) []pageTransition {
+	var transitions []pageTransition
+	for _, transition := range []pageTransition{
+		{models.PageStatusInReview, "Submit for Review", "btn-secondary", ""},
+		{models.PageStatusDraft, "Return to Draft", "btn-secondary", ""},
+		{models.PageStatusPublished, "Publish", "btn-primary", ""},
+		{models.PageStatusArchived, "Archive", "btn-danger", "Archive this page? Readers will no longer see it until it is published again."},
+	} {
+		if transition.Status != page.Status && canSetPageStatus(page.Status, transition.Status, canPublish) {
+			transitions = append(transitions, transition)
+		}
+	}
+	return transitions
+}
+
+// setPageStatus moves a page the user may change to another state
+func (h *PageEditorHandler) setPageStatus(r *http.Request, page *models.Page, status string) (*models.Page, error) {
+	if !models.ValidPageStatus(status) {
+		return page, &pageInputError{http.StatusBadRequest, "Unknown page status: " + status}
+	}
+	if !canSetPageStatus(page.Status, status, h.sm.Can(r, models.PermissionPagesPublish)) {
+		return page, &pageInputError{http.StatusForbidden, "Publishing and archiving pages requires the pages.publish permission."}
+	}
+	if err := h.db.SetPageStatus(page.ID, status); err != nil {
+		return page, err
+	}
+	if status == models.PageStatusPublished {
+		h.recordAudit(r, models.AuditPagePublished, page.Slug)
+	} else {
+		h.recordAudit(r, models.AuditPageStatusChanged, page.Slug+": "+page.Status+" -> "+status)
+	}
+	return h.savedPage(page)
+}
+
+// schedulePage sets when a page the user may change is published and archived
+func (h *PageEditorHandler) schedulePage(r *http.Request, page *models.Page, publishAt, unpublishAt sql.NullTime) (*models.Page, error) {
+	if !h.sm.Can(r, models.PermissionPagesPublish) {
+		return page, &pageInputError{http.StatusForbidden, "Scheduling pages requires the pages.publish permission."}
+	}
+	if publishAt.Valid && unpublishAt.Valid && !unpublishAt.Time.After(publishAt.Time) {
+		return page, &pageInputError{http.StatusBadRequest, "The page must be archived after it is published."}
+	}
+	if err := h.db.SchedulePage(page.ID, publishAt, unpublishAt); err != nil {
+		return page, err
+	}
+	details := page.Slug
+	if publishAt.Valid {
+		details += ", publish " + publishAt.Time.Format("2006-01-02 15:04")
+	}
+	if unpublishAt.Valid {
+		details += ", archive " + unpublishAt.Time.Format("2006-01-02 15:04")
+	}
+	if !publishAt.Valid && !unpublishAt.Valid {
+		details += ", schedule cleared"
+	}
+	h.recordAudit(r, models.AuditPageScheduled, details)
+	return h.savedPage(page)
+}
+
+// parseScheduleTime reads a scheduled time in layout, in server local time unless the layout
+// carries a zone. An empty value cancels the schedule.
+func parseScheduleTime(value, layout string) (sql.NullTime, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return sql.NullTime{}, nil
+	}
+	parsed, err := time.ParseInLocation(layout, value, time.Local)
+	if err != nil {
+		return sql.NullTime{}, &pageInputError{http.StatusBadRequest, "Invalid time: " + value}
+	}
+	return sql.NullTime{Time: parsed, Valid: true}, nil
+}
+
+// HandlePreviewPage shows the working draft of a page as readers would see it once
+// published. Only members of the page's write groups can preview it.
+func (h *PageEditorHandler) HandlePreviewPage(w http.ResponseWriter, r *http.Request) {
+	access, err := h.sm.Access(r)
+	if err != nil {
+		RenderMessage(w, "500 Internal Server Error", "Server Error", "error", "Failed to check page permissions.", "/", "Go Home", http.StatusInternalServerError)
+		return
+	}
+	page, err := h.writablePage(strings.TrimPrefix(r.URL.Path, "/page-editor/preview/"), access)
+	if err != nil {
+		status, message := pageErrorStatus(err)
+		RenderMessage(w, http.StatusText(status), "Cannot Preview Page", "error", message, "/page-editor", "Back to Pages", status)
+		return
+	}
+
+	html, err := templates.RenderPage(page, CSPNonce(r))
+	if err != nil {
+		http.Error(w, "Error rendering page", http.StatusInternalServerError)
+		return
+	}
+
+	// Drafts must not be cached or indexed under the preview address
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(html))
+}
+
+// HandlePageStatus moves a page to the state in the form's status field
+func (h *PageEditorHandler) HandlePageStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		RenderMessage(w, "405 Method Not Allowed", "Method Not Allowed", "error", "Only POST is allowed for this endpoint.", "/page-editor", "Back to Pages", http.StatusMethodNotAllowed)
+		return
+	}
+	access, err := h.sm.Access(r)
+	if err != nil {
+		RenderMessage(w, "500 Internal Server Error", "Server Error", "error", "Failed to check page permissions.", "/", "Go Home", http.StatusInternalServerError)
+		return
+	}
+
+	page, err := h.writablePage(strings.TrimPrefix(r.URL.Path, "/page-editor/status/"), access)
+	if err == nil {
+		page, err = h.setPageStatus(r, page, r.FormValue("status"))
+	}
+	if err != nil {
+		status, message := pageErrorStatus(err)
+		RenderMessage(w, http.StatusText(status), "Cannot Change Page Status", "error", message, "/page-editor", "Back to Pages", status)
+		return
+	}
+	http.Redirect(w, r, "/page-editor/edit/"+page.Slug+"?saved=1", http.StatusSeeOther)
+}
+
+// HandleSchedulePage sets the times a page is published and archived from the form's
+// publish_at and unpublish_at fields
+func (h *PageEditorHandler) HandleSchedulePage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		RenderMessage(w, "405 Method Not Allowed", "Method Not Allowed", "error", "Only POST is allowed for this endpoint.", "/page-editor", "Back to Pages", http.StatusMethodNotAllowed)
+		return
+	}
+	access, err := h.sm.Access(r)
+	if err != nil {
+		RenderMessage(w, "500 Internal Server Error", "Server Error", "error", "Failed to check page permissions.", "/", "Go Home", http.StatusInternalServerError)
+		return
+	}
+
+	page, err := h.writablePage(strings.TrimPrefix(r.URL.Path, "/page-editor/schedule/"), access)
+	if err == nil {
+		var publishAt, unpublishAt sql.NullTime
+		if publishAt, err = parseScheduleTime(r.FormValue("publish_at"), scheduleTimeLayout); err == nil {
+			if unpublishAt, err = parseScheduleTime(r.FormValue("unpublish_at"), scheduleTimeLayout); err == nil {
+				page, err = h.schedulePage(r, page, publishAt, unpublishAt)
+			}
+		}
+	}
+	if err != nil {
+		status, message := pageErrorStatus(err)
+		RenderMessage(w, http.StatusText(status), "Cannot Schedule Page", "error", message, "/page-editor", "Back to Pages", status)
+		return
+	}
+	http.Redirect(w, r, "/page-editor/edit/"+page.Slug+"?saved=1", http.StatusSeeOther)
+}
+
+// setAPIPageStatus moves a page the caller may change to the state in the request body,
+// e.g. {"status": "published"}
+func (h *PageEditorHandler) setAPIPageStatus(w http.ResponseWriter, r *http.Request, slug string, access *database.Access) {
+	var input struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	page, err := h.writablePage(slug, access)
+	if err == nil {
+		page, err = h.setPageStatus(r, page, input.Status)
+	}
+	if err != nil {
+		writeAPIPageError(w, err)
+		return
+	}
+	writeAPIResponse(w, http.StatusOK, APIResponse{Success: true, Message: "Page status updated successfully", Data: newAPIPage(page, access)})
+}
+
+// scheduleAPIPage sets the times a page the caller may change is published and archived
+// from RFC 3339 times in the request body, e.g. {"publish_at": "2025-06-01T09:00:00Z",
+// "unpublish_at": null}. Omitted or null times cancel that part of the schedule.
+func (h *PageEditorHandler) scheduleAPIPage(w http.ResponseWriter, r *http.Request, slug string, access *database.Access) {
+	var input struct {
+		PublishAt   string `json:"publish_at"`
+		UnpublishAt string `json:"unpublish_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	page, err := h.writablePage(slug, access)
+	if err == nil {
+		var publishAt, unpublishAt sql.NullTime
+		if publishAt, err = parseScheduleTime(input.PublishAt, time.RFC3339); err == nil {
+			if unpublishAt, err = parseScheduleTime(input.UnpublishAt, time.RFC3339); err == nil {
+				page, err = h.schedulePage(r, page, publishAt, unpublishAt)
+			}
+		}
+	}
+	if err != nil {
+		writeAPIPageError(w, err)
+		return
+	}
+	writeAPIResponse(w, http.StatusOK, APIResponse{Success: true, Message: "Page schedule updated successfully", Data: newAPIPage(page, access)})
+}
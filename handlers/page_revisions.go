@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"stingray/database"
+	"stingray/diff"
+	"stingray/models"
+	"strconv"
+	"strings"
+)
+
+// pageRegions lists the parts of a page compared between revisions, in display order
+var pageRegions = []struct {
+	Name  string
+	Value func(page *models.Page) string
+}{
+	{"Slug", func(page *models.Page) string { return page.Slug }},
+	{"Title", func(page *models.Page) string { return page.Title }},
+	{"Meta Description", func(page *models.Page) string { return page.MetaDescription }},
+	{"Template", func(page *models.Page) string { return page.Template }},
+	{"Content Format", func(page *models.Page) string { return page.ContentFormat }},
+	{"CSS Class", func(page *models.Page) string { return page.CSSClass }},
+	{"Read Groups", func(page *models.Page) string { return pageGroupsText(page.ReadGroups) }},
+	{"Write Groups", func(page *models.Page) string { return pageGroupsText(page.WriteGroups) }},
+	{"Header", func(page *models.Page) string { return page.Header }},
+	{"Navigation", func(page *models.Page) string { return page.Navigation }},
+	{"Main Content", func(page *models.Page) string { return page.MainContent }},
+	{"Sidebar", func(page *models.Page) string { return page.Sidebar }},
+	{"Footer", func(page *models.Page) string { return page.Footer }},
+	{"Scripts", func(page *models.Page) string { return page.Scripts }},
+}
+
+// pageGroupsText shows a page's group list as comma-separated names
+func pageGroupsText(groups sql.NullString) string {
+	names, err := models.PageGroups(groups)
+	if err != nil {
+		return groups.String
+	}
+	return strings.Join(names, ", ")
+}
+
+// regionDiff is the side-by-side comparison of one region of a page
+type regionDiff struct {
+	Name    string
+	Rows    []diff.Row
+	Changed bool
+}
+
+// diffPages compares every region of two snapshots of a page
+func diffPages(from, to *models.Page) []regionDiff {
+	regions := make([]regionDiff, 0, len(pageRegions))
+	for _, region := range pageRegions {
+		rows := diff.Lines(region.Value(from), region.Value(to))
+		regions = append(regions, regionDiff{Name: region.Name, Rows: rows, Changed: diff.HasChanges(rows)})
+	}
+	return regions
+}
+
+// editorAccess loads the user's group set for a page editor request, rendering an error
+// page when it cannot be loaded
+func (h *PageEditorHandler) editorAccess(w http.ResponseWriter, r *http.Request) (*database.Access, bool) {
+	access, err := h.sm.Access(r)
+	if err != nil {
+		RenderMessage(w, "500 Internal Server Error", "Server Error", "error", "Failed to check page permissions.", "/", "Go Home", http.StatusInternalServerError)
+		return nil, false
+	}
+	return access, true
+}
+
+// HandlePageHistory lists a page's revisions with forms to compare and restore them
+func (h *PageEditorHandler) HandlePageHistory(w http.ResponseWriter, r *http.Request) {
+	access, ok := h.editorAccess(w, r)
+	if !ok {
+		return
+	}
+	page, err := h.writablePage(strings.TrimPrefix(r.URL.Path, "/page-editor/history/"), access)
+	if err != nil {
+		status, message := pageErrorStatus(err)
+		RenderMessage(w, http.StatusText(status), "Cannot Show History", "error", message, "/page-editor", "Back to Pages", status)
+		return
+	}
+	revisions, err := h.db.GetPageRevisions(page.ID)
+	if err != nil {
+		database.LogSQLError(err)
+		http.Error(w, "Error fetching revisions", http.StatusInternalServerError)
+		return
+	}
+
+	contentTemplate := `<h1>History of {{.Page.Title}}</h1>
+			<p><a href="/page-editor/edit/{{.Page.Slug}}">Edit page</a> | <a href="/page/{{.Page.Slug}}">View page</a></p>
+			{{if .Revisions}}
+			<form method="GET" action="/page-editor/diff/{{.Page.Slug}}">
+				<table class="data-table">
+					<thead>
+						<tr><th>From</th><th>To</th><th>Revision</th><th>Saved</th><th>Author</th><th>Note</th><th>Actions</th></tr>
+					</thead>
+					<tbody>
+						{{range $i, $revision := .Revisions}}
+						<tr>
+							<td><input type="radio" name="from" value="{{.Revision}}"{{if eq $i 1}} checked{{end}}></td>
+							<td><input type="radio" name="to" value="{{.Revision}}"{{if eq $i 0}} checked{{end}}></td>
+							<td>{{.Revision}}{{if eq $i 0}} <em>(current)</em>{{end}}{{if and $.Page.PublishedRevision.Valid (eq .Revision $.Page.PublishedRevision.Int64)}} <em>(live)</em>{{end}}</td>
+							<td>{{.Created.Format "2006-01-02 15:04:05"}}</td>
+							<td>{{if .Author.Name}}{{.Author.Name}}{{else}}<em>system</em>{{end}}</td>
+							<td>{{.Note}}</td>
+							<td>
+								{{if ne $i 0}}
+								<button type="submit" form="restore-{{.Revision}}" class="btn btn-secondary">Restore</button>
+								{{end}}
+							</td>
+						</tr>
+						{{end}}
+					</tbody>
+				</table>
+				<p><button type="submit" class="btn btn-primary">Compare Selected Revisions</button></p>
+			</form>
+			{{range $i, $revision := .Revisions}}{{if ne $i 0}}
+			<form id="restore-{{.Revision}}" method="POST" action="/page-editor/restore/{{$.Page.Slug}}" data-confirm="Restore revision {{.Revision}}? The current content stays in the history.">
+				<input type="hidden" name="revision" value="{{.Revision}}">
+			</form>
+			{{end}}{{end}}
+			{{else}}
+			<p>No revisions yet. The next save records the current content and the change.</p>
+			{{end}}`
+
+	contentData := map[string]interface{}{
+		"Page":      page,
+		"Revisions": revisions,
+	}
+	renderContentPage(w, "Page History - Sting Ray", "Page Editor", pageEditorNavigation, contentTemplate, contentData)
+}
+
+// HandlePageDiff shows two revisions of a page side by side, region by region. Without
+// from and to, the latest revision is compared with the one before it.
+func (h *PageEditorHandler) HandlePageDiff(w http.ResponseWriter, r *http.Request) {
+	access, ok := h.editorAccess(w, r)
+	if !ok {
+		return
+	}
+	page, err := h.writablePage(strings.TrimPrefix(r.URL.Path, "/page-editor/diff/"), access)
+	if err != nil {
+		status, message := pageErrorStatus(err)
+		RenderMessage(w, http.StatusText(status), "Cannot Compare Revisions", "error", message, "/page-editor", "Back to Pages", status)
+		return
+	}
+	historyURL := "/page-editor/history/" + url.PathEscape(page.Slug)
+
+	revisions, err := h.db.GetPageRevisions(page.ID)
+	if err != nil {
+		database.LogSQLError(err)
+		http.Error(w, "Error fetching revisions", http.StatusInternalServerError)
+		return
+	}
+	if len(revisions) < 2 {
+		RenderMessage(w, "Nothing to Compare", "Nothing to Compare", "error", "This page has fewer than two revisions.", historyURL, "Back to History", http.StatusNotFound)
+		return
+	}
+
+	from, to := revisions[1].Revision, revisions[0].Revision
+	if value := r.URL.Query().Get("from"); value != "" {
+		from, err = strconv.Atoi(value)
+	}
+	if value := r.URL.Query().Get("to"); err == nil && value != "" {
+		to, err = strconv.Atoi(value)
+	}
+	if err != nil {
+		RenderMessage(w, "Invalid Revision", "Invalid Revision", "error", "Revisions are numbers.", historyURL, "Back to History", http.StatusBadRequest)
+		return
+	}
+
+	var fromRevision, toRevision *models.PageRevision
+	for i := range revisions {
+		if revisions[i].Revision == from {
+			fromRevision = &revisions[i]
+		}
+		if revisions[i].Revision == to {
+			toRevision = &revisions[i]
+		}
+	}
+	if fromRevision == nil || toRevision == nil {
+		RenderMessage(w, "Revision Not Found", "Revision Not Found", "error", "The page has no such revision.", historyURL, "Back to History", http.StatusNotFound)
+		return
+	}
+
+	contentTemplate := `<style>
+				.diff-table { width: 100%; border-collapse: collapse; table-layout: fixed; font-family: monospace; font-size: 0.85rem; margin-bottom: 2rem; }
+				.diff-table td { padding: 0.15rem 0.5rem; vertical-align: top; white-space: pre-wrap; word-break: break-all; border-bottom: 1px solid #f1f3f5; }
+				.diff-table td.line { width: 3rem; color: #adb5bd; text-align: right; }
+				.diff-removed .left, .diff-changed .left { background: #ffecec; }
+				.diff-added .right, .diff-changed .right { background: #eaffea; }
+			</style>
+			<h1>{{.Page.Title}}: revision {{.From.Revision}} to {{.To.Revision}}</h1>
+			<p><a href="{{.HistoryURL}}">Back to history</a></p>
+			<table class="data-table">
+				<tr><th></th><th>Revision {{.From.Revision}}</th><th>Revision {{.To.Revision}}</th></tr>
+				<tr><td>Saved</td><td>{{.From.Created.Format "2006-01-02 15:04:05"}}</td><td>{{.To.Created.Format "2006-01-02 15:04:05"}}</td></tr>
+				<tr><td>Author</td><td>{{or .From.Author.Name "system"}}</td><td>{{or .To.Author.Name "system"}}</td></tr>
+				<tr><td>Note</td><td>{{.From.Note}}</td><td>{{.To.Note}}</td></tr>
+			</table>
+			{{range .Regions}}{{if .Changed}}
+			<h2>{{.Name}}</h2>
+			<table class="diff-table">
+				{{range .Rows}}
+				<tr class="diff-{{.Kind}}">
+					<td class="line">{{if .LeftLine}}{{.LeftLine}}{{end}}</td><td class="left">{{.Left}}</td>
+					<td class="line">{{if .RightLine}}{{.RightLine}}{{end}}</td><td class="right">{{.Right}}</td>
+				</tr>
+				{{end}}
+			</table>
+			{{end}}{{end}}
+			{{if .Unchanged}}<p>Unchanged: {{.Unchanged}}</p>{{end}}`
+
+	regions := diffPages(&fromRevision.Snapshot, &toRevision.Snapshot)
+	var unchanged []string
+	for _, region := range regions {
+		if !region.Changed {
+			unchanged = append(unchanged, region.Name)
+		}
+	}
+	contentData := map[string]interface{}{
+		"Page":       page,
+		"From":       fromRevision,
+		"To":         toRevision,
+		"Regions":    regions,
+		"Unchanged":  strings.Join(unchanged, ", "),
+		"HistoryURL": historyURL,
+	}
+	renderContentPage(w, "Compare Revisions - Sting Ray", "Page Editor", pageEditorNavigation, contentTemplate, contentData)
+}
+
+// HandleRestoreRevision makes an earlier revision the page's content again, recorded as a
+// new revision
+func (h *PageEditorHandler) HandleRestoreRevision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		RenderMessage(w, "405 Method Not Allowed", "Method Not Allowed", "error", "Only POST is allowed for this endpoint.", "/page-editor", "Back to Pages", http.StatusMethodNotAllowed)
+		return
+	}
+	access, ok := h.editorAccess(w, r)
+	if !ok {
+		return
+	}
+
+	page, err := h.writablePage(strings.TrimPrefix(r.URL.Path, "/page-editor/restore/"), access)
+	if err == nil {
+		var revision int
+		if revision, err = strconv.Atoi(r.FormValue("revision")); err != nil {
+			err = &pageInputError{http.StatusBadRequest, "Revisions are numbers."}
+		} else {
+			page, err = h.restoreRevision(r, page, revision, access)
+		}
+	}
+	if err != nil {
+		status, message := pageErrorStatus(err)
+		RenderMessage(w, http.StatusText(status), "Cannot Restore Revision", "error", message, "/page-editor", "Back to Pages", status)
+		return
+	}
+	http.Redirect(w, r, "/page-editor/history/"+url.PathEscape(page.Slug), http.StatusSeeOther)
+}
+
+// restoreRevision restores an earlier revision of a page the user may change, returning
+// the page as saved. The restored write groups must still include the user.
+func (h *PageEditorHandler) restoreRevision(r *http.Request, page *models.Page, revision int, access *database.Access) (*models.Page, error) {
+	snapshot, err := h.db.GetPageRevision(page.ID, revision)
+	if err != nil {
+		return nil, err
+	}
+	if canWrite, _ := access.CanWriteRow(snapshot.Snapshot.WriteGroups); !canWrite {
+		return nil, &pageInputError{http.StatusBadRequest, "That revision's write groups do not include any of your groups, so you could not edit the page again."}
+	}
+	if err := checkHTMLContent(page, &snapshot.Snapshot, access); err != nil {
+		return nil, err
+	}
+	if err := checkBuiltInSlug(page, &snapshot.Snapshot); err != nil {
+		return nil, err
+	}
+	if _, err := h.db.RestorePageRevision(page.ID, revision, h.pageAuthor(r)); err != nil {
+		if err == database.ErrPageSlugTaken {
+			return nil, &pageInputError{http.StatusConflict, "Another page now uses that revision's slug."}
+		}
+		return nil, err
+	}
+	h.recordAudit(r, models.AuditPageUpdated, snapshot.Snapshot.Slug+" (restored revision "+strconv.Itoa(revision)+")")
+	return h.savedPage(&snapshot.Snapshot)
+}
+
+// apiPageRevision is the API representation of a page revision. The snapshot is only
+// included when a single revision is requested.
+type apiPageRevision struct {
+	Revision   int      `json:"revision"`
+	AuthorID   int      `json:"author_id,omitempty"`
+	AuthorName string   `json:"author_name,omitempty"`
+	Note       string   `json:"note,omitempty"`
+	Created    string   `json:"created"`
+	Page       *apiPage `json:"page,omitempty"`
+}
+
+// newAPIPageRevision converts a revision for an API response
+func newAPIPageRevision(revision *models.PageRevision, withSnapshot bool, access *database.Access) apiPageRevision {
+	result := apiPageRevision{
+		Revision:   revision.Revision,
+		AuthorID:   revision.Author.ID,
+		AuthorName: revision.Author.Name,
+		Note:       revision.Note,
+		Created:    revision.Created.Format("2006-01-02 15:04:05"),
+	}
+	if withSnapshot {
+		page := newAPIPage(&revision.Snapshot, access)
+		result.Page = &page
+	}
+	return result
+}
+
+// writeAPIRevisions responds with the revisions of a page the caller may change
+func (h *PageEditorHandler) writeAPIRevisions(w http.ResponseWriter, slug string, access *database.Access) {
+	page, err := h.writablePage(slug, access)
+	if err != nil {
+		writeAPIPageError(w, err)
+		return
+	}
+	revisions, err := h.db.GetPageRevisions(page.ID)
+	if err != nil {
+		writeAPIStoreError(w, err, "", "Failed to retrieve revisions")
+		return
+	}
+	result := []apiPageRevision{}
+	for i := range revisions {
+		result = append(result, newAPIPageRevision(&revisions[i], false, access))
+	}
+	writeAPIResponse(w, http.StatusOK, APIResponse{Success: true, Data: result})
+}
+
+// writeAPIRevision responds with one revision of a page the caller may change
+func (h *PageEditorHandler) writeAPIRevision(w http.ResponseWriter, slug, revisionText string, access *database.Access) {
+	revisionNumber, err := strconv.Atoi(revisionText)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid revision")
+		return
+	}
+	page, err := h.writablePage(slug, access)
+	if err != nil {
+		writeAPIPageError(w, err)
+		return
+	}
+	revision, err := h.db.GetPageRevision(page.ID, revisionNumber)
+	if err != nil {
+		writeAPIStoreError(w, err, "Revision not found", "Failed to retrieve revision")
+		return
+	}
+	writeAPIResponse(w, http.StatusOK, APIResponse{Success: true, Data: newAPIPageRevision(revision, true, access)})
+}
+
+// restoreAPIRevision restores a revision of a page the caller may change
+func (h *PageEditorHandler) restoreAPIRevision(w http.ResponseWriter, r *http.Request, slug, revisionText string, access *database.Access) {
+	revisionNumber, err := strconv.Atoi(revisionText)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid revision")
+		return
+	}
+	page, err := h.writablePage(slug, access)
+	if err == nil {
+		page, err = h.restoreRevision(r, page, revisionNumber, access)
+	}
+	if err != nil {
+		writeAPIPageError(w, err)
+		return
+	}
+	writeAPIResponse(w, http.StatusOK, APIResponse{Success: true, Message: "Revision restored successfully", Data: newAPIPage(page, access)})
+}
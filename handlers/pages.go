@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"strings"
 	"stingray/database"
+	"stingray/models"
 	"stingray/templates"
 	"stingray/config"
 	"io/ioutil"
@@ -20,10 +21,10 @@ type PageHandler struct {
 }
 
 // NewPageHandler creates a new page handler
-func NewPageHandler(db *database.Database, cfg *config.Config) *PageHandler {
+func NewPageHandler(db *database.Database, sm *SessionMiddleware, cfg *config.Config) *PageHandler {
 	return &PageHandler{
 		db:  db,
-		sm:  NewSessionMiddleware(db),
+		sm:  sm,
 		cfg: cfg,
 	}
 }
@@ -35,7 +36,7 @@ func (h *PageHandler) HandleHome(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	page, err := h.db.GetPage("home")
+	page, err := h.db.GetPublishedPage("home")
 	if err != nil {
 		database.LogSQLError(err)
 		http.Error(w, "Page not found", http.StatusNotFound)
@@ -43,28 +44,27 @@ func (h *PageHandler) HandleHome(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Modify navigation based on authentication status
-	if h.sm.IsAuthenticated(r) {
-		username := r.Header.Get("X-Username")
+	if principal := h.sm.Principal(r); principal.Authenticated() {
+		username := principal.Username()
 		if username == "" {
 			username = "User"
 		}
 		
-		// Check if user is admin or engineer
-		session, err := h.sm.GetSessionFromRequest(r)
-		var isAdmin, isEngineer bool
-		if err == nil {
-			isAdmin, _ = h.db.IsUserInGroup(session.UserID, "admin")
-			isEngineer, _ = h.db.IsUserInGroup(session.UserID, "engineer")
-		}
+		// Schema editors get a link to the table browser
+		canEditSchema := h.sm.Can(r, models.PermissionSchemaEdit)
 		
-		// Build navigation with admin/engineer-specific links
+		// Build navigation with permission-specific links
 		nav := `<a href="/">Home</a> | <a href="/page/about">About</a> | <a href="/user/profile">Profile</a> | <a href="/user/logout">Logout</a> | <a href="/config">Config</a>`
-		sidebar := `<h3>Welcome, ` + username + `!</h3><ul><li><a href="/page/about">About</a></li><li><a href="/page/demo">Demo</a></li><li><a href="/user/profile">Profile</a></li><li><a href="/user/logout">Logout</a></li>`
+		sidebar := `<h3>Welcome, ` + template.HTMLEscapeString(username) + `!</h3><ul><li><a href="/page/about">About</a></li><li><a href="/page/demo">Demo</a></li><li><a href="/user/profile">Profile</a></li><li><a href="/user/logout">Logout</a></li>`
 		
-		if isAdmin || isEngineer {
+		if canEditSchema {
 			nav += ` | <a href="/metadata/tables">Database Tables</a>`
 			sidebar += `<li><a href="/metadata/tables">Database Tables</a></li>`
 		}
+		if h.sm.Can(r, models.PermissionPagesEdit) {
+			nav += ` | <a href="/page-editor">Pages</a>`
+			sidebar += `<li><a href="/page-editor">Edit Pages</a></li>`
+		}
 		
 		sidebar += `</ul>`
 		page.Navigation = nav
@@ -74,7 +74,7 @@ func (h *PageHandler) HandleHome(w http.ResponseWriter, r *http.Request) {
 		page.Sidebar = `<h3>Quick Links</h3><ul><li><a href="/page/about">About</a></li><li><a href="/page/demo">Demo</a></li><li><a href="/user/login">Login</a></li><li><a href="/config">Config</a></li></ul>`
 	}
 
-	html, err := templates.RenderPage(page)
+	html, err := templates.RenderPage(page, CSPNonce(r))
 	if err != nil {
 		http.Error(w, "Error rendering page", http.StatusInternalServerError)
 		return
@@ -92,17 +92,14 @@ func (h *PageHandler) HandlePage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get user ID for permission checking
-	var userID int
-	if h.sm.IsAuthenticated(r) {
-		session, err := h.sm.GetSessionFromRequest(r)
-		if err == nil {
-			userID = session.UserID
-		}
-	}
-
 	// Get page with permission check
-	page, err := h.db.GetPageWithPermissionCheck(path, userID)
+	principal := h.sm.Principal(r)
+	access, err := h.sm.Access(r)
+	if err != nil {
+		RenderMessage(w, "500 Internal Server Error", "Server Error", "error", "Failed to check page permissions.", "/", "Go Home", http.StatusInternalServerError)
+		return
+	}
+	page, err := h.db.GetPageWithAccess(path, access)
 	if err != nil {
 		database.LogSQLError(err)
 		if err.Error() == "access denied" {
@@ -115,32 +112,25 @@ func (h *PageHandler) HandlePage(w http.ResponseWriter, r *http.Request) {
 
 	// Modify navigation based on authentication status for login page
 	if path == "login" {
-		if h.sm.IsAuthenticated(r) {
+		if principal.Authenticated() {
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 			return
 		}
 	} else {
 		// Modify navigation for other pages based on authentication status
-		if h.sm.IsAuthenticated(r) {
-			username := r.Header.Get("X-Username")
-			if username == "" {
-				username = "User"
-			}
+		if principal.Authenticated() {
+			// Schema editors get a link to the table browser
+			canEditSchema := h.sm.Can(r, models.PermissionSchemaEdit)
 			
-			// Check if user is admin or engineer
-			session, err := h.sm.GetSessionFromRequest(r)
-			var isAdmin, isEngineer bool
-			if err == nil {
-				isAdmin, _ = h.db.IsUserInGroup(session.UserID, "admin")
-				isEngineer, _ = h.db.IsUserInGroup(session.UserID, "engineer")
-			}
-			
-			// Build navigation with admin/engineer-specific links
+			// Build navigation with permission-specific links
 			nav := `<a href="/">Home</a> | <a href="/page/about">About</a> | <a href="/user/profile">Profile</a> | <a href="/user/logout">Logout</a>`
 			
-			if isAdmin || isEngineer {
+			if canEditSchema {
 				nav += ` | <a href="/metadata/tables">Database Tables</a>`
 			}
+			if h.sm.Can(r, models.PermissionPagesEdit) {
+				nav += ` | <a href="/page-editor">Pages</a>`
+			}
 			
 			page.Navigation = nav
 		} else {
@@ -155,7 +145,7 @@ func (h *PageHandler) HandlePage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	html, err := templates.RenderPage(page)
+	html, err := templates.RenderPage(page, CSPNonce(r))
 	if err != nil {
 		http.Error(w, "Error rendering page", http.StatusInternalServerError)
 		return
@@ -167,7 +157,7 @@ func (h *PageHandler) HandlePage(w http.ResponseWriter, r *http.Request) {
 
 // HandlePages handles the pages listing request
 func (h *PageHandler) HandlePages(w http.ResponseWriter, r *http.Request) {
-	pages, err := h.db.GetAllPages()
+	pages, err := h.db.GetPublishedPages()
 	if err != nil {
 		database.LogSQLError(err)
 		http.Error(w, "Error fetching pages", http.StatusInternalServerError)
@@ -228,7 +218,11 @@ func (h *PageHandler) HandlePages(w http.ResponseWriter, r *http.Request) {
 
 // HandleTemplates handles the templates listing request
 func (h *PageHandler) HandleTemplates(w http.ResponseWriter, r *http.Request) {
-	templates := []string{"default", "simple", "modern", "modern_header", "modern_footer", "login_form"}
+	templates, err := templates.ListTemplates()
+	if err != nil {
+		http.Error(w, "Error listing templates", http.StatusInternalServerError)
+		return
+	}
 
 	// Check if JSON response is requested
 	if r.URL.Query().Get("response_format") == "json" {
@@ -313,19 +307,13 @@ func (h *PageHandler) HandleTemplate(w http.ResponseWriter, r *http.Request) {
 
 // Handler to display and edit config settings and .env file
 func (h *PageHandler) HandleConfigPage(w http.ResponseWriter, r *http.Request) {
-	// Only allow admin or engineer
+	// Viewing requires config.view and saving requires config.edit
 	if !h.sm.IsAuthenticated(r) {
 		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 		return
 	}
-	session, err := h.sm.GetSessionFromRequest(r)
-	if err != nil {
-		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
-		return
-	}
-	isAdmin, _ := h.db.IsUserInGroup(session.UserID, "admin")
-	isEngineer, _ := h.db.IsUserInGroup(session.UserID, "engineer")
-	if !isAdmin && !isEngineer {
+	if !h.sm.Can(r, models.PermissionConfigView) ||
+		(r.Method == "POST" && !h.sm.Can(r, models.PermissionConfigEdit)) {
 		RenderMessage(w, "Access Denied", "Access Denied", "error", "You do not have permission to access this page.", "/", "Go Home", http.StatusForbidden)
 		return
 	}
@@ -398,6 +386,45 @@ func (h *PageHandler) HandleConfigPage(w http.ResponseWriter, r *http.Request) {
 		configRow("DKIMSelector", cfg.DKIMSelector) +
 		configRow("DKIMDomain", cfg.DKIMDomain) +
 		configRow("ServerPort", cfg.ServerPort) +
+		configRow("PublicBaseURL", cfg.PublicBaseURL) +
+		configRow("TrustedProxies", cfg.TrustedProxies) +
+		configRow("TLSCertFile", cfg.TLSCertFile) +
+		configRow("TLSKeyFile", cfg.TLSKeyFile) +
+		configRow("HTTPRedirectPort", cfg.HTTPRedirectPort) +
+		configRow("HSTSMaxAge", cfg.HSTSMaxAge.String()) +
+		configRow("HSTSIncludeSubdomains", fmt.Sprintf("%v", cfg.HSTSIncludeSubdomains)) +
+		configRow("ContentSecurityPolicy", cfg.ContentSecurityPolicy) +
+		configRow("CSPReportOnly", fmt.Sprintf("%v", cfg.CSPReportOnly)) +
+		configRow("CSPReportURI", cfg.CSPReportURI) +
+		configRow("FrameAncestors", cfg.FrameAncestors) +
+		configRow("ReferrerPolicy", cfg.ReferrerPolicy) +
+		configRow("PermissionsPolicy", cfg.PermissionsPolicy) +
+		configRow("SecureCookies", fmt.Sprintf("%v", cfg.SecureCookies())) +
+		configRow("DevShowTokenLinks", fmt.Sprintf("%v", cfg.DevShowTokenLinks)) +
+		configRow("SessionIdleTimeout", cfg.SessionIdleTimeout.String()) +
+		configRow("SessionAbsoluteLifetime", cfg.SessionAbsoluteLifetime.String()) +
+		configRow("SessionRememberMeLifetime", cfg.SessionRememberMeLifetime.String()) +
+		configRow("SessionRenewInterval", cfg.SessionRenewInterval.String()) +
+		configRow("SessionStore", cfg.SessionStore) +
+		configRow("SessionSecret", mask(cfg.SessionSecret)) +
+		configRow("SessionCacheSize", fmt.Sprintf("%d", cfg.SessionCacheSize)) +
+		configRow("RegistrationEnabled", fmt.Sprintf("%v", cfg.RegistrationEnabled)) +
+		configRow("RegistrationDefaultGroup", cfg.RegistrationDefaultGroup) +
+		configRow("RegistrationVerifyEmail", fmt.Sprintf("%v", cfg.RegistrationVerifyEmail)) +
+		configRow("RegistrationRequireApproval", fmt.Sprintf("%v", cfg.RegistrationRequireApproval)) +
+		configRow("PasswordMinLength", fmt.Sprintf("%d", cfg.PasswordMinLength)) +
+		configRow("PasswordRequireUpper", fmt.Sprintf("%v", cfg.PasswordRequireUpper)) +
+		configRow("PasswordRequireLower", fmt.Sprintf("%v", cfg.PasswordRequireLower)) +
+		configRow("PasswordRequireDigit", fmt.Sprintf("%v", cfg.PasswordRequireDigit)) +
+		configRow("PasswordRequireSymbol", fmt.Sprintf("%v", cfg.PasswordRequireSymbol)) +
+		configRow("PasswordDisallowUserInfo", fmt.Sprintf("%v", cfg.PasswordDisallowUserInfo)) +
+		configRow("PasswordHistorySize", fmt.Sprintf("%d", cfg.PasswordHistorySize)) +
+		configRow("BreachedPasswordsFile", cfg.BreachedPasswordsFile) +
+		configRow("PasswordResetMaxRequests", fmt.Sprintf("%d", cfg.PasswordResetMaxRequests)) +
+		configRow("PasswordResetWindow", cfg.PasswordResetWindow.String()) +
+		configRow("MagicLinkDuration", cfg.MagicLinkDuration.String()) +
+		configRow("AuditLogRetention", cfg.AuditLogRetention.String()) +
+		configRow("PermissionCacheTTL", cfg.PermissionCacheTTL.String()) +
 		`</table><br><a href='/'>Back to Home</a></body></html>`
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Write([]byte(html))
@@ -3,12 +3,15 @@ package handlers
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
+	"stingray/auth"
 	"stingray/config"
 	"stingray/database"
 	"stingray/email"
 	"stingray/logging"
+	"stingray/models"
 	"stingray/templates"
 	"time"
 )
@@ -18,29 +21,17 @@ type PasswordResetHandler struct {
 	db     *database.Database
 	cfg    *config.Config
 	email  *email.EmailService
+	sm     *SessionMiddleware
 	logger *logging.Logger
 }
 
 // NewPasswordResetHandler creates a new password reset handler
-func NewPasswordResetHandler(db *database.Database, cfg *config.Config, logger *logging.Logger) *PasswordResetHandler {
-	emailService, err := email.NewEmailService(
-		cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword,
-		cfg.FromEmail, cfg.FromName, cfg.DKIMPrivateKeyFile, cfg.DKIMSelector, cfg.DKIMDomain,
-	)
-	if err != nil {
-		// Log error but continue without email service
-		if logger != nil {
-			logger.LogError("Failed to initialize email service: %v", err)
-		} else {
-			fmt.Printf("Warning: Failed to initialize email service: %v\n", err)
-		}
-		emailService = nil
-	}
-
+func NewPasswordResetHandler(db *database.Database, sm *SessionMiddleware, cfg *config.Config, logger *logging.Logger) *PasswordResetHandler {
 	return &PasswordResetHandler{
 		db:     db,
 		cfg:    cfg,
-		email:  emailService,
+		email:  newEmailService(cfg, logger),
+		sm:     sm,
 		logger: logger,
 	}
 }
@@ -49,7 +40,7 @@ func NewPasswordResetHandler(db *database.Database, cfg *config.Config, logger *
 func (h *PasswordResetHandler) HandlePasswordResetRequest(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
 		// Show password reset request form
-		page, err := h.db.GetPage("password-reset-request")
+		page, err := h.db.GetPublishedPage("password-reset-request")
 		if err != nil {
 			database.LogSQLError(err)
 			RenderMessage(w, "Password Reset", "Password Reset", "info", 
@@ -57,7 +48,7 @@ func (h *PasswordResetHandler) HandlePasswordResetRequest(w http.ResponseWriter,
 			return
 		}
 
-		html, err := templates.RenderPage(page)
+		html, err := templates.RenderPage(page, CSPNonce(r))
 		if err != nil {
 			http.Error(w, "Error rendering page", http.StatusInternalServerError)
 			return
@@ -90,20 +81,21 @@ func (h *PasswordResetHandler) HandlePasswordResetRequest(w http.ResponseWriter,
 		return
 	}
 
-	// Generate reset token
-	token, err := generateResetToken()
-	if err != nil {
-		database.LogSQLError(err)
-		RenderMessage(w, "Password Reset Error", "Password Reset Error", "error", 
-			"Failed to generate reset token. Please try again.", "/user/password-reset-request", "Try Again", http.StatusInternalServerError)
+	// Each account may only request a few links per window so its inbox cannot be flooded
+	if h.resetThrottled(user.ID) {
+		recordAuditEvent(h.db, nil, h.logger, r, models.AuditEvent{
+			EventType:    models.AuditPasswordResetRequested,
+			TargetUserID: user.ID,
+			TargetName:   user.Username,
+			Details:      "throttled",
+		})
+		RenderMessage(w, "Password Reset Requested", "Password Reset Requested", "success", 
+			"If an account with that email exists, a password reset link has been sent.", "/user/login", "Back to Login", http.StatusOK)
 		return
 	}
 
-	// Set expiration (1 hour from now)
-	expiresAt := time.Now().Add(1 * time.Hour)
-
-	// Create reset token in database
-	err = h.db.CreatePasswordResetToken(user.ID, email, token, expiresAt)
+	// Create reset token in database; earlier links for the account stop working
+	token, err := createPasswordResetToken(h.db, user.ID, email)
 	if err != nil {
 		database.LogSQLError(err)
 		RenderMessage(w, "Password Reset Error", "Password Reset Error", "error", 
@@ -111,9 +103,15 @@ func (h *PasswordResetHandler) HandlePasswordResetRequest(w http.ResponseWriter,
 		return
 	}
 
+	recordAuditEvent(h.db, nil, h.logger, r, models.AuditEvent{
+		EventType:    models.AuditPasswordResetRequested,
+		TargetUserID: user.ID,
+		TargetName:   user.Username,
+	})
+
 	// Send password reset email
+	resetURL := h.cfg.PublicURL("/user/password-reset-confirm?token=" + token)
 	if h.email != nil {
-		resetURL := fmt.Sprintf("http://localhost:6273/user/password-reset-confirm?token=%s", token)
 		err = h.email.SendPasswordResetEmail(email, resetURL)
 		if err != nil {
 			h.logger.LogError("Failed to send password reset email: %v", err)
@@ -122,13 +120,14 @@ func (h *PasswordResetHandler) HandlePasswordResetRequest(w http.ResponseWriter,
 				"Failed to send reset email. Please try again.", "/user/password-reset-request", "Try Again", http.StatusInternalServerError)
 			return
 		}
-	} else {
-		// Fallback for when email service is not available
-		resetURL := fmt.Sprintf("/user/password-reset-confirm?token=%s", token)
+	} else if h.cfg.DevShowTokenLinks {
+		// Development fallback for when email service is not available
 		RenderMessage(w, "Password Reset Requested", "Password Reset Requested", "success", 
 			fmt.Sprintf("If an account with that email exists, a password reset link has been sent. For testing, you can use this link: %s", resetURL), 
 			"/user/login", "Back to Login", http.StatusOK)
 		return
+	} else {
+		h.logger.LogError("Password reset requested for user %d but email is not configured", user.ID)
 	}
 	
 	RenderMessage(w, "Password Reset Requested", "Password Reset Requested", "success", 
@@ -167,7 +166,7 @@ func (h *PasswordResetHandler) HandlePasswordResetConfirm(w http.ResponseWriter,
 		}
 
 		// Show password reset form
-		page, err := h.db.GetPage("password-reset-confirm")
+		page, err := h.db.GetPublishedPage("password-reset-confirm")
 		if err != nil {
 			database.LogSQLError(err)
 			// Create a simple form if page doesn't exist
@@ -212,7 +211,7 @@ func (h *PasswordResetHandler) HandlePasswordResetConfirm(w http.ResponseWriter,
 		// Replace token placeholder in content
 		page.MainContent = fmt.Sprintf(page.MainContent, token)
 
-		html, err := templates.RenderPage(page)
+		html, err := templates.RenderPage(page, CSPNonce(r))
 		if err != nil {
 			http.Error(w, "Error rendering page", http.StatusInternalServerError)
 			return
@@ -245,28 +244,19 @@ func (h *PasswordResetHandler) HandlePasswordResetConfirm(w http.ResponseWriter,
 		return
 	}
 
-	// Verify token again
-	resetToken, err := h.db.GetPasswordResetToken(token)
-	if err != nil {
-		RenderMessage(w, "Invalid Reset Link", "Invalid Reset Link", "error", 
-			"The password reset link is invalid or has expired.", "/user/password-reset-request", "Request New Reset", http.StatusBadRequest)
+	// Claim the link and set the password together, so a link resets at most once
+	userID, err := h.db.ResetPassword(token, password)
+	if errors.Is(err, database.ErrPasswordResetInvalid) {
+		RenderMessage(w, "Invalid Reset Link", "Invalid Reset Link", "error",
+			"This password reset link is invalid, has expired or has already been used.", "/user/password-reset-request", "Request New Reset", http.StatusBadRequest)
 		return
 	}
-
-	if resetToken.Used {
-		RenderMessage(w, "Token Already Used", "Token Already Used", "error", 
-			"This password reset link has already been used.", "/user/password-reset-request", "Request New Reset", http.StatusBadRequest)
+	if policyErr, ok := auth.IsPolicyError(err); ok {
+		RenderMessage(w, "Password Not Accepted", "Password Not Accepted", "error",
+			"Your new password does not meet the password requirements. "+policyErr.Error(),
+			"/user/password-reset-confirm?token="+token, "Try Again", http.StatusBadRequest)
 		return
 	}
-
-	if time.Now().After(resetToken.ExpiresAt) {
-		RenderMessage(w, "Token Expired", "Token Expired", "error", 
-			"This password reset link has expired.", "/user/password-reset-request", "Request New Reset", http.StatusBadRequest)
-		return
-	}
-
-	// Update user password
-	err = h.db.UpdateUserPassword(resetToken.UserID, password)
 	if err != nil {
 		database.LogSQLError(err)
 		RenderMessage(w, "Password Reset Error", "Password Reset Error", "error", 
@@ -274,17 +264,48 @@ func (h *PasswordResetHandler) HandlePasswordResetConfirm(w http.ResponseWriter,
 		return
 	}
 
-	// Mark token as used
-	err = h.db.MarkPasswordResetTokenUsed(token)
-	if err != nil {
+	// Every session of the account stops working
+	if err := h.sm.store.InvalidateAllUserSessions(userID); err != nil {
 		database.LogSQLError(err)
-		// Don't fail the reset if marking as used fails
 	}
 
+	recordAuditEvent(h.db, nil, h.logger, r, models.AuditEvent{
+		EventType:    models.AuditPasswordResetCompleted,
+		TargetUserID: userID,
+	})
+
 	RenderMessage(w, "Password Reset Successful", "Password Reset Successful", "success", 
 		"Your password has been successfully reset. You can now login with your new password.", "/user/login", "Login", http.StatusOK)
 }
 
+// PasswordResetDuration is how long a password reset link stays valid
+const PasswordResetDuration = 1 * time.Hour
+
+// resetThrottled reports whether an account has used up its password reset requests for the window
+func (h *PasswordResetHandler) resetThrottled(userID int) bool {
+	if h.cfg.PasswordResetMaxRequests <= 0 {
+		return false
+	}
+	count, err := h.db.CountRecentPasswordResetTokens(userID, time.Now().Add(-h.cfg.PasswordResetWindow))
+	if err != nil {
+		// Fail closed so a database problem cannot be used to flood an inbox
+		return true
+	}
+	return count >= h.cfg.PasswordResetMaxRequests
+}
+
+// createPasswordResetToken generates a reset token for the user and stores it with its expiry
+func createPasswordResetToken(db *database.Database, userID int, emailAddress string) (string, error) {
+	token, err := generateResetToken()
+	if err != nil {
+		return "", err
+	}
+	if err := db.CreatePasswordResetToken(userID, emailAddress, token, time.Now().Add(PasswordResetDuration)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
 // generateResetToken generates a secure random token for password reset
 func generateResetToken() (string, error) {
 	bytes := make([]byte, 32)
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"stingray/database"
+	"stingray/models"
+	"strings"
+	"sync"
+)
+
+// principalKey is the request context key holding the request's Principal
+type principalKey struct{}
+
+// sessionKey is the request context key holding the session Refresh loaded, nil when the
+// request has none, so identifying the request does not look the session up again
+type sessionKey struct{}
+
+// identityHeaders were once set by the session middleware to pass the user to handlers.
+// Inbound values are removed so a client can never present them as its identity.
+var identityHeaders = []string{"X-User-ID", "X-Username"}
+
+// Principal is the user behind a request, resolved once per request from the session
+// cookie. Anonymous requests get a Principal without a session.
+type Principal struct {
+	Session *models.Session
+	User    *models.User
+	Groups  []models.Group
+
+	// access is the user's full group set and permissions, loaded on first use
+	mu     sync.Mutex
+	access *database.Access
+}
+
+// Authenticated reports whether the request carries a valid session
+func (p *Principal) Authenticated() bool {
+	return p != nil && p.Session != nil
+}
+
+// UserID returns the signed-in user's ID, or zero for anonymous requests
+func (p *Principal) UserID() int {
+	if !p.Authenticated() {
+		return 0
+	}
+	return p.Session.UserID
+}
+
+// Username returns the signed-in user's name, or "" for anonymous requests
+func (p *Principal) Username() string {
+	if !p.Authenticated() {
+		return ""
+	}
+	return p.Session.Username
+}
+
+// Identify strips spoofed identity headers and stores the request's Principal in its
+// context, so handlers read the user without querying the session again
+func (m *SessionMiddleware) Identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, header := range identityHeaders {
+			r.Header.Del(header)
+		}
+		ctx := context.WithValue(r.Context(), principalKey{}, m.resolvePrincipal(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Principal returns the Principal stored by Identify, resolving it from the session cookie
+// when the request did not pass through Identify
+func (m *SessionMiddleware) Principal(r *http.Request) *Principal {
+	if principal, ok := r.Context().Value(principalKey{}).(*Principal); ok {
+		return principal
+	}
+	return m.resolvePrincipal(r)
+}
+
+// resolvePrincipal loads the session, user and groups for a request, reusing the session
+// Refresh loaded. Asset requests only get the session. Lookup errors leave the request
+// anonymous.
+func (m *SessionMiddleware) resolvePrincipal(r *http.Request) *Principal {
+	session, ok := r.Context().Value(sessionKey{}).(*models.Session)
+	if !ok {
+		session, _ = m.GetSessionFromRequest(r)
+	}
+	if session == nil {
+		return &Principal{}
+	}
+	if strings.HasPrefix(r.URL.Path, assetPrefix) {
+		return &Principal{Session: session}
+	}
+	user, err := m.db.GetUserByID(session.UserID)
+	if err != nil {
+		database.LogSQLError(err)
+		return &Principal{}
+	}
+	groups, err := m.db.GetUserGroups(session.UserID)
+	if err != nil {
+		database.LogSQLError(err)
+		return &Principal{}
+	}
+	return &Principal{Session: session, User: user, Groups: groups}
+}
+
+// Access returns the group set and permissions of the user behind the request, loading
+// them at most once per request so repeated permission checks cost no further queries
+func (m *SessionMiddleware) Access(r *http.Request) (*database.Access, error) {
+	principal := m.Principal(r)
+	principal.mu.Lock()
+	defer principal.mu.Unlock()
+	if principal.access == nil {
+		access, err := m.db.LoadAccess(principal.UserID())
+		if err != nil {
+			return nil, err
+		}
+		principal.access = access
+	}
+	return principal.access, nil
+}
+
+// Can reports whether the user behind the request holds the permission, checked with
+// database.Can against the Access loaded once per request. Requests without a valid
+// session are checked as an anonymous user, and lookup errors deny access.
+func (m *SessionMiddleware) Can(r *http.Request, permission string) bool {
+	access, err := m.Access(r)
+	if err != nil {
+		return false
+	}
+	allowed, err := m.db.Can(access, permission)
+	if err != nil {
+		database.LogSQLError(err)
+		return false
+	}
+	return allowed
+}
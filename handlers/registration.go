@@ -0,0 +1,394 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"stingray/auth"
+	"stingray/config"
+	"stingray/database"
+	"stingray/email"
+	"stingray/logging"
+	"stingray/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EmailVerificationDuration is how long an email verification link stays valid
+const EmailVerificationDuration = 24 * time.Hour
+
+// guestNavigation is the navigation bar shown on pages for visitors who are not logged in
+const guestNavigation = `<a href="/">Home</a> | <a href="/page/about">About</a> | <a href="/user/login">Login</a>`
+
+// RegistrationHandler handles self-service account registration
+type RegistrationHandler struct {
+	db     *database.Database
+	cfg    *config.Config
+	email  *email.EmailService
+	logger *logging.Logger
+}
+
+// NewRegistrationHandler creates a new registration handler
+func NewRegistrationHandler(db *database.Database, cfg *config.Config, logger *logging.Logger) *RegistrationHandler {
+	return &RegistrationHandler{
+		db:     db,
+		cfg:    cfg,
+		email:  newEmailService(cfg, logger),
+		logger: logger,
+	}
+}
+
+// HandleRegister shows the registration form and creates accounts
+func (h *RegistrationHandler) HandleRegister(w http.ResponseWriter, r *http.Request) {
+	if !h.cfg.RegistrationEnabled {
+		RenderMessage(w, "Registration Closed", "Registration Closed", "error",
+			"New accounts can only be created by an administrator.", "/user/login", "Back to Login", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == "GET" {
+		h.renderRegisterForm(w, "", "", "", http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		RenderMessage(w, "405 Method Not Allowed", "Method Not Allowed", "error",
+			"Only POST is allowed for this endpoint.", "/user/register", "Back to Registration", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := strings.TrimSpace(r.FormValue("username"))
+	emailAddress := strings.TrimSpace(r.FormValue("email"))
+	password := r.FormValue("password")
+	confirmPassword := r.FormValue("confirm_password")
+
+	if username == "" || emailAddress == "" || password == "" {
+		h.renderRegisterForm(w, "Username, email and password are required.", username, emailAddress, http.StatusBadRequest)
+		return
+	}
+	if _, err := mail.ParseAddress(emailAddress); err != nil {
+		h.renderRegisterForm(w, "Please enter a valid email address.", username, emailAddress, http.StatusBadRequest)
+		return
+	}
+	if password != confirmPassword {
+		h.renderRegisterForm(w, "Passwords do not match.", username, emailAddress, http.StatusBadRequest)
+		return
+	}
+
+	exists, err := h.db.UserExists(username, emailAddress)
+	if err != nil {
+		database.LogSQLError(err)
+		h.renderRegisterForm(w, "Registration failed. Please try again.", username, emailAddress, http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		h.renderRegisterForm(w, "That username or email address is already registered.", username, emailAddress, http.StatusConflict)
+		return
+	}
+
+	status := models.UserStatusActive
+	if h.cfg.RegistrationVerifyEmail {
+		status = models.UserStatusPendingVerification
+	} else if h.cfg.RegistrationRequireApproval {
+		status = models.UserStatusPendingApproval
+	}
+
+	userID, err := h.db.RegisterUser(username, emailAddress, password, h.cfg.RegistrationDefaultGroup, status)
+	if policyErr, ok := auth.IsPolicyError(err); ok {
+		h.renderRegisterForm(w, policyErr.Error(), username, emailAddress, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		database.LogSQLError(err)
+		h.renderRegisterForm(w, "Registration failed. Please try again.", username, emailAddress, http.StatusInternalServerError)
+		return
+	}
+
+	switch status {
+	case models.UserStatusPendingVerification:
+		h.sendVerification(w, r, userID, emailAddress)
+	case models.UserStatusPendingApproval:
+		RenderMessage(w, "Registration Received", "Registration Received", "success",
+			"Your account has been created and is waiting for administrator approval. You will receive an email once it is approved.",
+			"/", "Go Home", http.StatusOK)
+	default:
+		RenderMessage(w, "Registration Successful", "Registration Successful", "success",
+			"Your account has been created. You can now log in.", "/user/login", "Login", http.StatusOK)
+	}
+}
+
+// sendVerification creates a verification token and emails the link to the new user
+func (h *RegistrationHandler) sendVerification(w http.ResponseWriter, r *http.Request, userID int, emailAddress string) {
+	token, err := generateResetToken()
+	if err != nil {
+		RenderMessage(w, "Registration Error", "Registration Error", "error",
+			"Failed to generate verification token. Please contact the administrator.", "/", "Go Home", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.db.CreateEmailVerificationToken(userID, emailAddress, token, time.Now().Add(EmailVerificationDuration))
+	if err != nil {
+		database.LogSQLError(err)
+		RenderMessage(w, "Registration Error", "Registration Error", "error",
+			"Failed to create verification token. Please contact the administrator.", "/", "Go Home", http.StatusInternalServerError)
+		return
+	}
+
+	verifyURL := h.cfg.PublicURL("/user/verify-email?token=" + token)
+	if h.email == nil && h.cfg.DevShowTokenLinks {
+		// Development fallback for when email service is not available
+		RenderMessage(w, "Verify Your Email", "Verify Your Email", "success",
+			fmt.Sprintf("Your account has been created. For testing, verify your email with this link: %s", verifyURL),
+			"/", "Go Home", http.StatusOK)
+		return
+	}
+	if h.email == nil {
+		h.logger.LogError("Verification email for user %d not sent: email is not configured", userID)
+		RenderMessage(w, "Registration Error", "Registration Error", "error",
+			"Your account was created but the verification email could not be sent. Please contact the administrator.",
+			"/", "Go Home", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.email.SendVerificationEmail(emailAddress, verifyURL); err != nil {
+		h.logger.LogError("Failed to send verification email: %v", err)
+		RenderMessage(w, "Registration Error", "Registration Error", "error",
+			"Your account was created but the verification email could not be sent. Please contact the administrator.",
+			"/", "Go Home", http.StatusInternalServerError)
+		return
+	}
+
+	RenderMessage(w, "Verify Your Email", "Verify Your Email", "success",
+		"Your account has been created. Check your inbox for a link to verify your email address.",
+		"/", "Go Home", http.StatusOK)
+}
+
+// HandleVerifyEmail confirms a new user's email address
+func (h *RegistrationHandler) HandleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		RenderMessage(w, "Invalid Verification Link", "Invalid Verification Link", "error",
+			"The verification link is invalid or missing.", "/", "Go Home", http.StatusBadRequest)
+		return
+	}
+
+	status := models.UserStatusActive
+	if h.cfg.RegistrationRequireApproval {
+		status = models.UserStatusPendingApproval
+	}
+
+	if _, err := h.db.VerifyEmail(token, status); err != nil {
+		if errors.Is(err, database.ErrVerificationInvalid) {
+			RenderMessage(w, "Invalid Verification Link", "Invalid Verification Link", "error",
+				"The verification link is invalid, has expired or has already been used.", "/", "Go Home", http.StatusBadRequest)
+			return
+		}
+		database.LogSQLError(err)
+		RenderMessage(w, "Verification Error", "Verification Error", "error",
+			"Failed to verify your email address. Please try again.", "/", "Go Home", http.StatusInternalServerError)
+		return
+	}
+
+	if status == models.UserStatusPendingApproval {
+		RenderMessage(w, "Email Verified", "Email Verified", "success",
+			"Thanks for verifying your email address. Your account is now waiting for administrator approval.",
+			"/", "Go Home", http.StatusOK)
+		return
+	}
+
+	RenderMessage(w, "Email Verified", "Email Verified", "success",
+		"Thanks for verifying your email address. You can now log in.", "/user/login", "Login", http.StatusOK)
+}
+
+// HandlePendingRegistrations lists accounts waiting for verification or approval
+func (h *RegistrationHandler) HandlePendingRegistrations(w http.ResponseWriter, r *http.Request) {
+	pendingApproval, err := h.db.GetUsersByStatus(models.UserStatusPendingApproval)
+	if err != nil {
+		database.LogSQLError(err)
+		http.Error(w, "Error fetching registrations", http.StatusInternalServerError)
+		return
+	}
+	pendingVerification, err := h.db.GetUsersByStatus(models.UserStatusPendingVerification)
+	if err != nil {
+		database.LogSQLError(err)
+		http.Error(w, "Error fetching registrations", http.StatusInternalServerError)
+		return
+	}
+
+	contentTemplate := `<h1>Pending Registrations</h1>
+			<h2>Awaiting Approval</h2>
+			<table class="data-table">
+				<thead>
+					<tr><th>Username</th><th>Email</th><th>Registered</th><th>Actions</th></tr>
+				</thead>
+				<tbody>
+					{{range .PendingApproval}}
+					<tr>
+						<td>{{.Username}}</td>
+						<td>{{.Email}}</td>
+						<td>{{.CreatedAt.Format "2006-01-02 15:04:05"}}</td>
+						<td>
+							<form method="POST" action="/admin/registrations/approve" style="display: inline;">
+								<input type="hidden" name="user_id" value="{{.ID}}">
+								<button type="submit" class="btn">Approve</button>
+							</form>
+							<form method="POST" action="/admin/registrations/reject" style="display: inline;" data-confirm="Reject and delete this registration?">
+								<input type="hidden" name="user_id" value="{{.ID}}">
+								<button type="submit" class="btn btn-danger">Reject</button>
+							</form>
+						</td>
+					</tr>
+					{{else}}
+					<tr><td colspan="4">No registrations are waiting for approval.</td></tr>
+					{{end}}
+				</tbody>
+			</table>
+			<h2>Awaiting Email Verification</h2>
+			<table class="data-table">
+				<thead>
+					<tr><th>Username</th><th>Email</th><th>Registered</th><th>Actions</th></tr>
+				</thead>
+				<tbody>
+					{{range .PendingVerification}}
+					<tr>
+						<td>{{.Username}}</td>
+						<td>{{.Email}}</td>
+						<td>{{.CreatedAt.Format "2006-01-02 15:04:05"}}</td>
+						<td>
+							<form method="POST" action="/admin/registrations/reject" style="display: inline;" data-confirm="Delete this unverified registration?">
+								<input type="hidden" name="user_id" value="{{.ID}}">
+								<button type="submit" class="btn btn-danger">Delete</button>
+							</form>
+						</td>
+					</tr>
+					{{else}}
+					<tr><td colspan="4">No registrations are waiting for email verification.</td></tr>
+					{{end}}
+				</tbody>
+			</table>`
+
+	contentData := map[string]interface{}{
+		"PendingApproval":     pendingApproval,
+		"PendingVerification": pendingVerification,
+	}
+
+	renderContentPage(w, "Pending Registrations - Sting Ray", "Registrations", adminNavigation, contentTemplate, contentData)
+}
+
+// HandleApproveRegistration activates a pending account and notifies the user
+func (h *RegistrationHandler) HandleApproveRegistration(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.pendingUserFromForm(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.db.SetUserStatus(user.ID, models.UserStatusActive); err != nil {
+		database.LogSQLError(err)
+		RenderMessage(w, "Approval Failed", "Approval Failed", "error",
+			"The account could not be approved.", "/admin/registrations", "Back to Registrations", http.StatusInternalServerError)
+		return
+	}
+
+	if h.email != nil {
+		if err := h.email.SendAccountApprovedEmail(user.Email, h.cfg.PublicURL("/user/login")); err != nil {
+			h.logger.LogError("Failed to send account approval email: %v", err)
+		}
+	}
+
+	http.Redirect(w, r, "/admin/registrations", http.StatusSeeOther)
+}
+
+// HandleRejectRegistration deletes a pending account
+func (h *RegistrationHandler) HandleRejectRegistration(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.pendingUserFromForm(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.db.DeletePendingUser(user.ID); err != nil {
+		database.LogSQLError(err)
+		RenderMessage(w, "Reject Failed", "Reject Failed", "error",
+			"The registration could not be rejected.", "/admin/registrations", "Back to Registrations", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/registrations", http.StatusSeeOther)
+}
+
+// pendingUserFromForm loads the account named by the posted user_id, rendering an error
+// and returning false unless it is a POST for an account that is not yet active
+func (h *RegistrationHandler) pendingUserFromForm(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	if r.Method != "POST" {
+		RenderMessage(w, "405 Method Not Allowed", "Method Not Allowed", "error",
+			"Only POST is allowed for this endpoint.", "/admin/registrations", "Back to Registrations", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	userID, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil {
+		RenderMessage(w, "Invalid User", "Invalid User", "error",
+			"Invalid user_id parameter.", "/admin/registrations", "Back to Registrations", http.StatusBadRequest)
+		return nil, false
+	}
+
+	for _, status := range []string{models.UserStatusPendingApproval, models.UserStatusPendingVerification} {
+		users, err := h.db.GetUsersByStatus(status)
+		if err != nil {
+			database.LogSQLError(err)
+			http.Error(w, "Error fetching registrations", http.StatusInternalServerError)
+			return nil, false
+		}
+		for i := range users {
+			if users[i].ID == userID {
+				return &users[i], true
+			}
+		}
+	}
+
+	RenderMessage(w, "Registration Not Found", "Registration Not Found", "error",
+		"That account is not waiting for approval.", "/admin/registrations", "Back to Registrations", http.StatusNotFound)
+	return nil, false
+}
+
+// renderRegisterForm shows the registration form with an optional error message
+func (h *RegistrationHandler) renderRegisterForm(w http.ResponseWriter, errorMessage, username, emailAddress string, status int) {
+	contentTemplate := `<h1>Create an Account</h1>
+			{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+			<div class="card">
+				<form action="/user/register" method="post">
+					<div class="form-group">
+						<label for="username">Username:</label>
+						<input type="text" id="username" name="username" value="{{.Username}}" required>
+					</div>
+					<div class="form-group">
+						<label for="email">Email Address:</label>
+						<input type="email" id="email" name="email" value="{{.Email}}" required>
+					</div>
+					<div class="form-group">
+						<label for="password">Password:</label>
+						<input type="password" id="password" name="password" required>
+					</div>
+					<div class="form-group">
+						<label for="confirm_password">Confirm Password:</label>
+						<input type="password" id="confirm_password" name="confirm_password" required>
+					</div>
+					{{if .Requirements}}
+					<p>Your password must meet these requirements:</p>
+					<ul>{{range .Requirements}}<li>{{.}}</li>{{end}}</ul>
+					{{end}}
+					<button type="submit" class="btn">Register</button>
+				</form>
+				<p>Already have an account? <a href="/user/login">Log in</a></p>
+			</div>`
+
+	contentData := map[string]interface{}{
+		"Error":        errorMessage,
+		"Username":     username,
+		"Email":        emailAddress,
+		"Requirements": h.db.PasswordPolicy().Requirements(),
+	}
+
+	renderContentPageStatus(w, status, "Register - Sting Ray", "Create an Account", guestNavigation, contentTemplate, contentData)
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"sort"
+	"stingray/logging"
+	"strings"
+)
+
+// cspNoncePlaceholder is replaced by the request's nonce in a policy
+const cspNoncePlaceholder = "{nonce}"
+
+// maxCSPReportSize bounds the body accepted by the report endpoint
+const maxCSPReportSize = 64 << 10
+
+type cspNonceKey struct{}
+
+// SecurityPolicy is the set of security headers sent for a group of routes. An empty
+// field leaves the corresponding header unset.
+type SecurityPolicy struct {
+	// ContentSecurityPolicy may contain {nonce}, replaced by a fresh nonce per request
+	ContentSecurityPolicy string
+	// FrameAncestors is appended to the policy as its frame-ancestors directive
+	FrameAncestors    string
+	ReferrerPolicy    string
+	PermissionsPolicy string
+	// ReportOnly sends the policy as Content-Security-Policy-Report-Only so violations
+	// are reported but not blocked
+	ReportOnly bool
+}
+
+type routePolicy struct {
+	prefix string
+	policy SecurityPolicy
+}
+
+// SecurityHeadersMiddleware sets Content-Security-Policy, X-Content-Type-Options,
+// Referrer-Policy and Permissions-Policy on every response. Route groups can be given
+// their own policy; the longest matching path prefix wins.
+type SecurityHeadersMiddleware struct {
+	defaultPolicy SecurityPolicy
+	routes        []routePolicy
+	reportURI     string
+	logger        *logging.Logger
+}
+
+// NewSecurityHeadersMiddleware creates a middleware applying policy to every route without
+// a more specific group policy. A non-empty reportURI is added as the report-uri directive.
+func NewSecurityHeadersMiddleware(policy SecurityPolicy, reportURI string, logger *logging.Logger) *SecurityHeadersMiddleware {
+	return &SecurityHeadersMiddleware{defaultPolicy: policy, reportURI: reportURI, logger: logger}
+}
+
+// Route sets the policy for every path starting with prefix
+func (m *SecurityHeadersMiddleware) Route(prefix string, policy SecurityPolicy) {
+	m.routes = append(m.routes, routePolicy{prefix: prefix, policy: policy})
+	sort.SliceStable(m.routes, func(i, j int) bool {
+		return len(m.routes[i].prefix) > len(m.routes[j].prefix)
+	})
+}
+
+// PolicyFor returns the policy applied to a request path
+func (m *SecurityHeadersMiddleware) PolicyFor(path string) SecurityPolicy {
+	for _, route := range m.routes {
+		if strings.HasPrefix(path, route.prefix) {
+			return route.policy
+		}
+	}
+	return m.defaultPolicy
+}
+
+// Wrap sets the headers for the request's route group and stores a fresh nonce in the
+// request context for CSPNonce
+func (m *SecurityHeadersMiddleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := m.PolicyFor(r.URL.Path)
+		header := w.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		if policy.ReferrerPolicy != "" {
+			header.Set("Referrer-Policy", policy.ReferrerPolicy)
+		}
+		if policy.PermissionsPolicy != "" {
+			header.Set("Permissions-Policy", policy.PermissionsPolicy)
+		}
+
+		nonce := ""
+		if csp := m.contentSecurityPolicy(policy); csp != "" {
+			if strings.Contains(csp, cspNoncePlaceholder) {
+				var err error
+				nonce, err = generateCSPNonce()
+				if err != nil {
+					if m.logger != nil {
+						m.logger.LogError("Failed to generate CSP nonce: %v", err)
+					}
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				csp = strings.ReplaceAll(csp, cspNoncePlaceholder, nonce)
+			}
+			if policy.ReportOnly {
+				header.Set("Content-Security-Policy-Report-Only", csp)
+			} else {
+				header.Set("Content-Security-Policy", csp)
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), cspNonceKey{}, nonce)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// contentSecurityPolicy joins the policy, its frame-ancestors and the report-uri directives
+func (m *SecurityHeadersMiddleware) contentSecurityPolicy(policy SecurityPolicy) string {
+	var directives []string
+	if csp := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(policy.ContentSecurityPolicy), ";")); csp != "" {
+		directives = append(directives, csp)
+	}
+	if policy.FrameAncestors != "" {
+		directives = append(directives, "frame-ancestors "+policy.FrameAncestors)
+	}
+	if len(directives) == 0 {
+		return ""
+	}
+	if m.reportURI != "" {
+		directives = append(directives, "report-uri "+m.reportURI)
+	}
+	return strings.Join(directives, "; ")
+}
+
+// HandleReport collects violation reports posted by browsers and writes them to the log
+func (m *SecurityHeadersMiddleware) HandleReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCSPReportSize))
+	if err != nil {
+		http.Error(w, "Invalid report", http.StatusBadRequest)
+		return
+	}
+	report := strings.TrimSpace(string(body))
+	if report != "" && m.logger != nil {
+		m.logger.LogError("CSP violation reported by %s for %q: %q", ClientIP(r), r.Referer(), report)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CSPNonce returns the nonce allowed by the request's Content-Security-Policy, or "" when
+// the policy does not use one. Inline scripts must carry it in a nonce attribute to run.
+func CSPNonce(r *http.Request) string {
+	if nonce, ok := r.Context().Value(cspNonceKey{}).(string); ok {
+		return nonce
+	}
+	return ""
+}
+
+func generateCSPNonce() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(bytes), nil
+}
@@ -1,26 +1,73 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
-	"strconv"
+	"stingray/config"
 	"stingray/database"
 	"stingray/models"
+	"stingray/sessions"
 	"time"
 )
 
 const (
 	SessionCookieName = "stingray_session"
-	SessionDuration   = 24 * time.Hour // 24 hours
+	SessionDuration   = 24 * time.Hour // Default absolute session lifetime
 )
 
-// SessionMiddleware handles session management
+// SessionPolicy controls how long sessions live and how often activity renews them
+type SessionPolicy struct {
+	IdleTimeout        time.Duration // Expire after this long without activity
+	AbsoluteLifetime   time.Duration // Never live longer than this, regardless of activity
+	RememberMeLifetime time.Duration // Idle and absolute lifetime of "remember me" sessions
+	RenewInterval      time.Duration // Minimum time between renewals written to the database
+}
+
+// DefaultSessionPolicy returns the session lifetimes used when none are configured
+func DefaultSessionPolicy() SessionPolicy {
+	return SessionPolicy{
+		IdleTimeout:        2 * time.Hour,
+		AbsoluteLifetime:   SessionDuration,
+		RememberMeLifetime: 30 * 24 * time.Hour,
+		RenewInterval:      1 * time.Minute,
+	}
+}
+
+// SessionPolicyFromConfig returns the session lifetimes from the configuration
+func SessionPolicyFromConfig(cfg *config.Config) SessionPolicy {
+	return SessionPolicy{
+		IdleTimeout:        cfg.SessionIdleTimeout,
+		AbsoluteLifetime:   cfg.SessionAbsoluteLifetime,
+		RememberMeLifetime: cfg.SessionRememberMeLifetime,
+		RenewInterval:      cfg.SessionRenewInterval,
+	}
+}
+
+// Lifetimes returns the idle timeout and absolute lifetime for a new session
+func (p SessionPolicy) Lifetimes(rememberMe bool) (time.Duration, time.Duration) {
+	if rememberMe {
+		return p.RememberMeLifetime, p.RememberMeLifetime
+	}
+	return p.IdleTimeout, p.AbsoluteLifetime
+}
+
+// SessionMiddleware handles session management. The server creates one and shares it
+// with every handler, so they all use the same store and policy.
 type SessionMiddleware struct {
-	db *database.Database
+	db            *database.Database
+	store         sessions.Store
+	policy        SessionPolicy
+	secureCookies bool // Set the Secure flag on session cookies
 }
 
-// NewSessionMiddleware creates a new session middleware
-func NewSessionMiddleware(db *database.Database) *SessionMiddleware {
-	return &SessionMiddleware{db: db}
+// NewSessionMiddleware creates session middleware that keeps sessions in store, or in
+// MySQL when store is nil, with the given lifetimes. Session cookies get the Secure flag
+// when secureCookies is set.
+func NewSessionMiddleware(db *database.Database, store sessions.Store, policy SessionPolicy, secureCookies bool) *SessionMiddleware {
+	if store == nil {
+		store = sessions.NewMySQLStore(db)
+	}
+	return &SessionMiddleware{db: db, store: store, policy: policy, secureCookies: secureCookies}
 }
 
 // GetSessionFromRequest extracts and validates session from request
@@ -30,7 +77,7 @@ func (m *SessionMiddleware) GetSessionFromRequest(r *http.Request) (*models.Sess
 		return nil, err
 	}
 
-	session, err := m.db.GetSession(cookie.Value)
+	session, err := m.store.Get(cookie.Value)
 	if err != nil {
 		return nil, err
 	}
@@ -38,17 +85,112 @@ func (m *SessionMiddleware) GetSessionFromRequest(r *http.Request) (*models.Sess
 	return session, nil
 }
 
-// SetSessionCookie sets the session cookie in the response
-func (m *SessionMiddleware) SetSessionCookie(w http.ResponseWriter, sessionID string) {
-	http.SetCookie(w, &http.Cookie{
+// Refresh renews the idle expiry of the request's session on activity, throttled by the
+// renew interval, and rotates the session ID when the user's privileges have changed. The
+// session is stored in the request context for Identify.
+func (m *SessionMiddleware) Refresh(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := m.GetSessionFromRequest(r)
+		if err == nil {
+			if time.Since(session.LastSeen) > m.policy.RenewInterval {
+				idleTimeout, _ := m.policy.Lifetimes(session.RememberMe)
+				err = m.store.Renew(session, idleTimeout)
+				if err == sessions.ErrSessionRevoked {
+					m.ClearSessionCookie(w)
+					replaceRequestCookie(r, SessionCookieName, "")
+				} else if err == nil && !session.RotatePending {
+					m.reissueCookie(w, r, session)
+				}
+			}
+			if err == nil && session.RotatePending {
+				m.RotateSession(w, r, session)
+			}
+		}
+		if err != nil {
+			session = nil
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, session)))
+	})
+}
+
+// reissueCookie sends a fresh cookie when the store encodes session state in the cookie value
+func (m *SessionMiddleware) reissueCookie(w http.ResponseWriter, r *http.Request, session *models.Session) {
+	token, err := m.store.Token(session)
+	if err != nil {
+		return
+	}
+	if cookie, err := r.Cookie(SessionCookieName); err == nil && cookie.Value == token {
+		return
+	}
+	if m.SetSessionCookie(w, session) == nil {
+		replaceRequestCookie(r, SessionCookieName, token)
+	}
+}
+
+// RotateSession issues a new ID for an existing session, sets it on the response and
+// updates the request's cookie so later handlers see the new ID
+func (m *SessionMiddleware) RotateSession(w http.ResponseWriter, r *http.Request, session *models.Session) error {
+	if err := m.store.Rotate(session); err != nil {
+		return err
+	}
+	token, err := m.store.Token(session)
+	if err != nil {
+		return err
+	}
+	if err := m.SetSessionCookie(w, session); err != nil {
+		return err
+	}
+	replaceRequestCookie(r, SessionCookieName, token)
+	return nil
+}
+
+// replaceRequestCookie rewrites the Cookie header so the named cookie carries a new value
+func replaceRequestCookie(r *http.Request, name, value string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name == name {
+			cookie.Value = value
+		}
+		r.AddCookie(cookie)
+	}
+}
+
+// StartSession signs a user in: any session the browser already holds is discarded so a
+// planted ID cannot survive login, and a new session cookie is set
+func (m *SessionMiddleware) StartSession(w http.ResponseWriter, r *http.Request, user *models.User, rememberMe bool) error {
+	if previous := m.Principal(r); previous.Authenticated() {
+		m.store.Invalidate(previous.Session)
+	}
+
+	idleTimeout, absoluteLifetime := m.policy.Lifetimes(rememberMe)
+	session, err := m.store.Create(user.ID, user.Username, idleTimeout, absoluteLifetime, rememberMe, ClientIP(r), r.UserAgent())
+	if err != nil {
+		return err
+	}
+	return m.SetSessionCookie(w, session)
+}
+
+// SetSessionCookie sets the session cookie in the response. "Remember me" sessions get a
+// persistent cookie; all others end when the browser closes.
+func (m *SessionMiddleware) SetSessionCookie(w http.ResponseWriter, session *models.Session) error {
+	token, err := m.store.Token(session)
+	if err != nil {
+		return err
+	}
+	cookie := &http.Cookie{
 		Name:     SessionCookieName,
-		Value:    sessionID,
+		Value:    token,
 		Path:     "/",
 		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
+		Secure:   m.secureCookies,
 		SameSite: http.SameSiteStrictMode,
-		Expires:  time.Now().Add(SessionDuration),
-	})
+	}
+	if session.RememberMe {
+		cookie.Expires = session.AbsoluteExpiresAt
+	}
+	http.SetCookie(w, cookie)
+	return nil
 }
 
 // ClearSessionCookie removes the session cookie
@@ -58,7 +200,7 @@ func (m *SessionMiddleware) ClearSessionCookie(w http.ResponseWriter) {
 		Value:    "",
 		Path:     "/",
 		HttpOnly: true,
-		Secure:   false,
+		Secure:   m.secureCookies,
 		SameSite: http.SameSiteStrictMode,
 		Expires:  time.Now().Add(-1 * time.Hour), // Expire immediately
 		MaxAge:   -1,
@@ -67,8 +209,7 @@ func (m *SessionMiddleware) ClearSessionCookie(w http.ResponseWriter) {
 
 // IsAuthenticated checks if the request has a valid session
 func (m *SessionMiddleware) IsAuthenticated(r *http.Request) bool {
-	_, err := m.GetSessionFromRequest(r)
-	return err == nil
+	return m.Principal(r).Authenticated()
 }
 
 // RequireAuth middleware that redirects to login if not authenticated
@@ -82,13 +223,13 @@ func (m *SessionMiddleware) RequireAuth(next http.HandlerFunc) http.HandlerFunc
 	}
 }
 
-// OptionalAuth middleware that adds session info to request context if authenticated
+// OptionalAuth makes the request's Principal available to handlers that also serve
+// anonymous visitors, identifying the request if it did not pass through Identify
 func (m *SessionMiddleware) OptionalAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if session, err := m.GetSessionFromRequest(r); err == nil {
-					// Add session to request context or headers for template access
-		r.Header.Set("X-User-ID", strconv.Itoa(session.UserID))
-		r.Header.Set("X-Username", session.Username)
+		if _, ok := r.Context().Value(principalKey{}).(*Principal); !ok {
+			m.Identify(next).ServeHTTP(w, r)
+			return
 		}
 		next.ServeHTTP(w, r)
 	}
@@ -10,6 +10,7 @@ import (
 	"stingray/config"
 	"stingray/database"
 	"stingray/logging"
+	"stingray/models"
 )
 
 func main() {
@@ -32,8 +33,12 @@ func main() {
 	go func() {
 		ticker := time.NewTicker(1 * time.Hour) // Clean up every hour
 		defer ticker.Stop()
+		scheduleTicker := time.NewTicker(1 * time.Minute) // Scheduled pages go live within a minute
+		defer scheduleTicker.Stop()
 		for {
 			select {
+			case <-scheduleTicker.C:
+				processPageSchedules(db, logger)
 			case <-ticker.C:
 				if err := db.CleanupExpiredSessions(); err != nil {
 					logger.LogError("Failed to cleanup expired sessions: %v", err)
@@ -43,6 +48,18 @@ func main() {
 					logger.LogError("Failed to cleanup expired password reset tokens: %v", err)
 					log.Printf("Failed to cleanup expired password reset tokens: %v", err)
 				}
+				if err := db.CleanupExpiredEmailVerificationTokens(); err != nil {
+					logger.LogError("Failed to cleanup expired email verification tokens: %v", err)
+					log.Printf("Failed to cleanup expired email verification tokens: %v", err)
+				}
+				if err := db.CleanupExpiredMagicLinkTokens(); err != nil {
+					logger.LogError("Failed to cleanup expired sign-in links: %v", err)
+					log.Printf("Failed to cleanup expired sign-in links: %v", err)
+				}
+				if err := db.CleanupAuditLog(cfg.AuditLogRetention); err != nil {
+					logger.LogError("Failed to prune audit log: %v", err)
+					log.Printf("Failed to prune audit log: %v", err)
+				}
 			}
 		}
 	}()
@@ -58,6 +75,20 @@ func main() {
 		}
 	}()
 
+	// SIGHUP reloads the TLS certificate, e.g. after renewal
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := server.ReloadCertificates(); err != nil {
+				logger.LogError("Failed to reload TLS certificate: %v", err)
+				log.Printf("Failed to reload TLS certificate: %v", err)
+			} else {
+				logger.LogVerbose("Reloaded TLS certificate")
+			}
+		}
+	}()
+
 	<-stop
 	logger.LogVerbose("Received shutdown signal")
 	log.Println("Received shutdown signal")
@@ -69,4 +100,22 @@ func main() {
 	}
 	logger.LogVerbose("Server exited gracefully")
 	log.Println("Server exited gracefully")
-} 
\ No newline at end of file
+}
+
+// processPageSchedules publishes and archives the pages whose scheduled times have passed,
+// recording each change in the audit log
+func processPageSchedules(db *database.Database, logger *logging.Logger) {
+	published, archived, err := db.ProcessPageSchedules()
+	if err != nil {
+		logger.LogError("Failed to process page schedules: %v", err)
+		log.Printf("Failed to process page schedules: %v", err)
+	}
+	for _, slug := range published {
+		logger.LogVerbose("Published page %s as scheduled", slug)
+		db.RecordAuditEvent(&models.AuditEvent{EventType: models.AuditPagePublished, Details: slug + " (scheduled)"})
+	}
+	for _, slug := range archived {
+		logger.LogVerbose("Archived page %s as scheduled", slug)
+		db.RecordAuditEvent(&models.AuditEvent{EventType: models.AuditPageStatusChanged, Details: slug + ": archived (scheduled)"})
+	}
+}
@@ -0,0 +1,645 @@
+package markdown
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type inlineKind int
+
+const (
+	textInline      inlineKind = iota // Literal text, escaped on output
+	rawInline                         // Inline HTML, left to the sanitizer
+	codeInline                        // Code span
+	emphasisInline                    // <em> around children
+	strongInline                      // <strong> around children
+	linkInline                        // <a> around children
+	imageInline                       // <img> with children as alt text
+	softBreakInline                   // Line break within a paragraph
+	hardBreakInline                   // <br />
+	delimiterInline                   // Run of * or _ left over from emphasis
+)
+
+// inline is a node of a paragraph's content. Siblings form a doubly linked list, so
+// emphasis can wrap a run of them in place.
+type inline struct {
+	kind       inlineKind
+	text       string
+	dest       string
+	title      string
+	children   *inline
+	prev, next *inline
+
+	// Delimiter runs, which are also linked to each other
+	char                 byte
+	count                int
+	origCount            int
+	canOpen              bool
+	canClose             bool
+	pos                  int
+	prevDelim, nextDelim *inline
+}
+
+// inlineList is a list of sibling inlines
+type inlineList struct {
+	head, tail *inline
+}
+
+func (l *inlineList) append(n *inline) {
+	n.prev, n.next = l.tail, nil
+	if l.tail != nil {
+		l.tail.next = n
+	} else {
+		l.head = n
+	}
+	l.tail = n
+}
+
+func (l *inlineList) remove(n *inline) {
+	if n.prev != nil {
+		n.prev.next = n.next
+	} else {
+		l.head = n.next
+	}
+	if n.next != nil {
+		n.next.prev = n.prev
+	} else {
+		l.tail = n.prev
+	}
+}
+
+const (
+	tagNamePattern   = `[A-Za-z][A-Za-z0-9-]*`
+	attributePattern = `(?:[ \t\n]+[A-Za-z_:][A-Za-z0-9_.:-]*(?:[ \t\n]*=[ \t\n]*(?:[^ \t\n"'=<>` + "`" + `]+|'[^']*'|"[^"]*"))?)`
+	openTagPattern   = `<` + tagNamePattern + attributePattern + `*[ \t\n]*/?>`
+	closeTagPattern  = `</` + tagNamePattern + `[ \t\n]*>`
+)
+
+var (
+	rawHTMLPattern       = regexp.MustCompile(`^(?:` + openTagPattern + `|` + closeTagPattern + `|(?s:<!--.*?-->)|(?s:<\?.*?\?>)|<![A-Z]+[^>]*>)`)
+	autolinkPattern      = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9+.-]{1,31}:[^ \t\n<>]*)>`)
+	emailAutolinkPattern = regexp.MustCompile(`^<([A-Za-z0-9.!#$%&'*+/=?^_` + "`" + `{|}~-]+@[A-Za-z0-9](?:[A-Za-z0-9-]{0,61}[A-Za-z0-9])?(?:\.[A-Za-z0-9](?:[A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*)>`)
+	entityPattern        = regexp.MustCompile(`^&(?:#[0-9]{1,7}|#[xX][0-9A-Fa-f]{1,6}|[A-Za-z][A-Za-z0-9]{1,31});`)
+)
+
+const (
+	maxInlineDepth = 16 // How deeply link text nests
+	maxLinkParens  = 32 // How deeply parentheses nest in a link destination
+)
+
+// renderInline writes the inline Markdown in source as HTML
+func (p *parser) renderInline(out *strings.Builder, source string) {
+	renderInlines(out, p.parseInline(source, 0))
+}
+
+// inlineScanner remembers searches that failed, so runs of unmatched backticks and
+// brackets do not rescan the rest of the text each time
+type inlineScanner struct {
+	source   string
+	brackets map[int]int // Index of each [ to the index of its ]
+	noCloser map[int]int // Backtick run length to the index after which no such run remains
+}
+
+func newInlineScanner(source string) *inlineScanner {
+	s := &inlineScanner{source: source, brackets: make(map[int]int), noCloser: make(map[int]int)}
+	var open []int
+	for i := 0; i < len(source); i++ {
+		switch source[i] {
+		case '\\':
+			i++
+		case '`':
+			n := runLength(source, i, '`')
+			if end := s.codeSpanEnd(i+n, n); end >= 0 {
+				i = end + n - 1
+			} else {
+				i += n - 1
+			}
+		case '[':
+			open = append(open, i)
+		case ']':
+			if len(open) > 0 {
+				s.brackets[open[len(open)-1]] = i
+				open = open[:len(open)-1]
+			}
+		}
+	}
+	return s
+}
+
+// codeSpanEnd finds the start of the next run of exactly n backticks at or after from, or -1
+func (s *inlineScanner) codeSpanEnd(from, n int) int {
+	if after, ok := s.noCloser[n]; ok && from >= after {
+		return -1
+	}
+	for i := from; i < len(s.source); {
+		if s.source[i] != '`' {
+			i++
+			continue
+		}
+		run := runLength(s.source, i, '`')
+		if run == n {
+			return i
+		}
+		i += run
+	}
+	s.noCloser[n] = from
+	return -1
+}
+
+// parseInline parses inline Markdown into a list of inlines with emphasis resolved
+func (p *parser) parseInline(source string, depth int) *inline {
+	scanner := newInlineScanner(source)
+	var list inlineList
+	var firstDelim, lastDelim *inline
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			list.append(&inline{kind: textInline, text: text.String()})
+			text.Reset()
+		}
+	}
+	add := func(n *inline) {
+		flush()
+		list.append(n)
+	}
+
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch c {
+		case '\\':
+			switch {
+			case i+1 < len(source) && source[i+1] == '\n':
+				add(&inline{kind: hardBreakInline})
+				i = skipSpaces(source, i+2)
+			case i+1 < len(source) && isASCIIPunct(source[i+1]):
+				text.WriteByte(source[i+1])
+				i += 2
+			default:
+				text.WriteByte('\\')
+				i++
+			}
+
+		case '\n':
+			// Two or more trailing spaces make a hard break
+			line := text.String()
+			trimmed := strings.TrimRight(line, " ")
+			text.Reset()
+			text.WriteString(trimmed)
+			if len(line)-len(trimmed) >= 2 {
+				add(&inline{kind: hardBreakInline})
+			} else {
+				add(&inline{kind: softBreakInline})
+			}
+			i = skipSpaces(source, i+1)
+
+		case '`':
+			n := runLength(source, i, '`')
+			if end := scanner.codeSpanEnd(i+n, n); end >= 0 {
+				add(&inline{kind: codeInline, text: codeSpanText(source[i+n : end])})
+				i = end + n
+			} else {
+				text.WriteString(source[i : i+n])
+				i += n
+			}
+
+		case '*', '_':
+			n := runLength(source, i, c)
+			canOpen, canClose := flanking(source, i, i+n, c)
+			delim := &inline{kind: delimiterInline, char: c, count: n, origCount: n, canOpen: canOpen, canClose: canClose, pos: i, prevDelim: lastDelim}
+			if lastDelim != nil {
+				lastDelim.nextDelim = delim
+			} else {
+				firstDelim = delim
+			}
+			lastDelim = delim
+			add(delim)
+			i += n
+
+		case '!', '[':
+			open := i
+			if c == '!' {
+				open++
+			}
+			if open < len(source) && source[open] == '[' {
+				if link, end, ok := p.parseLink(scanner, open, c == '!', depth); ok {
+					add(link)
+					i = end
+					continue
+				}
+			}
+			text.WriteByte(c)
+			i++
+
+		case '<':
+			rest := source[i:]
+			if m := autolinkPattern.FindStringSubmatch(rest); m != nil {
+				add(&inline{kind: linkInline, dest: m[1], children: &inline{kind: textInline, text: m[1]}})
+				i += len(m[0])
+			} else if m := emailAutolinkPattern.FindStringSubmatch(rest); m != nil {
+				add(&inline{kind: linkInline, dest: "mailto:" + m[1], children: &inline{kind: textInline, text: m[1]}})
+				i += len(m[0])
+			} else if m := rawHTMLPattern.FindString(rest); m != "" {
+				add(&inline{kind: rawInline, text: m})
+				i += len(m)
+			} else {
+				text.WriteByte('<')
+				i++
+			}
+
+		case '&':
+			if m := entityPattern.FindString(source[i:]); m != "" {
+				if decoded := html.UnescapeString(m); decoded != m {
+					text.WriteString(decoded)
+					i += len(m)
+					continue
+				}
+			}
+			text.WriteByte('&')
+			i++
+
+		default:
+			j := i + 1
+			for j < len(source) && !strings.ContainsRune("\\\n`*_![<&", rune(source[j])) {
+				j++
+			}
+			text.WriteString(source[i:j])
+			i = j
+		}
+	}
+	flush()
+	processEmphasis(&list, firstDelim)
+	return list.head
+}
+
+// parseLink reads a link or image whose text opens with the [ at open. Inline links carry
+// their destination in parentheses; reference links name a link reference definition,
+// either in a second pair of brackets or by their text. It returns the index after the link.
+func (p *parser) parseLink(scanner *inlineScanner, open int, image bool, depth int) (*inline, int, bool) {
+	source := scanner.source
+	closing, ok := scanner.brackets[open]
+	if !ok || depth >= maxInlineDepth {
+		return nil, 0, false
+	}
+	kind := linkInline
+	if image {
+		kind = imageInline
+	}
+	label := source[open+1 : closing]
+	pos := closing + 1
+
+	if pos < len(source) && source[pos] == '(' {
+		if dest, title, end, ok := parseLinkTail(source, pos); ok {
+			return &inline{kind: kind, dest: dest, title: title, children: p.parseInline(label, depth+1)}, end, true
+		}
+	}
+
+	if pos < len(source) && source[pos] == '[' {
+		if refClosing, ok := scanner.brackets[pos]; ok {
+			name := source[pos+1 : refClosing]
+			if name == "" {
+				// A collapsed reference, [text][], names the definition by its text
+				name = label
+			}
+			if ref, ok := p.refs[normalizeLabel(name)]; ok {
+				return &inline{kind: kind, dest: ref.dest, title: ref.title, children: p.parseInline(label, depth+1)}, refClosing + 1, true
+			}
+		}
+	}
+
+	if ref, ok := p.refs[normalizeLabel(label)]; ok {
+		return &inline{kind: kind, dest: ref.dest, title: ref.title, children: p.parseInline(label, depth+1)}, pos, true
+	}
+	return nil, 0, false
+}
+
+// parseLinkTail reads an inline link's (destination "title") starting at the ( at pos
+func parseLinkTail(source string, pos int) (dest, title string, end int, ok bool) {
+	i := skipLinkSpace(source, pos+1)
+	switch {
+	case i < len(source) && source[i] == '<':
+		closing := strings.IndexAny(source[i+1:], "<>\n")
+		if closing < 0 || source[i+1+closing] != '>' {
+			return "", "", 0, false
+		}
+		dest = source[i+1 : i+1+closing]
+		i += closing + 2
+	default:
+		start, parens := i, 0
+	scan:
+		for i < len(source) {
+			switch c := source[i]; {
+			case c == '\\' && i+1 < len(source) && isASCIIPunct(source[i+1]):
+				i++
+			case c == '(':
+				// Bounding nesting also bounds the scan when the parentheses never close
+				if parens++; parens > maxLinkParens {
+					return "", "", 0, false
+				}
+			case c == ')':
+				if parens == 0 {
+					break scan
+				}
+				parens--
+			case c <= ' ':
+				break scan
+			}
+			i++
+		}
+		if parens != 0 {
+			return "", "", 0, false
+		}
+		dest = source[start:i]
+	}
+
+	j := skipLinkSpace(source, i)
+	if j > i && j < len(source) && strings.IndexByte(`"'(`, source[j]) >= 0 {
+		closer := source[j]
+		if closer == '(' {
+			closer = ')'
+		}
+		k := j + 1
+		for k < len(source) && source[k] != closer {
+			if source[k] == '\\' {
+				k++
+			}
+			k++
+		}
+		if k >= len(source) {
+			return "", "", 0, false
+		}
+		title = unescapeText(source[j+1 : k])
+		j = skipLinkSpace(source, k+1)
+	}
+	if j >= len(source) || source[j] != ')' {
+		return "", "", 0, false
+	}
+	return unescapeText(dest), title, j + 1, true
+}
+
+// skipLinkSpace skips spaces, tabs and at most one line break
+func skipLinkSpace(source string, i int) int {
+	newline := false
+	for i < len(source) {
+		switch source[i] {
+		case ' ', '\t':
+		case '\n':
+			if newline {
+				return i
+			}
+			newline = true
+		default:
+			return i
+		}
+		i++
+	}
+	return i
+}
+
+// flanking reports whether the delimiter run source[start:end] can open or close emphasis.
+// Underscores inside words are literal.
+func flanking(source string, start, end int, c byte) (canOpen, canClose bool) {
+	before, after := ' ', ' '
+	if start > 0 {
+		before, _ = utf8.DecodeLastRuneInString(source[:start])
+	}
+	if end < len(source) {
+		after, _ = utf8.DecodeRuneInString(source[end:])
+	}
+	left := !unicode.IsSpace(after) && (!isPunct(after) || unicode.IsSpace(before) || isPunct(before))
+	right := !unicode.IsSpace(before) && (!isPunct(before) || unicode.IsSpace(after) || isPunct(after))
+	if c == '*' {
+		return left, right
+	}
+	return left && (!right || isPunct(before)), right && (!left || isPunct(after))
+}
+
+// processEmphasis matches the delimiter runs from delimiters onwards into emphasis, innermost
+// first, following the CommonMark algorithm. Unmatched delimiters are left as literal text.
+func processEmphasis(list *inlineList, delimiters *inline) {
+	type bottomKey struct {
+		char    byte
+		canOpen bool
+		mod     int
+	}
+	// Position of the earliest delimiter still worth searching, per kind of closer
+	bottoms := make(map[bottomKey]int)
+
+	for closer := delimiters; closer != nil; {
+		if !closer.canClose {
+			closer = closer.nextDelim
+			continue
+		}
+		key := bottomKey{closer.char, closer.canOpen, closer.origCount % 3}
+		var opener *inline
+		for o := closer.prevDelim; o != nil && o.pos >= bottoms[key]; o = o.prevDelim {
+			if o.char != closer.char || !o.canOpen {
+				continue
+			}
+			// The rule of three: a run that can both open and close only pairs with another
+			// when their lengths do not sum to a multiple of three
+			if (o.canClose || closer.canOpen) && (o.origCount+closer.origCount)%3 == 0 &&
+				!(o.origCount%3 == 0 && closer.origCount%3 == 0) {
+				continue
+			}
+			opener = o
+			break
+		}
+		if opener == nil {
+			bottoms[key] = closer.pos
+			next := closer.nextDelim
+			if !closer.canOpen {
+				removeDelimiter(closer)
+			}
+			closer = next
+			continue
+		}
+
+		used := 1
+		kind := emphasisInline
+		if opener.count >= 2 && closer.count >= 2 {
+			used = 2
+			kind = strongInline
+		}
+		opener.count -= used
+		closer.count -= used
+
+		// Delimiters inside the emphasis can no longer match anything outside it
+		opener.nextDelim, closer.prevDelim = closer, opener
+		wrapper := &inline{kind: kind}
+		if opener.next != closer {
+			first, last := opener.next, closer.prev
+			first.prev, last.next = nil, nil
+			wrapper.children = first
+		}
+		opener.next, wrapper.prev = wrapper, opener
+		wrapper.next, closer.prev = closer, wrapper
+
+		if opener.count == 0 {
+			list.remove(opener)
+			removeDelimiter(opener)
+		}
+		if closer.count == 0 {
+			next := closer.nextDelim
+			list.remove(closer)
+			removeDelimiter(closer)
+			closer = next
+		}
+	}
+}
+
+// removeDelimiter unlinks a delimiter run from the list of delimiters
+func removeDelimiter(d *inline) {
+	if d.prevDelim != nil {
+		d.prevDelim.nextDelim = d.nextDelim
+	}
+	if d.nextDelim != nil {
+		d.nextDelim.prevDelim = d.prevDelim
+	}
+}
+
+// renderInlines writes a list of inlines as HTML
+func renderInlines(out *strings.Builder, n *inline) {
+	for ; n != nil; n = n.next {
+		switch n.kind {
+		case textInline:
+			out.WriteString(html.EscapeString(n.text))
+		case rawInline:
+			out.WriteString(n.text)
+		case codeInline:
+			out.WriteString("<code>" + html.EscapeString(n.text) + "</code>")
+		case emphasisInline:
+			out.WriteString("<em>")
+			renderInlines(out, n.children)
+			out.WriteString("</em>")
+		case strongInline:
+			out.WriteString("<strong>")
+			renderInlines(out, n.children)
+			out.WriteString("</strong>")
+		case linkInline:
+			out.WriteString(`<a href="` + html.EscapeString(encodeURL(n.dest)) + `"`)
+			if n.title != "" {
+				out.WriteString(` title="` + html.EscapeString(n.title) + `"`)
+			}
+			out.WriteString(">")
+			renderInlines(out, n.children)
+			out.WriteString("</a>")
+		case imageInline:
+			out.WriteString(`<img src="` + html.EscapeString(encodeURL(n.dest)) + `" alt="` + html.EscapeString(plainText(n.children)) + `"`)
+			if n.title != "" {
+				out.WriteString(` title="` + html.EscapeString(n.title) + `"`)
+			}
+			out.WriteString(" />")
+		case softBreakInline:
+			out.WriteString("\n")
+		case hardBreakInline:
+			out.WriteString("<br />\n")
+		case delimiterInline:
+			out.WriteString(strings.Repeat(string(n.char), n.count))
+		}
+	}
+}
+
+// plainText flattens inlines to text, for image descriptions
+func plainText(n *inline) string {
+	var b strings.Builder
+	for ; n != nil; n = n.next {
+		switch n.kind {
+		case textInline, codeInline:
+			b.WriteString(n.text)
+		case softBreakInline, hardBreakInline:
+			b.WriteString(" ")
+		case delimiterInline:
+			b.WriteString(strings.Repeat(string(n.char), n.count))
+		case rawInline:
+		default:
+			b.WriteString(plainText(n.children))
+		}
+	}
+	return b.String()
+}
+
+// encodeURL percent-encodes the characters of a link destination that are not allowed in
+// URLs, leaving existing escapes alone
+func encodeURL(dest string) string {
+	var b strings.Builder
+	for i := 0; i < len(dest); i++ {
+		c := dest[i]
+		switch {
+		case c == '%' && i+2 < len(dest) && isHex(dest[i+1]) && isHex(dest[i+2]):
+			b.WriteByte(c)
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', strings.IndexByte(";/?:@&=+$,-_.!~*'()#", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// codeSpanText converts line breaks in a code span to spaces and strips one space from
+// each side, so code starting or ending with a backtick can be written
+func codeSpanText(code string) string {
+	code = strings.ReplaceAll(code, "\n", " ")
+	if len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+		code = code[1 : len(code)-1]
+	}
+	return code
+}
+
+// unescapeText resolves backslash escapes and entities, for link destinations, titles and
+// code languages
+func unescapeText(s string) string {
+	if !strings.ContainsAny(s, `\&`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]):
+			b.WriteByte(s[i+1])
+			i++
+			continue
+		case s[i] == '&':
+			if m := entityPattern.FindString(s[i:]); m != "" {
+				if decoded := html.UnescapeString(m); decoded != m {
+					b.WriteString(decoded)
+					i += len(m) - 1
+					continue
+				}
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func runLength(s string, i int, c byte) int {
+	n := 0
+	for i+n < len(s) && s[i+n] == c {
+		n++
+	}
+	return n
+}
+
+func skipSpaces(s string, i int) int {
+	for i < len(s) && s[i] == ' ' {
+		i++
+	}
+	return i
+}
+
+func isASCIIPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
+
+func isPunct(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
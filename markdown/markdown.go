@@ -0,0 +1,641 @@
+// Package markdown converts CommonMark, with GitHub-style tables, to HTML for page content.
+// Raw HTML is allowed in the source, but the output is passed through Sanitize, so only an
+// allowlist of tags and attributes survives and authors cannot inject scripts.
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxDepth bounds how deeply block quotes and lists nest. Deeper markers are read as text,
+// so hostile input cannot exhaust the stack.
+const maxDepth = 32
+
+// Render converts a Markdown document to sanitized HTML
+func Render(source string) string {
+	p := &parser{refs: make(map[string]linkReference)}
+	blocks := p.parseBlocks(splitSourceLines(source), 0)
+	var out strings.Builder
+	p.renderBlocks(&out, blocks, false)
+	return Sanitize(out.String())
+}
+
+// RenderInline converts Markdown for a single line, such as a page header, to sanitized
+// HTML without wrapping it in a paragraph
+func RenderInline(source string) string {
+	p := &parser{refs: make(map[string]linkReference)}
+	var out strings.Builder
+	p.renderInline(&out, strings.TrimSpace(strings.ReplaceAll(source, "\r\n", "\n")))
+	return Sanitize(out.String())
+}
+
+// parser holds the link reference definitions of the document being rendered
+type parser struct {
+	refs map[string]linkReference
+}
+
+// linkReference is the target of a link reference definition such as [label]: /url "title"
+type linkReference struct {
+	dest  string
+	title string
+}
+
+type blockKind int
+
+const (
+	paragraphBlock blockKind = iota
+	headingBlock
+	ruleBlock
+	codeBlock
+	quoteBlock
+	listBlock
+	itemBlock
+	htmlBlock
+	tableBlock
+)
+
+// block is a parsed block. Inline Markdown is kept as source and rendered once every link
+// reference definition in the document is known.
+type block struct {
+	kind     blockKind
+	text     string     // Inline source of paragraphs and headings; content of code and HTML blocks
+	level    int        // Heading level
+	info     string     // Language of fenced code
+	children []*block   // Contents of quotes, lists and list items
+	ordered  bool       // Ordered list
+	start    int        // First number of an ordered list
+	tight    bool       // List items without blank lines, rendered without paragraphs
+	align    []string   // Column alignment of tables
+	rows     [][]string // Table cells; the first row is the header
+}
+
+var (
+	atxHeadingPattern     = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	setextPattern         = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	rulePattern           = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	fencePattern          = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})(.*)$")
+	listMarkerPattern     = regexp.MustCompile(`^( {0,3})([-+*]|[0-9]{1,9}[.)])([ \t]+|$)`)
+	templateLinePattern   = regexp.MustCompile(`^ {0,3}\{\{.*\}\}[ \t]*$`)
+	tableDelimiterPattern = regexp.MustCompile(`^[ \t]*:?-+:?[ \t]*$`)
+	linkReferencePattern  = regexp.MustCompile(`^ {0,3}\[((?:[^\\\[\]]|\\.){1,999})\]:[ \t]*\n?[ \t]*(<[^<>\n]*>|[^<\s]\S*)(?:(?:[ \t]+|[ \t]*\n[ \t]*)("(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'|\((?:[^()\\]|\\.)*\)))?[ \t]*(?:\n|$)`)
+
+	// HTML blocks that run until a closing tag, a comment end, or a blank line
+	htmlRawStartPattern     = regexp.MustCompile(`(?i)^ {0,3}<(script|pre|style|textarea)(?:[ \t>]|$)`)
+	htmlRawEndPattern       = regexp.MustCompile(`(?i)</(?:script|pre|style|textarea)>`)
+	htmlCommentStartPattern = regexp.MustCompile(`^ {0,3}<!--`)
+	htmlBlockStartPattern   = regexp.MustCompile(`(?i)^ {0,3}</?(?:address|article|aside|base|basefont|blockquote|body|caption|center|col|colgroup|dd|details|dialog|dir|div|dl|dt|fieldset|figcaption|figure|footer|form|frame|frameset|h[1-6]|head|header|hr|html|iframe|legend|li|link|main|menu|menuitem|nav|noframes|ol|optgroup|option|p|param|section|summary|table|tbody|td|tfoot|th|thead|title|tr|track|ul)(?:[ \t]|/?>|$)`)
+	htmlTagLinePattern      = regexp.MustCompile(`^ {0,3}(?:` + openTagPattern + `|` + closeTagPattern + `)[ \t]*$`)
+)
+
+// splitSourceLines splits source into lines, normalizing line endings and expanding tabs
+// in indentation
+func splitSourceLines(source string) []string {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	source = strings.ReplaceAll(source, "\r", "\n")
+	source = strings.ReplaceAll(source, "\x00", "�")
+	lines := strings.Split(source, "\n")
+	for i, line := range lines {
+		lines[i] = expandTabs(line)
+	}
+	return lines
+}
+
+// expandTabs replaces tabs in a line's indentation with spaces up to the next multiple of four
+func expandTabs(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+	var b strings.Builder
+	column := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\t':
+			width := 4 - column%4
+			b.WriteString(strings.Repeat(" ", width))
+			column += width
+		case ' ':
+			b.WriteByte(' ')
+			column++
+		default:
+			b.WriteString(line[i:])
+			return b.String()
+		}
+	}
+	return b.String()
+}
+
+// indentation counts a line's leading spaces
+func indentation(line string) int {
+	n := 0
+	for n < len(line) && line[n] == ' ' {
+		n++
+	}
+	return n
+}
+
+// stripIndent removes up to n leading spaces
+func stripIndent(line string, n int) string {
+	i := 0
+	for i < n && i < len(line) && line[i] == ' ' {
+		i++
+	}
+	return line[i:]
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// listMarker describes the marker starting a list item
+type listMarker struct {
+	ordered bool
+	char    byte // The bullet, or the delimiter after an ordered list's number
+	start   int
+	offset  int // Column where the item's content starts
+	empty   bool
+}
+
+// parseListMarker reads the list item marker at the start of line
+func parseListMarker(line string) (listMarker, bool) {
+	m := listMarkerPattern.FindStringSubmatch(line)
+	if m == nil || rulePattern.MatchString(line) {
+		return listMarker{}, false
+	}
+	marker := listMarker{char: m[2][len(m[2])-1]}
+	if len(m[2]) > 1 || (m[2][0] >= '0' && m[2][0] <= '9') {
+		marker.ordered = true
+		marker.start, _ = strconv.Atoi(m[2][:len(m[2])-1])
+	}
+	markerEnd := len(m[1]) + len(m[2])
+	spaces := len(m[3])
+	marker.empty = isBlank(line[markerEnd:])
+	if marker.empty || spaces > 4 {
+		// Content indented further starts an indented code block inside the item
+		spaces = 1
+	}
+	marker.offset = markerEnd + spaces
+	return marker, true
+}
+
+// interruptsParagraph reports whether line starts a block that ends a paragraph
+func interruptsParagraph(line string) bool {
+	if indentation(line) >= 4 {
+		return false
+	}
+	if atxHeadingPattern.MatchString(line) || fencePattern.MatchString(line) || rulePattern.MatchString(line) ||
+		strings.HasPrefix(strings.TrimLeft(line, " "), ">") || templateLinePattern.MatchString(line) ||
+		htmlRawStartPattern.MatchString(line) || htmlCommentStartPattern.MatchString(line) || htmlBlockStartPattern.MatchString(line) {
+		return true
+	}
+	if marker, ok := parseListMarker(line); ok {
+		return !marker.empty && (!marker.ordered || marker.start == 1)
+	}
+	return false
+}
+
+// parseBlocks parses lines into blocks. depth counts the enclosing quotes and list items.
+func (p *parser) parseBlocks(lines []string, depth int) []*block {
+	var blocks []*block
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		if isBlank(line) {
+			i++
+			continue
+		}
+
+		if indentation(line) >= 4 {
+			var content []string
+			for i < len(lines) && (isBlank(lines[i]) || indentation(lines[i]) >= 4) {
+				content = append(content, stripIndent(lines[i], 4))
+				i++
+			}
+			for len(content) > 0 && isBlank(content[len(content)-1]) {
+				content = content[:len(content)-1]
+			}
+			blocks = append(blocks, &block{kind: codeBlock, text: strings.Join(content, "\n") + "\n"})
+			continue
+		}
+
+		if m := fencePattern.FindStringSubmatch(line); m != nil && !(m[2][0] == '`' && strings.Contains(m[3], "`")) {
+			b, next := parseFence(lines, i, len(m[1]), m[2], m[3])
+			blocks = append(blocks, b)
+			i = next
+			continue
+		}
+
+		if m := atxHeadingPattern.FindStringSubmatch(line); m != nil {
+			blocks = append(blocks, &block{kind: headingBlock, level: len(m[1]), text: strings.TrimSpace(m[2])})
+			i++
+			continue
+		}
+
+		if rulePattern.MatchString(line) {
+			blocks = append(blocks, &block{kind: ruleBlock})
+			i++
+			continue
+		}
+
+		if templateLinePattern.MatchString(line) {
+			// Embedded template references stand alone rather than inside a paragraph
+			blocks = append(blocks, &block{kind: htmlBlock, text: strings.TrimSpace(line)})
+			i++
+			continue
+		}
+
+		if depth < maxDepth && strings.HasPrefix(strings.TrimLeft(line, " "), ">") {
+			b, next := p.parseQuote(lines, i, depth)
+			blocks = append(blocks, b)
+			i = next
+			continue
+		}
+
+		if _, ok := parseListMarker(line); ok && depth < maxDepth {
+			b, next := p.parseList(lines, i, depth)
+			blocks = append(blocks, b)
+			i = next
+			continue
+		}
+
+		if b, next, ok := parseHTMLBlock(lines, i); ok {
+			blocks = append(blocks, b)
+			i = next
+			continue
+		}
+
+		if b, next, ok := parseTable(lines, i); ok {
+			blocks = append(blocks, b)
+			i = next
+			continue
+		}
+
+		b, next := p.parseParagraph(lines, i)
+		if b != nil {
+			blocks = append(blocks, b)
+		}
+		i = next
+	}
+	return blocks
+}
+
+// parseFence reads a fenced code block opened at lines[i], returning it and the index of
+// the line after it. An unclosed fence runs to the end of its container.
+func parseFence(lines []string, i, indent int, fence, info string) (*block, int) {
+	b := &block{kind: codeBlock}
+	if fields := strings.Fields(unescapeText(info)); len(fields) > 0 {
+		b.info = fields[0]
+	}
+	var content []string
+	for i++; i < len(lines); i++ {
+		line := lines[i]
+		if indentation(line) < 4 {
+			trimmed := strings.TrimSpace(line)
+			if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+				i++
+				break
+			}
+		}
+		content = append(content, stripIndent(line, indent))
+	}
+	if len(content) > 0 {
+		b.text = strings.Join(content, "\n") + "\n"
+	}
+	return b, i
+}
+
+// parseQuote reads a block quote starting at lines[i], including lazy continuation lines
+func (p *parser) parseQuote(lines []string, i, depth int) (*block, int) {
+	var inner []string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimLeft(line, " ")
+		if indentation(line) < 4 && strings.HasPrefix(trimmed, ">") {
+			rest := trimmed[1:]
+			if strings.HasPrefix(rest, " ") {
+				rest = rest[1:]
+			}
+			inner = append(inner, rest)
+			continue
+		}
+		// A line without > continues the quote's last paragraph
+		if isBlank(line) || len(inner) == 0 || isBlank(inner[len(inner)-1]) || interruptsParagraph(line) {
+			break
+		}
+		inner = append(inner, line)
+	}
+	return &block{kind: quoteBlock, children: p.parseBlocks(inner, depth+1)}, i
+}
+
+// parseList reads a list starting at lines[i]. The list ends at a line that is neither part
+// of an item nor another item of the same kind.
+func (p *parser) parseList(lines []string, i, depth int) (*block, int) {
+	first, _ := parseListMarker(lines[i])
+	list := &block{kind: listBlock, ordered: first.ordered, start: first.start, tight: true}
+	blankBefore := false
+	for i < len(lines) {
+		marker, ok := parseListMarker(lines[i])
+		if !ok || marker.ordered != first.ordered || marker.char != first.char {
+			break
+		}
+		if blankBefore {
+			list.tight = false
+		}
+
+		var content []string
+		if !marker.empty {
+			content = append(content, lines[i][marker.offset:])
+		}
+		startedEmpty := marker.empty
+		sawBlank := false
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			if isBlank(line) {
+				if startedEmpty && len(content) == 0 {
+					// An item starting with a blank line cannot hold another
+					break
+				}
+				content = append(content, "")
+				sawBlank = true
+				continue
+			}
+			if indentation(line) >= marker.offset {
+				content = append(content, line[marker.offset:])
+				sawBlank = false
+				continue
+			}
+			if sawBlank || interruptsParagraph(line) {
+				break
+			}
+			if _, ok := parseListMarker(line); ok {
+				break
+			}
+			// A lazy continuation line of the item's last paragraph
+			content = append(content, strings.TrimLeft(line, " "))
+		}
+
+		trailingBlanks := 0
+		for len(content) > 0 && isBlank(content[len(content)-1]) {
+			content = content[:len(content)-1]
+			trailingBlanks++
+		}
+		blankBefore = trailingBlanks > 0 || (startedEmpty && i < len(lines) && isBlank(lines[i]))
+
+		item := &block{kind: itemBlock, children: p.parseBlocks(content, depth+1)}
+		if len(item.children) > 1 && hasBlankBetween(content) {
+			list.tight = false
+		}
+		list.children = append(list.children, item)
+	}
+	return list, i
+}
+
+// hasBlankBetween reports whether a blank line separates two lines of content
+func hasBlankBetween(content []string) bool {
+	for i := 1; i < len(content)-1; i++ {
+		if isBlank(content[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHTMLBlock reads raw HTML starting at lines[i]. Blocks starting with script, pre,
+// style or textarea run to the closing tag, comments to -->, and others to a blank line.
+func parseHTMLBlock(lines []string, i int) (*block, int, bool) {
+	line := lines[i]
+	var ends func(string) bool
+	inclusive := true
+	switch {
+	case htmlRawStartPattern.MatchString(line):
+		ends = htmlRawEndPattern.MatchString
+	case htmlCommentStartPattern.MatchString(line):
+		ends = func(line string) bool { return strings.Contains(line, "-->") }
+	case htmlBlockStartPattern.MatchString(line) || htmlTagLinePattern.MatchString(line):
+		ends = isBlank
+		inclusive = false
+	default:
+		return nil, i, false
+	}
+
+	start := i
+	for ; i < len(lines); i++ {
+		if ends(lines[i]) && (i > start || inclusive) {
+			if inclusive {
+				i++
+			}
+			break
+		}
+	}
+	return &block{kind: htmlBlock, text: strings.Join(lines[start:i], "\n")}, i, true
+}
+
+// parseTable reads a table whose header row is lines[i] and whose delimiter row follows.
+// The table ends at a blank line or the start of another block.
+func parseTable(lines []string, i int) (*block, int, bool) {
+	if i+1 >= len(lines) || !strings.Contains(lines[i+1], "|") {
+		return nil, i, false
+	}
+	header := splitTableRow(lines[i])
+	delimiters := splitTableRow(lines[i+1])
+	if len(header) != len(delimiters) {
+		return nil, i, false
+	}
+	b := &block{kind: tableBlock}
+	for _, cell := range delimiters {
+		if !tableDelimiterPattern.MatchString(cell) {
+			return nil, i, false
+		}
+		cell = strings.TrimSpace(cell)
+		switch {
+		case strings.HasPrefix(cell, ":") && strings.HasSuffix(cell, ":"):
+			b.align = append(b.align, "center")
+		case strings.HasSuffix(cell, ":"):
+			b.align = append(b.align, "right")
+		case strings.HasPrefix(cell, ":"):
+			b.align = append(b.align, "left")
+		default:
+			b.align = append(b.align, "")
+		}
+	}
+
+	b.rows = append(b.rows, header)
+	for i += 2; i < len(lines) && !isBlank(lines[i]) && !interruptsParagraph(lines[i]); i++ {
+		row := splitTableRow(lines[i])
+		// Rows have as many cells as the header
+		for len(row) < len(header) {
+			row = append(row, "")
+		}
+		b.rows = append(b.rows, row[:len(header)])
+	}
+	return b, i, true
+}
+
+// splitTableRow splits a table row on unescaped pipes, dropping the optional outer pipes
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// parseParagraph reads a paragraph starting at lines[i], which becomes a heading when
+// underlined with = or -. Link reference definitions at its start are recorded and
+// removed; nil is returned when nothing else remains.
+func (p *parser) parseParagraph(lines []string, i int) (*block, int) {
+	text := []string{strings.TrimLeft(lines[i], " ")}
+	for i++; i < len(lines); i++ {
+		line := lines[i]
+		if isBlank(line) {
+			break
+		}
+		if m := setextPattern.FindStringSubmatch(line); m != nil && indentation(line) < 4 {
+			level := 2
+			if m[1][0] == '=' {
+				level = 1
+			}
+			return &block{kind: headingBlock, level: level, text: strings.TrimSpace(strings.Join(text, "\n"))}, i + 1
+		}
+		if interruptsParagraph(line) {
+			break
+		}
+		text = append(text, strings.TrimLeft(line, " "))
+	}
+
+	source := strings.Join(text, "\n")
+	for {
+		m := linkReferencePattern.FindStringSubmatch(source)
+		if m == nil || strings.TrimSpace(m[1]) == "" {
+			break
+		}
+		label := normalizeLabel(m[1])
+		if _, exists := p.refs[label]; !exists {
+			// The first definition of a label wins
+			dest := m[2]
+			if strings.HasPrefix(dest, "<") {
+				dest = dest[1 : len(dest)-1]
+			}
+			title := ""
+			if m[3] != "" {
+				title = unescapeText(m[3][1 : len(m[3])-1])
+			}
+			p.refs[label] = linkReference{dest: unescapeText(dest), title: title}
+		}
+		source = source[len(m[0]):]
+	}
+	source = strings.TrimRight(source, " \t\n")
+	if source == "" {
+		return nil, i
+	}
+	return &block{kind: paragraphBlock, text: source}, i
+}
+
+// normalizeLabel matches link labels case-insensitively and ignoring runs of whitespace
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
+
+// renderBlocks writes blocks as HTML. Paragraphs in tight lists are written without <p>.
+func (p *parser) renderBlocks(out *strings.Builder, blocks []*block, tight bool) {
+	for i, b := range blocks {
+		switch b.kind {
+		case paragraphBlock:
+			if tight {
+				p.renderInline(out, b.text)
+				if i < len(blocks)-1 {
+					out.WriteString("\n")
+				}
+				continue
+			}
+			out.WriteString("<p>")
+			p.renderInline(out, b.text)
+			out.WriteString("</p>\n")
+		case headingBlock:
+			level := strconv.Itoa(b.level)
+			out.WriteString("<h" + level + ">")
+			p.renderInline(out, b.text)
+			out.WriteString("</h" + level + ">\n")
+		case ruleBlock:
+			out.WriteString("<hr />\n")
+		case codeBlock:
+			out.WriteString("<pre><code")
+			if b.info != "" {
+				out.WriteString(` class="language-` + html.EscapeString(b.info) + `"`)
+			}
+			out.WriteString(">" + html.EscapeString(b.text) + "</code></pre>\n")
+		case quoteBlock:
+			out.WriteString("<blockquote>\n")
+			p.renderBlocks(out, b.children, false)
+			out.WriteString("</blockquote>\n")
+		case listBlock:
+			tag := "ul"
+			if b.ordered {
+				tag = "ol"
+			}
+			out.WriteString("<" + tag)
+			if b.ordered && b.start != 1 {
+				out.WriteString(` start="` + strconv.Itoa(b.start) + `"`)
+			}
+			out.WriteString(">\n")
+			for _, item := range b.children {
+				out.WriteString("<li>")
+				if len(item.children) > 0 && !(b.tight && item.children[0].kind == paragraphBlock) {
+					out.WriteString("\n")
+				}
+				p.renderBlocks(out, item.children, b.tight)
+				out.WriteString("</li>\n")
+			}
+			out.WriteString("</" + tag + ">\n")
+		case htmlBlock:
+			out.WriteString(b.text + "\n")
+		case tableBlock:
+			p.renderTable(out, b)
+		}
+	}
+}
+
+// renderTable writes a table, with a body only when there are rows after the header
+func (p *parser) renderTable(out *strings.Builder, b *block) {
+	row := func(cells []string, tag string) {
+		out.WriteString("<tr>\n")
+		for i, cell := range cells {
+			out.WriteString("<" + tag)
+			if b.align[i] != "" {
+				out.WriteString(` align="` + b.align[i] + `"`)
+			}
+			out.WriteString(">")
+			p.renderInline(out, cell)
+			out.WriteString("</" + tag + ">\n")
+		}
+		out.WriteString("</tr>\n")
+	}
+
+	out.WriteString("<table>\n<thead>\n")
+	row(b.rows[0], "th")
+	out.WriteString("</thead>\n")
+	if len(b.rows) > 1 {
+		out.WriteString("<tbody>\n")
+		for _, cells := range b.rows[1:] {
+			row(cells, "td")
+		}
+		out.WriteString("</tbody>\n")
+	}
+	out.WriteString("</table>\n")
+}
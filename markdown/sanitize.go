@@ -0,0 +1,326 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// allowedTags maps each tag Sanitize keeps to the attributes it may carry besides class
+// and title. Other tags are removed and their text kept.
+var allowedTags = map[string][]string{
+	"a":          {"href"},
+	"abbr":       nil,
+	"b":          nil,
+	"blockquote": nil,
+	"br":         nil,
+	"code":       nil,
+	"dd":         nil,
+	"del":        nil,
+	"div":        nil,
+	"dl":         nil,
+	"dt":         nil,
+	"em":         nil,
+	"figcaption": nil,
+	"figure":     nil,
+	"h1":         nil,
+	"h2":         nil,
+	"h3":         nil,
+	"h4":         nil,
+	"h5":         nil,
+	"h6":         nil,
+	"hr":         nil,
+	"i":          nil,
+	"img":        {"src", "alt", "width", "height"},
+	"ins":        nil,
+	"kbd":        nil,
+	"li":         nil,
+	"mark":       nil,
+	"ol":         {"start"},
+	"p":          nil,
+	"pre":        nil,
+	"s":          nil,
+	"small":      nil,
+	"span":       nil,
+	"strong":     nil,
+	"sub":        nil,
+	"sup":        nil,
+	"table":      nil,
+	"tbody":      nil,
+	"td":         {"align", "colspan", "rowspan"},
+	"tfoot":      nil,
+	"th":         {"align", "colspan", "rowspan", "scope"},
+	"thead":      nil,
+	"tr":         nil,
+	"u":          nil,
+	"ul":         nil,
+}
+
+// droppedTags are removed together with everything up to their closing tag
+var droppedTags = map[string]bool{
+	"applet": true, "embed": true, "frame": true, "frameset": true, "head": true, "iframe": true,
+	"math": true, "noembed": true, "noframes": true, "noscript": true, "object": true, "plaintext": true,
+	"script": true, "select": true, "style": true, "svg": true, "template": true, "textarea": true,
+	"title": true, "xmp": true,
+}
+
+// voidTags have no content or closing tag
+var voidTags = map[string]bool{"br": true, "hr": true, "img": true}
+
+var classPattern = regexp.MustCompile(`^[A-Za-z0-9 _+#.-]*$`)
+
+// Sanitize reduces HTML to the allowlisted tags and attributes. Links and images may only
+// point at http, https, mailto (links only) or relative URLs. Comments are removed, text is
+// re-escaped, and tags are balanced so content cannot close elements around it.
+func Sanitize(source string) string {
+	var out strings.Builder
+	var open []string
+	lower := asciiLower(source)
+	for i := 0; i < len(source); {
+		lt := strings.IndexByte(source[i:], '<')
+		if lt < 0 {
+			writeText(&out, source[i:])
+			break
+		}
+		writeText(&out, source[i:i+lt])
+		i += lt
+		rest := source[i:]
+
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			i = skipPast(source, i+4, "-->")
+			continue
+		case strings.HasPrefix(rest, "<!") || strings.HasPrefix(rest, "<?"):
+			i = skipPast(source, i+2, ">")
+			continue
+		}
+
+		t, ok := parseTag(rest)
+		if !ok {
+			out.WriteString("&lt;")
+			i++
+			continue
+		}
+		i += t.length
+
+		if droppedTags[t.name] {
+			if !t.closing {
+				if end := strings.Index(lower[i:], "</"+t.name); end >= 0 {
+					i = skipPast(source, i+end, ">")
+				} else {
+					i = len(source)
+				}
+			}
+			continue
+		}
+		attributes, ok := allowedTags[t.name]
+		if !ok {
+			continue
+		}
+
+		if t.closing {
+			// Only close elements opened here, along with any left open inside them
+			for k := len(open) - 1; k >= 0; k-- {
+				if open[k] == t.name {
+					for len(open) > k {
+						out.WriteString("</" + open[len(open)-1] + ">")
+						open = open[:len(open)-1]
+					}
+					break
+				}
+			}
+			continue
+		}
+
+		out.WriteString("<" + t.name)
+		for _, attr := range t.attributes {
+			if !allowedAttribute(attr[0], attributes) {
+				continue
+			}
+			if value, ok := cleanAttribute(attr[0], attr[1]); ok {
+				out.WriteString(" " + attr[0] + `="` + escapeAttribute(value) + `"`)
+			}
+		}
+		if voidTags[t.name] {
+			out.WriteString(" />")
+		} else {
+			out.WriteString(">")
+			open = append(open, t.name)
+		}
+	}
+	for k := len(open) - 1; k >= 0; k-- {
+		out.WriteString("</" + open[k] + ">")
+	}
+	return out.String()
+}
+
+// htmlTag is a start or end tag read by parseTag
+type htmlTag struct {
+	name       string
+	closing    bool
+	attributes [][2]string // Names in lower case and decoded values
+	length     int
+}
+
+// parseTag reads the tag at the start of s, which begins with <. It fails when s does not
+// hold a complete tag, in which case the < is text.
+func parseTag(s string) (htmlTag, bool) {
+	var t htmlTag
+	i := 1
+	if i < len(s) && s[i] == '/' {
+		t.closing = true
+		i++
+	}
+	start := i
+	if i >= len(s) || !isLetter(s[i]) {
+		return t, false
+	}
+	for i < len(s) && (isLetter(s[i]) || s[i] >= '0' && s[i] <= '9' || s[i] == '-') {
+		i++
+	}
+	t.name = asciiLower(s[start:i])
+
+	for {
+		i = skipHTMLSpace(s, i)
+		if i >= len(s) {
+			return t, false
+		}
+		if s[i] == '>' {
+			t.length = i + 1
+			return t, true
+		}
+		nameStart := i
+		for i < len(s) && !isHTMLSpace(s[i]) && s[i] != '/' && s[i] != '>' && (s[i] != '=' || i == nameStart) {
+			i++
+		}
+		name := asciiLower(s[nameStart:i])
+		if name == "/" || name == "" {
+			i++
+			continue
+		}
+
+		value := ""
+		if j := skipHTMLSpace(s, i); j < len(s) && s[j] == '=' {
+			j = skipHTMLSpace(s, j+1)
+			if j < len(s) && (s[j] == '"' || s[j] == '\'') {
+				end := strings.IndexByte(s[j+1:], s[j])
+				if end < 0 {
+					return t, false
+				}
+				value = s[j+1 : j+1+end]
+				i = j + end + 2
+			} else {
+				valueStart := j
+				for j < len(s) && !isHTMLSpace(s[j]) && s[j] != '>' {
+					j++
+				}
+				value = s[valueStart:j]
+				i = j
+			}
+		}
+		t.attributes = append(t.attributes, [2]string{name, html.UnescapeString(value)})
+	}
+}
+
+// allowedAttribute reports whether a tag allowing attributes may carry the named attribute
+func allowedAttribute(name string, attributes []string) bool {
+	if name == "class" || name == "title" {
+		return true
+	}
+	for _, allowed := range attributes {
+		if name == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanAttribute checks an attribute's value, reporting false when it must be dropped
+func cleanAttribute(name, value string) (string, bool) {
+	switch name {
+	case "href":
+		return value, safeURL(value, "http", "https", "mailto")
+	case "src":
+		return value, safeURL(value, "http", "https")
+	case "width", "height", "colspan", "rowspan", "start":
+		if value == "" || len(value) > 4 || strings.Trim(value, "0123456789") != "" {
+			return "", false
+		}
+	case "align":
+		value = asciiLower(value)
+		return value, value == "left" || value == "center" || value == "right"
+	case "scope":
+		value = asciiLower(value)
+		return value, value == "row" || value == "col" || value == "rowgroup" || value == "colgroup"
+	case "class":
+		return value, classPattern.MatchString(value)
+	}
+	return value, true
+}
+
+// safeURL reports whether a URL is relative or uses one of schemes. Browsers ignore
+// whitespace and control characters in a scheme, so they are removed before checking.
+func safeURL(value string, schemes ...string) bool {
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, value)
+	colon := strings.IndexByte(cleaned, ':')
+	if colon < 0 || strings.ContainsAny(cleaned[:colon], "/?#") {
+		return true
+	}
+	scheme := asciiLower(cleaned[:colon])
+	for _, allowed := range schemes {
+		if scheme == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// writeText writes text with exactly the characters that need it escaped
+func writeText(out *strings.Builder, text string) {
+	out.WriteString(html.EscapeString(html.UnescapeString(text)))
+}
+
+// escapeAttribute escapes an attribute value. Braces are escaped too, so an embedded
+// template reference cannot be expanded inside an attribute.
+func escapeAttribute(value string) string {
+	return strings.ReplaceAll(html.EscapeString(value), "{", "&#123;")
+}
+
+// skipPast returns the index after the next end at or after i, or the end of s
+func skipPast(s string, i int, end string) int {
+	if k := strings.Index(s[i:], end); k >= 0 {
+		return i + k + len(end)
+	}
+	return len(s)
+}
+
+// asciiLower lowers ASCII letters only, keeping byte offsets unchanged
+func asciiLower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func skipHTMLSpace(s string, i int) int {
+	for i < len(s) && isHTMLSpace(s[i]) {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"time"
+)
+
+// Audit event types
+const (
+	AuditLoginSuccess           = "login.success"
+	AuditLoginFailure           = "login.failure"
+	AuditLogout                 = "logout"
+	AuditPasswordChanged        = "password.changed"
+	AuditPasswordResetRequested = "password_reset.requested"
+	AuditPasswordResetCompleted = "password_reset.completed"
+	AuditMagicLinkRequested     = "magic_link.requested"
+	AuditGroupMemberAdded       = "group.member_added"
+	AuditGroupMemberRemoved     = "group.member_removed"
+	AuditGroupNested            = "group.nested"
+	AuditGroupUnnested          = "group.unnested"
+	AuditPermissionGranted      = "permission.granted"
+	AuditPermissionRevoked      = "permission.revoked"
+	AuditSessionRevoked         = "session.revoked"
+	AuditImpersonationStart     = "impersonation.start"
+	AuditImpersonationStop      = "impersonation.stop"
+	AuditPageCreated            = "page.created"
+	AuditPageUpdated            = "page.updated"
+	AuditPageDeleted            = "page.deleted"
+	AuditPagePublished          = "page.published"
+	AuditPageStatusChanged      = "page.status_changed"
+	AuditPageScheduled          = "page.scheduled"
+)
+
+// AuditEventTypes lists every event type, in the order shown by the audit log filter
+var AuditEventTypes = []string{
+	AuditLoginSuccess,
+	AuditLoginFailure,
+	AuditLogout,
+	AuditPasswordChanged,
+	AuditPasswordResetRequested,
+	AuditPasswordResetCompleted,
+	AuditMagicLinkRequested,
+	AuditGroupMemberAdded,
+	AuditGroupMemberRemoved,
+	AuditGroupNested,
+	AuditGroupUnnested,
+	AuditPermissionGranted,
+	AuditPermissionRevoked,
+	AuditSessionRevoked,
+	AuditImpersonationStart,
+	AuditImpersonationStop,
+	AuditPageCreated,
+	AuditPageUpdated,
+	AuditPageDeleted,
+	AuditPagePublished,
+	AuditPageStatusChanged,
+	AuditPageScheduled,
+}
+
+// AuditEvent records who did what to whom. Names are copied at the time of the event so
+// the record stays readable after the users are renamed or deleted.
+type AuditEvent struct {
+	ID           int
+	EventType    string
+	ActorID      int
+	ActorName    string
+	TargetUserID int
+	TargetName   string
+	Details      string
+	IPAddress    string
+	UserAgent    string
+	CreatedAt    time.Time
+}
+
+// AuditFilter narrows an audit log query; zero values match everything
+type AuditFilter struct {
+	EventType string
+	Username  string // Matches the actor or the target
+	IPAddress string
+	Since     time.Time
+	Until     time.Time
+}
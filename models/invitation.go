@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+)
+
+// Invitation is an admin-issued, single-use link that lets someone create an account
+// already placed in the chosen groups
+type Invitation struct {
+	ID             int
+	Email          string
+	Token          string // SHA-256 hash of the token sent in the link
+	Groups         []string
+	InvitedBy      int
+	InvitedByName  string
+	ExpiresAt      time.Time
+	Accepted       bool
+	AcceptedUserID int
+	Revoked        bool
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// IsPending reports whether the invitation can still be accepted
+func (i *Invitation) IsPending() bool {
+	return !i.Accepted && !i.Revoked && time.Now().Before(i.ExpiresAt)
+}
@@ -1,25 +1,129 @@
 package models
 
 import (
-	"time"
 	"database/sql"
+	"encoding/json"
+	"time"
 )
 
 type Page struct {
-	ID             int
-	Slug           string
-	Title          string
-	MetaDescription string
-	Header         string
-	Navigation     string
-	MainContent    string
-	Sidebar        string
-	Footer         string
-	CSSClass       string
-	Scripts        string
-	Template       string
-	ReadGroups     sql.NullString
-	WriteGroups    sql.NullString
-	Created        time.Time
-	Modified       time.Time
-} 
\ No newline at end of file
+	ID                int
+	Slug              string
+	Title             string
+	MetaDescription   string
+	Header            string
+	Navigation        string
+	MainContent       string
+	Sidebar           string
+	Footer            string
+	CSSClass          string
+	Scripts           string
+	Template          string
+	ContentFormat     string // One of the PageFormat values; how Header through Footer are written
+	ReadGroups        sql.NullString
+	WriteGroups       sql.NullString
+	Status            string        // One of the PageStatus values; describes the working draft
+	PublishedRevision sql.NullInt64 // The revision readers see; null until published through the editor
+	PublishAt         sql.NullTime  // When the working draft is published automatically
+	UnpublishAt       sql.NullTime  // When the page is archived automatically
+	Created           time.Time
+	Modified          time.Time
+}
+
+// Page states. The columns of _page hold the working draft; readers see the published
+// revision instead, so a published page can be edited without changing what is live.
+const (
+	PageStatusDraft     = "draft"     // Being written, or changed since it was published
+	PageStatusInReview  = "in_review" // Waiting for someone with pages.publish
+	PageStatusPublished = "published" // The working draft is what readers see
+	PageStatusArchived  = "archived"  // Taken down; readers see nothing
+)
+
+// PageStatuses lists the page states in workflow order
+var PageStatuses = []string{PageStatusDraft, PageStatusInReview, PageStatusPublished, PageStatusArchived}
+
+// ValidPageStatus reports whether status is one of the page states
+func ValidPageStatus(status string) bool {
+	for _, known := range PageStatuses {
+		if status == known {
+			return true
+		}
+	}
+	return false
+}
+
+// IsLive reports whether readers can see the page. Pages published before drafts existed
+// have no published revision and show their current content.
+func (p *Page) IsLive() bool {
+	if p.Status == PageStatusArchived {
+		return false
+	}
+	return p.PublishedRevision.Valid || p.Status == PageStatusPublished
+}
+
+// Page content formats. Markdown content is converted to sanitized HTML when the page is
+// rendered; HTML content is trusted as written.
+const (
+	PageFormatHTML     = "html"
+	PageFormatMarkdown = "markdown"
+)
+
+// ValidPageFormat reports whether format is one of the page content formats
+func ValidPageFormat(format string) bool {
+	return format == PageFormatHTML || format == PageFormatMarkdown
+}
+
+// ValidPageSlug reports whether slug can address a page at /page/{slug}: 1 to 255 lowercase
+// letters, digits, hyphens and underscores
+func ValidPageSlug(slug string) bool {
+	if slug == "" || len(slug) > 255 {
+		return false
+	}
+	for _, c := range slug {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// PageGroups decodes a page's read_groups or write_groups. A missing list, meaning no
+// restriction, decodes to nil.
+func PageGroups(groups sql.NullString) ([]string, error) {
+	if !groups.Valid || groups.String == "" {
+		return nil, nil
+	}
+	var names []string
+	if err := json.Unmarshal([]byte(groups.String), &names); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// PageGroupsValue encodes group names for read_groups or write_groups. No names leaves the
+// page unrestricted.
+func PageGroupsValue(names []string) sql.NullString {
+	if len(names) == 0 {
+		return sql.NullString{}
+	}
+	encoded, _ := json.Marshal(names)
+	return sql.NullString{String: string(encoded), Valid: true}
+}
+
+// PageAuthor identifies who saved a page revision. A zero ID means the revision was
+// recorded by the system.
+type PageAuthor struct {
+	ID   int
+	Name string
+}
+
+// PageRevision is a full snapshot of a page taken each time it is saved
+type PageRevision struct {
+	ID       int
+	PageID   int
+	Revision int // Numbered from 1 for each page
+	Snapshot Page
+	Author   PageAuthor
+	Note     string // Why the revision was recorded when not an ordinary save, e.g. a restore
+	Created  time.Time
+}
@@ -13,4 +13,15 @@ type PasswordResetToken struct {
 	Used      bool
 	CreatedAt time.Time
 	UpdatedAt time.Time
-} 
\ No newline at end of file
+}
+
+type EmailVerificationToken struct {
+	ID        int
+	UserID    int
+	Token     string
+	Email     string
+	ExpiresAt time.Time
+	Used      bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
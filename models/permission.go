@@ -0,0 +1,139 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Named permissions checked by handlers and middleware. Permissions are granted to groups,
+// so a user holds every permission granted to any group they belong to, including groups
+// inherited through nesting.
+const (
+	// PermissionAll grants every permission
+	PermissionAll = "*"
+	// PermissionUsersManage covers users, groups, sessions, registrations, invitations and
+	// the permission mapping itself
+	PermissionUsersManage = "users.manage"
+	// PermissionConfigView allows viewing the config settings page
+	PermissionConfigView = "config.view"
+	// PermissionConfigEdit allows editing and reloading the .env file
+	PermissionConfigEdit = "config.edit"
+	// PermissionSchemaEdit allows creating, editing and deleting tables and field metadata
+	PermissionSchemaEdit = "schema.edit"
+	// PermissionTablesViewAll allows the engineer view that lists every table regardless
+	// of its read groups
+	PermissionTablesViewAll = "tables.view_all"
+	// PermissionOrdersView allows viewing the orders page
+	PermissionOrdersView = "page.orders.view"
+	// PermissionFAQView allows viewing the FAQ page
+	PermissionFAQView = "page.faq.view"
+	// PermissionMagicLink allows signing in with an emailed single-use link instead of a password
+	PermissionMagicLink = "login.magic_link"
+	// PermissionPagesEdit allows using the page editor and pages API; each page's write
+	// groups further limit which pages can be changed
+	PermissionPagesEdit = "pages.edit"
+	// PermissionPagesEditHTML allows writing page content in the html format, which is
+	// rendered as written; editors without it write Markdown, which is sanitized
+	PermissionPagesEditHTML = "pages.edit_html"
+	// PermissionPagesPublish allows publishing, archiving and scheduling pages; without it
+	// page editors can only move drafts in and out of review
+	PermissionPagesPublish = "pages.publish"
+)
+
+// Permission describes a named permission for the admin UI
+type Permission struct {
+	Name        string
+	Description string
+}
+
+// KnownPermissions lists the permissions the application checks. Table permissions
+// (table.<name>.read and table.<name>.write) are not listed here because they are derived
+// from the table metadata.
+var KnownPermissions = []Permission{
+	{PermissionAll, "Every permission"},
+	{PermissionUsersManage, "Manage users, groups, sessions, registrations, invitations and permissions"},
+	{PermissionConfigView, "View the config settings page"},
+	{PermissionConfigEdit, "Edit and reload the .env file"},
+	{PermissionSchemaEdit, "Create, edit and delete tables and field metadata"},
+	{PermissionTablesViewAll, "List every table, including system tables, in the engineer view"},
+	{PermissionOrdersView, "View the orders page"},
+	{PermissionFAQView, "View the FAQ page"},
+	{PermissionMagicLink, "Sign in with an emailed link instead of a password"},
+	{PermissionPagesEdit, "Create, edit and delete pages they are in the write groups of"},
+	{PermissionPagesEditHTML, "Write page content as unsanitized HTML"},
+	{PermissionPagesPublish, "Publish, archive and schedule pages they are in the write groups of"},
+}
+
+// GroupPermission is a permission granted to a group
+type GroupPermission struct {
+	ID         int
+	GroupID    int
+	GroupName  string
+	Permission string
+	CreatedAt  time.Time
+}
+
+// TablePermission returns the permission for reading or writing a table, e.g.
+// table.orders.write. action is "read" or "write".
+func TablePermission(tableName, action string) string {
+	return "table." + tableName + "." + action
+}
+
+// ParseTablePermission splits a table permission into its table name and action
+func ParseTablePermission(permission string) (tableName, action string, ok bool) {
+	if !strings.HasPrefix(permission, "table.") {
+		return "", "", false
+	}
+	rest := strings.TrimPrefix(permission, "table.")
+	i := strings.LastIndex(rest, ".")
+	if i <= 0 {
+		return "", "", false
+	}
+	tableName, action = rest[:i], rest[i+1:]
+	if action != "read" && action != "write" {
+		return "", "", false
+	}
+	return tableName, action, true
+}
+
+// ValidPermissionName reports whether name is a well-formed permission: "*" or dot-separated
+// segments of letters, digits, underscores, hyphens or "*"
+func ValidPermissionName(name string) bool {
+	if name == PermissionAll {
+		return true
+	}
+	if name == "" || len(name) > 128 {
+		return false
+	}
+	for _, segment := range strings.Split(name, ".") {
+		if segment == "" {
+			return false
+		}
+		for _, c := range segment {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '*') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// PermissionMatches reports whether a granted permission covers the requested one. "*"
+// covers everything, and a "*" segment matches any single segment, so table.*.read covers
+// table.orders.read.
+func PermissionMatches(granted, requested string) bool {
+	if granted == PermissionAll || granted == requested {
+		return true
+	}
+	grantedParts := strings.Split(granted, ".")
+	requestedParts := strings.Split(requested, ".")
+	if len(grantedParts) != len(requestedParts) {
+		return false
+	}
+	for i, part := range grantedParts {
+		if part != "*" && part != requestedParts[i] {
+			return false
+		}
+	}
+	return true
+}
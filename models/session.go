@@ -14,4 +14,18 @@ type Session struct {
 	CreatedAt   time.Time // This will map to 'created' in the database
 	ExpiresAt   time.Time
 	IsActive    bool
+	LastSeen    time.Time
+	IPAddress   string
+	UserAgent   string
+	// AbsoluteExpiresAt caps sliding renewal; ExpiresAt is the idle expiry
+	AbsoluteExpiresAt time.Time
+	RememberMe        bool
+	RotatePending     bool
+	// ImpersonatorID is the administrator acting as this user; zero for normal sessions
+	ImpersonatorID int
+}
+
+// IsImpersonated reports whether an administrator is acting as the session's user
+func (s *Session) IsImpersonated() bool {
+	return s.ImpersonatorID != 0
 } 
\ No newline at end of file
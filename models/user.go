@@ -11,10 +11,18 @@ type User struct {
 	Password    string
 	ReadGroups  string
 	WriteGroups string
+	Status      string // One of the UserStatus constants
 	CreatedAt   time.Time
 	UpdatedAt   time.Time // This will map to 'modified' in the database
 }
 
+// Account states. Only active users may log in.
+const (
+	UserStatusActive              = "active"
+	UserStatusPendingVerification = "pending_verification"
+	UserStatusPendingApproval     = "pending_approval"
+)
+
 type Group struct {
 	ID          int
 	Name        string
@@ -4,10 +4,15 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"stingray/auth"
+	"stingray/certs"
 	"stingray/config"
 	"stingray/database"
 	"stingray/handlers"
 	"stingray/logging"
+	"stingray/models"
+	"stingray/sessions"
+	"strings"
 )
 
 type Server struct {
@@ -15,38 +20,77 @@ type Server struct {
 	cfg         *config.Config
 	logger      *logging.Logger
 	server      *http.Server
+	redirectServer *http.Server
+	certificates   *certs.Reloader
 	pageHandler *handlers.PageHandler
 	authHandler *handlers.AuthHandler
 	sessionMW   *handlers.SessionMiddleware
 	roleMW      *handlers.RoleMiddleware
 	loggingMW   *handlers.LoggingMiddleware
+	csrfMW      *handlers.CSRFMiddleware
 	apiHandler  *handlers.APIHandler
 	metadataHandler *handlers.MetadataHandler
 	passwordResetHandler *handlers.PasswordResetHandler
+	adminHandler         *handlers.AdminHandler
+	registrationHandler  *handlers.RegistrationHandler
+	invitationHandler    *handlers.InvitationHandler
+	impersonationHandler *handlers.ImpersonationHandler
+	auditHandler         *handlers.AuditHandler
+	magicLinkHandler     *handlers.MagicLinkHandler
+	pageEditorHandler    *handlers.PageEditorHandler
 }
 
 func NewServer(db *database.Database, cfg *config.Config) *Server {
 	mux := http.NewServeMux()
 	logger := logging.NewLogger(cfg.LoggingLevel)
-	sessionMW := handlers.NewSessionMiddleware(db)
-	roleMW := handlers.NewRoleMiddleware(db)
+	sessionStore, err := sessions.NewStore(cfg, db)
+	if err != nil {
+		log.Fatalf("Failed to configure session store: %v", err)
+	}
+	if cfg.SessionStore == sessions.StoreCookie && cfg.SessionSecret == "" {
+		logger.LogError("SESSION_SECRET is not set; cookie sessions will not survive a restart")
+	}
+	clientIP, err := handlers.NewClientIPResolver(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Failed to configure trusted proxies: %v", err)
+	}
+	db.SetPasswordPolicy(newPasswordPolicy(cfg, logger))
+	db.SetAccessCacheTTL(cfg.PermissionCacheTTL)
+	sessionMW := handlers.NewSessionMiddleware(db, sessionStore, handlers.SessionPolicyFromConfig(cfg), cfg.SecureCookies())
+	roleMW := handlers.NewRoleMiddleware(db, sessionMW)
 	loggingMW := handlers.NewLoggingMiddleware(logger)
-	apiHandler := handlers.NewAPIHandler(db, cfg)
+	csrfMW := handlers.NewCSRFMiddleware(logger, cfg.SecureCookies())
+	apiHandler := handlers.NewAPIHandler(db, sessionMW, cfg)
 	
 	server := &Server{
 		db:          db,
 		cfg:         cfg,
 		logger:      logger,
-		pageHandler: handlers.NewPageHandler(db, cfg), // Pass cfg
-		authHandler: handlers.NewAuthHandler(db, logger),
+		pageHandler: handlers.NewPageHandler(db, sessionMW, cfg), // Pass cfg
+		authHandler: handlers.NewAuthHandler(db, sessionMW, logger),
 		sessionMW:   sessionMW,
 		roleMW:      roleMW,
 		loggingMW:   loggingMW,
+		csrfMW:      csrfMW,
 		apiHandler:  apiHandler,
-		metadataHandler: handlers.NewMetadataHandler(db),
-		passwordResetHandler: handlers.NewPasswordResetHandler(db, cfg, logger),
+		metadataHandler: handlers.NewMetadataHandler(db, sessionMW),
+		passwordResetHandler: handlers.NewPasswordResetHandler(db, sessionMW, cfg, logger),
+		adminHandler:         handlers.NewAdminHandler(db, sessionMW),
+		registrationHandler:  handlers.NewRegistrationHandler(db, cfg, logger),
+		invitationHandler:    handlers.NewInvitationHandler(db, sessionMW, cfg, logger),
+		impersonationHandler: handlers.NewImpersonationHandler(db, sessionMW, logger),
+		auditHandler:         handlers.NewAuditHandler(db),
+		magicLinkHandler:     handlers.NewMagicLinkHandler(db, sessionMW, cfg, logger),
+		pageEditorHandler:    handlers.NewPageEditorHandler(db, sessionMW, logger),
 	}
 
+	// Admin pages all require the users.manage permission
+	requireUsersManage := roleMW.RequirePermission(models.PermissionUsersManage)
+	requirePagesEdit := roleMW.RequirePermission(models.PermissionPagesEdit)
+
+	// Shared site script, used instead of inline event handlers
+	mux.HandleFunc(handlers.SiteScriptPath, handlers.HandleSiteScript)
+
 	// Page routes with optional auth middleware
 	mux.HandleFunc("/", loggingMW.Wrap(sessionMW.OptionalAuth(server.pageHandler.HandleHome)))
 	mux.HandleFunc("/page/", loggingMW.Wrap(sessionMW.OptionalAuth(server.pageHandler.HandlePage)))
@@ -54,50 +98,72 @@ func NewServer(db *database.Database, cfg *config.Config) *Server {
 	mux.HandleFunc("/templates", loggingMW.Wrap(sessionMW.OptionalAuth(server.pageHandler.HandleTemplate)))
 	mux.HandleFunc("/template/", loggingMW.Wrap(sessionMW.OptionalAuth(server.pageHandler.HandleTemplate)))
 
-	// Config settings page (admin or engineer only)
-	mux.HandleFunc("/config", loggingMW.Wrap(func(w http.ResponseWriter, r *http.Request) {
-		if !sessionMW.IsAuthenticated(r) {
-			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
-			return
-		}
-		session, err := sessionMW.GetSessionFromRequest(r)
-		if err != nil {
-			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
-			return
-		}
-		isAdmin, _ := db.IsUserInGroup(session.UserID, "admin")
-		isEngineer, _ := db.IsUserInGroup(session.UserID, "engineer")
-		if !isAdmin && !isEngineer {
-			handlers.RenderMessage(w, "Access Denied", "Access Denied", "error", "You do not have permission to access this page.", "/", "Go Home", http.StatusForbidden)
-			return
-		}
-		if r.Method == "POST" {
-			log.Printf("[DEBUG] HandleConfigPage POST called")
-			server.pageHandler.HandleConfigPage(w, r)
-		} else {
-			server.pageHandler.HandleConfigPage(w, r)
-		}
-	}))
+	// Page editor; each page's write groups further limit who can change it
+	mux.HandleFunc("/page-editor", loggingMW.Wrap(requirePagesEdit(server.pageEditorHandler.HandlePageList)))
+	mux.HandleFunc("/page-editor/new", loggingMW.Wrap(requirePagesEdit(server.pageEditorHandler.HandleNewPage)))
+	mux.HandleFunc("/page-editor/edit/", loggingMW.Wrap(requirePagesEdit(server.pageEditorHandler.HandleEditPage)))
+	mux.HandleFunc("/page-editor/delete/", loggingMW.Wrap(requirePagesEdit(server.pageEditorHandler.HandleDeletePage)))
+	mux.HandleFunc("/page-editor/history/", loggingMW.Wrap(requirePagesEdit(server.pageEditorHandler.HandlePageHistory)))
+	mux.HandleFunc("/page-editor/diff/", loggingMW.Wrap(requirePagesEdit(server.pageEditorHandler.HandlePageDiff)))
+	mux.HandleFunc("/page-editor/restore/", loggingMW.Wrap(requirePagesEdit(server.pageEditorHandler.HandleRestoreRevision)))
+	mux.HandleFunc("/page-editor/preview/", loggingMW.Wrap(requirePagesEdit(server.pageEditorHandler.HandlePreviewPage)))
+	mux.HandleFunc("/page-editor/status/", loggingMW.Wrap(requirePagesEdit(server.pageEditorHandler.HandlePageStatus)))
+	mux.HandleFunc("/page-editor/schedule/", loggingMW.Wrap(requirePagesEdit(server.pageEditorHandler.HandleSchedulePage)))
+
+	// Config settings page; saving additionally requires config.edit
+	mux.HandleFunc("/config", loggingMW.Wrap(roleMW.RequirePermission(models.PermissionConfigView)(sessionMW.BlockWhileImpersonating(server.pageHandler.HandleConfigPage))))
 	
 	// Auth routes
 	mux.HandleFunc("/user/login", loggingMW.Wrap(server.authHandler.HandleLogin))
 	mux.HandleFunc("/user/login_post", loggingMW.Wrap(server.authHandler.HandleLoginPost))
+	mux.HandleFunc("/user/magic-link", loggingMW.Wrap(server.magicLinkHandler.HandleMagicLinkRequest))
+	mux.HandleFunc("/user/magic-link/verify", loggingMW.Wrap(server.magicLinkHandler.HandleMagicLinkVerify))
 	mux.HandleFunc("/user/logout", loggingMW.Wrap(server.authHandler.HandleLogout))
 	mux.HandleFunc("/user/profile", loggingMW.Wrap(sessionMW.RequireAuth(server.authHandler.HandleProfile)))
+	mux.HandleFunc("/user/password", loggingMW.Wrap(sessionMW.RequireAuth(sessionMW.BlockWhileImpersonating(server.authHandler.HandleChangePassword))))
+	mux.HandleFunc("/user/sessions/revoke", loggingMW.Wrap(sessionMW.RequireAuth(sessionMW.BlockWhileImpersonating(server.authHandler.HandleRevokeSession))))
+	mux.HandleFunc("/user/sessions/revoke-all", loggingMW.Wrap(sessionMW.RequireAuth(sessionMW.BlockWhileImpersonating(server.authHandler.HandleRevokeAllSessions))))
+	mux.HandleFunc("/user/register", loggingMW.Wrap(server.registrationHandler.HandleRegister))
+	mux.HandleFunc("/user/verify-email", loggingMW.Wrap(server.registrationHandler.HandleVerifyEmail))
+	mux.HandleFunc("/user/accept-invite", loggingMW.Wrap(server.invitationHandler.HandleAcceptInvitation))
+	mux.HandleFunc("/user/impersonation/stop", loggingMW.Wrap(sessionMW.RequireAuth(server.impersonationHandler.HandleStopImpersonation)))
 
 	// Password reset routes
-	mux.HandleFunc("/user/password-reset-request", loggingMW.Wrap(server.passwordResetHandler.HandlePasswordResetRequest))
+	mux.HandleFunc("/user/password-reset-request", loggingMW.Wrap(sessionMW.BlockWhileImpersonating(server.passwordResetHandler.HandlePasswordResetRequest)))
 	mux.HandleFunc("/user/password-reset-confirm", loggingMW.Wrap(server.passwordResetHandler.HandlePasswordResetConfirm))
 
 	// Role-based page routes
-	mux.HandleFunc("/page/orders", loggingMW.Wrap(roleMW.RequireAdmin(server.pageHandler.HandlePage)))
-	mux.HandleFunc("/page/faq", loggingMW.Wrap(roleMW.RequireCustomer(server.pageHandler.HandlePage)))
+	mux.HandleFunc("/page/orders", loggingMW.Wrap(roleMW.RequirePermission(models.PermissionOrdersView)(server.pageHandler.HandlePage)))
+	mux.HandleFunc("/page/faq", loggingMW.Wrap(roleMW.RequirePermission(models.PermissionFAQView)(server.pageHandler.HandlePage)))
+
+	// Admin routes
+	mux.HandleFunc("/admin/sessions", loggingMW.Wrap(requireUsersManage(server.adminHandler.HandleSessions)))
+	mux.HandleFunc("/admin/sessions/revoke", loggingMW.Wrap(requireUsersManage(server.adminHandler.HandleRevokeSession)))
+	mux.HandleFunc("/admin/sessions/revoke-all", loggingMW.Wrap(requireUsersManage(server.adminHandler.HandleRevokeUserSessions)))
+	mux.HandleFunc("/admin/registrations", loggingMW.Wrap(requireUsersManage(server.registrationHandler.HandlePendingRegistrations)))
+	mux.HandleFunc("/admin/registrations/approve", loggingMW.Wrap(requireUsersManage(server.registrationHandler.HandleApproveRegistration)))
+	mux.HandleFunc("/admin/registrations/reject", loggingMW.Wrap(requireUsersManage(server.registrationHandler.HandleRejectRegistration)))
+	mux.HandleFunc("/admin/invitations", loggingMW.Wrap(requireUsersManage(server.invitationHandler.HandleInvitations)))
+	mux.HandleFunc("/admin/invitations/create", loggingMW.Wrap(requireUsersManage(server.invitationHandler.HandleCreateInvitation)))
+	mux.HandleFunc("/admin/invitations/resend", loggingMW.Wrap(requireUsersManage(server.invitationHandler.HandleResendInvitation)))
+	mux.HandleFunc("/admin/invitations/revoke", loggingMW.Wrap(requireUsersManage(server.invitationHandler.HandleRevokeInvitation)))
+	mux.HandleFunc("/admin/permissions", loggingMW.Wrap(requireUsersManage(server.adminHandler.HandlePermissions)))
+	mux.HandleFunc("/admin/permissions/grant", loggingMW.Wrap(requireUsersManage(server.adminHandler.HandleGrantPermission)))
+	mux.HandleFunc("/admin/permissions/revoke", loggingMW.Wrap(requireUsersManage(server.adminHandler.HandleRevokePermission)))
+	mux.HandleFunc("/admin/audit-log", loggingMW.Wrap(requireUsersManage(server.auditHandler.HandleAuditLog)))
+	mux.HandleFunc("/admin/impersonate", loggingMW.Wrap(requireUsersManage(server.impersonationHandler.HandleImpersonation)))
+	mux.HandleFunc("/admin/impersonate/start", loggingMW.Wrap(requireUsersManage(server.impersonationHandler.HandleStartImpersonation)))
 
 	// API routes
-	mux.HandleFunc("/api/users", loggingMW.Wrap(apiHandler.HandleGetUsers))
-	mux.HandleFunc("/api/groups", loggingMW.Wrap(apiHandler.HandleGetGroups))
+	mux.HandleFunc("/api/users", loggingMW.Wrap(apiHandler.HandleUsers))
+	mux.HandleFunc("/api/users/", loggingMW.Wrap(apiHandler.HandleUser))
+	mux.HandleFunc("/api/groups", loggingMW.Wrap(apiHandler.HandleGroups))
+	mux.HandleFunc("/api/groups/", loggingMW.Wrap(apiHandler.HandleGroup))
 	mux.HandleFunc("/api/user-groups", loggingMW.Wrap(apiHandler.HandleGetUserGroups))
+	mux.HandleFunc("/api/user-groups/bulk", loggingMW.Wrap(apiHandler.HandleBulkMembership))
 	mux.HandleFunc("/api/current-user", loggingMW.Wrap(apiHandler.HandleGetCurrentUser))
+	mux.HandleFunc("/api/pages", loggingMW.Wrap(server.pageEditorHandler.HandleAPIPages))
+	mux.HandleFunc("/api/pages/", loggingMW.Wrap(server.pageEditorHandler.HandleAPIPage))
 
 	// Metadata routes
 	mux.HandleFunc("/metadata/tables", loggingMW.Wrap(server.metadataHandler.HandleTableList))
@@ -113,24 +179,122 @@ func NewServer(db *database.Database, cfg *config.Config) *Server {
 	mux.HandleFunc("/api/metadata/field/", loggingMW.Wrap(sessionMW.RequireAuth(server.metadataHandler.HandleFieldMetadata)))
 
 	// Register the new /api/reload route
-	mux.HandleFunc("/api/reload", loggingMW.Wrap(sessionMW.RequireAuth(apiHandler.HandleReloadEnv)))
+	mux.HandleFunc("/api/reload", loggingMW.Wrap(sessionMW.RequireAuth(sessionMW.BlockWhileImpersonating(apiHandler.HandleReloadEnv))))
 
+	// Security headers: the site-wide policy comes from the configuration. The JSON API never
+	// serves markup, so it may load nothing at all, and /user/ pages carry reset, invitation
+	// and magic link tokens in their URLs, so they never send a Referer.
+	pagePolicy := handlers.SecurityPolicy{
+		ContentSecurityPolicy: cfg.ContentSecurityPolicy,
+		FrameAncestors:        cfg.FrameAncestors,
+		ReferrerPolicy:        cfg.ReferrerPolicy,
+		PermissionsPolicy:     cfg.PermissionsPolicy,
+		ReportOnly:            cfg.CSPReportOnly,
+	}
+	securityMW := handlers.NewSecurityHeadersMiddleware(pagePolicy, cfg.CSPReportURI, logger)
+	apiPolicy := pagePolicy
+	apiPolicy.ContentSecurityPolicy = "default-src 'none'"
+	securityMW.Route("/api/", apiPolicy)
+	userPolicy := pagePolicy
+	userPolicy.ReferrerPolicy = "no-referrer"
+	securityMW.Route("/user/", userPolicy)
+
+	// All routes go through client IP resolution so logs and audit records see the real
+	// client behind trusted proxies, through the security headers, through CSRF protection
+	// so every state-changing request carries a token, through session refresh so active
+	// sessions slide their idle expiry forward, through identification so handlers read the
+	// signed-in user from the request context, and through the impersonation banner so
+	// administrators always know whose account they are in. Browsers post CSP violation
+	// reports without a CSRF token, so the report endpoint sits outside that protection.
+	root := http.NewServeMux()
+	if cfg.CSPReportURI != "" && strings.HasPrefix(cfg.CSPReportURI, "/") {
+		root.HandleFunc(cfg.CSPReportURI, loggingMW.Wrap(securityMW.HandleReport))
+	}
+	root.Handle("/", csrfMW.Protect(sessionMW.Refresh(sessionMW.Identify(sessionMW.ImpersonationBanner(mux)))))
+	var handler http.Handler = clientIP.Middleware(securityMW.Wrap(root))
 	server.server = &http.Server{
-		Addr:    ":" + cfg.ServerPort,
-		Handler: mux,
+		Addr: ":" + cfg.ServerPort,
 	}
 
+	// With TLS the certificate is reloaded on SIGHUP, browsers are told to stay on HTTPS,
+	// and an optional second listener redirects plain HTTP to HTTPS
+	if cfg.TLSEnabled() {
+		server.certificates, err = certs.NewReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			log.Fatalf("Failed to configure TLS: %v", err)
+		}
+		server.server.TLSConfig = server.certificates.TLSConfig()
+		handler = handlers.HSTS(cfg.HSTSMaxAge, cfg.HSTSIncludeSubdomains, handler)
+		if cfg.HTTPRedirectPort != "" {
+			server.redirectServer = &http.Server{
+				Addr:    ":" + cfg.HTTPRedirectPort,
+				Handler: loggingMW.Wrap(handlers.RedirectToHTTPS(cfg.ServerPort, cfg.PublicBaseURL).ServeHTTP),
+			}
+		}
+	} else if cfg.HTTPRedirectPort != "" {
+		logger.LogError("HTTP_REDIRECT_PORT is set but TLS is not enabled; no redirect listener will be started")
+	}
+	server.server.Handler = handler
+
 	return server
 }
 
 func (s *Server) Start() error {
-	s.logger.LogVerbose("Starting Sting Ray server on port " + s.cfg.ServerPort + "...")
-	log.Printf("Starting Sting Ray server on port %s...", s.cfg.ServerPort)
-	return s.server.ListenAndServe()
+	if s.certificates == nil {
+		s.logger.LogVerbose("Starting Sting Ray server on port %s...", s.cfg.ServerPort)
+		log.Printf("Starting Sting Ray server on port %s...", s.cfg.ServerPort)
+		return s.server.ListenAndServe()
+	}
+
+	if s.redirectServer != nil {
+		go func() {
+			log.Printf("Redirecting HTTP on port %s to HTTPS", s.cfg.HTTPRedirectPort)
+			if err := s.redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				s.logger.LogError("HTTP redirect server error: %v", err)
+				log.Printf("HTTP redirect server error: %v", err)
+			}
+		}()
+	}
+	s.logger.LogVerbose("Starting Sting Ray server with TLS on port %s...", s.cfg.ServerPort)
+	log.Printf("Starting Sting Ray server with TLS on port %s...", s.cfg.ServerPort)
+	return s.server.ListenAndServeTLS("", "")
+}
+
+// ReloadCertificates reads the TLS certificate and key files again. It does nothing when
+// TLS is not enabled.
+func (s *Server) ReloadCertificates() error {
+	if s.certificates == nil {
+		return nil
+	}
+	return s.certificates.Reload()
 }
 
 func (s *Server) Shutdown(ctx context.Context) error {
 	s.logger.LogVerbose("Shutting down server gracefully...")
 	log.Println("Shutting down server gracefully...")
+	if s.redirectServer != nil {
+		s.redirectServer.Shutdown(ctx)
+	}
 	return s.server.Shutdown(ctx)
-} 
\ No newline at end of file
+}
+
+// newPasswordPolicy builds the password policy from configuration and loads the breached password list
+func newPasswordPolicy(cfg *config.Config, logger *logging.Logger) *auth.PasswordPolicy {
+	policy := &auth.PasswordPolicy{
+		MinLength:        cfg.PasswordMinLength,
+		RequireUpper:     cfg.PasswordRequireUpper,
+		RequireLower:     cfg.PasswordRequireLower,
+		RequireDigit:     cfg.PasswordRequireDigit,
+		RequireSymbol:    cfg.PasswordRequireSymbol,
+		DisallowUserInfo: cfg.PasswordDisallowUserInfo,
+		HistorySize:      cfg.PasswordHistorySize,
+	}
+	if cfg.BreachedPasswordsFile != "" {
+		if err := policy.LoadBreachedPasswords(cfg.BreachedPasswordsFile); err != nil {
+			logger.LogError("Failed to load breached passwords from %s: %v", cfg.BreachedPasswordsFile, err)
+		} else {
+			logger.LogVerbose("Loaded %d breached passwords from %s", policy.BreachedPasswordCount(), cfg.BreachedPasswordsFile)
+		}
+	}
+	return policy
+}
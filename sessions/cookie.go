@@ -0,0 +1,145 @@
+package sessions
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"stingray/database"
+	"stingray/models"
+	"time"
+)
+
+// ErrSessionRevoked is returned when a session carried in a cookie no longer exists in the database
+var ErrSessionRevoked = errors.New("session has been revoked")
+
+// CookieStore keeps the session itself in an encrypted, authenticated cookie so requests
+// need no database read. The database is consulted only when the session is renewed,
+// which also picks up revocations, so a revoked session stops working within one
+// renew interval.
+type CookieStore struct {
+	*MySQLStore
+	aead cipher.AEAD
+}
+
+// cookiePayload is the session state sealed into the cookie
+type cookiePayload struct {
+	ID                int       `json:"i"`
+	SessionID         string    `json:"s"`
+	UserID            int       `json:"u"`
+	Username          string    `json:"n"`
+	CreatedAt         time.Time `json:"c"`
+	ExpiresAt         time.Time `json:"e"`
+	AbsoluteExpiresAt time.Time `json:"a"`
+	LastSeen          time.Time `json:"l"`
+	RememberMe        bool      `json:"r"`
+	RotatePending     bool      `json:"p"`
+	ImpersonatorID    int       `json:"m,omitempty"`
+}
+
+// NewCookieStore creates a cookie-backed session store. The encryption key is derived
+// from secret; when secret is empty a random key is used and sessions end on restart.
+func NewCookieStore(db *database.Database, secret string) (*CookieStore, error) {
+	var key [32]byte
+	if secret != "" {
+		key = sha256.Sum256([]byte(secret))
+	} else if _, err := rand.Read(key[:]); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CookieStore{MySQLStore: NewMySQLStore(db), aead: aead}, nil
+}
+
+func (s *CookieStore) Get(token string) (*models.Session, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session cookie")
+	}
+	nonceSize := s.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, fmt.Errorf("invalid session cookie")
+	}
+	plaintext, err := s.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session cookie")
+	}
+
+	var payload cookiePayload
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return nil, fmt.Errorf("invalid session cookie")
+	}
+	if !payload.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("session expired")
+	}
+
+	return &models.Session{
+		ID:                payload.ID,
+		SessionID:         payload.SessionID,
+		UserID:            payload.UserID,
+		Username:          payload.Username,
+		CreatedAt:         payload.CreatedAt,
+		ExpiresAt:         payload.ExpiresAt,
+		IsActive:          true,
+		LastSeen:          payload.LastSeen,
+		AbsoluteExpiresAt: payload.AbsoluteExpiresAt,
+		RememberMe:        payload.RememberMe,
+		RotatePending:     payload.RotatePending,
+		ImpersonatorID:    payload.ImpersonatorID,
+	}, nil
+}
+
+func (s *CookieStore) Token(session *models.Session) (string, error) {
+	plaintext, err := json.Marshal(cookiePayload{
+		ID:                session.ID,
+		SessionID:         session.SessionID,
+		UserID:            session.UserID,
+		Username:          session.Username,
+		CreatedAt:         session.CreatedAt,
+		ExpiresAt:         session.ExpiresAt,
+		AbsoluteExpiresAt: session.AbsoluteExpiresAt,
+		LastSeen:          session.LastSeen,
+		RememberMe:        session.RememberMe,
+		RotatePending:     session.RotatePending,
+		ImpersonatorID:    session.ImpersonatorID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(s.aead.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+// Renew checks the session is still active in the database before extending it
+func (s *CookieStore) Renew(session *models.Session, idleTimeout time.Duration) error {
+	current, err := s.db.GetSession(session.SessionID)
+	if err == sql.ErrNoRows {
+		return ErrSessionRevoked
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.MySQLStore.Renew(session, idleTimeout); err != nil {
+		return err
+	}
+	session.ID = current.ID
+	session.RotatePending = current.RotatePending
+	return nil
+}
@@ -0,0 +1,161 @@
+package sessions
+
+import (
+	"container/list"
+	"stingray/database"
+	"stingray/models"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps recently used sessions in an in-process LRU cache in front of MySQL.
+// Cached entries are reloaded after ttl, so sessions revoked directly in the database
+// stop working within that window.
+type MemoryStore struct {
+	*MySQLStore
+	capacity int
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	token    string
+	session  models.Session
+	loadedAt time.Time
+}
+
+// NewMemoryStore creates an LRU-cached session store holding at most capacity sessions
+func NewMemoryStore(db *database.Database, capacity int, ttl time.Duration) *MemoryStore {
+	if capacity <= 0 {
+		capacity = 10000
+	}
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+	return &MemoryStore{
+		MySQLStore: NewMySQLStore(db),
+		capacity:   capacity,
+		ttl:        ttl,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (s *MemoryStore) Create(userID int, username string, idleTimeout, absoluteLifetime time.Duration, rememberMe bool, ipAddress, userAgent string) (*models.Session, error) {
+	session, err := s.MySQLStore.Create(userID, username, idleTimeout, absoluteLifetime, rememberMe, ipAddress, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	s.put(session.SessionID, session)
+	return session, nil
+}
+
+func (s *MemoryStore) Get(token string) (*models.Session, error) {
+	if session, ok := s.get(token); ok {
+		return session, nil
+	}
+	session, err := s.MySQLStore.Get(token)
+	if err != nil {
+		return nil, err
+	}
+	s.put(token, session)
+	return session, nil
+}
+
+func (s *MemoryStore) Renew(session *models.Session, idleTimeout time.Duration) error {
+	if err := s.MySQLStore.Renew(session, idleTimeout); err != nil {
+		return err
+	}
+	s.put(session.SessionID, session)
+	return nil
+}
+
+func (s *MemoryStore) Rotate(session *models.Session) error {
+	oldSessionID := session.SessionID
+	if err := s.MySQLStore.Rotate(session); err != nil {
+		return err
+	}
+	s.remove(oldSessionID)
+	s.put(session.SessionID, session)
+	return nil
+}
+
+func (s *MemoryStore) Invalidate(session *models.Session) error {
+	s.remove(session.SessionID)
+	return s.MySQLStore.Invalidate(session)
+}
+
+func (s *MemoryStore) InvalidateUserSession(userID, id int) error {
+	s.removeWhere(func(session *models.Session) bool { return session.UserID == userID && session.ID == id })
+	return s.MySQLStore.InvalidateUserSession(userID, id)
+}
+
+func (s *MemoryStore) InvalidateAllUserSessions(userID int) error {
+	s.removeWhere(func(session *models.Session) bool { return session.UserID == userID })
+	return s.MySQLStore.InvalidateAllUserSessions(userID)
+}
+
+// get returns a copy of a cached session that is still fresh and unexpired
+func (s *MemoryStore) get(token string) (*models.Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.entries[token]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*memoryEntry)
+	if time.Since(entry.loadedAt) > s.ttl || !entry.session.ExpiresAt.After(time.Now()) {
+		s.order.Remove(element)
+		delete(s.entries, token)
+		return nil, false
+	}
+	s.order.MoveToFront(element)
+	session := entry.session
+	return &session, true
+}
+
+func (s *MemoryStore) put(token string, session *models.Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.entries[token]; ok {
+		entry := element.Value.(*memoryEntry)
+		entry.session = *session
+		entry.loadedAt = time.Now()
+		s.order.MoveToFront(element)
+		return
+	}
+
+	s.entries[token] = s.order.PushFront(&memoryEntry{token: token, session: *session, loadedAt: time.Now()})
+	for s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryEntry).token)
+	}
+}
+
+func (s *MemoryStore) remove(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.entries[token]; ok {
+		s.order.Remove(element)
+		delete(s.entries, token)
+	}
+}
+
+func (s *MemoryStore) removeWhere(match func(session *models.Session) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for token, element := range s.entries {
+		if match(&element.Value.(*memoryEntry).session) {
+			s.order.Remove(element)
+			delete(s.entries, token)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package sessions
+
+import (
+	"stingray/database"
+	"stingray/models"
+	"time"
+)
+
+// MySQLStore reads every session straight from the _session table
+type MySQLStore struct {
+	db *database.Database
+}
+
+// NewMySQLStore creates a session store backed directly by MySQL
+func NewMySQLStore(db *database.Database) *MySQLStore {
+	return &MySQLStore{db: db}
+}
+
+func (s *MySQLStore) Create(userID int, username string, idleTimeout, absoluteLifetime time.Duration, rememberMe bool, ipAddress, userAgent string) (*models.Session, error) {
+	return s.db.CreateSessionWithClient(userID, username, idleTimeout, absoluteLifetime, rememberMe, ipAddress, userAgent)
+}
+
+func (s *MySQLStore) Get(token string) (*models.Session, error) {
+	return s.db.GetSession(token)
+}
+
+func (s *MySQLStore) Token(session *models.Session) (string, error) {
+	return session.SessionID, nil
+}
+
+func (s *MySQLStore) Renew(session *models.Session, idleTimeout time.Duration) error {
+	expiresAt, err := s.db.RenewSession(session.SessionID, idleTimeout)
+	if err != nil {
+		return err
+	}
+	renewed(session, expiresAt)
+	return nil
+}
+
+func (s *MySQLStore) Rotate(session *models.Session) error {
+	newSessionID, err := s.db.RotateSessionID(session.SessionID)
+	if err != nil {
+		return err
+	}
+	session.SessionID = newSessionID
+	session.RotatePending = false
+	return nil
+}
+
+func (s *MySQLStore) Invalidate(session *models.Session) error {
+	return s.db.InvalidateSession(session.SessionID)
+}
+
+func (s *MySQLStore) InvalidateUserSession(userID, id int) error {
+	return s.db.InvalidateUserSession(userID, id)
+}
+
+func (s *MySQLStore) InvalidateAllUserSessions(userID int) error {
+	return s.db.InvalidateAllUserSessions(userID)
+}
@@ -0,0 +1,60 @@
+package sessions
+
+import (
+	"fmt"
+	"stingray/config"
+	"stingray/database"
+	"stingray/models"
+	"time"
+)
+
+// Store abstracts where sessions are looked up between requests. MySQL remains the
+// source of truth for every backend: sessions are always created, listed and revoked
+// there, while the cached and cookie backends avoid a database read on most requests.
+type Store interface {
+	// Create starts a new session for the user
+	Create(userID int, username string, idleTimeout, absoluteLifetime time.Duration, rememberMe bool, ipAddress, userAgent string) (*models.Session, error)
+	// Get returns the active session referenced by a cookie value
+	Get(token string) (*models.Session, error)
+	// Token returns the cookie value that references the session
+	Token(session *models.Session) (string, error)
+	// Renew records activity and slides the session's idle expiry forward
+	Renew(session *models.Session, idleTimeout time.Duration) error
+	// Rotate gives the session a new ID
+	Rotate(session *models.Session) error
+	// Invalidate ends a single session
+	Invalidate(session *models.Session) error
+	// InvalidateUserSession ends one of a user's sessions by its row ID
+	InvalidateUserSession(userID, id int) error
+	// InvalidateAllUserSessions ends every session belonging to a user
+	InvalidateAllUserSessions(userID int) error
+}
+
+const (
+	StoreMySQL  = "mysql"
+	StoreMemory = "memory"
+	StoreCookie = "cookie"
+)
+
+// NewStore creates the session store selected by SESSION_STORE
+func NewStore(cfg *config.Config, db *database.Database) (Store, error) {
+	switch cfg.SessionStore {
+	case "", StoreMySQL:
+		return NewMySQLStore(db), nil
+	case StoreMemory:
+		return NewMemoryStore(db, cfg.SessionCacheSize, cfg.SessionRenewInterval), nil
+	case StoreCookie:
+		return NewCookieStore(db, cfg.SessionSecret)
+	default:
+		return nil, fmt.Errorf("unknown session store %q", cfg.SessionStore)
+	}
+}
+
+// renewed updates the in-memory copy of a session after a successful renewal
+func renewed(session *models.Session, expiresAt time.Time) {
+	if !session.AbsoluteExpiresAt.IsZero() && expiresAt.After(session.AbsoluteExpiresAt) {
+		expiresAt = session.AbsoluteExpiresAt
+	}
+	session.ExpiresAt = expiresAt
+	session.LastSeen = time.Now()
+}
@@ -3,9 +3,11 @@ package templates
 import (
 	"fmt"
 	"html/template"
-	"log"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
+	"stingray/markdown"
 	"stingray/models"
 )
 
@@ -24,122 +26,126 @@ func LoadTemplate(name string) (string, error) {
 	return "", fmt.Errorf("template %s not found: %v", name, lastErr)
 }
 
-// ProcessEmbeddedTemplates processes embedded template references in content
-func ProcessEmbeddedTemplates(content string) (string, error) {
-	// Find all template references like {{template_name}}
-	processed := content
-
-	for {
-		// Find the next template reference
-		if !strings.Contains(processed, "{{template_") {
-			break
+// ListTemplates returns the names of the available templates, sorted. Files with an
+// extension, such as this source file, are not templates.
+func ListTemplates() ([]string, error) {
+	var lastErr error
+	for _, dir := range []string{"templates", "../templates"} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			lastErr = err
+			continue
 		}
-
-		start := strings.Index(processed, "{{template_")
-		if start == -1 {
-			break
+		var names []string
+		for _, entry := range entries {
+			if entry.Type().IsRegular() && !strings.Contains(entry.Name(), ".") {
+				names = append(names, entry.Name())
+			}
 		}
+		sort.Strings(names)
+		return names, nil
+	}
+	return nil, fmt.Errorf("templates directory not found: %v", lastErr)
+}
 
-		// Find the closing }} for this specific template reference
-		// Start searching from the position after the opening {{
-		searchStart := start + 2
-		end := strings.Index(processed[searchStart:], "}}")
-		if end == -1 {
-			// No closing braces found, break to avoid infinite loop
-			log.Printf("Warning: Template reference without closing braces found at position %d", start)
-			break
+// TemplateExists reports whether a template with the name is available
+func TemplateExists(name string) bool {
+	names, err := ListTemplates()
+	if err != nil {
+		return false
+	}
+	for _, existing := range names {
+		if existing == name {
+			return true
 		}
-		end = searchStart + end + 2 // Adjust for the slice and add 2 for the }}
-
-		// Extract the template reference
-		templateRef := processed[start+2 : end-2] // Remove {{ and }}
-		templateName := strings.TrimPrefix(templateRef, "template_")
+	}
+	return false
+}
 
-		templateContent, err := LoadTemplate(templateName)
-		if err != nil {
-			log.Printf("Warning: Template %s not found, removing reference", templateName)
-			processed = processed[:start] + processed[end:]
-		} else {
-			processed = processed[:start] + templateContent + processed[end:]
+// ListLayouts returns the names of the templates pages can use, sorted, leaving out
+// partials that only other templates include
+func ListLayouts() ([]string, error) {
+	names, err := ListTemplates()
+	if err != nil {
+		return nil, err
+	}
+	var layouts []string
+	for _, name := range names {
+		if DefaultSet.IsLayout(name) {
+			layouts = append(layouts, name)
 		}
 	}
+	return layouts, nil
+}
 
-	return processed, nil
+// ProcessEmbeddedTemplates expands the {{template_name}} references in content with
+// DefaultSet, rendering each template without data. A reference to a missing template is
+// an error.
+//
+// Deprecated: templates include each other with {{template "name" .}}; page content is
+// expanded with Set.ExpandReferences, which passes the page's data to the template.
+func ProcessEmbeddedTemplates(content string) (string, error) {
+	return DefaultSet.ExpandReferences(content, nil)
 }
 
-// RenderPage renders a page using its template
-func RenderPage(page *models.Page) (string, error) {
-	// Load the main template
-	templateContent, err := LoadTemplate(page.Template)
-	if err != nil {
-		return "", err
-	}
+// scriptTagPattern matches the opening tag of an inline or external script
+var scriptTagPattern = regexp.MustCompile(`(?i)<script\b[^>]*>`)
+var scriptNoncePattern = regexp.MustCompile(`(?i)\snonce\s*=`)
 
-	// Process embedded templates in the template itself
-	templateContent, err = ProcessEmbeddedTemplates(templateContent)
-	if err != nil {
-		return "", err
+// AddScriptNonce gives every script tag in html that has no nonce the request's
+// Content-Security-Policy nonce, so the browser runs it. Only use it on markup written by
+// editors, never on user input, or injected scripts would be allowed too.
+func AddScriptNonce(html, nonce string) string {
+	if nonce == "" {
+		return html
 	}
+	attribute := ` nonce="` + template.HTMLEscapeString(nonce) + `"`
+	return scriptTagPattern.ReplaceAllStringFunc(html, func(tag string) string {
+		if scriptNoncePattern.MatchString(tag) {
+			return tag
+		}
+		return tag[:len("<script")] + attribute + tag[len("<script"):]
+	})
+}
 
-	// Process embedded templates in content
-	processedContent, err := ProcessEmbeddedTemplates(page.MainContent)
-	if err != nil {
-		return "", err
+// RenderPage renders a page using its template. The page's Scripts are given nonce, the
+// request's Content-Security-Policy nonce, which templates can also use as {{.Nonce}}.
+func RenderPage(page *models.Page, nonce string) (string, error) {
+	// Markdown is converted and sanitized before embedded templates are expanded, so
+	// the template markup it refers to is left alone
+	header, navigation, mainContent, sidebar, footer := page.Header, page.Navigation, page.MainContent, page.Sidebar, page.Footer
+	if page.ContentFormat == models.PageFormatMarkdown {
+		header = markdown.RenderInline(header)
+		navigation = markdown.RenderInline(navigation)
+		mainContent = markdown.Render(mainContent)
+		sidebar = markdown.Render(sidebar)
+		footer = markdown.Render(footer)
 	}
 
 	// Create template data
 	data := map[string]interface{}{
-		"Title":          page.Title,
+		"Title":           page.Title,
 		"MetaDescription": page.MetaDescription,
-		"Header":         template.HTML(page.Header),
-		"Navigation":     template.HTML(page.Navigation),
-		"MainContent":    template.HTML(processedContent),
-		"Sidebar":        template.HTML(page.Sidebar),
-		"Footer":         template.HTML(page.Footer),
-		"CSSClass":       page.CSSClass,
-		"Scripts":        template.HTML(page.Scripts),
-	}
-
-	// Parse and execute template
-	tmpl, err := template.New("page").Parse(templateContent)
-	if err != nil {
-		return "", err
+		"Header":          template.HTML(header),
+		"Navigation":      template.HTML(navigation),
+		"Sidebar":         template.HTML(sidebar),
+		"Footer":          template.HTML(footer),
+		"CSSClass":        page.CSSClass,
+		"Scripts":         template.HTML(AddScriptNonce(page.Scripts, nonce)),
+		"Nonce":           nonce,
 	}
 
-	var result strings.Builder
-	err = tmpl.Execute(&result, data)
+	// Templates embedded in the content receive the page's data
+	processedContent, err := DefaultSet.ExpandReferences(mainContent, data)
 	if err != nil {
 		return "", err
 	}
+	data["MainContent"] = template.HTML(processedContent)
 
-	return result.String(), nil
+	return DefaultSet.Execute(page.Template, data)
 }
 
 // RenderMetadataPage renders a metadata page using the metadata template
 func RenderMetadataPage(data map[string]interface{}) (string, error) {
-	// Load the metadata template
-	templateContent, err := LoadTemplate("metadata")
-	if err != nil {
-		return "", err
-	}
-
-	// Process embedded templates in the template itself
-	templateContent, err = ProcessEmbeddedTemplates(templateContent)
-	if err != nil {
-		return "", err
-	}
-
-	// Parse and execute template
-	tmpl, err := template.New("metadata").Parse(templateContent)
-	if err != nil {
-		return "", err
-	}
-
-	var result strings.Builder
-	err = tmpl.Execute(&result, data)
-	if err != nil {
-		return "", err
-	}
-
-	return result.String(), nil
-} 
\ No newline at end of file
+	return DefaultSet.Execute("metadata", data)
+}
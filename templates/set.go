@@ -0,0 +1,264 @@
+package templates
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template/parse"
+)
+
+// Set assembles html/template templates from files, one template per file named after it.
+//
+// Templates include each other with {{template "name" .}}, passing data to the partial. A
+// name that is not defined by a file already read is loaded from the file of that name.
+// Layouts declare overridable regions with {{block "name" .}}default{{end}}; a template
+// that defines the same name and then includes the layout replaces the default, because
+// the definitions of the template being executed take precedence over those of the files
+// it includes:
+//
+//	{{define "sidebar"}}<p>Wide page</p>{{end}}{{template "modern" .}}
+//
+// References to templates that do not exist and templates that include themselves, however
+// indirectly, are errors when the template is parsed rather than at execution.
+//
+// Templates written before the set existed refer to each other as {{template_name}}. Those
+// references are read as {{template "name" .}}, so the partial receives the same data.
+type Set struct {
+	load func(name string) (string, error)
+}
+
+// NewSet returns a set of the templates in dir
+func NewSet(dir string) *Set {
+	return &Set{load: func(name string) (string, error) {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return "", fmt.Errorf("template %s not found: %v", name, err)
+		}
+		return string(content), nil
+	}}
+}
+
+// DefaultSet is the set of templates in the templates directory, found as by LoadTemplate
+var DefaultSet = &Set{load: LoadTemplate}
+
+// legacyReferencePattern matches a {{template_name}} reference
+var legacyReferencePattern = regexp.MustCompile(`\{\{\s*template_([A-Za-z0-9_-]+)\s*\}\}`)
+
+// upgradeReferences rewrites {{template_name}} references as {{template "name" .}}
+func upgradeReferences(source string) string {
+	return legacyReferencePattern.ReplaceAllString(source, `{{template "$1" .}}`)
+}
+
+// Parse assembles the named template with every template it uses, ready to execute
+func (s *Set) Parse(name string) (*template.Template, error) {
+	b := &setBuilder{
+		set:    s,
+		trees:  make(map[string]*parse.Tree),
+		loaded: make(map[string]bool),
+		state:  make(map[string]int),
+	}
+	if err := b.loadFile(name); err != nil {
+		return nil, err
+	}
+	if b.trees[name] == nil {
+		return nil, fmt.Errorf("template %s is empty", name)
+	}
+	if err := b.resolve(name, nil); err != nil {
+		return nil, err
+	}
+
+	tmpl := template.New(name)
+	for _, used := range b.used {
+		if _, err := tmpl.AddParseTree(used, b.trees[used]); err != nil {
+			return nil, err
+		}
+	}
+	return tmpl, nil
+}
+
+// Execute renders the named template with data
+func (s *Set) Execute(name string, data interface{}) (string, error) {
+	tmpl, err := s.Parse(name)
+	if err != nil {
+		return "", err
+	}
+	var result strings.Builder
+	if err := tmpl.ExecuteTemplate(&result, name, data); err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
+
+// IsLayout reports whether pages can use the named template: it parses, and it or a
+// template it includes renders the page's {{.MainContent}}. Partials such as login_form
+// and modern_header do not.
+func (s *Set) IsLayout(name string) bool {
+	tmpl, err := s.Parse(name)
+	if err != nil {
+		return false
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil && rendersField(t.Tree.Root, "MainContent") {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpandReferences replaces each {{template_name}} reference in content, such as a page's
+// main content, with the named template rendered with data. The content itself is never
+// parsed as a template, so nothing else in it is executed.
+func (s *Set) ExpandReferences(content string, data interface{}) (string, error) {
+	if !strings.Contains(content, "{{") {
+		return content, nil
+	}
+	rendered := make(map[string]string)
+	var expandErr error
+	expanded := legacyReferencePattern.ReplaceAllStringFunc(content, func(reference string) string {
+		name := legacyReferencePattern.FindStringSubmatch(reference)[1]
+		if html, ok := rendered[name]; ok || expandErr != nil {
+			return html
+		}
+		html, err := s.Execute(name, data)
+		if err != nil {
+			expandErr = err
+			return ""
+		}
+		rendered[name] = html
+		return html
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+	return expanded, nil
+}
+
+// DFS states of templates in setBuilder.resolve
+const (
+	resolving = 1
+	resolved  = 2
+)
+
+// setBuilder collects the parse trees of the templates one template uses
+type setBuilder struct {
+	set    *Set
+	trees  map[string]*parse.Tree // Templates by name; the first definition read wins
+	loaded map[string]bool        // Files read so far
+	state  map[string]int         // resolving or resolved
+	used   []string               // Templates reachable from the one being parsed
+}
+
+// loadFile reads a file's templates, keeping earlier definitions of the same names. The
+// file is parsed on its own, so its trees are not shared with any other set.
+func (b *setBuilder) loadFile(name string) error {
+	if b.loaded[name] {
+		return nil
+	}
+	b.loaded[name] = true
+	if name == "" || strings.ContainsAny(name, `/\.`) {
+		return fmt.Errorf("invalid template name %q", name)
+	}
+	source, err := b.set.load(name)
+	if err != nil {
+		return err
+	}
+	parsed, err := template.New(name).Parse(upgradeReferences(source))
+	if err != nil {
+		return err
+	}
+	for _, t := range parsed.Templates() {
+		if t.Tree != nil && b.trees[t.Name()] == nil {
+			b.trees[t.Name()] = t.Tree
+		}
+	}
+	return nil
+}
+
+// resolve checks that the named template and everything it includes are defined, loading
+// files as needed. path is the chain of templates that led to name, for reporting cycles.
+func (b *setBuilder) resolve(name string, path []string) error {
+	switch b.state[name] {
+	case resolving:
+		for i, earlier := range path {
+			if earlier == name {
+				return fmt.Errorf("template cycle: %s -> %s", strings.Join(path[i:], " -> "), name)
+			}
+		}
+	case resolved:
+		return nil
+	}
+
+	if b.trees[name] == nil {
+		referrer := path[len(path)-1]
+		if err := b.loadFile(name); err != nil {
+			return fmt.Errorf("template %s uses undefined template %q: %v", referrer, name, err)
+		}
+		if b.trees[name] == nil {
+			return fmt.Errorf("template %s uses undefined template %q", referrer, name)
+		}
+	}
+
+	b.state[name] = resolving
+	path = append(path, name)
+	for _, reference := range templateReferences(b.trees[name].Root, nil) {
+		if err := b.resolve(reference, path); err != nil {
+			return err
+		}
+	}
+	b.state[name] = resolved
+	b.used = append(b.used, name)
+	return nil
+}
+
+// templateReferences appends the names of the templates node includes to names
+func templateReferences(node parse.Node, names []string) []string {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n != nil {
+			for _, child := range n.Nodes {
+				names = templateReferences(child, names)
+			}
+		}
+	case *parse.IfNode:
+		names = templateReferences(n.ElseList, templateReferences(n.List, names))
+	case *parse.RangeNode:
+		names = templateReferences(n.ElseList, templateReferences(n.List, names))
+	case *parse.WithNode:
+		names = templateReferences(n.ElseList, templateReferences(n.List, names))
+	case *parse.TemplateNode:
+		names = append(names, n.Name)
+	}
+	return names
+}
+
+// rendersField reports whether node outputs the named field of the data, as {{.field}}
+func rendersField(node parse.Node, field string) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n != nil {
+			for _, child := range n.Nodes {
+				if rendersField(child, field) {
+					return true
+				}
+			}
+		}
+	case *parse.ActionNode:
+		for _, cmd := range n.Pipe.Cmds {
+			for _, arg := range cmd.Args {
+				if f, ok := arg.(*parse.FieldNode); ok && len(f.Ident) == 1 && f.Ident[0] == field {
+					return true
+				}
+			}
+		}
+	case *parse.IfNode:
+		return rendersField(n.List, field) || rendersField(n.ElseList, field)
+	case *parse.RangeNode:
+		return rendersField(n.List, field) || rendersField(n.ElseList, field)
+	case *parse.WithNode:
+		return rendersField(n.List, field) || rendersField(n.ElseList, field)
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package tests
+
+import (
+	"database/sql"
+	"stingray/database"
+	"stingray/models"
+	"testing"
+	"time"
+)
+
+func TestAccessSetMembership(t *testing.T) {
+	access := database.NewAccess(7, []string{"engineer", "staff"}, []string{"schema.edit", "table.orders.*"})
+
+	if !access.InGroup("engineer") || !access.InGroup("everyone") || access.InGroup("admin") {
+		t.Errorf("Unexpected group set %v", access.Groups)
+	}
+	if !access.HasPermission("schema.edit") || !access.HasPermission("table.orders.write") || access.HasPermission("users.manage") {
+		t.Errorf("Unexpected permission checks for %v", access.Permissions)
+	}
+
+	rows := []struct {
+		groups sql.NullString
+		want   bool
+	}{
+		{sql.NullString{}, true},
+		{sql.NullString{String: `["admin", "staff"]`, Valid: true}, true},
+		{sql.NullString{String: `["everyone"]`, Valid: true}, true},
+		{sql.NullString{String: `["admin"]`, Valid: true}, false},
+		{sql.NullString{String: `[]`, Valid: true}, false},
+	}
+	for _, tt := range rows {
+		if got, err := access.CanReadRow(tt.groups); err != nil || got != tt.want {
+			t.Errorf("CanReadRow(%q) = %v, %v; want %v", tt.groups.String, got, err, tt.want)
+		}
+	}
+	if _, err := access.CanWriteRow(sql.NullString{String: "not json", Valid: true}); err == nil {
+		t.Error("Expected malformed write groups to be rejected")
+	}
+
+	tables := []struct {
+		metadata models.TableMetadata
+		want     bool
+	}{
+		{models.TableMetadata{TableName: "orders", ReadGroups: `["admin"]`}, true},
+		{models.TableMetadata{TableName: "notes", ReadGroups: `["staff"]`}, true},
+		{models.TableMetadata{TableName: "notes", ReadGroups: `[]`}, true},
+		{models.TableMetadata{TableName: "salaries", ReadGroups: `["admin"]`}, false},
+	}
+	for _, tt := range tables {
+		if got, err := access.CanAccessTable(&tt.metadata, "read"); err != nil || got != tt.want {
+			t.Errorf("CanAccessTable(%s, %s) = %v, %v; want %v", tt.metadata.TableName, tt.metadata.ReadGroups, got, err, tt.want)
+		}
+	}
+}
+
+func TestAccessCache(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer cleanupTestDatabase(t, db)
+	defer db.Close()
+
+	customer, err := db.AuthenticateUser("customer", "customer123")
+	if err != nil {
+		t.Fatalf("Failed to authenticate customer user: %v", err)
+	}
+
+	db.SetAccessCacheTTL(time.Minute)
+	defer db.SetAccessCacheTTL(0)
+
+	access, err := db.LoadAccess(customer.ID)
+	if err != nil {
+		t.Fatalf("LoadAccess failed: %v", err)
+	}
+	if !access.InGroup("customers") || access.InGroup("admin") {
+		t.Fatalf("Unexpected groups for customer: %v", access.Groups)
+	}
+
+	before := db.QueryCount()
+	if _, err := db.LoadAccess(customer.ID); err != nil {
+		t.Fatalf("LoadAccess failed: %v", err)
+	}
+	if db.QueryCount() != before {
+		t.Errorf("Expected a cached group set to need no queries, ran %d", db.QueryCount()-before)
+	}
+
+	// A membership change is visible immediately
+	if err := db.AddUserToGroup(customer.ID, "engineer"); err != nil {
+		t.Fatalf("Failed to add customer to engineer: %v", err)
+	}
+	access, err = db.LoadAccess(customer.ID)
+	if err != nil {
+		t.Fatalf("LoadAccess failed: %v", err)
+	}
+	if !access.InGroup("engineer") {
+		t.Error("Expected the cache to be invalidated when the user joined a group")
+	}
+
+	// So is a permission granted to one of the user's groups
+	if err := db.GrantPermission("customers", "reports.view"); err != nil {
+		t.Fatalf("Failed to grant permission: %v", err)
+	}
+	access, err = db.LoadAccess(customer.ID)
+	if err != nil {
+		t.Fatalf("LoadAccess failed: %v", err)
+	}
+	if allowed, err := db.Can(access, "reports.view"); err != nil || !allowed {
+		t.Errorf("Expected the new permission to apply at once, got %v, %v", allowed, err)
+	}
+}
+
+// BenchmarkPermissionChecks compares checking every table and page one query-backed check
+// at a time with loading the group set once and checking set membership. Run with
+// go test ./tests/ -bench PermissionChecks -run '^$' and compare queries/op.
+func BenchmarkPermissionChecks(b *testing.B) {
+	db := setupTestDatabase(b)
+	defer db.Close()
+
+	customer, err := db.AuthenticateUser("customer", "customer123")
+	if err != nil {
+		b.Fatalf("Failed to authenticate customer user: %v", err)
+	}
+	tables, err := db.GetAllTableMetadata()
+	if err != nil {
+		b.Fatalf("Failed to load table metadata: %v", err)
+	}
+	pages, err := db.GetAllPages()
+	if err != nil {
+		b.Fatalf("Failed to load pages: %v", err)
+	}
+
+	b.Run("PerCheck", func(b *testing.B) {
+		start := db.QueryCount()
+		for i := 0; i < b.N; i++ {
+			for _, table := range tables {
+				access, _ := db.LoadAccess(customer.ID)
+				db.Can(access, models.TablePermission(table.TableName, "read"))
+			}
+			for _, page := range pages {
+				db.CheckUserReadPermission(customer.ID, page.ReadGroups)
+			}
+		}
+		b.ReportMetric(float64(db.QueryCount()-start)/float64(b.N), "queries/op")
+	})
+
+	b.Run("GroupSet", func(b *testing.B) {
+		start := db.QueryCount()
+		for i := 0; i < b.N; i++ {
+			access, err := db.LoadAccess(customer.ID)
+			if err != nil {
+				b.Fatalf("LoadAccess failed: %v", err)
+			}
+			for j := range tables {
+				access.CanAccessTable(&tables[j], "read")
+			}
+			for _, page := range pages {
+				access.CanReadRow(page.ReadGroups)
+			}
+		}
+		b.ReportMetric(float64(db.QueryCount()-start)/float64(b.N), "queries/op")
+	})
+}
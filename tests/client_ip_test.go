@@ -0,0 +1,56 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"stingray/handlers"
+	"testing"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	resolver, err := handlers.NewClientIPResolver("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatalf("Failed to create resolver: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"direct client", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"untrusted peer cannot spoof", "203.0.113.7:5000", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.5:5000", map[string]string{"X-Forwarded-For": "198.51.100.20"}, "198.51.100.20"},
+		{"spoofed entry on the left is ignored", "10.0.0.5:5000", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.20"}, "198.51.100.20"},
+		{"chain of trusted proxies", "10.0.0.5:5000", map[string]string{"X-Forwarded-For": "198.51.100.20, 192.0.2.1, 10.1.1.1"}, "198.51.100.20"},
+		{"malformed hop stops the walk", "10.0.0.5:5000", map[string]string{"X-Forwarded-For": "198.51.100.20, garbage"}, "10.0.0.5"},
+		{"forwarded header", "10.0.0.5:5000", map[string]string{"Forwarded": `for=1.2.3.4, for="[2001:db8::17]:4711";proto=https`}, "2001:db8::17"},
+		{"forwarded takes precedence", "10.0.0.5:5000", map[string]string{"Forwarded": "for=198.51.100.20", "X-Forwarded-For": "1.2.3.4"}, "198.51.100.20"},
+		{"forwarded unknown", "10.0.0.5:5000", map[string]string{"Forwarded": "for=unknown"}, "10.0.0.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+
+			var got string
+			handler := resolver.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = handlers.ClientIP(r)
+			}))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := handlers.NewClientIPResolver("10.0.0.0/33"); err == nil {
+		t.Error("Expected an invalid CIDR to be rejected")
+	}
+}
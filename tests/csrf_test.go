@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"stingray/handlers"
+	"strings"
+	"testing"
+)
+
+func TestCSRFRejectsPostWithoutToken(t *testing.T) {
+	csrfMW := handlers.NewCSRFMiddleware(nil, false)
+	called := false
+	handler := csrfMW.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest("POST", "/user/login_post", strings.NewReader("username=admin"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+	if called {
+		t.Error("Handler should not be called without a CSRF token")
+	}
+}
+
+func TestCSRFAcceptsMatchingToken(t *testing.T) {
+	csrfMW := handlers.NewCSRFMiddleware(nil, false)
+	handler := csrfMW.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	token := strings.Repeat("ab", 32)
+
+	// Token in form field
+	form := url.Values{}
+	form.Set(handlers.CSRFFormField, token)
+	req := httptest.NewRequest("POST", "/config", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: handlers.CSRFCookieName, Value: token})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 with form token, got %d", w.Code)
+	}
+
+	// Token in header
+	req = httptest.NewRequest("DELETE", "/api/metadata/field/t/f", nil)
+	req.Header.Set(handlers.CSRFHeaderName, token)
+	req.AddCookie(&http.Cookie{Name: handlers.CSRFCookieName, Value: token})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 with header token, got %d", w.Code)
+	}
+
+	// Mismatched token
+	req = httptest.NewRequest("DELETE", "/api/metadata/field/t/f", nil)
+	req.Header.Set(handlers.CSRFHeaderName, strings.Repeat("cd", 32))
+	req.AddCookie(&http.Cookie{Name: handlers.CSRFCookieName, Value: token})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 with mismatched token, got %d", w.Code)
+	}
+}
+
+func TestCSRFInjectsTokenIntoForms(t *testing.T) {
+	csrfMW := handlers.NewCSRFMiddleware(nil, false)
+	handler := csrfMW.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<form method="post" action="/x"></form><form action="/search"></form>`))
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	var token string
+	for _, c := range w.Result().Cookies() {
+		if c.Name == handlers.CSRFCookieName {
+			token = c.Value
+		}
+	}
+	if token == "" {
+		t.Fatal("Expected CSRF cookie to be set")
+	}
+
+	body := w.Body.String()
+	if strings.Count(body, `name="csrf_token"`) != 1 {
+		t.Errorf("Expected exactly one injected token field, got body: %s", body)
+	}
+	if !strings.Contains(body, token) {
+		t.Error("Injected field does not carry the cookie token")
+	}
+}
+
+func TestCSRFPassesOtherResponsesThrough(t *testing.T) {
+	csrfMW := handlers.NewCSRFMiddleware(nil, false)
+	rec := httptest.NewRecorder()
+	var streamed string
+	handler := csrfMW.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"form": "<form method=\"post\">"}`))
+		w.(http.Flusher).Flush()
+		streamed = rec.Body.String()
+	}))
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/pages", nil))
+	if !rec.Flushed || streamed == "" {
+		t.Error("Expected JSON to reach the client before the handler returned")
+	}
+	if strings.Contains(rec.Body.String(), "csrf_token") {
+		t.Errorf("Expected JSON to be left alone, got %s", rec.Body.String())
+	}
+
+	// HTML without a Content-Type is recognized the way net/http would sniff it
+	rec = httptest.NewRecorder()
+	handler = csrfMW.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`<!DOCTYPE html><form method="post"></form>`))
+	}))
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), "csrf_token") {
+		t.Errorf("Expected the sniffed HTML form to get a token, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
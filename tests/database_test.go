@@ -42,4 +42,11 @@ func TestProcessEmbeddedTemplatesInDatabase(t *testing.T) {
 	if processed == content {
 		t.Errorf("Template was not processed: %s", processed)
 	}
-} 
\ No newline at end of file
+}
+
+func TestPublicURL(t *testing.T) {
+	cfg := &config.Config{PublicBaseURL: "https://www.example.com/"}
+	if got := cfg.PublicURL("/user/login"); got != "https://www.example.com/user/login" {
+		t.Errorf("PublicURL() = %q, want %q", got, "https://www.example.com/user/login")
+	}
+}
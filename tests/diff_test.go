@@ -0,0 +1,43 @@
+package tests
+
+import (
+	"stingray/diff"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	rows := diff.Lines("<h1>Title</h1>\n<p>Old</p>\n<p>Kept</p>\n<p>Gone</p>\n", "<h1>Title</h1>\n<p>New</p>\n<p>Kept</p>\n<p>Extra</p>\n<p>More</p>")
+	want := []diff.Row{
+		{Kind: diff.Equal, Left: "<h1>Title</h1>", Right: "<h1>Title</h1>", LeftLine: 1, RightLine: 1},
+		{Kind: diff.Changed, Left: "<p>Old</p>", Right: "<p>New</p>", LeftLine: 2, RightLine: 2},
+		{Kind: diff.Equal, Left: "<p>Kept</p>", Right: "<p>Kept</p>", LeftLine: 3, RightLine: 3},
+		{Kind: diff.Changed, Left: "<p>Gone</p>", Right: "<p>Extra</p>", LeftLine: 4, RightLine: 4},
+		{Kind: diff.Added, Right: "<p>More</p>", RightLine: 5},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("Expected %d rows, got %d: %+v", len(want), len(rows), rows)
+	}
+	for i := range want {
+		if rows[i] != want[i] {
+			t.Errorf("Row %d = %+v, want %+v", i, rows[i], want[i])
+		}
+	}
+	if !diff.HasChanges(rows) {
+		t.Error("Expected changes to be reported")
+	}
+
+	rows = diff.Lines("a\nb\nc", "a\nc")
+	if len(rows) != 3 || rows[1].Kind != diff.Removed || rows[1].Left != "b" || rows[2].LeftLine != 3 || rows[2].RightLine != 2 {
+		t.Errorf("Unexpected rows for a removed line: %+v", rows)
+	}
+
+	if rows := diff.Lines("same\r\ntext\n", "same\ntext"); diff.HasChanges(rows) || len(rows) != 2 {
+		t.Errorf("Expected line endings to be ignored: %+v", rows)
+	}
+	if rows := diff.Lines("", ""); len(rows) != 0 {
+		t.Errorf("Expected no rows for empty texts: %+v", rows)
+	}
+	if rows := diff.Lines("", "new"); len(rows) != 1 || rows[0].Kind != diff.Added {
+		t.Errorf("Expected an added line: %+v", rows)
+	}
+}
@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"stingray/markdown"
+	"stingray/models"
+	"stingray/templates"
+	"strings"
+	"testing"
+)
+
+func TestMarkdownRender(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"heading", "# Title #", "<h1>Title</h1>\n"},
+		{"setext heading", "Title\n-----", "<h2>Title</h2>\n"},
+		{"emphasis", "*a **b** c* and __d__ and snake_case_name", "<p><em>a <strong>b</strong> c</em> and <strong>d</strong> and snake_case_name</p>\n"},
+		{"code span", "Use `a < b` here", "<p>Use <code>a &lt; b</code> here</p>\n"},
+		{"link", `[Docs](/docs "Read me") and <https://example.com>`, `<p><a href="/docs" title="Read me">Docs</a> and <a href="https://example.com">https://example.com</a></p>` + "\n"},
+		{"reference link", "See [the docs][docs].\n\n[docs]: /docs", `<p>See <a href="/docs">the docs</a>.</p>` + "\n"},
+		{"unclosed reference destination", "[x]: <", "<p>[x]: &lt;</p>\n"},
+		{"bracketed reference destination", "[x]\n\n[x]: <my docs>", `<p><a href="my%20docs">x</a></p>` + "\n"},
+		{"image", "![A *cat*](/cat.png)", `<p><img src="/cat.png" alt="A cat" /></p>` + "\n"},
+		{"hard break", "one  \ntwo", "<p>one<br />\ntwo</p>\n"},
+		{"tight list", "- a\n- b\n  1. c", "<ul>\n<li>a</li>\n<li>b\n<ol>\n<li>c</li>\n</ol>\n</li>\n</ul>\n"},
+		{"loose list", "3. a\n\n4. b", "<ol start=\"3\">\n<li>\n<p>a</p>\n</li>\n<li>\n<p>b</p>\n</li>\n</ol>\n"},
+		{"block quote", "> quoted\ncontinued", "<blockquote>\n<p>quoted\ncontinued</p>\n</blockquote>\n"},
+		{"fenced code", "```go\nif a < b {\n```", "<pre><code class=\"language-go\">if a &lt; b {\n</code></pre>\n"},
+		{"indented code", "    <b>raw</b>", "<pre><code>&lt;b&gt;raw&lt;/b&gt;\n</code></pre>\n"},
+		{"rule", "***", "<hr />\n"},
+		{"table", "| Name | Qty |\n|:-----|----:|\n| a \\| b | 2 |", "<table>\n<thead>\n<tr>\n<th align=\"left\">Name</th>\n<th align=\"right\">Qty</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td align=\"left\">a | b</td>\n<td align=\"right\">2</td>\n</tr>\n</tbody>\n</table>\n"},
+		{"escapes", `\*not emphasis\* &amp; 4 < 5`, "<p>*not emphasis* &amp; 4 &lt; 5</p>\n"},
+		{"html block", "<div class=\"note\">\n*raw*\n</div>", "<div class=\"note\">\n*raw*\n</div>\n"},
+		{"template reference", "Intro\n{{template_login_form}}\nOutro", "<p>Intro</p>\n{{template_login_form}}\n<p>Outro</p>\n"},
+	} {
+		if got := markdown.Render(tc.source); got != tc.want {
+			t.Errorf("%s: Render(%q) = %q, want %q", tc.name, tc.source, got, tc.want)
+		}
+	}
+
+	if got := markdown.RenderInline("Release *notes*"); got != "Release <em>notes</em>" {
+		t.Errorf("RenderInline = %q", got)
+	}
+}
+
+func TestMarkdownSanitizes(t *testing.T) {
+	for _, tc := range []struct {
+		source string
+		want   string
+	}{
+		{"<script>alert(1)</script>Hello", "Hello"},
+		{"<SCRIPT src=x></SCRIPT>", ""},
+		{`<img src="x.png" onerror="alert(1)">`, `<img src="x.png" />`},
+		{`<a href="javascript:alert(1)">x</a>`, "<a>x</a>"},
+		{`<a href="java&#x09;script:alert(1)">x</a>`, "<a>x</a>"},
+		{`<a href="https://example.com" target="_blank" style="color: red">x</a>`, `<a href="https://example.com">x</a>`},
+		{`<img src="data:image/svg+xml,<svg onload=alert(1)>">`, "<img />"},
+		{"<iframe src=//evil></iframe><style>body{}</style>ok", "ok"},
+		{"<!-- hidden --><b>shown</b>", "<b>shown</b>"},
+		{"<form><input name=x><b>text</b></form>", "<b>text</b>"},
+		{"<p>unclosed <b>bold</div></div>", "<p>unclosed <b>bold</b></p>"},
+		{`<span title="{{template_login_form}}">x</span>`, `<span title="&#123;&#123;template_login_form}}">x</span>`},
+		{"1 < 2 & 3 > 2", "1 &lt; 2 &amp; 3 &gt; 2"},
+	} {
+		if got := markdown.Sanitize(tc.source); got != tc.want {
+			t.Errorf("Sanitize(%q) = %q, want %q", tc.source, got, tc.want)
+		}
+	}
+
+	// Markdown links get the same URL checks as HTML
+	if got := markdown.Render("[x](javascript:alert(1))"); got != "<p><a>x</a></p>\n" {
+		t.Errorf("Expected the javascript: link to be dropped, got %q", got)
+	}
+	if got := markdown.Render("Hi\n\n<script>alert(1)</script>"); strings.Contains(got, "alert") {
+		t.Errorf("Expected the script to be removed, got %q", got)
+	}
+}
+
+func TestRenderMarkdownPage(t *testing.T) {
+	page := &models.Page{
+		Title:         "Notes",
+		Header:        "Release *notes*",
+		MainContent:   "## Changes\n\n<script>alert(1)</script>\n\n{{template_login_form}}",
+		Template:      "modern",
+		ContentFormat: models.PageFormatMarkdown,
+	}
+	html, err := templates.RenderPage(page, "abc")
+	if err != nil {
+		t.Fatalf("RenderPage failed: %v", err)
+	}
+	if !strings.Contains(html, "<h2>Changes</h2>") || !strings.Contains(html, "Release <em>notes</em>") {
+		t.Error("Expected the Markdown to be converted")
+	}
+	if strings.Contains(html, "alert(1)") {
+		t.Error("Expected the author's script to be removed")
+	}
+	if strings.Contains(html, "{{template_login_form}}") || !strings.Contains(html, "<form") {
+		t.Error("Expected the embedded template to be expanded after sanitizing")
+	}
+
+	// HTML pages are trusted as written
+	page.ContentFormat = models.PageFormatHTML
+	page.MainContent = "## Not a heading"
+	if html, err := templates.RenderPage(page, "abc"); err != nil || strings.Contains(html, "<h2>") {
+		t.Errorf("Expected HTML content to be left alone: %v", err)
+	}
+}
@@ -0,0 +1,457 @@
+package tests
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"stingray/config"
+	"stingray/handlers"
+	"stingray/logging"
+	"stingray/models"
+	"stingray/templates"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPageSlugsAndGroups(t *testing.T) {
+	for slug, want := range map[string]bool{
+		"about":                  true,
+		"release-notes_2":        true,
+		"":                       false,
+		"About":                  false,
+		"../config":              false,
+		"docs/intro":             false,
+		strings.Repeat("a", 256): false,
+	} {
+		if got := models.ValidPageSlug(slug); got != want {
+			t.Errorf("ValidPageSlug(%q) = %v, want %v", slug, got, want)
+		}
+	}
+
+	encoded := models.PageGroupsValue([]string{"admin", "engineer"})
+	if !encoded.Valid || encoded.String != `["admin","engineer"]` {
+		t.Errorf("Unexpected encoded groups %+v", encoded)
+	}
+	groups, err := models.PageGroups(encoded)
+	if err != nil || len(groups) != 2 || groups[1] != "engineer" {
+		t.Errorf("PageGroups round trip = %v, %v", groups, err)
+	}
+	if open := models.PageGroupsValue(nil); open.Valid {
+		t.Error("Expected no groups to leave the page unrestricted")
+	}
+	if _, err := models.PageGroups(sql.NullString{String: "admin", Valid: true}); err == nil {
+		t.Error("Expected malformed groups to be rejected")
+	}
+}
+
+func TestPageStatuses(t *testing.T) {
+	for _, status := range models.PageStatuses {
+		if !models.ValidPageStatus(status) {
+			t.Errorf("Expected %s to be a valid status", status)
+		}
+	}
+	if models.ValidPageStatus("deleted") {
+		t.Error("Expected unknown statuses to be rejected")
+	}
+
+	published := sql.NullInt64{Int64: 2, Valid: true}
+	for _, tc := range []struct {
+		page models.Page
+		live bool
+	}{
+		{models.Page{Status: models.PageStatusPublished}, true}, // Published before drafts existed
+		{models.Page{Status: models.PageStatusDraft}, false},
+		{models.Page{Status: models.PageStatusDraft, PublishedRevision: published}, true},
+		{models.Page{Status: models.PageStatusInReview, PublishedRevision: published}, true},
+		{models.Page{Status: models.PageStatusArchived, PublishedRevision: published}, false},
+	} {
+		if got := tc.page.IsLive(); got != tc.live {
+			t.Errorf("IsLive() for %s with published revision %v = %v, want %v", tc.page.Status, tc.page.PublishedRevision.Valid, got, tc.live)
+		}
+	}
+}
+
+func TestListTemplates(t *testing.T) {
+	names, err := templates.ListTemplates()
+	if err != nil {
+		t.Fatalf("ListTemplates failed: %v", err)
+	}
+	joined := "," + strings.Join(names, ",") + ","
+	for _, name := range []string{"modern", "metadata", "login_form"} {
+		if !strings.Contains(joined, ","+name+",") {
+			t.Errorf("Expected template %s in %v", name, names)
+		}
+	}
+	if strings.Contains(joined, "renderer.go") {
+		t.Errorf("Source files are not templates: %v", names)
+	}
+	if !templates.TemplateExists("modern") || templates.TemplateExists("missing") {
+		t.Error("Unexpected TemplateExists results")
+	}
+
+	// Only layouts that render a page's main content are offered for pages
+	layouts, err := templates.ListLayouts()
+	if err != nil {
+		t.Fatalf("ListLayouts failed: %v", err)
+	}
+	if strings.Join(layouts, ",") != "metadata,modern" {
+		t.Errorf("Expected the metadata and modern layouts, got %v", layouts)
+	}
+}
+
+func TestPagesAPI(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer cleanupTestDatabase(t, db)
+	defer db.Close()
+
+	for _, permission := range []string{models.PermissionPagesEdit, models.PermissionPagesEditHTML} {
+		if err := db.GrantPermission("admin", permission); err != nil {
+			t.Fatalf("Failed to grant %s: %v", permission, err)
+		}
+	}
+	sessionCookie := func(username, password string) *http.Cookie {
+		user, err := db.AuthenticateUser(username, password)
+		if err != nil {
+			t.Fatalf("Failed to authenticate %s: %v", username, err)
+		}
+		session, err := db.CreateSession(user.ID, user.Username, time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		return &http.Cookie{Name: handlers.SessionCookieName, Value: session.SessionID}
+	}
+	adminCookie := sessionCookie("admin", "admin123")
+	customerCookie := sessionCookie("customer", "customer123")
+
+	sm := newTestSessionMiddleware(db)
+	editor := handlers.NewPageEditorHandler(db, sm, nil)
+	call := func(cookie *http.Cookie, method, path, body string) (int, handlers.APIResponse) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		handler := editor.HandleAPIPage
+		if path == "/api/pages" {
+			handler = editor.HandleAPIPages
+		}
+		sm.Identify(http.HandlerFunc(handler)).ServeHTTP(rec, req)
+		var response handlers.APIResponse
+		json.Unmarshal(rec.Body.Bytes(), &response)
+		return rec.Code, response
+	}
+
+	status, response := call(adminCookie, "POST", "/api/pages", `{"slug": "release-notes", "title": "Release Notes", "main_content": "<p>1.0</p>"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected page creation to succeed, got %d: %s", status, response.Error)
+	}
+	if status, _ := call(adminCookie, "POST", "/api/pages", `{"slug": "release-notes", "title": "Again"}`); status != http.StatusConflict {
+		t.Errorf("Expected a duplicate slug to conflict, got %d", status)
+	}
+	if status, _ := call(adminCookie, "POST", "/api/pages", `{"slug": "Bad Slug", "title": "Bad"}`); status != http.StatusBadRequest {
+		t.Errorf("Expected an invalid slug to be rejected, got %d", status)
+	}
+	if status, _ := call(adminCookie, "POST", "/api/pages", `{"slug": "other", "title": "Other", "template": "missing"}`); status != http.StatusBadRequest {
+		t.Errorf("Expected an unknown template to be rejected, got %d", status)
+	}
+	if status, _ := call(adminCookie, "POST", "/api/pages", `{"slug": "other", "title": "Other", "template": "login_form"}`); status != http.StatusBadRequest {
+		t.Errorf("Expected a partial to be rejected as a page template, got %d", status)
+	}
+	if status, _ := call(adminCookie, "POST", "/api/pages", `{"slug": "other", "title": "Other", "content_format": "rst"}`); status != http.StatusBadRequest {
+		t.Errorf("Expected an unknown content format to be rejected, got %d", status)
+	}
+
+	// Built-in pages are loaded by slug, so they stay in place
+	if status, _ := call(adminCookie, "DELETE", "/api/pages/login", ""); status != http.StatusBadRequest {
+		t.Errorf("Expected deleting the login page to be refused, got %d", status)
+	}
+	if status, _ := call(adminCookie, "PUT", "/api/pages/login", `{"slug": "sign-in"}`); status != http.StatusBadRequest {
+		t.Errorf("Expected renaming the login page to be refused, got %d", status)
+	}
+
+	// Partial updates keep the fields that were not sent
+	if status, response := call(adminCookie, "PUT", "/api/pages/release-notes", `{"title": "What's New"}`); status != http.StatusOK {
+		t.Fatalf("Expected the update to succeed, got %d: %s", status, response.Error)
+	}
+	page, err := db.GetPage("release-notes")
+	if err != nil {
+		t.Fatalf("Failed to load page: %v", err)
+	}
+	if page.Title != "What's New" || page.MainContent != "<p>1.0</p>" || page.Template != "modern" || page.ContentFormat != models.PageFormatHTML {
+		t.Errorf("Unexpected page after update: %+v", page)
+	}
+
+	// Editors cannot remove themselves from the write groups
+	if status, _ := call(adminCookie, "PUT", "/api/pages/release-notes", `{"write_groups": ["customers"]}`); status != http.StatusBadRequest {
+		t.Errorf("Expected a lockout to be rejected, got %d", status)
+	}
+
+	// Customers do not hold pages.edit
+	if status, _ := call(customerCookie, "GET", "/api/pages", ""); status != http.StatusForbidden {
+		t.Errorf("Expected customers to be refused, got %d", status)
+	}
+
+	// Each save is a revision, and restoring one adds another
+	status, response = call(adminCookie, "GET", "/api/pages/release-notes/revisions", "")
+	if revisions, _ := response.Data.([]interface{}); status != http.StatusOK || len(revisions) != 2 {
+		t.Fatalf("Expected two revisions, got %d: %v", status, response.Data)
+	}
+	if status, _ := call(adminCookie, "GET", "/api/pages/release-notes/revisions/7", ""); status != http.StatusNotFound {
+		t.Errorf("Expected a missing revision to be reported, got %d", status)
+	}
+	if status, response := call(adminCookie, "POST", "/api/pages/release-notes/revisions/1/restore", ""); status != http.StatusOK {
+		t.Fatalf("Expected the restore to succeed, got %d: %s", status, response.Error)
+	}
+	page, err = db.GetPage("release-notes")
+	if err != nil || page.Title != "Release Notes" {
+		t.Errorf("Expected the first title to be restored: %+v, %v", page, err)
+	}
+	revisions, err := db.GetPageRevisions(page.ID)
+	if err != nil || len(revisions) != 3 || revisions[0].Revision != 3 || revisions[0].Note != "Restored revision 1" || revisions[0].Author.Name != "admin" {
+		t.Errorf("Unexpected revisions after restore: %+v, %v", revisions, err)
+	}
+
+	// Editors without pages.edit_html write Markdown, and cannot change HTML content
+	if err := db.GrantPermission("customers", models.PermissionPagesEdit); err != nil {
+		t.Fatalf("Failed to grant pages.edit: %v", err)
+	}
+	if status, _ := call(customerCookie, "POST", "/api/pages", `{"slug": "faq-notes", "title": "Notes", "content_format": "html", "main_content": "<script>alert(1)</script>", "write_groups": ["customers"]}`); status != http.StatusForbidden {
+		t.Errorf("Expected HTML content from a Markdown editor to be refused, got %d", status)
+	}
+	if status, response := call(customerCookie, "POST", "/api/pages", `{"slug": "faq-notes", "title": "Notes", "main_content": "*1.0*", "write_groups": ["customers"]}`); status != http.StatusCreated {
+		t.Fatalf("Expected a Markdown page to be created, got %d: %s", status, response.Error)
+	}
+	if page, err := db.GetPage("faq-notes"); err != nil || page.ContentFormat != models.PageFormatMarkdown {
+		t.Errorf("Expected new pages to default to Markdown: %+v, %v", page, err)
+	}
+	if status, _ := call(customerCookie, "PUT", "/api/pages/faq-notes", `{"content_format": "html"}`); status != http.StatusForbidden {
+		t.Errorf("Expected switching to HTML to be refused, got %d", status)
+	}
+
+	// Scripts run under the CSP nonce even on Markdown pages, so restoring them needs pages.edit_html too
+	notes, err := db.GetPage("faq-notes")
+	if err != nil {
+		t.Fatalf("Failed to load page: %v", err)
+	}
+	for _, scripts := range []string{"alert(1)", ""} {
+		notes.Scripts = scripts
+		if err := db.UpdatePage(notes, models.PageAuthor{Name: "admin"}); err != nil {
+			t.Fatalf("Failed to save scripts: %v", err)
+		}
+	}
+	if status, _ := call(customerCookie, "POST", "/api/pages/faq-notes/revisions/2/restore", ""); status != http.StatusForbidden {
+		t.Errorf("Expected restoring scripts to be refused, got %d", status)
+	}
+	if notes, err := db.GetPage("faq-notes"); err != nil || notes.Scripts != "" {
+		t.Errorf("Expected the scripts to stay empty: %+v, %v", notes, err)
+	}
+	if status, _ := call(adminCookie, "PUT", "/api/pages/release-notes", `{"write_groups": ["admin", "customers"]}`); status != http.StatusOK {
+		t.Fatalf("Expected the write groups to be updated, got %d", status)
+	}
+	if status, _ := call(customerCookie, "PUT", "/api/pages/release-notes", `{"main_content": "<script>alert(1)</script>"}`); status != http.StatusForbidden {
+		t.Errorf("Expected HTML content changes to be refused, got %d", status)
+	}
+	if status, response := call(customerCookie, "PUT", "/api/pages/release-notes", `{"meta_description": "Changes"}`); status != http.StatusOK {
+		t.Errorf("Expected other fields of an HTML page to be editable, got %d: %s", status, response.Error)
+	}
+
+	if status, _ := call(adminCookie, "DELETE", "/api/pages/release-notes", ""); status != http.StatusOK {
+		t.Errorf("Expected the delete to succeed, got %d", status)
+	}
+	if status, _ := call(adminCookie, "GET", "/api/pages/release-notes", ""); status != http.StatusNotFound {
+		t.Errorf("Expected the deleted page to be gone, got %d", status)
+	}
+}
+
+func TestPagePublishing(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer cleanupTestDatabase(t, db)
+	defer db.Close()
+
+	author := models.PageAuthor{Name: "admin"}
+	id, err := db.CreatePage(&models.Page{Slug: "launch", Title: "Launch", MainContent: "<p>v1</p>", Template: "modern"}, author)
+	if err != nil {
+		t.Fatalf("Failed to create page: %v", err)
+	}
+
+	// New pages are drafts that readers cannot see
+	if _, err := db.GetPublishedPage("launch"); err != sql.ErrNoRows {
+		t.Fatalf("Expected a new draft to be hidden, got %v", err)
+	}
+
+	if err := db.SetPageStatus(id, models.PageStatusPublished); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	// Editing a published page leaves readers on the published revision
+	page, err := db.GetPage("launch")
+	if err != nil {
+		t.Fatalf("Failed to load page: %v", err)
+	}
+	page.MainContent = "<p>v2</p>"
+	if err := db.UpdatePage(page, author); err != nil {
+		t.Fatalf("Failed to save draft: %v", err)
+	}
+	draft, _ := db.GetPage("launch")
+	published, err := db.GetPublishedPage("launch")
+	if err != nil || published.MainContent != "<p>v1</p>" || draft.MainContent != "<p>v2</p>" || draft.Status != models.PageStatusDraft {
+		t.Fatalf("Expected readers to keep v1 while the draft holds v2: %+v, %+v, %v", draft, published, err)
+	}
+
+	// Read groups are access control rather than content, so restricting them applies to the
+	// published revision straight away
+	customer, err := db.AuthenticateUser("customer", "customer123")
+	if err != nil {
+		t.Fatalf("Failed to authenticate customer: %v", err)
+	}
+	customerAccess, err := db.LoadAccess(customer.ID)
+	if err != nil {
+		t.Fatalf("Failed to load access: %v", err)
+	}
+	if _, err := db.GetPageWithAccess("launch", customerAccess); err != nil {
+		t.Fatalf("Expected customers to read the published page: %v", err)
+	}
+	draft.ReadGroups = models.PageGroupsValue([]string{"admin"})
+	if err := db.UpdatePage(draft, author); err != nil {
+		t.Fatalf("Failed to restrict read groups: %v", err)
+	}
+	if _, err := db.GetPageWithAccess("launch", customerAccess); err == nil {
+		t.Error("Expected restricted read groups to hide the published page without republishing")
+	}
+	if published, err := db.GetPublishedPage("launch"); err != nil || published.MainContent != "<p>v1</p>" {
+		t.Errorf("Expected readers to keep v1 content: %+v, %v", published, err)
+	}
+	draft.ReadGroups = models.PageGroupsValue(nil)
+	if err := db.UpdatePage(draft, author); err != nil {
+		t.Fatalf("Failed to reopen read groups: %v", err)
+	}
+
+	// Scheduled times are processed by the background loop
+	past := sql.NullTime{Time: time.Now().Add(-time.Minute), Valid: true}
+	if err := db.SchedulePage(id, past, sql.NullTime{}); err != nil {
+		t.Fatalf("Failed to schedule: %v", err)
+	}
+	publishedSlugs, archivedSlugs, err := db.ProcessPageSchedules()
+	if err != nil || len(publishedSlugs) != 1 || len(archivedSlugs) != 0 {
+		t.Fatalf("Unexpected schedule results %v, %v, %v", publishedSlugs, archivedSlugs, err)
+	}
+	if published, err := db.GetPublishedPage("launch"); err != nil || published.MainContent != "<p>v2</p>" {
+		t.Errorf("Expected the scheduled publish to make v2 live: %+v, %v", published, err)
+	}
+
+	if err := db.SchedulePage(id, sql.NullTime{}, past); err != nil {
+		t.Fatalf("Failed to schedule: %v", err)
+	}
+	if _, archivedSlugs, err := db.ProcessPageSchedules(); err != nil || len(archivedSlugs) != 1 {
+		t.Fatalf("Expected the page to be archived: %v, %v", archivedSlugs, err)
+	}
+	if _, err := db.GetPublishedPage("launch"); err != sql.ErrNoRows {
+		t.Errorf("Expected an archived page to be hidden, got %v", err)
+	}
+	if err := db.SetPageStatus(id, models.PageStatusDraft); err != nil {
+		t.Fatalf("Failed to reopen the page: %v", err)
+	}
+	if _, err := db.GetPublishedPage("launch"); err != sql.ErrNoRows {
+		t.Errorf("Expected a reopened page to stay hidden until published, got %v", err)
+	}
+
+	// Built-in pages predate drafts and stay live while they are edited
+	about, err := db.GetPage("about")
+	if err != nil {
+		t.Fatalf("Failed to load about page: %v", err)
+	}
+	originalTitle := about.Title
+	about.Title = "About (draft)"
+	if err := db.UpdatePage(about, author); err != nil {
+		t.Fatalf("Failed to edit about page: %v", err)
+	}
+	if published, err := db.GetPublishedPage("about"); err != nil || published.Title != originalTitle {
+		t.Errorf("Expected readers to keep the original about page: %+v, %v", published, err)
+	}
+
+	// The login and password reset pages are served as published too
+	logger := logging.NewLogger(logging.LevelErrors)
+	authHandler := handlers.NewAuthHandler(db, newTestSessionMiddleware(db), logger)
+	resetHandler := handlers.NewPasswordResetHandler(db, newTestSessionMiddleware(db), &config.Config{}, logger)
+	serve := func(handler http.HandlerFunc, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+	for _, slug := range []string{"login", "password-reset-request"} {
+		page, err := db.GetPage(slug)
+		if err != nil {
+			t.Fatalf("Failed to load %s page: %v", slug, err)
+		}
+		page.Header = "Unreviewed " + slug + " header"
+		if err := db.UpdatePage(page, author); err != nil {
+			t.Fatalf("Failed to edit %s page: %v", slug, err)
+		}
+	}
+	if rec := serve(authHandler.HandleLogin, "/user/login"); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "Unreviewed") {
+		t.Errorf("Expected the published login page, got %d", rec.Code)
+	}
+	if rec := serve(resetHandler.HandlePasswordResetRequest, "/user/password-reset-request"); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "Unreviewed") {
+		t.Errorf("Expected the published password reset page, got %d", rec.Code)
+	}
+	login, _ := db.GetPage("login")
+	if err := db.SetPageStatus(login.ID, models.PageStatusArchived); err != nil {
+		t.Fatalf("Failed to archive the login page: %v", err)
+	}
+	if rec := serve(authHandler.HandleLogin, "/user/login"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected the archived login page to be taken down, got %d", rec.Code)
+	}
+}
+
+func TestGenericEditorRefusesPages(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer cleanupTestDatabase(t, db)
+	defer db.Close()
+
+	user, err := db.AuthenticateUser("admin", "admin123")
+	if err != nil {
+		t.Fatalf("Failed to authenticate admin: %v", err)
+	}
+	session, err := db.CreateSession(user.ID, user.Username, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	about, err := db.GetPage("about")
+	if err != nil {
+		t.Fatalf("Failed to load about page: %v", err)
+	}
+
+	// Page rows are only changed through the page editor, even by users who may write the table
+	metadata := handlers.NewMetadataHandler(db, newTestSessionMiddleware(db))
+	id := strconv.Itoa(about.ID)
+	for _, tc := range []struct {
+		handler http.HandlerFunc
+		path    string
+	}{
+		{metadata.HandleEditRow, "/metadata/edit/_page/" + id},
+		{metadata.HandleEditRow, "/metadata/edit/_page/new"},
+		{metadata.HandleEditRow, "/metadata/edit/_page_revision/1"},
+		{metadata.HandleDeleteRow, "/metadata/delete/_page/" + id},
+		{metadata.HandleDeleteRow, "/metadata/delete/_page_revision/1"},
+	} {
+		form := url.Values{"main_content": {"<script>alert(1)</script>"}, "scripts": {"alert(1)"}}
+		req := httptest.NewRequest("POST", tc.path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: handlers.SessionCookieName, Value: session.SessionID})
+		rec := httptest.NewRecorder()
+		tc.handler(rec, req)
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/page-editor" {
+			t.Errorf("Expected %s to redirect to the page editor, got %d %q", tc.path, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+
+	after, err := db.GetPage("about")
+	if err != nil {
+		t.Fatalf("Expected the about page to survive: %v", err)
+	}
+	if after.MainContent != about.MainContent || after.Scripts != about.Scripts {
+		t.Errorf("Expected the about page to be unchanged, got %+v", after)
+	}
+}
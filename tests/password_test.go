@@ -1,9 +1,12 @@
 package tests
 
 import (
+	"encoding/base64"
 	"strings"
 	"testing"
 	"stingray/auth"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
 )
 
 func TestHashPassword(t *testing.T) {
@@ -210,4 +213,159 @@ func TestPasswordSecurity(t *testing.T) {
 			}
 		})
 	}
-} 
\ No newline at end of file
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := &auth.PasswordPolicy{
+		MinLength:        10,
+		RequireUpper:     true,
+		RequireDigit:     true,
+		DisallowUserInfo: true,
+		HistorySize:      3,
+	}
+	policy.SetBreachedPasswords([]string{"Password1234"})
+
+	tests := []struct {
+		name       string
+		password   string
+		violations int
+	}{
+		{"Valid", "Correct7Horse", 0},
+		{"TooShort", "Short1A", 1},
+		{"MissingClasses", "alllowercaseletters", 2},
+		{"ContainsUsername", "Alice2024rocks", 1},
+		{"ContainsEmailName", "Wonderland99Z", 1},
+		{"Breached", "password1234", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Validate(tt.password, "alice", "wonderland@example.com")
+			if tt.violations == 0 {
+				if err != nil {
+					t.Errorf("Expected password to be accepted, got: %v", err)
+				}
+				return
+			}
+			policyErr, ok := auth.IsPolicyError(err)
+			if !ok {
+				t.Fatalf("Expected a policy error, got: %v", err)
+			}
+			if len(policyErr.Violations) != tt.violations {
+				t.Errorf("Expected %d violations, got %d: %v", tt.violations, len(policyErr.Violations), policyErr.Violations)
+			}
+		})
+	}
+
+	t.Run("RejectsReusedPassword", func(t *testing.T) {
+		oldHash, err := auth.HashPassword("Correct7Horse")
+		if err != nil {
+			t.Fatalf("Failed to hash password: %v", err)
+		}
+		if err := policy.ReusedPassword("Correct7Horse", []string{oldHash}); err == nil {
+			t.Error("Expected reused password to be rejected")
+		}
+		if err := policy.ReusedPassword("Battery9Staple", []string{oldHash}); err != nil {
+			t.Errorf("Expected new password to be accepted, got: %v", err)
+		}
+	})
+
+	t.Run("NilPolicyAcceptsAnything", func(t *testing.T) {
+		var none *auth.PasswordPolicy
+		if err := none.Validate("x", "alice", "alice@example.com"); err != nil {
+			t.Errorf("Expected nil policy to accept any password, got: %v", err)
+		}
+	})
+}
+
+func TestPasswordRehash(t *testing.T) {
+	password := "upgrade-me"
+
+	t.Run("CurrentParamsDoNotNeedRehash", func(t *testing.T) {
+		hash, err := auth.HashPassword(password)
+		if err != nil {
+			t.Fatalf("Failed to hash password: %v", err)
+		}
+		match, needsRehash, err := auth.VerifyPassword(password, hash)
+		if err != nil || !match {
+			t.Fatalf("Expected password to verify, got match=%v err=%v", match, err)
+		}
+		if needsRehash {
+			t.Error("Hash with default parameters should not need rehashing")
+		}
+	})
+
+	t.Run("WeakerParamsNeedRehash", func(t *testing.T) {
+		hash, err := auth.HashPasswordWithParams(password, &auth.Argon2Params{
+			Memory:      16 * 1024,
+			Iterations:  1,
+			Parallelism: 1,
+			SaltLength:  16,
+			KeyLength:   32,
+		})
+		if err != nil {
+			t.Fatalf("Failed to hash password: %v", err)
+		}
+		match, needsRehash, err := auth.VerifyPassword(password, hash)
+		if err != nil || !match {
+			t.Fatalf("Expected password to verify, got match=%v err=%v", match, err)
+		}
+		if !needsRehash {
+			t.Error("Hash with outdated parameters should need rehashing")
+		}
+	})
+
+	t.Run("LegacyBcrypt", func(t *testing.T) {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		if err != nil {
+			t.Fatalf("Failed to create bcrypt hash: %v", err)
+		}
+		if !auth.IsHashFormat(string(hash)) {
+			t.Error("bcrypt hash should be recognized as hash format")
+		}
+		match, needsRehash, err := auth.VerifyPassword(password, string(hash))
+		if err != nil || !match {
+			t.Fatalf("Expected bcrypt password to verify, got match=%v err=%v", match, err)
+		}
+		if !needsRehash {
+			t.Error("bcrypt hash should need rehashing")
+		}
+		if match, _, _ := auth.VerifyPassword("wrong", string(hash)); match {
+			t.Error("Wrong password should not verify against bcrypt hash")
+		}
+	})
+
+	t.Run("LegacyScrypt", func(t *testing.T) {
+		salt := []byte("0123456789abcdef")
+		key, err := scrypt.Key([]byte(password), salt, 1<<10, 8, 1, 32)
+		if err != nil {
+			t.Fatalf("Failed to create scrypt hash: %v", err)
+		}
+		hash := "$scrypt$ln=10,r=8,p=1$" + base64.RawStdEncoding.EncodeToString(salt) + "$" + base64.RawStdEncoding.EncodeToString(key)
+		match, needsRehash, err := auth.VerifyPassword(password, hash)
+		if err != nil || !match {
+			t.Fatalf("Expected scrypt password to verify, got match=%v err=%v", match, err)
+		}
+		if !needsRehash {
+			t.Error("scrypt hash should need rehashing")
+		}
+		if match, _, _ := auth.VerifyPassword("wrong", hash); match {
+			t.Error("Wrong password should not verify against scrypt hash")
+		}
+	})
+
+	t.Run("MalformedScrypt", func(t *testing.T) {
+		salt := base64.RawStdEncoding.EncodeToString([]byte("saltsalt"))
+		for _, hash := range []string{
+			"$scrypt$ln=4,r=8,p=1$" + salt + "$",
+			"$scrypt$ln=4,r=8,p=1$" + salt + "$" + base64.RawStdEncoding.EncodeToString([]byte("short")),
+			"$scrypt$ln=4,r=8,p=0$" + salt + "$" + base64.RawStdEncoding.EncodeToString(make([]byte, 32)),
+			"$scrypt$ln=4,r=0,p=1$" + salt + "$" + base64.RawStdEncoding.EncodeToString(make([]byte, 32)),
+		} {
+			match, _, err := auth.VerifyPassword("wrong-password", hash)
+			if match || err == nil {
+				t.Errorf("Expected %q to be rejected, got match=%v err=%v", hash, match, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"stingray/database"
+	"stingray/models"
+)
+
+func TestRegistration(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer cleanupTestDatabase(t, db)
+	defer db.Close()
+
+	t.Run("RegisterAddsDefaultGroup", func(t *testing.T) {
+		userID, err := db.RegisterUser("newcomer", "newcomer@example.com", "Newcomer-Pass-1", "customers", models.UserStatusPendingVerification)
+		if err != nil {
+			t.Fatalf("Failed to register user: %v", err)
+		}
+		groups, err := db.GetUserGroups(userID)
+		if err != nil || len(groups) != 1 || groups[0].Name != "customers" {
+			t.Errorf("Expected the new user to be in customers only, got %+v, %v", groups, err)
+		}
+		if _, err := db.AuthenticateUser("newcomer", "Newcomer-Pass-1"); err != database.ErrAccountNotActive {
+			t.Errorf("Expected a pending account to be refused, got %v", err)
+		}
+	})
+
+	t.Run("MissingGroupCreatesNoAccount", func(t *testing.T) {
+		if _, err := db.RegisterUser("orphan", "orphan@example.com", "Orphan-Pass-1", "no-such-group", models.UserStatusActive); err == nil {
+			t.Fatal("Expected registration into a missing group to fail")
+		}
+		if exists, err := db.UserExists("orphan", "orphan@example.com"); err != nil || exists {
+			t.Errorf("Expected no account to be left behind, got exists=%v err=%v", exists, err)
+		}
+	})
+
+	t.Run("VerifyEmail", func(t *testing.T) {
+		userID, err := db.RegisterUser("verifier", "verifier@example.com", "Verifier-Pass-1", "customers", models.UserStatusPendingVerification)
+		if err != nil {
+			t.Fatalf("Failed to register user: %v", err)
+		}
+		token := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+		if err := db.CreateEmailVerificationToken(userID, "verifier@example.com", token, time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("Failed to create verification token: %v", err)
+		}
+
+		var stored int
+		if err := db.GetDB().QueryRow("SELECT COUNT(*) FROM _email_verification_token WHERE token = ?", token).Scan(&stored); err != nil || stored != 0 {
+			t.Errorf("Expected only a hash of the token to be stored, got %d rows, %v", stored, err)
+		}
+
+		verification, err := db.GetEmailVerificationToken(token)
+		if err != nil || verification.UserID != userID || verification.Used {
+			t.Fatalf("Expected an unused token for the new user, got %+v, %v", verification, err)
+		}
+		if verifiedID, err := db.VerifyEmail(token, models.UserStatusActive); err != nil || verifiedID != userID {
+			t.Fatalf("Failed to verify email: %d, %v", verifiedID, err)
+		}
+		if verification, err := db.GetEmailVerificationToken(token); err != nil || !verification.Used {
+			t.Errorf("Expected the token to be used, got %+v, %v", verification, err)
+		}
+		if _, err := db.AuthenticateUser("verifier", "Verifier-Pass-1"); err != nil {
+			t.Errorf("Expected the verified account to log in, got %v", err)
+		}
+
+		// A link verifies once
+		if _, err := db.VerifyEmail(token, models.UserStatusActive); err != database.ErrVerificationInvalid {
+			t.Errorf("Expected a used link to be refused, got %v", err)
+		}
+	})
+
+	t.Run("OldLinkCannotReactivate", func(t *testing.T) {
+		userID, err := db.RegisterUser("suspended", "suspended@example.com", "Suspended-Pass-1", "customers", models.UserStatusPendingVerification)
+		if err != nil {
+			t.Fatalf("Failed to register user: %v", err)
+		}
+		token := "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+		if err := db.CreateEmailVerificationToken(userID, "suspended@example.com", token, time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("Failed to create verification token: %v", err)
+		}
+
+		// An administrator moves the account on before the link is clicked
+		if err := db.SetUserStatus(userID, models.UserStatusPendingApproval); err != nil {
+			t.Fatalf("Failed to change status: %v", err)
+		}
+		if _, err := db.VerifyEmail(token, models.UserStatusActive); err != database.ErrVerificationInvalid {
+			t.Errorf("Expected the old link to be refused, got %v", err)
+		}
+		if user, err := db.GetUserByID(userID); err != nil || user.Status != models.UserStatusPendingApproval {
+			t.Errorf("Expected the status to be unchanged, got %+v, %v", user, err)
+		}
+	})
+
+	t.Run("ApproveRegistration", func(t *testing.T) {
+		userID, err := db.RegisterUser("approved", "approved@example.com", "Approved-Pass-1", "customers", models.UserStatusPendingApproval)
+		if err != nil {
+			t.Fatalf("Failed to register user: %v", err)
+		}
+		pending, err := db.GetUsersByStatus(models.UserStatusPendingApproval)
+		if err != nil || !containsUser(pending, userID) {
+			t.Fatalf("Expected the account to wait for approval, got %+v, %v", pending, err)
+		}
+		if err := db.SetUserStatus(userID, models.UserStatusActive); err != nil {
+			t.Fatalf("Failed to approve user: %v", err)
+		}
+		if _, err := db.AuthenticateUser("approved", "Approved-Pass-1"); err != nil {
+			t.Errorf("Expected the approved account to log in, got %v", err)
+		}
+		if err := db.DeletePendingUser(userID); err == nil {
+			t.Error("Expected an active account not to be deleted as a pending registration")
+		}
+	})
+
+	t.Run("RejectRegistration", func(t *testing.T) {
+		userID, err := db.RegisterUser("rejected", "rejected@example.com", "Rejected-Pass-1", "customers", models.UserStatusPendingApproval)
+		if err != nil {
+			t.Fatalf("Failed to register user: %v", err)
+		}
+		if err := db.DeletePendingUser(userID); err != nil {
+			t.Fatalf("Failed to reject registration: %v", err)
+		}
+		if _, err := db.GetUserByID(userID); err == nil {
+			t.Error("Expected the rejected account to be deleted")
+		}
+	})
+}
+
+func containsUser(users []models.User, userID int) bool {
+	for _, user := range users {
+		if user.ID == userID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"stingray/handlers"
+	"stingray/models"
+	"stingray/templates"
+	"strings"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	policy := handlers.SecurityPolicy{
+		ContentSecurityPolicy: "default-src 'self'; script-src 'self' 'nonce-{nonce}'",
+		FrameAncestors:        "'none'",
+		ReferrerPolicy:        "strict-origin-when-cross-origin",
+		PermissionsPolicy:     "camera=()",
+	}
+	mw := handlers.NewSecurityHeadersMiddleware(policy, "/csp-report", nil)
+	apiPolicy := policy
+	apiPolicy.ContentSecurityPolicy = "default-src 'none'"
+	mw.Route("/api/", apiPolicy)
+	reportOnly := policy
+	reportOnly.ReportOnly = true
+	mw.Route("/api/preview/", reportOnly)
+
+	var nonce string
+	handler := mw.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = handlers.CSPNonce(r)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/page/about", nil))
+	if nonce == "" {
+		t.Fatal("Expected a nonce for a policy using {nonce}")
+	}
+	want := "default-src 'self'; script-src 'self' 'nonce-" + nonce + "'; frame-ancestors 'none'; report-uri /csp-report"
+	if got := rec.Header().Get("Content-Security-Policy"); got != want {
+		t.Errorf("Content-Security-Policy = %q, want %q", got, want)
+	}
+	for header, value := range map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"Referrer-Policy":        "strict-origin-when-cross-origin",
+		"Permissions-Policy":     "camera=()",
+	} {
+		if got := rec.Header().Get(header); got != value {
+			t.Errorf("%s = %q, want %q", header, got, value)
+		}
+	}
+
+	first := nonce
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/page/about", nil))
+	if nonce == first {
+		t.Error("Expected a fresh nonce on every request")
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/users", nil))
+	if got := rec.Header().Get("Content-Security-Policy"); got != "default-src 'none'; frame-ancestors 'none'; report-uri /csp-report" {
+		t.Errorf("Unexpected API policy %q", got)
+	}
+	if nonce != "" {
+		t.Error("Expected no nonce for a policy without {nonce}")
+	}
+
+	// The longest matching prefix wins
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/preview/1", nil))
+	if rec.Header().Get("Content-Security-Policy") != "" || rec.Header().Get("Content-Security-Policy-Report-Only") == "" {
+		t.Error("Expected the report-only policy for the more specific route group")
+	}
+}
+
+func TestCSPReportEndpoint(t *testing.T) {
+	mw := handlers.NewSecurityHeadersMiddleware(handlers.SecurityPolicy{}, "/csp-report", nil)
+
+	body := `{"csp-report":{"document-uri":"http://localhost/","violated-directive":"script-src"}}`
+	req := httptest.NewRequest("POST", "/csp-report", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/csp-report")
+	rec := httptest.NewRecorder()
+	mw.HandleReport(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 for a report, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mw.HandleReport(rec, httptest.NewRequest("GET", "/csp-report", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", rec.Code)
+	}
+}
+
+func TestRenderPageAddsScriptNonce(t *testing.T) {
+	page := &models.Page{
+		Title:       "Nonce Test",
+		MainContent: "<p>Content</p>",
+		Scripts:     `<script>console.log(1)</script><script src="/assets/stingray.js" nonce="kept"></script>`,
+		Template:    "modern",
+	}
+	html, err := templates.RenderPage(page, "abc123")
+	if err != nil {
+		t.Fatalf("RenderPage failed: %v", err)
+	}
+	if !strings.Contains(html, `<script nonce="abc123">console.log(1)</script>`) {
+		t.Error("Expected the inline page script to carry the nonce")
+	}
+	if !strings.Contains(html, `nonce="kept"`) || strings.Contains(html, `nonce="abc123" src=`) {
+		t.Error("Expected an existing nonce attribute to be left alone")
+	}
+
+	html, err = templates.RenderPage(page, "")
+	if err != nil {
+		t.Fatalf("RenderPage failed: %v", err)
+	}
+	if strings.Contains(html, `nonce="abc123"`) {
+		t.Error("Expected no nonce when the policy does not use one")
+	}
+}
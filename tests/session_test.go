@@ -1,10 +1,18 @@
 package tests
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 	"stingray/models"
 	"stingray/database"
+	"stingray/config"
+	"stingray/handlers"
+	"stingray/logging"
+	"stingray/sessions"
 )
 
 func TestSessionOperations(t *testing.T) {
@@ -78,4 +86,472 @@ func TestSessionIDGeneration(t *testing.T) {
 	if id1 == id2 {
 		t.Error("Expected different session IDs to be different")
 	}
-} 
\ No newline at end of file
+}
+
+func TestSessionPolicyLifetimes(t *testing.T) {
+	policy := handlers.SessionPolicyFromConfig(&config.Config{
+		SessionIdleTimeout:        30 * time.Minute,
+		SessionAbsoluteLifetime:   8 * time.Hour,
+		SessionRememberMeLifetime: 14 * 24 * time.Hour,
+		SessionRenewInterval:      time.Minute,
+	})
+
+	idle, absolute := policy.Lifetimes(false)
+	if idle != 30*time.Minute || absolute != 8*time.Hour {
+		t.Errorf("Expected 30m idle and 8h absolute lifetime, got %v and %v", idle, absolute)
+	}
+
+	idle, absolute = policy.Lifetimes(true)
+	if idle != 14*24*time.Hour || absolute != 14*24*time.Hour {
+		t.Errorf("Expected remember-me sessions to last 336h, got %v and %v", idle, absolute)
+	}
+}
+
+func TestCookieSessionStore(t *testing.T) {
+	store, err := sessions.NewCookieStore(nil, "test-secret")
+	if err != nil {
+		t.Fatalf("Error creating cookie store: %v", err)
+	}
+
+	session := &models.Session{
+		ID:                7,
+		SessionID:         "abc123",
+		UserID:            1,
+		Username:          "admin",
+		CreatedAt:         time.Now(),
+		ExpiresAt:         time.Now().Add(time.Hour),
+		AbsoluteExpiresAt: time.Now().Add(24 * time.Hour),
+		RememberMe:        true,
+	}
+
+	token, err := store.Token(session)
+	if err != nil {
+		t.Fatalf("Error sealing session: %v", err)
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		loaded, err := store.Get(token)
+		if err != nil {
+			t.Fatalf("Expected sealed session to load, got %v", err)
+		}
+		if loaded.SessionID != "abc123" || loaded.UserID != 1 || loaded.Username != "admin" || !loaded.RememberMe {
+			t.Errorf("Loaded session does not match: %+v", loaded)
+		}
+	})
+
+	t.Run("KeepsImpersonator", func(t *testing.T) {
+		impersonated := *session
+		impersonated.ImpersonatorID = 9
+		sealed, err := store.Token(&impersonated)
+		if err != nil {
+			t.Fatalf("Error sealing session: %v", err)
+		}
+		loaded, err := store.Get(sealed)
+		if err != nil {
+			t.Fatalf("Expected sealed session to load, got %v", err)
+		}
+		if !loaded.IsImpersonated() || loaded.ImpersonatorID != 9 {
+			t.Errorf("Expected impersonator 9, got %d", loaded.ImpersonatorID)
+		}
+	})
+
+	t.Run("RejectsTamperedCookie", func(t *testing.T) {
+		tampered := []byte(token)
+		tampered[len(tampered)/2] ^= 1
+		if _, err := store.Get(string(tampered)); err == nil {
+			t.Error("Expected tampered cookie to be rejected")
+		}
+	})
+
+	t.Run("RejectsOtherSecret", func(t *testing.T) {
+		other, _ := sessions.NewCookieStore(nil, "another-secret")
+		if _, err := other.Get(token); err == nil {
+			t.Error("Expected cookie sealed with a different secret to be rejected")
+		}
+	})
+
+	t.Run("RejectsExpiredSession", func(t *testing.T) {
+		session.ExpiresAt = time.Now().Add(-time.Minute)
+		expired, _ := store.Token(session)
+		if _, err := store.Get(expired); err == nil {
+			t.Error("Expected expired cookie session to be rejected")
+		}
+	})
+}
+
+func TestImpersonationBanner(t *testing.T) {
+	html := []byte(`<html><head><title>Home</title></head><body class="page"><h1>Home</h1></body></html>`)
+	withBanner := string(handlers.InjectImpersonationBanner(html, "<customer>"))
+
+	bodyAt := strings.Index(withBanner, `<body class="page">`)
+	bannerAt := strings.Index(withBanner, `class="impersonation-banner"`)
+	if bodyAt < 0 || bannerAt < bodyAt || bannerAt > strings.Index(withBanner, "<h1>") {
+		t.Fatalf("Expected the banner right after the body tag, got %s", withBanner)
+	}
+	if !strings.Contains(withBanner, "&lt;customer&gt;") || strings.Contains(withBanner, "<customer>") {
+		t.Error("Expected the username to be escaped")
+	}
+	if !strings.Contains(withBanner, `action="/user/impersonation/stop"`) {
+		t.Error("Expected a return to my account form")
+	}
+
+	fragment := []byte(`<p>No body tag</p>`)
+	if got := handlers.InjectImpersonationBanner(fragment, "customer"); string(got) != string(fragment) {
+		t.Errorf("Expected HTML without a body tag to be unchanged, got %s", got)
+	}
+}
+
+func TestIdentifyStripsIdentityHeaders(t *testing.T) {
+	sm := handlers.NewSessionMiddleware(nil, nil, handlers.DefaultSessionPolicy(), false)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-User-ID", "1")
+	req.Header.Set("X-Username", "admin")
+
+	var principal *handlers.Principal
+	var spoofed string
+	sm.Identify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal = sm.Principal(r)
+		spoofed = r.Header.Get("X-User-ID") + r.Header.Get("X-Username")
+	})).ServeHTTP(httptest.NewRecorder(), req)
+
+	if principal.Authenticated() || principal.UserID() != 0 || principal.Username() != "" {
+		t.Errorf("Expected an anonymous principal, got %+v", principal)
+	}
+	if spoofed != "" {
+		t.Errorf("Expected inbound identity headers to be removed, got %q", spoofed)
+	}
+}
+
+func TestIdentifyPrincipal(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer cleanupTestDatabase(t, db)
+	defer db.Close()
+
+	admin, err := db.AuthenticateUser("admin", "admin123")
+	if err != nil {
+		t.Fatalf("Failed to authenticate admin user: %v", err)
+	}
+	session, err := db.CreateSession(admin.ID, admin.Username, 1*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	sm := newTestSessionMiddleware(db)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: handlers.SessionCookieName, Value: session.SessionID})
+	req.Header.Set("X-Username", "customer")
+
+	var principal *handlers.Principal
+	var access *database.Access
+	sm.Identify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal = sm.Principal(r)
+		access, err = sm.Access(r)
+	})).ServeHTTP(httptest.NewRecorder(), req)
+
+	if !principal.Authenticated() || principal.UserID() != admin.ID || principal.Username() != "admin" {
+		t.Fatalf("Expected the admin principal, got %+v", principal)
+	}
+	if principal.User == nil || principal.User.Email != admin.Email {
+		t.Errorf("Expected the principal to carry the admin user, got %+v", principal.User)
+	}
+	if err != nil || !access.InGroup("admin") || access.InGroup("customers") {
+		t.Errorf("Unexpected groups for admin: %+v, %v", principal.Groups, err)
+	}
+
+	// Behind Refresh the session is looked up once, and asset requests skip the user
+	for path, wantUser := range map[string]bool{"/": true, handlers.SiteScriptPath: false} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.AddCookie(&http.Cookie{Name: handlers.SessionCookieName, Value: session.SessionID})
+		var principal *handlers.Principal
+		sm.Refresh(sm.Identify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal = sm.Principal(r)
+		}))).ServeHTTP(httptest.NewRecorder(), req)
+		if principal.UserID() != admin.ID || (principal.User != nil) != wantUser {
+			t.Errorf("Unexpected principal for %s: %+v", path, principal)
+		}
+	}
+}
+
+func TestPasswordChangeRevokesCachedSessions(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer cleanupTestDatabase(t, db)
+	defer db.Close()
+
+	admin, err := db.AuthenticateUser("admin", "admin123")
+	if err != nil {
+		t.Fatalf("Failed to authenticate admin user: %v", err)
+	}
+
+	// Cached entries outlive the test, so only an explicit revocation can end them
+	store := sessions.NewMemoryStore(db, 100, time.Hour)
+	sm := handlers.NewSessionMiddleware(db, store, handlers.DefaultSessionPolicy(), false)
+	current, err := store.Create(admin.ID, admin.Username, time.Hour, time.Hour, false, "", "")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	other, err := store.Create(admin.ID, admin.Username, time.Hour, time.Hour, false, "", "")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	form := strings.NewReader("current_password=admin123&password=Changed-Password-42&confirm_password=Changed-Password-42")
+	req := httptest.NewRequest("POST", "/user/password", form)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: handlers.SessionCookieName, Value: current.SessionID})
+	rec := httptest.NewRecorder()
+	handlers.NewAuthHandler(db, sm, logging.NewLogger(logging.LevelErrors)).HandleChangePassword(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the password change to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if _, err := store.Get(other.SessionID); err == nil {
+		t.Error("Expected the other session to be revoked")
+	}
+	if _, err := store.Get(current.SessionID); err == nil {
+		t.Error("Expected the session that changed the password to be replaced")
+	}
+	var renewed *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == handlers.SessionCookieName && cookie.Value != "" {
+			renewed = cookie
+		}
+	}
+	if renewed == nil {
+		t.Fatal("Expected a new session cookie for the browser that changed the password")
+	}
+	if _, err := store.Get(renewed.Value); err != nil {
+		t.Errorf("Expected the new session to be usable, got %v", err)
+	}
+}
+
+func TestImpersonation(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer cleanupTestDatabase(t, db)
+	defer db.Close()
+	db.GetDB().Exec("DELETE FROM _audit_log")
+
+	admin, err := db.AuthenticateUser("admin", "admin123")
+	if err != nil {
+		t.Fatalf("Failed to authenticate admin user: %v", err)
+	}
+	customer, err := db.AuthenticateUser("customer", "customer123")
+	if err != nil {
+		t.Fatalf("Failed to authenticate customer user: %v", err)
+	}
+	otherAdminID, err := db.CreateUserWithGroups("otheradmin", "otheradmin@example.com", "otheradmin123", []string{"admin"})
+	if err != nil {
+		t.Fatalf("Failed to create second administrator: %v", err)
+	}
+
+	sm := newTestSessionMiddleware(db)
+	h := handlers.NewImpersonationHandler(db, sm, logging.NewLogger(logging.LevelErrors))
+	post := func(handler http.HandlerFunc, path, sessionID, form string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: handlers.SessionCookieName, Value: sessionID})
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+	sessionCookie := func(rec *httptest.ResponseRecorder) string {
+		for _, cookie := range rec.Result().Cookies() {
+			if cookie.Name == handlers.SessionCookieName && cookie.Value != "" {
+				return cookie.Value
+			}
+		}
+		return ""
+	}
+	adminSession, err := db.CreateSession(admin.ID, admin.Username, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	// Holders of users.manage and the administrator themselves cannot be impersonated
+	if rec := post(h.HandleStartImpersonation, "/admin/impersonate/start", adminSession.SessionID, "user_id="+strconv.Itoa(otherAdminID)); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 when impersonating an administrator, got %d", rec.Code)
+	}
+	if rec := post(h.HandleStartImpersonation, "/admin/impersonate/start", adminSession.SessionID, "user_id="+strconv.Itoa(admin.ID)); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 when impersonating yourself, got %d", rec.Code)
+	}
+
+	// Starting replaces the administrator's session with one as the customer, capped at an hour
+	rec := post(h.HandleStartImpersonation, "/admin/impersonate/start", adminSession.SessionID, "user_id="+strconv.Itoa(customer.ID))
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected impersonation to start, got %d: %s", rec.Code, rec.Body.String())
+	}
+	impersonationID := sessionCookie(rec)
+	impersonation, err := db.GetSession(impersonationID)
+	if err != nil {
+		t.Fatalf("Expected an impersonation session, got %v", err)
+	}
+	if impersonation.UserID != customer.ID || impersonation.ImpersonatorID != admin.ID {
+		t.Errorf("Expected a session as customer marked with the administrator, got %+v", impersonation)
+	}
+	if limit := time.Now().Add(handlers.ImpersonationDuration + time.Minute); impersonation.AbsoluteExpiresAt.After(limit) || impersonation.ExpiresAt.After(limit) {
+		t.Errorf("Expected the session to end within %v, got %v", handlers.ImpersonationDuration, impersonation.AbsoluteExpiresAt)
+	}
+	if _, err := db.GetSession(adminSession.SessionID); err == nil {
+		t.Error("Expected the administrator's own session to end")
+	}
+	if rec := post(h.HandleStartImpersonation, "/admin/impersonate/start", impersonationID, "user_id="+strconv.Itoa(customer.ID)); rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 when impersonating from an impersonation session, got %d", rec.Code)
+	}
+
+	// Blocked routes refuse changes from the impersonation session but still serve pages
+	reached := false
+	blocked := sm.BlockWhileImpersonating(func(w http.ResponseWriter, r *http.Request) { reached = true })
+	if rec := post(blocked, "/user/password", impersonationID, "password=x"); rec.Code != http.StatusForbidden || reached {
+		t.Errorf("Expected a change while impersonating to be refused, got %d (reached=%v)", rec.Code, reached)
+	}
+	req := httptest.NewRequest("GET", "/user/profile", nil)
+	req.AddCookie(&http.Cookie{Name: handlers.SessionCookieName, Value: impersonationID})
+	blocked(httptest.NewRecorder(), req)
+	if !reached {
+		t.Error("Expected reads to pass while impersonating")
+	}
+	customerSession, err := db.CreateSession(customer.ID, customer.Username, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	reached = false
+	if post(blocked, "/user/password", customerSession.SessionID, "password=x"); !reached {
+		t.Error("Expected the customer's own session to pass")
+	}
+
+	// Stopping ends the impersonation and signs the administrator back in
+	rec = post(h.HandleStopImpersonation, "/user/impersonation/stop", impersonationID, "")
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/admin/impersonate" {
+		t.Fatalf("Expected a redirect back to the user list, got %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if _, err := db.GetSession(impersonationID); err == nil {
+		t.Error("Expected the impersonation session to end")
+	}
+	if restored, err := db.GetSession(sessionCookie(rec)); err != nil || restored.UserID != admin.ID || restored.IsImpersonated() {
+		t.Errorf("Expected a plain administrator session, got %+v, %v", restored, err)
+	}
+
+	// Both events are in the audit log with the administrator as actor
+	for _, eventType := range []string{models.AuditImpersonationStart, models.AuditImpersonationStop} {
+		events, total, err := db.GetAuditEvents(models.AuditFilter{EventType: eventType}, 10, 0)
+		if err != nil {
+			t.Fatalf("Failed to get audit events: %v", err)
+		}
+		if total != 1 || events[0].ActorID != admin.ID || events[0].TargetUserID != customer.ID {
+			t.Errorf("Expected one %s event by admin for customer, got %d: %+v", eventType, total, events)
+		}
+	}
+
+	// An impersonation session stops working once its hour is up
+	adminSession, err = db.CreateSession(admin.ID, admin.Username, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	impersonationID = sessionCookie(post(h.HandleStartImpersonation, "/admin/impersonate/start", adminSession.SessionID, "user_id="+strconv.Itoa(customer.ID)))
+	db.GetDB().Exec("UPDATE _session SET created = ?, expires_at = ?, absolute_expires_at = ? WHERE session_id = ?",
+		time.Now().Add(-time.Hour-time.Minute), time.Now().Add(-time.Minute), time.Now().Add(-time.Minute), impersonationID)
+	if _, err := db.GetSession(impersonationID); err == nil {
+		t.Error("Expected the impersonation session to expire after an hour")
+	}
+	if rec := post(h.HandleStopImpersonation, "/user/impersonation/stop", impersonationID, ""); rec.Header().Get("Location") != "/user/login" {
+		t.Errorf("Expected an expired impersonation to need a new login, got %s", rec.Header().Get("Location"))
+	}
+}
+
+func TestUserSessions(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer cleanupTestDatabase(t, db)
+	defer db.Close()
+
+	admin, err := db.AuthenticateUser("admin", "admin123")
+	if err != nil {
+		t.Fatalf("Failed to authenticate admin user: %v", err)
+	}
+	customer, err := db.AuthenticateUser("customer", "customer123")
+	if err != nil {
+		t.Fatalf("Failed to authenticate customer user: %v", err)
+	}
+
+	newSession := func(user *models.User) *models.Session {
+		session, err := db.CreateSession(user.ID, user.Username, time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		return session
+	}
+	current, laptop, phone := newSession(admin), newSession(admin), newSession(admin)
+	customerSession := newSession(customer)
+
+	sm := newTestSessionMiddleware(db)
+	authHandler := handlers.NewAuthHandler(db, sm, logging.NewLogger(logging.LevelErrors))
+	post := func(handler http.HandlerFunc, path, form string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: handlers.SessionCookieName, Value: current.SessionID})
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+	active := func(sessionID string) bool {
+		_, err := db.GetSession(sessionID)
+		return err == nil
+	}
+
+	// Users only see their own sessions
+	sessions, err := db.GetActiveUserSessions(admin.ID)
+	if err != nil {
+		t.Fatalf("Failed to list sessions: %v", err)
+	}
+	if len(sessions) != 3 {
+		t.Fatalf("Expected the admin's 3 sessions, got %d", len(sessions))
+	}
+	for _, session := range sessions {
+		if session.UserID != admin.ID {
+			t.Errorf("Expected only the admin's sessions, got one for user %d", session.UserID)
+		}
+	}
+
+	// Revoking one session leaves the others signed in
+	if rec := post(authHandler.HandleRevokeSession, "/user/sessions/revoke", "session="+strconv.Itoa(laptop.ID)); rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the session to be revoked, got %d", rec.Code)
+	}
+	if active(laptop.SessionID) || !active(current.SessionID) || !active(phone.SessionID) {
+		t.Error("Expected only the revoked session to end")
+	}
+
+	// Another user's session cannot be revoked
+	if rec := post(authHandler.HandleRevokeSession, "/user/sessions/revoke", "session="+strconv.Itoa(customerSession.ID)); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another user's session, got %d", rec.Code)
+	}
+	if !active(customerSession.SessionID) {
+		t.Error("Expected the customer's session to stay active")
+	}
+
+	// Revoking every other session keeps only the current one
+	if rec := post(authHandler.HandleRevokeSession, "/user/sessions/revoke", "session="+strconv.Itoa(phone.ID)); rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the session to be revoked, got %d", rec.Code)
+	}
+	if sessions, err := db.GetActiveUserSessions(admin.ID); err != nil || len(sessions) != 1 || sessions[0].SessionID != current.SessionID {
+		t.Errorf("Expected only the current session to remain, got %+v, %v", sessions, err)
+	}
+
+	// Logging out everywhere ends the current session too, and no one else's
+	newSession(admin)
+	rec := post(authHandler.HandleRevokeAllSessions, "/user/sessions/revoke-all", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected every session to be revoked, got %d", rec.Code)
+	}
+	if sessions, err := db.GetActiveUserSessions(admin.ID); err != nil || len(sessions) != 0 {
+		t.Errorf("Expected no admin sessions to remain, got %+v, %v", sessions, err)
+	}
+	if !active(customerSession.SessionID) {
+		t.Error("Expected the customer's session to stay active")
+	}
+	cleared := false
+	for _, cookie := range rec.Result().Cookies() {
+		cleared = cleared || (cookie.Name == handlers.SessionCookieName && cookie.MaxAge < 0)
+	}
+	if !cleared {
+		t.Error("Expected the session cookie to be cleared")
+	}
+}
@@ -1,6 +1,8 @@
 package tests
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"stingray/templates"
@@ -67,4 +69,60 @@ func TestProcessEmbeddedTemplatesRecursive(t *testing.T) {
 	if strings.Contains(processed, "{{template_login_form}}") {
 		t.Error("Template references were not fully processed")
 	}
-} 
\ No newline at end of file
+}
+
+func TestTemplateSet(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"layout":  `<main>{{block "content" .}}default {{.Title}}{{end}}</main>{{template "footer" .}}`,
+		"footer":  `<footer>{{.Footer}}</footer>`,
+		"wide":    `{{define "content"}}wide {{.Title}}{{end}}{{template "layout" .}}`,
+		"legacy":  `<div>{{template_footer}}</div>`,
+		"missing": `{{template "nowhere" .}}`,
+		"loop_a":  `{{if .Title}}{{template "loop_b" .}}{{end}}`,
+		"loop_b":  `{{template "loop_a" .}}`,
+		"escaped": `<p title="{{.Title}}">{{.Title}}</p>`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	set := templates.NewSet(dir)
+	data := map[string]interface{}{"Title": "<Home>", "Footer": "Bye"}
+
+	for name, want := range map[string]string{
+		"layout":  "<main>default &lt;Home&gt;</main><footer>Bye</footer>",
+		"wide":    "<main>wide &lt;Home&gt;</main><footer>Bye</footer>",
+		"legacy":  "<div><footer>Bye</footer></div>",
+		"escaped": `<p title="&lt;Home&gt;">&lt;Home&gt;</p>`,
+	} {
+		got, err := set.Execute(name, data)
+		if err != nil || got != want {
+			t.Errorf("Execute(%s) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+
+	// Overriding a block for one template leaves the layout's default for the others
+	if got, _ := set.Execute("layout", data); !strings.Contains(got, "default") {
+		t.Errorf("Expected the layout's own block, got %q", got)
+	}
+
+	if _, err := set.Parse("missing"); err == nil || !strings.Contains(err.Error(), `"nowhere"`) {
+		t.Errorf("Expected the missing template to be reported, got %v", err)
+	}
+	if _, err := set.Parse("loop_a"); err == nil || !strings.Contains(err.Error(), "loop_a -> loop_b -> loop_a") {
+		t.Errorf("Expected the cycle to be reported, got %v", err)
+	}
+	if _, err := set.Parse("../layout"); err == nil {
+		t.Error("Expected template names outside the directory to be rejected")
+	}
+
+	// Content is not a template; only its legacy references are expanded, with data
+	expanded, err := set.ExpandReferences("<p>{{.Title}}</p>{{template_footer}}", data)
+	if err != nil || expanded != "<p>{{.Title}}</p><footer>Bye</footer>" {
+		t.Errorf("ExpandReferences = %q, %v", expanded, err)
+	}
+	if _, err := set.ExpandReferences("{{template_nowhere}}", data); err == nil {
+		t.Error("Expected a reference to a missing template to fail")
+	}
+}
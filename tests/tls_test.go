@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"stingray/certs"
+	"stingray/config"
+	"stingray/handlers"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedCert writes a certificate and key for commonName to dir
+func writeSelfSignedCert(t *testing.T, dir, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+func commonName(t *testing.T, reloader *certs.Reloader) string {
+	cert, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return parsed.Subject.CommonName
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSignedCert(t, dir, "first")
+
+	reloader, err := certs.NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Failed to load certificate: %v", err)
+	}
+	if got := commonName(t, reloader); got != "first" {
+		t.Errorf("Expected the first certificate, got %q", got)
+	}
+
+	writeSelfSignedCert(t, dir, "renewed")
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("Failed to reload certificate: %v", err)
+	}
+	if got := commonName(t, reloader); got != "renewed" {
+		t.Errorf("Expected the renewed certificate after reload, got %q", got)
+	}
+
+	// A broken file keeps the previous certificate in use
+	os.WriteFile(certFile, []byte("not a certificate"), 0600)
+	if err := reloader.Reload(); err == nil {
+		t.Error("Expected reloading a broken certificate to fail")
+	}
+	if got := commonName(t, reloader); got != "renewed" {
+		t.Errorf("Expected the renewed certificate to stay in use, got %q", got)
+	}
+
+	if _, err := certs.NewReloader(filepath.Join(dir, "missing.pem"), keyFile); err == nil {
+		t.Error("Expected a missing certificate to be rejected")
+	}
+}
+
+func TestHSTSAndRedirect(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	rec := httptest.NewRecorder()
+	handlers.HSTS(24*time.Hour, true, ok).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "max-age=86400; includeSubDomains" {
+		t.Errorf("Unexpected HSTS header %q", got)
+	}
+
+	redirects := []struct {
+		port      string
+		publicURL string
+		target    string
+	}{
+		{"443", "", "https://example.com/page/about?x=1"},
+		{"8443", "http://localhost:8443", "https://example.com:8443/page/about?x=1"},
+		{"8443", "https://www.example.org/", "https://www.example.org/page/about?x=1"},
+	}
+	for _, tt := range redirects {
+		req := httptest.NewRequest("GET", "http://example.com:8080/page/about?x=1", nil)
+		rec := httptest.NewRecorder()
+		handlers.RedirectToHTTPS(tt.port, tt.publicURL).ServeHTTP(rec, req)
+		if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != tt.target {
+			t.Errorf("Port %s: expected 301 to %s, got %d to %s", tt.port, tt.target, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
+
+func TestSecureCookiesFollowServingMode(t *testing.T) {
+	tests := []struct {
+		cfg    config.Config
+		secure bool
+	}{
+		{config.Config{PublicBaseURL: "http://localhost:8080"}, false},
+		{config.Config{PublicBaseURL: "https://www.example.com"}, true},
+		{config.Config{TLSCertFile: "cert.pem", TLSKeyFile: "key.pem"}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.SecureCookies(); got != tt.secure {
+			t.Errorf("SecureCookies() = %v for %+v, want %v", got, tt.cfg, tt.secure)
+		}
+	}
+}
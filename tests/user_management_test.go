@@ -2,19 +2,24 @@ package tests
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+	"stingray/config"
 	"stingray/database"
 	"stingray/handlers"
 	"stingray/logging"
+	"stingray/models"
 )
 
-func setupTestDatabase(t *testing.T) *database.Database {
+func setupTestDatabase(t testing.TB) *database.Database {
 	// Use a test database
 	dsn := "root:password@tcp(localhost:3306)/stingray_test?parseTime=true"
 	db, err := database.NewDatabase(dsn, false)
@@ -24,13 +29,20 @@ func setupTestDatabase(t *testing.T) *database.Database {
 	return db
 }
 
-func cleanupTestDatabase(t *testing.T, db *database.Database) {
+func cleanupTestDatabase(t testing.TB, db *database.Database) {
 	// Clean up test data
 	db.GetDB().Exec("DELETE FROM _user_and_group")
 	db.GetDB().Exec("DELETE FROM _session")
 	db.GetDB().Exec("DELETE FROM _user")
 	db.GetDB().Exec("DELETE FROM _group")
 	db.GetDB().Exec("DELETE FROM _page")
+	db.GetDB().Exec("DELETE FROM _audit_log")
+	db.GetDB().Exec("DELETE FROM _invitation")
+}
+
+// newTestSessionMiddleware returns session middleware keeping sessions in the test database
+func newTestSessionMiddleware(db *database.Database) *handlers.SessionMiddleware {
+	return handlers.NewSessionMiddleware(db, nil, handlers.DefaultSessionPolicy(), false)
 }
 
 func TestUserAuthentication(t *testing.T) {
@@ -200,7 +212,7 @@ func TestLoginHandler(t *testing.T) {
 	defer db.Close()
 
 	logger := logging.NewLogger(logging.LevelVerbose)
-	authHandler := handlers.NewAuthHandler(db, logger)
+	authHandler := handlers.NewAuthHandler(db, newTestSessionMiddleware(db), logger)
 
 	// Get admin password from environment
 	adminPassword := os.Getenv("TEST_ADMIN_PASSWORD")
@@ -247,7 +259,7 @@ func TestRoleMiddleware(t *testing.T) {
 	defer cleanupTestDatabase(t, db)
 	defer db.Close()
 
-	roleMW := handlers.NewRoleMiddleware(db)
+	roleMW := handlers.NewRoleMiddleware(db, newTestSessionMiddleware(db))
 
 	// Test admin access to admin-only page
 	admin, err := db.AuthenticateUser("admin", "admin123")
@@ -323,7 +335,7 @@ func TestAPIEndpoints(t *testing.T) {
 	defer cleanupTestDatabase(t, db)
 	defer db.Close()
 
-	apiHandler := handlers.NewAPIHandler(db)
+	apiHandler := handlers.NewAPIHandler(db, newTestSessionMiddleware(db), config.LoadConfig())
 
 	// Test getting users (admin only)
 	admin, err := db.AuthenticateUser("admin", "admin123")
@@ -445,4 +457,752 @@ func TestDatabaseOperations(t *testing.T) {
 	if retrievedUser.Username != admin.Username {
 		t.Errorf("Expected username '%s', got '%s'", admin.Username, retrievedUser.Username)
 	}
-} 
\ No newline at end of file
+}
+
+func TestInvitationIsPending(t *testing.T) {
+	tests := []struct {
+		name       string
+		invitation models.Invitation
+		pending    bool
+	}{
+		{"Open", models.Invitation{ExpiresAt: time.Now().Add(time.Hour)}, true},
+		{"Expired", models.Invitation{ExpiresAt: time.Now().Add(-time.Hour)}, false},
+		{"Accepted", models.Invitation{ExpiresAt: time.Now().Add(time.Hour), Accepted: true}, false},
+		{"Revoked", models.Invitation{ExpiresAt: time.Now().Add(time.Hour), Revoked: true}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.invitation.IsPending(); got != tt.pending {
+				t.Errorf("Expected IsPending() = %v, got %v", tt.pending, got)
+			}
+		})
+	}
+}
+
+func TestInvitations(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer cleanupTestDatabase(t, db)
+	defer db.Close()
+
+	admin, err := db.AuthenticateUser("admin", "admin123")
+	if err != nil {
+		t.Fatalf("Failed to authenticate admin user: %v", err)
+	}
+
+	t.Run("CreateAndAccept", func(t *testing.T) {
+		token := "invite-create-and-accept"
+		id, err := db.CreateInvitation("invitee@example.com", []string{"customers"}, admin.ID, token, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("Failed to create invitation: %v", err)
+		}
+
+		var stored int
+		if err := db.GetDB().QueryRow("SELECT COUNT(*) FROM _invitation WHERE token = ?", token).Scan(&stored); err != nil || stored != 0 {
+			t.Errorf("Expected only a hash of the token to be stored, got %d rows, %v", stored, err)
+		}
+		invitation, err := db.GetInvitationByToken(token)
+		if err != nil || invitation.ID != id || !invitation.IsPending() || invitation.InvitedByName != "admin" {
+			t.Fatalf("Expected the open invitation, got %+v, %v", invitation, err)
+		}
+
+		userID, err := db.AcceptInvitation(token, "invitee", "Invitee-Pass-1")
+		if err != nil {
+			t.Fatalf("Failed to accept invitation: %v", err)
+		}
+		groups, err := db.GetUserGroups(userID)
+		if err != nil || len(groups) != 1 || groups[0].Name != "customers" {
+			t.Errorf("Expected the invitee to be in customers only, got %+v, %v", groups, err)
+		}
+		if _, err := db.AuthenticateUser("invitee", "Invitee-Pass-1"); err != nil {
+			t.Errorf("Expected the invitee to log in, got %v", err)
+		}
+
+		invitation, err = db.GetInvitationByToken(token)
+		if err != nil || !invitation.Accepted || invitation.AcceptedUserID != userID {
+			t.Errorf("Expected the invitation to record its account, got %+v, %v", invitation, err)
+		}
+		if _, err := db.AcceptInvitation(token, "second", "Second-Pass-1"); err != database.ErrInvitationInvalid {
+			t.Errorf("Expected a used invitation to be refused, got %v", err)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		token := "invite-expired"
+		if _, err := db.CreateInvitation("late@example.com", []string{"customers"}, admin.ID, token, time.Now().Add(-time.Minute)); err != nil {
+			t.Fatalf("Failed to create invitation: %v", err)
+		}
+		if _, err := db.AcceptInvitation(token, "late", "Late-Pass-1"); err != database.ErrInvitationInvalid {
+			t.Errorf("Expected an expired invitation to be refused, got %v", err)
+		}
+		if exists, err := db.UserExists("late", "late@example.com"); err != nil || exists {
+			t.Errorf("Expected no account for an expired invitation, got exists=%v err=%v", exists, err)
+		}
+	})
+
+	t.Run("Renewed", func(t *testing.T) {
+		id, err := db.CreateInvitation("renewed@example.com", []string{"customers"}, admin.ID, "invite-old", time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("Failed to create invitation: %v", err)
+		}
+		if err := db.RenewInvitation(id, "invite-new", time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("Failed to renew invitation: %v", err)
+		}
+		if _, err := db.GetInvitationByToken("invite-old"); err == nil {
+			t.Error("Expected the previous link to stop working")
+		}
+		if invitation, err := db.GetInvitationByToken("invite-new"); err != nil || invitation.ID != id {
+			t.Errorf("Expected the new link to find the invitation, got %+v, %v", invitation, err)
+		}
+	})
+
+	t.Run("DeletedGroup", func(t *testing.T) {
+		groupID, err := db.CreateGroup("short-lived", "Deleted before the invitation is accepted")
+		if err != nil {
+			t.Fatalf("Failed to create group: %v", err)
+		}
+		token := "invite-deleted-group"
+		if _, err := db.CreateInvitation("orphan@example.com", []string{"customers", "short-lived"}, admin.ID, token, time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("Failed to create invitation: %v", err)
+		}
+		if err := db.DeleteGroup(groupID); err != nil {
+			t.Fatalf("Failed to delete group: %v", err)
+		}
+
+		if _, err := db.AcceptInvitation(token, "orphan", "Orphan-Pass-1"); !errors.Is(err, database.ErrGroupMissing) {
+			t.Fatalf("Expected ErrGroupMissing, got %v", err)
+		}
+		if exists, err := db.UserExists("orphan", "orphan@example.com"); err != nil || exists {
+			t.Errorf("Expected no account to be created, got exists=%v err=%v", exists, err)
+		}
+		if invitation, err := db.GetInvitationByToken(token); err != nil || !invitation.IsPending() {
+			t.Errorf("Expected the invitation to stay open, got %+v, %v", invitation, err)
+		}
+	})
+
+	t.Run("Conflicts", func(t *testing.T) {
+		invitations := handlers.NewInvitationHandler(db, newTestSessionMiddleware(db), &config.Config{}, logging.NewLogger(logging.LevelErrors))
+		accept := func(token, username string) *httptest.ResponseRecorder {
+			form := url.Values{"token": {token}, "username": {username}, "password": {"Invitee-Pass-1"}, "confirm_password": {"Invitee-Pass-1"}}
+			req := httptest.NewRequest("POST", "/user/accept-invite", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			invitations.HandleAcceptInvitation(rec, req)
+			return rec
+		}
+
+		// A taken username can be fixed on the form
+		if _, err := db.CreateInvitation("newcomer@example.com", []string{"customers"}, admin.ID, "invite-taken-username", time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("Failed to create invitation: %v", err)
+		}
+		if rec := accept("invite-taken-username", "customer"); rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "username is already taken") {
+			t.Errorf("Expected the taken username to be reported, got %d", rec.Code)
+		}
+
+		// A taken email address means the invitee already has an account
+		if _, err := db.CreateInvitation(admin.Email, []string{"customers"}, admin.ID, "invite-taken-email", time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("Failed to create invitation: %v", err)
+		}
+		rec := accept("invite-taken-email", "brand-new-name")
+		if body := rec.Body.String(); rec.Code != http.StatusConflict || strings.Contains(body, "username is already taken") || !strings.Contains(body, "reset its password") {
+			t.Errorf("Expected the existing account to be reported, got %d", rec.Code)
+		}
+	})
+}
+
+func TestUserManagementAPI(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer cleanupTestDatabase(t, db)
+	defer db.Close()
+
+	apiHandler := handlers.NewAPIHandler(db, newTestSessionMiddleware(db), config.LoadConfig())
+
+	admin, err := db.AuthenticateUser("admin", "admin123")
+	if err != nil {
+		t.Fatalf("Failed to authenticate admin user: %v", err)
+	}
+	session, err := db.CreateSession(admin.ID, admin.Username, 1*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	call := func(handler http.HandlerFunc, method, path, body string) (int, handlers.APIResponse) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(&http.Cookie{Name: handlers.SessionCookieName, Value: session.SessionID})
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		var response handlers.APIResponse
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response for %s %s: %v", method, path, err)
+		}
+		return w.Code, response
+	}
+
+	// Create a user in the customers group
+	code, response := call(apiHandler.HandleUsers, "POST", "/api/users",
+		`{"username": "apiuser", "email": "apiuser@example.com", "password": "apiuser-password", "groups": ["customers"]}`)
+	if code != http.StatusCreated || !response.Success {
+		t.Fatalf("Expected user to be created, got %d: %s", code, response.Error)
+	}
+	created := response.Data.(map[string]interface{})
+	userPath := "/api/users/" + strconv.Itoa(int(created["id"].(float64)))
+
+	// Duplicate usernames are rejected
+	code, _ = call(apiHandler.HandleUsers, "POST", "/api/users",
+		`{"username": "apiuser", "email": "other@example.com", "password": "apiuser-password"}`)
+	if code != http.StatusConflict {
+		t.Errorf("Expected 409 for duplicate user, got %d", code)
+	}
+
+	// Update the email address
+	code, response = call(apiHandler.HandleUser, "PUT", userPath, `{"email": "renamed@example.com"}`)
+	if code != http.StatusOK || response.Data.(map[string]interface{})["email"] != "renamed@example.com" {
+		t.Errorf("Expected email to be updated, got %d: %v", code, response.Data)
+	}
+
+	// Taking another user's username or email is a conflict, not a server error
+	code, response = call(apiHandler.HandleUser, "PUT", userPath, `{"username": "admin"}`)
+	if code != http.StatusConflict || response.Success || !strings.Contains(response.Error, "username") {
+		t.Errorf("Expected 409 for a taken username, got %d: %s", code, response.Error)
+	}
+	code, response = call(apiHandler.HandleUser, "PUT", userPath, `{"email": "`+admin.Email+`"}`)
+	if code != http.StatusConflict || !strings.Contains(response.Error, "email") {
+		t.Errorf("Expected 409 for a taken email address, got %d: %s", code, response.Error)
+	}
+
+	// Callers without users.manage get JSON errors rather than the HTML error page
+	customer, err := db.AuthenticateUser("customer", "customer123")
+	if err != nil {
+		t.Fatalf("Failed to authenticate customer user: %v", err)
+	}
+	customerSession, err := db.CreateSession(customer.ID, customer.Username, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	for _, cookie := range []*http.Cookie{nil, {Name: handlers.SessionCookieName, Value: customerSession.SessionID}} {
+		req := httptest.NewRequest("GET", "/api/users", nil)
+		want := http.StatusUnauthorized
+		if cookie != nil {
+			req.AddCookie(cookie)
+			want = http.StatusForbidden
+		}
+		rec := httptest.NewRecorder()
+		apiHandler.HandleUsers(rec, req)
+		var denied handlers.APIResponse
+		if err := json.NewDecoder(rec.Body).Decode(&denied); err != nil || rec.Code != want || denied.Success {
+			t.Errorf("Expected a JSON %d, got %d (%v)", want, rec.Code, err)
+		}
+	}
+
+	// Add and remove group membership
+	code, _ = call(apiHandler.HandleUser, "POST", userPath+"/groups", `{"groups": ["engineer"]}`)
+	if code != http.StatusOK {
+		t.Errorf("Expected group to be added, got %d", code)
+	}
+	userID := int(created["id"].(float64))
+	if inGroup, _ := db.IsUserInGroup(userID, "engineer"); !inGroup {
+		t.Error("Expected user to be in the engineer group")
+	}
+	code, _ = call(apiHandler.HandleUser, "DELETE", userPath+"/groups/engineer", "")
+	if code != http.StatusOK {
+		t.Errorf("Expected group to be removed, got %d", code)
+	}
+	if inGroup, _ := db.IsUserInGroup(userID, "engineer"); inGroup {
+		t.Error("Expected user to be removed from the engineer group")
+	}
+
+	// Bulk membership reports unknown users without failing the rest
+	code, response = call(apiHandler.HandleBulkMembership, "POST", "/api/user-groups/bulk",
+		`{"action": "add", "user_ids": [`+strconv.Itoa(userID)+`, 999999], "groups": ["engineer"]}`)
+	if code != http.StatusOK || response.Success {
+		t.Errorf("Expected partial success, got %d success=%v", code, response.Success)
+	}
+	if inGroup, _ := db.IsUserInGroup(userID, "engineer"); !inGroup {
+		t.Error("Expected bulk add to put the user in the engineer group")
+	}
+
+	// Set a password
+	code, _ = call(apiHandler.HandleUser, "POST", userPath+"/password", `{"password": "brand-new-password"}`)
+	if code != http.StatusOK {
+		t.Errorf("Expected password to be set, got %d", code)
+	}
+	if _, err := db.AuthenticateUser("apiuser", "brand-new-password"); err != nil {
+		t.Errorf("Expected new password to work: %v", err)
+	}
+
+	// Admins cannot delete themselves, but can delete other users
+	code, _ = call(apiHandler.HandleUser, "DELETE", "/api/users/"+strconv.Itoa(admin.ID), "")
+	if code != http.StatusBadRequest {
+		t.Errorf("Expected 400 when deleting own account, got %d", code)
+	}
+	code, _ = call(apiHandler.HandleUser, "DELETE", userPath, "")
+	if code != http.StatusOK {
+		t.Errorf("Expected user to be deleted, got %d", code)
+	}
+	code, _ = call(apiHandler.HandleUser, "GET", userPath, "")
+	if code != http.StatusNotFound {
+		t.Errorf("Expected 404 for deleted user, got %d", code)
+	}
+
+	// Built-in groups cannot be deleted
+	groups, err := db.GetAllGroups()
+	if err != nil {
+		t.Fatalf("Failed to get groups: %v", err)
+	}
+	for _, group := range groups {
+		if group.Name == "admin" {
+			code, _ = call(apiHandler.HandleGroup, "DELETE", "/api/groups/"+strconv.Itoa(group.ID), "")
+			if code != http.StatusBadRequest {
+				t.Errorf("Expected 400 when deleting the admin group, got %d", code)
+			}
+		}
+		// Read and write groups refer to groups by name, so only descriptions change
+		if group.Name == "engineer" {
+			groupPath := "/api/groups/" + strconv.Itoa(group.ID)
+			if code, _ = call(apiHandler.HandleGroup, "PUT", groupPath, `{"name": "engineers"}`); code != http.StatusBadRequest {
+				t.Errorf("Expected 400 when renaming a group, got %d", code)
+			}
+			code, response = call(apiHandler.HandleGroup, "PUT", groupPath, `{"name": "engineer", "description": "Builders"}`)
+			if code != http.StatusOK {
+				t.Errorf("Expected the description to be updated, got %d: %s", code, response.Error)
+			}
+		}
+	}
+
+	// Admins cannot leave the last group that gives them users.manage, whatever it is called
+	adminPath := "/api/users/" + strconv.Itoa(admin.ID) + "/groups/"
+	code, _ = call(apiHandler.HandleUser, "DELETE", adminPath+"admin", "")
+	if code != http.StatusBadRequest {
+		t.Errorf("Expected 400 when leaving the only managing group, got %d", code)
+	}
+	if _, err := db.CreateGroup("operators", "Operators"); err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+	if err := db.GrantPermission("operators", models.PermissionUsersManage); err != nil {
+		t.Fatalf("Failed to grant permission: %v", err)
+	}
+	if err := db.AddUserToGroup(admin.ID, "operators"); err != nil {
+		t.Fatalf("Failed to add admin to operators: %v", err)
+	}
+	code, _ = call(apiHandler.HandleUser, "DELETE", adminPath+"admin", "")
+	if code != http.StatusOK {
+		t.Errorf("Expected leaving admin to succeed while operators grants users.manage, got %d", code)
+	}
+	code, response = call(apiHandler.HandleBulkMembership, "POST", "/api/user-groups/bulk",
+		`{"action": "remove", "user_ids": [`+strconv.Itoa(admin.ID)+`], "groups": ["operators"]}`)
+	if code != http.StatusBadRequest || response.Success {
+		t.Errorf("Expected bulk removal from the last managing group to be refused, got %d", code)
+	}
+	if inGroup, _ := db.IsUserInGroup(admin.ID, "operators"); !inGroup {
+		t.Error("Expected the admin to stay in operators")
+	}
+}
+
+func TestNestedGroups(t *testing.T) {
+	// engineer and admin are nested inside staff, and staff inside internal
+	parents := map[string][]string{
+		"engineer": {"staff"},
+		"admin":    {"staff"},
+		"staff":    {"internal"},
+	}
+
+	expanded := database.ExpandGroups([]string{"engineer"}, parents)
+	want := map[string]bool{"engineer": true, "staff": true, "internal": true}
+	if len(expanded) != len(want) {
+		t.Fatalf("Expected %d groups, got %v", len(want), expanded)
+	}
+	for _, group := range expanded {
+		if !want[group] {
+			t.Errorf("Unexpected group %q in %v", group, expanded)
+		}
+	}
+
+	if !database.IsNestedIn(parents, "admin", "internal") {
+		t.Error("Expected admin to be nested in internal through staff")
+	}
+	if database.IsNestedIn(parents, "staff", "admin") {
+		t.Error("Expected staff not to be nested in admin")
+	}
+
+	cycles := []struct {
+		parent, child string
+		cycle         bool
+	}{
+		{"engineer", "engineer", true},
+		{"engineer", "staff", true},
+		{"admin", "internal", true},
+		{"internal", "customers", false},
+		{"engineer", "admin", false},
+	}
+	for _, tt := range cycles {
+		if got := database.WouldCreateCycle(parents, tt.parent, tt.child); got != tt.cycle {
+			t.Errorf("WouldCreateCycle(%s, %s) = %v, want %v", tt.parent, tt.child, got, tt.cycle)
+		}
+	}
+
+	// A cycle that already exists in the data must not loop forever
+	parents["internal"] = []string{"engineer"}
+	if got := database.ExpandGroups([]string{"staff"}, parents); len(got) != 3 {
+		t.Errorf("Expected cyclic expansion to visit each group once, got %v", got)
+	}
+}
+
+func TestNestedGroupsInDatabase(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer cleanupTestDatabase(t, db)
+	defer db.Close()
+
+	customer, err := db.AuthenticateUser("customer", "customer123")
+	if err != nil {
+		t.Fatalf("Failed to authenticate customer user: %v", err)
+	}
+	for _, name := range []string{"staff", "internal"} {
+		if _, err := db.CreateGroup(name, "Nesting test group"); err != nil {
+			t.Fatalf("Failed to create group %s: %v", name, err)
+		}
+	}
+	inGroup := func(groupName string) bool {
+		t.Helper()
+		member, err := db.IsUserInGroup(customer.ID, groupName)
+		if err != nil {
+			t.Fatalf("Failed to check membership of %s: %v", groupName, err)
+		}
+		return member
+	}
+
+	// customers inside staff inside internal makes the customer a member of both
+	if err := db.AddGroupToGroup("staff", "customers"); err != nil {
+		t.Fatalf("Failed to nest customers in staff: %v", err)
+	}
+	if err := db.AddGroupToGroup("internal", "staff"); err != nil {
+		t.Fatalf("Failed to nest staff in internal: %v", err)
+	}
+	if !inGroup("staff") || !inGroup("internal") {
+		t.Error("Expected the customer to inherit staff and internal")
+	}
+
+	// Nesting a group in itself or in one of its own members is refused
+	for _, tt := range []struct{ parent, child string }{
+		{"staff", "staff"},
+		{"customers", "staff"},
+		{"customers", "internal"},
+	} {
+		if err := db.AddGroupToGroup(tt.parent, tt.child); err != database.ErrGroupCycle {
+			t.Errorf("Expected nesting %s in %s to be refused as a cycle, got %v", tt.child, tt.parent, err)
+		}
+	}
+	if err := db.AddGroupToGroup("everyone", "staff"); err == nil {
+		t.Error("Expected the everyone group not to be nested")
+	}
+
+	// Removing the link drops the inherited memberships
+	if err := db.RemoveGroupFromGroup("staff", "customers"); err != nil {
+		t.Fatalf("Failed to remove nesting: %v", err)
+	}
+	if inGroup("staff") || inGroup("internal") {
+		t.Error("Expected the customer to lose staff and internal")
+	}
+	if !inGroup("customers") {
+		t.Error("Expected the customer to keep their direct group")
+	}
+}
+
+func TestPermissionMatching(t *testing.T) {
+	matches := []struct {
+		granted, requested string
+		match              bool
+	}{
+		{"schema.edit", "schema.edit", true},
+		{"schema.edit", "config.view", false},
+		{"*", "users.manage", true},
+		{"table.*.write", "table.orders.write", true},
+		{"table.*.write", "table.orders.read", false},
+		{"table.*", "table.orders.write", false},
+		{"config.*", "config.edit", true},
+	}
+	for _, tt := range matches {
+		if got := models.PermissionMatches(tt.granted, tt.requested); got != tt.match {
+			t.Errorf("PermissionMatches(%q, %q) = %v, want %v", tt.granted, tt.requested, got, tt.match)
+		}
+	}
+
+	tableName, action, ok := models.ParseTablePermission(models.TablePermission("orders", "write"))
+	if !ok || tableName != "orders" || action != "write" {
+		t.Errorf("Expected orders/write, got %q/%q (ok=%v)", tableName, action, ok)
+	}
+	for _, permission := range []string{"schema.edit", "table.orders", "table.orders.delete", "table..read"} {
+		if _, _, ok := models.ParseTablePermission(permission); ok {
+			t.Errorf("Expected %q not to parse as a table permission", permission)
+		}
+	}
+
+	for _, name := range []string{"*", "users.manage", "table.*.read", "page.faq-2.view"} {
+		if !models.ValidPermissionName(name) {
+			t.Errorf("Expected %q to be valid", name)
+		}
+	}
+	for _, name := range []string{"", "schema.", ".edit", "schema edit", "schema.<edit>"} {
+		if models.ValidPermissionName(name) {
+			t.Errorf("Expected %q to be invalid", name)
+		}
+	}
+}
+
+func TestCan(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer cleanupTestDatabase(t, db)
+	defer db.Close()
+
+	admin, err := db.AuthenticateUser("admin", "admin123")
+	if err != nil {
+		t.Fatalf("Failed to authenticate admin user: %v", err)
+	}
+	customer, err := db.AuthenticateUser("customer", "customer123")
+	if err != nil {
+		t.Fatalf("Failed to authenticate customer user: %v", err)
+	}
+
+	can := func(userID int, permission string) (bool, error) {
+		access, err := db.LoadAccess(userID)
+		if err != nil {
+			return false, err
+		}
+		return db.Can(access, permission)
+	}
+
+	checks := []struct {
+		name       string
+		userID     int
+		permission string
+		allowed    bool
+	}{
+		{"admin manages users", admin.ID, models.PermissionUsersManage, true},
+		{"admin edits schema", admin.ID, models.PermissionSchemaEdit, true},
+		{"customer cannot manage users", customer.ID, models.PermissionUsersManage, false},
+		{"customer views faq", customer.ID, models.PermissionFAQView, true},
+		{"anonymous cannot view config", 0, models.PermissionConfigView, false},
+	}
+	for _, tt := range checks {
+		allowed, err := can(tt.userID, tt.permission)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if allowed != tt.allowed {
+			t.Errorf("%s: Can(%d, %q) = %v, want %v", tt.name, tt.userID, tt.permission, allowed, tt.allowed)
+		}
+	}
+
+	// Granting and revoking takes effect immediately
+	if err := db.GrantPermission("customers", models.PermissionConfigView); err != nil {
+		t.Fatalf("Failed to grant permission: %v", err)
+	}
+	if allowed, _ := can(customer.ID, models.PermissionConfigView); !allowed {
+		t.Error("Expected customer to view config after grant")
+	}
+	if err := db.RevokePermission("customers", models.PermissionConfigView); err != nil {
+		t.Fatalf("Failed to revoke permission: %v", err)
+	}
+	if allowed, _ := can(customer.ID, models.PermissionConfigView); allowed {
+		t.Error("Expected customer not to view config after revoke")
+	}
+
+	if err := db.GrantPermission("customers", "not a permission"); err != database.ErrInvalidPermission {
+		t.Errorf("Expected ErrInvalidPermission, got %v", err)
+	}
+	if err := db.RevokePermission("admin", models.PermissionUsersManage); err != database.ErrProtectedPermission {
+		t.Errorf("Expected ErrProtectedPermission, got %v", err)
+	}
+}
+
+// TestParseAuditFilter tests reading the audit log filter from query parameters
+func TestParseAuditFilter(t *testing.T) {
+	filter, err := handlers.ParseAuditFilter(url.Values{
+		"event_type": {models.AuditLoginFailure},
+		"username":   {" alice "},
+		"since":      {"2024-03-01"},
+		"until":      {"2024-03-02"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse filter: %v", err)
+	}
+	if filter.EventType != models.AuditLoginFailure || filter.Username != "alice" {
+		t.Errorf("Unexpected filter: %+v", filter)
+	}
+	if got := filter.Until.Sub(filter.Since); got != 48*time.Hour {
+		t.Errorf("Expected until to include the whole last day, got a %v range", got)
+	}
+
+	invalid := []url.Values{
+		{"event_type": {"login.maybe"}},
+		{"since": {"03/01/2024"}},
+		{"until": {"yesterday"}},
+	}
+	for _, query := range invalid {
+		if _, err := handlers.ParseAuditFilter(query); err == nil {
+			t.Errorf("Expected an error for %v", query)
+		}
+	}
+}
+
+// TestAuditLog tests recording, filtering and cleaning up audit events
+func TestAuditLog(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer cleanupTestDatabase(t, db)
+	defer db.Close()
+	db.GetDB().Exec("DELETE FROM _audit_log")
+
+	events := []models.AuditEvent{
+		{EventType: models.AuditLoginFailure, ActorName: "mallory", IPAddress: "203.0.113.9", Details: "invalid credentials"},
+		{EventType: models.AuditLoginSuccess, ActorID: 1, ActorName: "admin", IPAddress: "192.0.2.1"},
+		{EventType: models.AuditPermissionGranted, ActorID: 1, ActorName: "admin", IPAddress: "192.0.2.1", Details: "customers: config.view"},
+	}
+	for i := range events {
+		if err := db.RecordAuditEvent(&events[i]); err != nil {
+			t.Fatalf("Failed to record audit event: %v", err)
+		}
+	}
+
+	failures, total, err := db.GetAuditEvents(models.AuditFilter{EventType: models.AuditLoginFailure}, 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to get audit events: %v", err)
+	}
+	if total != 1 || len(failures) != 1 || failures[0].ActorName != "mallory" {
+		t.Errorf("Expected one login failure by mallory, got %d: %+v", total, failures)
+	}
+
+	byAdmin, total, err := db.GetAuditEvents(models.AuditFilter{Username: "admin", IPAddress: "192.0.2.1"}, 1, 0)
+	if err != nil {
+		t.Fatalf("Failed to get audit events: %v", err)
+	}
+	if total != 2 || len(byAdmin) != 1 {
+		t.Errorf("Expected a page of 1 out of 2 admin events, got %d of %d", len(byAdmin), total)
+	}
+
+	// Events older than the retention period are removed
+	db.GetDB().Exec("UPDATE _audit_log SET created = ? WHERE event_type = ?", time.Now().Add(-48*time.Hour), models.AuditLoginFailure)
+	if err := db.CleanupAuditLog(24 * time.Hour); err != nil {
+		t.Fatalf("Failed to clean up audit log: %v", err)
+	}
+	if _, total, _ := db.GetAuditEvents(models.AuditFilter{}, 10, 0); total != 2 {
+		t.Errorf("Expected 2 events to remain after cleanup, got %d", total)
+	}
+}
+
+// TestPasswordResetTokens tests that reset tokens are stored hashed, replace earlier tokens and are counted for throttling
+func TestPasswordResetTokens(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer cleanupTestDatabase(t, db)
+	defer db.Close()
+
+	customer, err := db.AuthenticateUser("customer", "customer123")
+	if err != nil {
+		t.Fatalf("Failed to authenticate customer user: %v", err)
+	}
+	db.GetDB().Exec("DELETE FROM _password_reset_token WHERE user_id = ?", customer.ID)
+
+	expires := time.Now().Add(time.Hour)
+	if err := db.CreatePasswordResetToken(customer.ID, customer.Email, "first-token", expires); err != nil {
+		t.Fatalf("Failed to create reset token: %v", err)
+	}
+	first, err := db.GetPasswordResetToken("first-token")
+	if err != nil {
+		t.Fatalf("Failed to look up reset token: %v", err)
+	}
+	if first.Token == "first-token" {
+		t.Error("Expected the reset token to be stored hashed")
+	}
+
+	// Issuing a new token invalidates the previous one
+	if err := db.CreatePasswordResetToken(customer.ID, customer.Email, "second-token", expires); err != nil {
+		t.Fatalf("Failed to create reset token: %v", err)
+	}
+	if first, _ := db.GetPasswordResetToken("first-token"); first == nil || !first.Used {
+		t.Error("Expected the first token to be invalidated by the second")
+	}
+	if count, err := db.CountRecentPasswordResetTokens(customer.ID, time.Now().Add(-time.Hour)); err != nil || count != 2 {
+		t.Errorf("Expected 2 recent reset tokens, got %d (%v)", count, err)
+	}
+
+	// Using a token invalidates every outstanding token
+	if err := db.InvalidatePasswordResetTokens(customer.ID); err != nil {
+		t.Fatalf("Failed to invalidate reset tokens: %v", err)
+	}
+	if second, _ := db.GetPasswordResetToken("second-token"); second == nil || !second.Used {
+		t.Error("Expected the second token to be invalidated")
+	}
+
+	// Of two concurrent resets with one link, only one sets a password
+	if err := db.CreatePasswordResetToken(customer.ID, customer.Email, "third-token", expires); err != nil {
+		t.Fatalf("Failed to create reset token: %v", err)
+	}
+	passwords := []string{"First-Reset-Pass-1", "Second-Reset-Pass-2"}
+	errs := make([]error, len(passwords))
+	var wg sync.WaitGroup
+	for i, password := range passwords {
+		wg.Add(1)
+		go func(i int, password string) {
+			defer wg.Done()
+			_, errs[i] = db.ResetPassword("third-token", password)
+		}(i, password)
+	}
+	wg.Wait()
+	succeeded := 0
+	for i, err := range errs {
+		if err == nil {
+			succeeded++
+			if _, err := db.AuthenticateUser("customer", passwords[i]); err != nil {
+				t.Errorf("Expected the winning password to work: %v", err)
+			}
+		} else if err != database.ErrPasswordResetInvalid {
+			t.Errorf("Unexpected reset error: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("Expected exactly one reset to succeed, got %d", succeeded)
+	}
+	if _, err := db.ResetPassword("third-token", "Third-Reset-Pass-3"); err != database.ErrPasswordResetInvalid {
+		t.Errorf("Expected a used link to be refused, got %v", err)
+	}
+}
+
+// TestMagicLinkTokens tests that sign-in links work once, only before they expire and only in the requesting browser
+func TestMagicLinkTokens(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer cleanupTestDatabase(t, db)
+	defer db.Close()
+
+	customer, err := db.AuthenticateUser("customer", "customer123")
+	if err != nil {
+		t.Fatalf("Failed to authenticate customer user: %v", err)
+	}
+
+	if err := db.CreateMagicLinkToken(customer.ID, "link-token", "browser-key", time.Now().Add(15*time.Minute)); err != nil {
+		t.Fatalf("Failed to create sign-in link: %v", err)
+	}
+	if _, err := db.ConsumeMagicLinkToken("link-token", "other-browser"); err != database.ErrMagicLinkOtherBrowser {
+		t.Errorf("Expected ErrMagicLinkOtherBrowser from another browser, got %v", err)
+	}
+	userID, err := db.ConsumeMagicLinkToken("link-token", "browser-key")
+	if err != nil || userID != customer.ID {
+		t.Fatalf("Expected the link to sign in user %d, got %d (%v)", customer.ID, userID, err)
+	}
+	if _, err := db.ConsumeMagicLinkToken("link-token", "browser-key"); err != database.ErrMagicLinkInvalid {
+		t.Errorf("Expected a used link to be rejected, got %v", err)
+	}
+
+	if err := db.CreateMagicLinkToken(customer.ID, "expired-token", "browser-key", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("Failed to create sign-in link: %v", err)
+	}
+	if _, err := db.ConsumeMagicLinkToken("expired-token", "browser-key"); err != database.ErrMagicLinkInvalid {
+		t.Errorf("Expected an expired link to be rejected, got %v", err)
+	}
+
+	// A new link replaces the previous one
+	db.CreateMagicLinkToken(customer.ID, "older-token", "browser-key", time.Now().Add(15*time.Minute))
+	db.CreateMagicLinkToken(customer.ID, "newer-token", "browser-key", time.Now().Add(15*time.Minute))
+	if _, err := db.ConsumeMagicLinkToken("older-token", "browser-key"); err != database.ErrMagicLinkInvalid {
+		t.Errorf("Expected the older link to be invalidated, got %v", err)
+	}
+}